@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/repo"
+	"github.com/Elisandil/go-snap/internal/service"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// apikey creates a new API key for an owner and prints it once.
+// With -claim-unowned, the links created before API keys existed, which have no owner, are assigned to the owner
+// as well, so that the key can manage them.
+// Usage: apikey -owner 1 -name "newsletter pipeline" [-claim-unowned]
+func main() {
+	ownerID := flag.Int64("owner", 0, "ID of the owner the key acts on behalf of (required)")
+	name := flag.String("name", "", "description of the key, such as the client that uses it")
+	claimUnowned := flag.Bool("claim-unowned", false, "assign the links that have no owner to the owner as well")
+	flag.Parse()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: time.RFC3339,
+	})
+
+	if *ownerID <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+
+		if err := godotenv.Load("../../.env"); err != nil {
+			log.Warn().Msg("no .env file found, relying on environment variables")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pgPool, err := connectPostgres(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error connecting to Postgres")
+	}
+	defer pgPool.Close()
+
+	// Links are claimed first, so that a failed claim doesn't leave a stored key that was never printed
+	pgRepo := repo.NewPostgresRepo(pgPool)
+	if *claimUnowned {
+		claimed, err := pgRepo.ClaimUnownedURLs(ctx, *ownerID)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error claiming the links without owner")
+		}
+		log.Info().Int64("urls", claimed).Int64("owner_id", *ownerID).Msg("links without owner claimed")
+	}
+
+	authService := service.NewAuthService(pgRepo)
+	rawKey, key, err := authService.CreateAPIKey(ctx, *ownerID, *name)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error creating API key")
+	}
+
+	log.Info().Int64("id", key.ID).Int64("owner_id", key.OwnerID).Msg("API key created, store it now: it can't be shown again")
+	fmt.Println(rawKey)
+}
+
+// ------------------------------------------------------------------------------------------------
+// 											PRIVATE FUNCTIONS
+//-------------------------------------------------------------------------------------------------
+
+// connectPostgres establishes a connection to the Postgres database configured in the environment.
+func connectPostgres(ctx context.Context) (*pgxpool.Pool, error) {
+	connStr := fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		os.Getenv("POSTGRES_HOST"),
+		os.Getenv("POSTGRES_PORT"),
+		os.Getenv("POSTGRES_USER"),
+		os.Getenv("POSTGRES_PASSWORD"),
+		os.Getenv("POSTGRES_DATABASE"),
+	)
+
+	pool, err := pgxpool.New(ctx, connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return pool, nil
+}
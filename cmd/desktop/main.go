@@ -23,7 +23,10 @@ func main() {
 	a := app.NewWithID(appID)
 	a.Settings().SetTheme(&ui.CustomTheme{})
 
-	mainWindow := ui.NewMainWindow(a)
+	client := ui.NewAPIClient(getEnvOrDefault("DESKTOP_API_URL", "http://localhost:8080"))
+	client.SetAPIKey(os.Getenv("DESKTOP_API_KEY"))
+
+	mainWindow := ui.NewMainWindowWithClient(a, client)
 	mainWindow.ShowAndRun()
 }
 
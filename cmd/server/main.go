@@ -3,15 +3,22 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Elisandil/go-snap/internal/api"
+	"github.com/Elisandil/go-snap/internal/cache"
+	"github.com/Elisandil/go-snap/internal/geo"
 	"github.com/Elisandil/go-snap/internal/repo"
 	"github.com/Elisandil/go-snap/internal/service"
 	"github.com/Elisandil/go-snap/internal/shortid"
+	"github.com/Elisandil/go-snap/resources"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
@@ -45,38 +52,89 @@ func main() {
 	}
 	defer pgPool.Close()
 
-	// Connect to Redis
-	redisClient := connectRedis()
-	defer func(redisClient *redis.Client) {
-		err := redisClient.Close()
-		if err != nil {
-			log.Error().Err(err).Msg("error closing Redis client")
-		}
-	}(redisClient)
-
 	// Test connections
 	ctx := context.Background()
 	if err := pgPool.Ping(ctx); err != nil {
 		log.Fatal().Err(err).Msg("error pinging Postgres")
 	}
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Fatal().Err(err).Msg("error pinging Redis")
+
+	log.Info().Msg("Successfully connected to Postgres")
+
+	// Bring databases created by earlier releases up to date, since the init script only runs on new ones
+	pgRepo := repo.NewPostgresRepo(pgPool)
+	if getEnvAsBoolOrDefault("POSTGRES_MIGRATE", true) {
+		if err := pgRepo.Migrate(ctx, resources.Schema); err != nil {
+			log.Fatal().Err(err).Msg("error migrating the database schema")
+		}
+		log.Info().Msg("database schema is up to date")
 	}
 
-	log.Info().Msg("Successfully connected to Postgres and Redis")
+	// Connect to Redis, which is optional: without it, URLs are only cached in process
+	var cacheStore cache.Store
+	if os.Getenv("REDIS_HOST") != "" {
+		redisClient := connectRedis()
+		defer func(redisClient *redis.Client) {
+			err := redisClient.Close()
+			if err != nil {
+				log.Error().Err(err).Msg("error closing Redis client")
+			}
+		}(redisClient)
+
+		if err := redisClient.Ping(ctx).Err(); err != nil {
+			log.Warn().Err(err).Msg("error pinging Redis, serving from the in-process cache until it is reachable")
+		} else {
+			log.Info().Msg("Successfully connected to Redis")
+		}
+		cacheStore = repo.NewRedisRepo(redisClient, 24*time.Hour)
+	} else {
+		log.Warn().Msg("REDIS_HOST is not set, caching URLs in process only")
+	}
 
 	// Initialize repositories, services, and handlers
-	pgRepo := repo.NewPostgresRepo(pgPool)
-	redisRepo := repo.NewRedisRepo(redisClient, 24*time.Hour)
+	urlCache := cache.New(cacheStore, cache.Config{
+		Timeout:          getEnvAsDuration("REDIS_CALL_TIMEOUT", 100*time.Millisecond),
+		FailureThreshold: getEnvAsIntOrDefault("REDIS_BREAKER_FAILURES", 5),
+		Cooldown:         getEnvAsDuration("REDIS_BREAKER_COOLDOWN", 10*time.Second),
+		LocalSize:        getEnvAsIntOrDefault("LOCAL_CACHE_SIZE", 10000),
+		LocalTTL:         getEnvAsDuration("LOCAL_CACHE_TTL", time.Minute),
+	})
 	generator := shortid.NewGenerator()
 	baseURL := getEnv("SERVER_BASE_URL")
-	shortenerService := service.NewShortenerService(pgRepo, redisRepo, generator, baseURL)
+	options := []service.Option{
+		service.WithClickFlushInterval(getEnvAsDuration("CLICK_FLUSH_INTERVAL", time.Second)),
+		service.WithMaxBatchSize(getEnvAsIntOrDefault("SHORTEN_BATCH_MAX_SIZE", 1000)),
+		service.WithReuseExisting(getEnvAsBoolOrDefault("SHORTEN_REUSE_EXISTING", false)),
+		service.WithRedirectStatus(getEnvAsRedirectStatus("REDIRECT_STATUS", http.StatusFound)),
+		service.WithPasswordAttempts(getEnvAsIntOrDefault("PASSWORD_MAX_ATTEMPTS", 5),
+			getEnvAsDuration("PASSWORD_ATTEMPT_WINDOW", 15*time.Minute)),
+		service.WithDomainLists(loadDomainList("DOMAIN_BLOCKLIST_FILE"), loadDomainList("DOMAIN_ALLOWLIST_FILE")),
+		service.WithDomainRulesRefresh(getEnvAsDuration("DOMAIN_RULES_REFRESH_INTERVAL", time.Minute)),
+		service.WithNotFoundTTL(getEnvAsDuration("NOT_FOUND_CACHE_TTL", 30*time.Second)),
+		service.WithEarlyRefresh(getEnvAsDuration("CACHE_EARLY_REFRESH_WINDOW", 0)),
+	}
+	if option := codeStrategyOption(); option != nil {
+		options = append(options, option)
+	}
+	if countryFile := os.Getenv("GEOIP_COUNTRY_FILE"); countryFile != "" {
+		countries, err := geo.LoadCountryCSV(countryFile)
+		if err != nil {
+			log.Fatal().Err(err).Str("path", countryFile).Msg("error loading the country database")
+		}
+		log.Info().Int("ranges", countries.Len()).Msg("loaded the country database")
+		options = append(options, service.WithCountryLookup(countries))
+	}
+	shortenerService := service.NewShortenerService(pgRepo, urlCache, generator, baseURL, options...)
+	if err := shortenerService.ReloadDomainRules(ctx); err != nil {
+		log.Fatal().Err(err).Msg("error loading the stored domain rules")
+	}
+	authService := service.NewAuthService(pgRepo)
 	handler := api.NewHandler(shortenerService)
 
 	// Setup and start the Echo server
 	e := echo.New()
 	e.HideBanner = true
-	api.SetupRoutes(e, handler)
+	e.IPExtractor = ipExtractor()
+	api.SetupRoutes(e, handler, authService)
 
 	port := getEnv("SERVER_PORT")
 	go func() {
@@ -87,17 +145,23 @@ func main() {
 	}()
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	// SIGTERM is what docker stop sends, so pending clicks are flushed when the container stops as well
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 
 	log.Info().Msg("shutting down the server ...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("error during server shutdown")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("error during server shutdown")
 	}
 
+	// Pending clicks are flushed even if the server didn't shut down cleanly, with a timeout of their own
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelClose()
+	shortenerService.Close(closeCtx)
+
 	log.Info().Msg("server stopped gracefully")
 }
 
@@ -128,6 +192,116 @@ func getEnvAsInt(key string) int {
 	return value
 }
 
+// getEnvAsIntOrDefault retrieves the value of the environment variable named by the key as an integer,
+// falling back to the given default when the variable is not set.
+func getEnvAsIntOrDefault(key string, fallback int) int {
+	if os.Getenv(key) == "" {
+		return fallback
+	}
+	return getEnvAsInt(key)
+}
+
+// getEnvAsRedirectStatus retrieves the value of the environment variable named by the key as a redirect
+// status code supported by short URLs, falling back to the given default when the variable is not set.
+func getEnvAsRedirectStatus(key string, fallback int) int {
+	status := getEnvAsIntOrDefault(key, fallback)
+	if !service.IsSupportedRedirectStatus(status) {
+		log.Fatal().Msgf("environment variable %s must be 301, 302, 307 or 308", key)
+	}
+	return status
+}
+
+// getEnvAsBoolOrDefault retrieves the value of the environment variable named by the key as a boolean,
+// falling back to the given default when the variable is not set.
+func getEnvAsBoolOrDefault(key string, fallback bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return fallback
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		log.Fatal().Msgf("environment variable %s must be a boolean", key)
+	}
+	return value
+}
+
+// getEnvAsDuration retrieves the value of the environment variable named by the key as a duration,
+// falling back to the given default when the variable is not set.
+func getEnvAsDuration(key string, fallback time.Duration) time.Duration {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return fallback
+	}
+
+	value, err := time.ParseDuration(valueStr)
+	if err != nil || value <= 0 {
+		log.Fatal().Msgf("environment variable %s must be a positive duration", key)
+	}
+	return value
+}
+
+// loadDomainList reads the domain list file named by the environment variable key.
+// It returns nil when the variable is not set.
+func loadDomainList(key string) []string {
+	path := os.Getenv(key)
+	if path == "" {
+		return nil
+	}
+
+	domains, err := service.LoadDomainList(path)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", path).Msgf("error loading the domain list of %s", key)
+	}
+	log.Info().Int("domains", len(domains)).Str("path", path).Msg("loaded domain list")
+
+	return domains
+}
+
+// codeStrategyOption returns the option of the short code strategy named by SHORT_CODE_STRATEGY: random,
+// the default, sequential, or obfuscated, which shuffles sequential codes with the SHORT_CODE_KEY secret.
+// It returns nil for random codes.
+func codeStrategyOption() service.Option {
+	switch strategy := os.Getenv("SHORT_CODE_STRATEGY"); strategy {
+	case "", "random":
+		return nil
+	case "sequential":
+		return service.WithSequentialCodes(nil)
+	case "obfuscated":
+		obfuscator, err := shortid.NewObfuscator([]byte(os.Getenv("SHORT_CODE_KEY")))
+		if err != nil {
+			log.Fatal().Err(err).Msg("environment variable SHORT_CODE_KEY must be set for obfuscated short codes")
+		}
+		return service.WithSequentialCodes(obfuscator)
+	default:
+		log.Fatal().Msgf("environment variable SHORT_CODE_STRATEGY must be random, sequential or obfuscated, got %s",
+			strategy)
+		return nil
+	}
+}
+
+// ipExtractor returns how the client IP of requests is found, used by country targeting, click analytics and
+// the password attempt limiter. By default it is the address of the connection, since forwarding headers are
+// set by the client. With TRUSTED_PROXIES, a comma separated list of CIDR ranges, it is read from the
+// X-Forwarded-For header of requests that only went through proxies of those ranges.
+func ipExtractor() echo.IPExtractor {
+	proxies := os.Getenv("TRUSTED_PROXIES")
+	if proxies == "" {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range strings.Split(proxies, ",") {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(proxy))
+		if err != nil {
+			log.Fatal().Err(err).Msg("environment variable TRUSTED_PROXIES must be a list of CIDR ranges")
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 // validateConfig checks for the presence of required environment variables.
 func validateConfig() error {
 	requiredKeys := []string{
@@ -136,8 +310,6 @@ func validateConfig() error {
 		"POSTGRES_USER",
 		"POSTGRES_PASSWORD",
 		"POSTGRES_DATABASE",
-		"REDIS_HOST",
-		"REDIS_PORT",
 	}
 
 	for _, key := range requiredKeys {
@@ -0,0 +1,146 @@
+package analytics
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	UserAgentBot     = "bot"
+	UserAgentMobile  = "mobile"
+	UserAgentTablet  = "tablet"
+	UserAgentDesktop = "desktop"
+	UserAgentOther   = "other"
+)
+
+const (
+	OSiOS     = "ios"
+	OSAndroid = "android"
+	OSWindows = "windows"
+	OSMacOS   = "macos"
+	OSLinux   = "linux"
+	OSOther   = "other"
+)
+
+// botMarkers are lowercase substrings that identify crawlers, link unfurlers and HTTP libraries.
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "preview", "facebookexternalhit", "curl", "wget", "python-requests",
+	"go-http-client",
+}
+
+// ReferrerHost extracts the lowercase host of the given referrer URL.
+// It returns an empty string if the referrer is empty or can't be parsed.
+func ReferrerHost(referrer string) string {
+
+	if referrer == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
+
+// UserAgentClass classifies the given User-Agent header into a coarse device class.
+// It returns one of UserAgentBot, UserAgentMobile, UserAgentTablet, UserAgentDesktop or UserAgentOther.
+func UserAgentClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	if ua == "" {
+		return UserAgentOther
+	}
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return UserAgentBot
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+
+		return UserAgentTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		return UserAgentMobile
+	case strings.Contains(ua, "windows") || strings.Contains(ua, "macintosh") ||
+		strings.Contains(ua, "x11") || strings.Contains(ua, "linux"):
+
+		return UserAgentDesktop
+	default:
+		return UserAgentOther
+	}
+}
+
+// OperatingSystem detects the operating system of the given User-Agent header.
+// It returns one of OSiOS, OSAndroid, OSWindows, OSMacOS, OSLinux or OSOther.
+// iOS is checked before macOS, since iPhone and iPad browsers also claim to be "like Mac OS X".
+func OperatingSystem(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return OSiOS
+	case strings.Contains(ua, "android"):
+		return OSAndroid
+	case strings.Contains(ua, "windows"):
+		return OSWindows
+	case strings.Contains(ua, "macintosh") || strings.Contains(ua, "mac os x"):
+		return OSMacOS
+	case strings.Contains(ua, "linux") || strings.Contains(ua, "x11"):
+		return OSLinux
+	default:
+		return OSOther
+	}
+}
+
+// PreferredLanguage returns the lowercase language tag with the highest quality in the given
+// Accept-Language header, such as "es" or "es-mx". Among tags of the same quality, the first one wins.
+// It returns an empty string if the header is empty or only has the "*" wildcard and rejected tags.
+func PreferredLanguage(acceptLanguage string) string {
+	preferred, bestQuality := "", 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > bestQuality {
+			preferred, bestQuality = tag, quality
+		}
+	}
+
+	return preferred
+}
+
+// AnonymizeIP truncates the given client IP to a network prefix so it can't identify a single host.
+// IPv4 addresses are truncated to /24 and IPv6 addresses to /48, in CIDR notation.
+// It returns an empty string if the IP can't be parsed.
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		network := &net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+		return network.String()
+	}
+
+	network := &net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}
+	return network.String()
+}
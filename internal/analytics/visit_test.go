@@ -0,0 +1,243 @@
+package analytics
+
+import "testing"
+
+func TestReferrerHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "empty referrer",
+			input:    "",
+			expected: "",
+		},
+		{
+			name:     "full url",
+			input:    "https://news.ycombinator.com/item?id=1",
+			expected: "news.ycombinator.com",
+		},
+		{
+			name:     "uppercase host with port",
+			input:    "http://WWW.Example.com:8080/path",
+			expected: "www.example.com",
+		},
+		{
+			name:     "malformed url",
+			input:    "http://[::1",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ReferrerHost(tt.input)
+			if result != tt.expected {
+				t.Errorf("ReferrerHost(%s) = %s; want %s", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUserAgentClass(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "empty user agent",
+			input:    "",
+			expected: UserAgentOther,
+		},
+		{
+			name:     "googlebot",
+			input:    "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			expected: UserAgentBot,
+		},
+		{
+			name:     "curl",
+			input:    "curl/8.4.0",
+			expected: UserAgentBot,
+		},
+		{
+			name:     "iphone",
+			input:    "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148",
+			expected: UserAgentMobile,
+		},
+		{
+			name:     "android phone",
+			input:    "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36",
+			expected: UserAgentMobile,
+		},
+		{
+			name:     "android tablet",
+			input:    "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 Chrome/120.0 Safari/537.36",
+			expected: UserAgentTablet,
+		},
+		{
+			name:     "ipad",
+			input:    "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148",
+			expected: UserAgentTablet,
+		},
+		{
+			name:     "windows desktop",
+			input:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36",
+			expected: UserAgentDesktop,
+		},
+		{
+			name:     "unknown client",
+			input:    "SomeClient/1.0",
+			expected: UserAgentOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := UserAgentClass(tt.input)
+			if result != tt.expected {
+				t.Errorf("UserAgentClass(%s) = %s; want %s", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOperatingSystem(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "iphone",
+			input:    "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148",
+			expected: OSiOS,
+		},
+		{
+			name:     "ipad",
+			input:    "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148",
+			expected: OSiOS,
+		},
+		{
+			name:     "android",
+			input:    "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36",
+			expected: OSAndroid,
+		},
+		{
+			name:     "windows",
+			input:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36",
+			expected: OSWindows,
+		},
+		{
+			name:     "macos",
+			input:    "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15",
+			expected: OSMacOS,
+		},
+		{
+			name:     "linux",
+			input:    "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+			expected: OSLinux,
+		},
+		{
+			name:     "unknown client",
+			input:    "curl/8.4.0",
+			expected: OSOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := OperatingSystem(tt.input)
+			if result != tt.expected {
+				t.Errorf("OperatingSystem(%s) = %s; want %s", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "empty header",
+			input:    "",
+			expected: "",
+		},
+		{
+			name:     "single language",
+			input:    "es-MX",
+			expected: "es-mx",
+		},
+		{
+			name:     "first language without quality",
+			input:    "es-ES,es;q=0.9,en;q=0.8",
+			expected: "es-es",
+		},
+		{
+			name:     "highest quality wins",
+			input:    "en;q=0.5, fr;q=0.9, de;q=0.7",
+			expected: "fr",
+		},
+		{
+			name:     "wildcard and rejected tags are skipped",
+			input:    "*, it;q=0",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := PreferredLanguage(tt.input)
+			if result != tt.expected {
+				t.Errorf("PreferredLanguage(%s) = %s; want %s", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "ipv4",
+			input:    "203.0.113.42",
+			expected: "203.0.113.0/24",
+		},
+		{
+			name:     "ipv6",
+			input:    "2001:db8:85a3:8d3:1319:8a2e:370:7348",
+			expected: "2001:db8:85a3::/48",
+		},
+		{
+			name:     "ipv4-mapped ipv6",
+			input:    "::ffff:192.0.2.128",
+			expected: "192.0.2.0/24",
+		},
+		{
+			name:     "empty",
+			input:    "",
+			expected: "",
+		},
+		{
+			name:     "garbage",
+			input:    "not-an-ip",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := AnonymizeIP(tt.input)
+			if result != tt.expected {
+				t.Errorf("AnonymizeIP(%s) = %s; want %s", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
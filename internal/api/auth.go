@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/service"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+type Authenticator interface {
+	Authenticate(ctx context.Context, rawKey string) (*domain.APIKey, error)
+}
+
+// APIKeyAuth returns a middleware that requires an API key in the "Authorization: Bearer <key>" header.
+// The owner of a valid key is added to the request context, so the service can assign and restrict
+// URLs to it. Missing, unknown and revoked keys are rejected with 401 Unauthorized, and failures of the key
+// store with 503 Service Unavailable, through HTTPErrorHandler.
+func APIKeyAuth(authenticator Authenticator) echo.MiddlewareFunc {
+	return middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		Validator: func(rawKey string, c echo.Context) (bool, error) {
+			key, err := authenticator.Authenticate(c.Request().Context(), rawKey)
+			if err != nil {
+				return false, err
+			}
+			c.SetRequest(c.Request().WithContext(service.WithOwner(c.Request().Context(), key.OwnerID)))
+
+			return true, nil
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			var missing *middleware.ErrKeyAuthMissing
+			if errors.As(err, &missing) || errors.Is(err, service.ErrInvalidAPIKey) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+
+				return service.ErrInvalidAPIKey
+			}
+
+			return err
+		},
+	})
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// maxBatchBodySize bounds the size of a bulk shortening body, whatever its format.
+const maxBatchBodySize = 10 << 20
+
+var errUnsupportedBatchFormat = errors.New("unsupported content type: use application/json, text/csv or application/x-ndjson")
+
+// csvColumns are the columns of a CSV batch, in the order used when the file has no header row.
+var csvColumns = []string{"long_url", "alias", "expires_at"}
+
+// parseBatchRequest decodes the body of a bulk shortening request according to its content type.
+// JSON bodies hold a BatchCreateURLRequest, NDJSON bodies one CreateURLRequest per line,
+// and CSV bodies one URL per row.
+// A body without a content type is decoded as JSON.
+func parseBatchRequest(c echo.Context) ([]*domain.CreateURLRequest, error) {
+	mediaType := echo.MIMEApplicationJSON
+	if contentType := c.Request().Header.Get(echo.HeaderContentType); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, errUnsupportedBatchFormat
+		}
+		mediaType = parsed
+	}
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxBatchBodySize)
+
+	switch mediaType {
+	case echo.MIMEApplicationJSON:
+		var request domain.BatchCreateURLRequest
+		if err := json.NewDecoder(body).Decode(&request); err != nil {
+			return nil, err
+		}
+		return request.URLs, nil
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return parseNDJSONBatch(body)
+	case "text/csv":
+		return parseCSVBatch(body)
+	default:
+		return nil, errUnsupportedBatchFormat
+	}
+}
+
+// parseNDJSONBatch decodes one create request per line, skipping blank lines.
+func parseNDJSONBatch(body io.Reader) ([]*domain.CreateURLRequest, error) {
+	var requests []*domain.CreateURLRequest
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchBodySize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var request domain.CreateURLRequest
+		if err := json.Unmarshal([]byte(text), &request); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		requests = append(requests, &request)
+	}
+
+	return requests, scanner.Err()
+}
+
+// parseCSVBatch decodes one create request per row.
+// If the first row holds column names, such as long_url, alias and expires_at, columns are matched by name.
+// Otherwise the columns are read in the order of csvColumns, and only the first one is required.
+func parseCSVBatch(body io.Reader) ([]*domain.CreateURLRequest, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := csvColumns
+	if isCSVHeader(records[0]) {
+		columns = make([]string, len(records[0]))
+		for i, name := range records[0] {
+			columns[i] = strings.ToLower(strings.TrimSpace(name))
+		}
+		records = records[1:]
+	}
+
+	requests := make([]*domain.CreateURLRequest, 0, len(records))
+	for _, record := range records {
+		request := &domain.CreateURLRequest{}
+		for i, value := range record {
+			if i >= len(columns) {
+				break
+			}
+			value = strings.TrimSpace(value)
+			switch columns[i] {
+			case "long_url":
+				request.LongURL = value
+			case "alias":
+				request.Alias = value
+			case "expires_at":
+				request.ExpiresAt = value
+			}
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+// isCSVHeader reports whether a CSV row names the long_url column instead of holding a URL.
+func isCSVHeader(record []string) bool {
+	for _, value := range record {
+		if strings.EqualFold(strings.TrimSpace(value), "long_url") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/service"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// problemContentType is the media type of the RFC 7807 error responses.
+const problemContentType = "application/problem+json"
+
+var (
+	errInvalidPayload = errors.New("invalid request payload")
+	errValidation     = errors.New("validation failed")
+)
+
+// problemKind is the problem that an error, and every error wrapping it, is answered with.
+// An empty detail means the message of the error is shown instead.
+type problemKind struct {
+	err    error
+	status int
+	code   string
+	detail string
+}
+
+// problemKinds lists the errors that have a problem of their own, in the order they are matched,
+// so that errors wrapping another one of the list come before it: invalid targeting rules and variants
+// wrap the ErrInvalidURL of their destination.
+var problemKinds = []problemKind{
+	{err: service.ErrInvalidShortCode, status: http.StatusBadRequest, code: "invalid_short_code"},
+	{err: errInvalidPayload, status: http.StatusBadRequest, code: "invalid_payload"},
+	{err: service.ErrInvalidAPIKey, status: http.StatusUnauthorized, code: "invalid_api_key",
+		detail: "A valid API key is required"},
+	{err: service.ErrNotFound, status: http.StatusNotFound, code: "not_found", detail: "Short URL not found"},
+	{err: service.ErrAliasTaken, status: http.StatusConflict, code: "alias_taken"},
+	{err: service.ErrLinkExpired, status: http.StatusGone, code: "link_expired", detail: "Short URL has expired"},
+	{err: service.ErrLinkDisabled, status: http.StatusGone, code: "link_disabled",
+		detail: "Short URL has been disabled"},
+	{err: service.ErrClickLimitReached, status: http.StatusGone, code: "click_limit_reached",
+		detail: "Short URL has reached its click limit"},
+	{err: errUnsupportedBatchFormat, status: http.StatusUnsupportedMediaType, code: "unsupported_format"},
+	{err: service.ErrInvalidTargeting, status: http.StatusUnprocessableEntity, code: "invalid_targeting"},
+	{err: service.ErrInvalidVariants, status: http.StatusUnprocessableEntity, code: "invalid_variants"},
+	{err: service.ErrInvalidURL, status: http.StatusUnprocessableEntity, code: "invalid_url"},
+	{err: service.ErrInvalidAlias, status: http.StatusUnprocessableEntity, code: "invalid_alias"},
+	{err: service.ErrReservedAlias, status: http.StatusUnprocessableEntity, code: "reserved_alias"},
+	{err: service.ErrInvalidExpiration, status: http.StatusUnprocessableEntity, code: "invalid_expiration"},
+	{err: service.ErrInvalidRedirect, status: http.StatusUnprocessableEntity, code: "invalid_redirect_status"},
+	{err: service.ErrInvalidPassword, status: http.StatusUnprocessableEntity, code: "invalid_password"},
+	{err: service.ErrInvalidMaxClicks, status: http.StatusUnprocessableEntity, code: "invalid_max_clicks"},
+	{err: service.ErrInvalidBatch, status: http.StatusUnprocessableEntity, code: "invalid_batch"},
+	{err: service.ErrInvalidStatsQuery, status: http.StatusUnprocessableEntity, code: "invalid_stats_query"},
+	{err: service.ErrInvalidListQuery, status: http.StatusUnprocessableEntity, code: "invalid_list_query"},
+	{err: service.ErrInvalidQRCode, status: http.StatusUnprocessableEntity, code: "invalid_qr_code"},
+	{err: errValidation, status: http.StatusUnprocessableEntity, code: "validation_failed"},
+	{err: service.ErrUnavailable, status: http.StatusServiceUnavailable, code: "unavailable",
+		detail: "The service is temporarily unavailable, please try again later"},
+}
+
+// HTTPErrorHandler answers the errors returned by handlers and middleware with an RFC 7807 problem+json body.
+// Malformed short codes and payloads answer with 400, unknown short codes with 404, expired, disabled and
+// exhausted links with 410, invalid input and unsafe destinations with 422 and backend outages with 503,
+// each with a machine-readable code; see problemKinds. Unsafe destinations carry the reason of the
+// *service.UnsafeURLError in code.
+// Errors of Echo itself, such as unknown routes or the rate limit, keep their status, and any other error
+// answers with 500 without exposing its message. Server errors are logged.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	problem := newProblem(err)
+	problem.Instance = c.Request().URL.Path
+	if problem.Status >= http.StatusInternalServerError {
+		log.Error().Err(err).Str("method", c.Request().Method).Str("path", problem.Instance).
+			Msg("error handling request")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, problemContentType)
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(problem.Status)
+	} else {
+		err = c.JSON(problem.Status, problem)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error writing problem response")
+	}
+}
+
+// newProblem builds the problem details of the given error, without its instance.
+func newProblem(err error) *domain.Problem {
+	var unsafe *service.UnsafeURLError
+	if errors.As(err, &unsafe) {
+		return problemOf(http.StatusUnprocessableEntity, unsafe.Code, unsafe.Error())
+	}
+
+	for _, kind := range problemKinds {
+		if errors.Is(err, kind.err) {
+			detail := kind.detail
+			if detail == "" {
+				detail = err.Error()
+			}
+			return problemOf(kind.status, kind.code, detail)
+		}
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return problemOf(httpErr.Code, "", fmt.Sprint(httpErr.Message))
+	}
+
+	return problemOf(http.StatusInternalServerError, "internal_error", "")
+}
+
+// problemOf builds a problem of the about:blank type, whose title is the text of its status.
+func problemOf(status int, code, detail string) *domain.Problem {
+	return &domain.Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
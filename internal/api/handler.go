@@ -2,11 +2,23 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/service"
 	"github.com/labstack/echo/v4"
-	"github.com/rs/zerolog/log"
+)
+
+const (
+	// variantCookiePrefix is followed by the short code in the name of the cookie that keeps a visitor
+	// on the variant of a sticky short URL
+	variantCookiePrefix = "gosnap_variant_"
+	variantCookieMaxAge = 30 * 24 * time.Hour
 )
 
 type Handler struct {
@@ -20,79 +32,357 @@ func NewHandler(service ShortenerServiceInterface) *Handler {
 }
 
 type ShortenerServiceInterface interface {
-	CreateShortURL(ctx context.Context, longURL string) (*domain.CreateURLResponse, error)
-	GetLongURL(ctx context.Context, shortCode string) (string, error)
-	GetURLStats(ctx context.Context, shortCode string) (*domain.StatsResponse, error)
+	CreateShortURL(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error)
+	CreateShortURLs(ctx context.Context, requests []*domain.CreateURLRequest) (*domain.BatchCreateURLResponse, error)
+	GetLongURL(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error)
+	UnlockURL(ctx context.Context, shortCode, password string, visit *domain.Visit) (*domain.URL, error)
+	GetURLPreview(ctx context.Context, shortCode string) (*domain.URL, error)
+	GetURLStats(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error)
+	DeleteURL(ctx context.Context, shortCode string) error
+	SetURLEnabled(ctx context.Context, shortCode string, enabled bool) (*domain.StatsResponse, error)
+	UpdateLongURL(ctx context.Context, shortCode, longURL string) (*domain.StatsResponse, error)
+	ListURLs(ctx context.Context, query *domain.ListURLsQuery) (*domain.ListURLsResponse, error)
+	GetQRCode(ctx context.Context, shortCode string, query *domain.QRCodeQuery) (*domain.QRCode, error)
 }
 
 // CreateShortURL handles the creation of a new short URL.
 // @Summary Create Short URL
-// @Description Create a new short URL from a long URL
+// @Description Create a new short URL from a long URL, optionally using a custom alias
+// @Description or reusing the existing short URL of the same long URL
 // @Param request body domain.CreateURLRequest true "Create URL Request"
 // @Accept json
 // @Produce json
+// @Success 200 {object} domain.CreateURLResponse "Existing short URL reused"
 // @Success 201 {object} domain.CreateURLResponse
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} domain.Problem
+// @Failure 409 {object} domain.Problem
+// @Failure 422 {object} domain.Problem "Invalid input or unsafe destination, with its reason in code"
+// @Failure 503 {object} domain.Problem
 func (h *Handler) CreateShortURL(c echo.Context) error {
 	var request domain.CreateURLRequest
 
 	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request payload",
-		})
+		return errInvalidPayload
 	}
 	if err := c.Validate(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Validation failed: " + err.Error(),
-		})
+		return fmt.Errorf("%w: %w", errValidation, err)
 	}
 
-	response, err := h.service.CreateShortURL(c.Request().Context(), request.LongURL)
+	response, err := h.service.CreateShortURL(c.Request().Context(), &request)
 	if err != nil {
-		log.Error().Err(err).Msg("error creating short URL")
-
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to create short URL",
-		})
+		return err
+	}
+	if response.Reused {
+		return c.JSON(http.StatusOK, response)
 	}
 
 	return c.JSON(http.StatusCreated, response)
 }
 
+// CreateShortURLs handles the creation of several short URLs with a single request.
+// The body can be a JSON object with a "urls" array, a CSV file or NDJSON, depending on its content type.
+// @Summary Create Short URLs in Bulk
+// @Description Create short URLs for a batch of long URLs; each item reports its own result or error
+// @Param request body domain.BatchCreateURLRequest true "Batch Create URL Request"
+// @Accept json,text/csv,application/x-ndjson
+// @Produce json
+// @Success 200 {object} domain.BatchCreateURLResponse
+// @Failure 400 {object} domain.Problem
+// @Failure 415 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
+func (h *Handler) CreateShortURLs(c echo.Context) error {
+	requests, err := parseBatchRequest(c)
+	if err != nil {
+		if errors.Is(err, errUnsupportedBatchFormat) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errInvalidPayload, err)
+	}
+
+	response, err := h.service.CreateShortURLs(c.Request().Context(), requests)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
 // Redirect handles the redirection from a short URL to the original long URL.
+// The status code is the one chosen for the link, or the server default.
+// Links with preview enabled answer with the preview page instead of redirecting.
+// Links with passthrough enabled also accept a trailing path, which is appended to the long URL along with
+// the query string.
+// Password protected links answer with a password form, posted back to the short URL and handed to Unlock.
+// HEAD requests are answered like GET requests, but aren't counted as clicks.
 // @Summary Redirect to Long URL
-// @Description Redirect from a short URL to the original long URL
+// @Description Redirect from a short URL to the original long URL with a 301, 302, 307 or 308 status
 // @Param shortCode path string true "Short URL code"
+// @Produce html
+// @Success 200 {string} string "Preview page of links with preview enabled, or password form of protected links"
+// @Success 301
 // @Success 302
-// @Failure 404 {object} map[string]string
+// @Success 307
+// @Success 308
+// @Failure 400 {object} domain.Problem
+// @Failure 404 {object} domain.Problem
+// @Failure 410 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
 func (h *Handler) Redirect(c echo.Context) error {
 	shortCode := c.Param("shortCode")
 
-	longURL, err := h.service.GetLongURL(c.Request().Context(), shortCode)
+	url, err := h.service.GetLongURL(c.Request().Context(), shortCode, newVisit(c))
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "Short URL not found",
-		})
+		if errors.Is(err, service.ErrPasswordRequired) {
+			if c.Request().Method == http.MethodPost {
+				return h.Unlock(c)
+			}
+			return renderPasswordForm(c, http.StatusOK, c.Request().URL.RequestURI(), "")
+		}
+		return err
+	}
+	setVariantCookie(c, url)
+	if url.Preview {
+		return renderPreview(c, url)
 	}
 
-	return c.Redirect(http.StatusFound, longURL)
+	return c.Redirect(url.RedirectStatus, url.LongURL)
+}
+
+// Unlock handles the password form of a protected short URL, which is posted to the short URL itself:
+// Redirect hands it the POST requests to protected links, while the other ones are redirected as usual.
+// A correct password redirects to the long URL with 303 See Other, whatever the status of the link,
+// so the browser follows it with a GET instead of sending the password to the destination.
+// Wrong passwords answer with the form again, and once too many have been given for the link,
+// further attempts are refused for a while.
+// @Summary Unlock Password Protected Short URL
+// @Description Check the password of a protected short URL and redirect to its long URL
+// @Param shortCode path string true "Short URL code"
+// @Param password formData string true "Password of the short URL"
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Success 200 {string} string "Preview page of links with preview enabled"
+// @Success 303
+// @Failure 400 {object} domain.Problem
+// @Failure 401 {string} string "Password form, after a wrong password"
+// @Failure 404 {object} domain.Problem
+// @Failure 410 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 429 {string} string "Password form, after too many wrong passwords"
+// @Failure 503 {object} domain.Problem
+func (h *Handler) Unlock(c echo.Context) error {
+	shortCode := c.Param("shortCode")
+	action := c.Request().URL.RequestURI()
+
+	url, err := h.service.UnlockURL(c.Request().Context(), shortCode, c.FormValue("password"), newVisit(c))
+	if err != nil {
+		if errors.Is(err, service.ErrWrongPassword) {
+			return renderPasswordForm(c, http.StatusUnauthorized, action, "Incorrect password, please try again.")
+		}
+		if errors.Is(err, service.ErrTooManyAttempts) {
+			return renderPasswordForm(c, http.StatusTooManyRequests, action,
+				"Too many incorrect passwords. Please try again later.")
+		}
+		return err
+	}
+	setVariantCookie(c, url)
+	if url.Preview {
+		return renderPreview(c, url)
+	}
+
+	return c.Redirect(http.StatusSeeOther, url.LongURL)
+}
+
+// Preview handles showing the destination of a short URL on a page, before the visitor continues to it.
+// It is served for the short URL followed by a plus sign, whether the link has preview enabled or not.
+// Password protected links answer with their password form instead, so the destination stays hidden.
+// @Summary Preview Short URL Destination
+// @Description Show the destination host and full URL of a short URL without redirecting
+// @Param shortCode path string true "Short URL code"
+// @Produce html
+// @Success 200 {string} string
+// @Failure 400 {object} domain.Problem
+// @Failure 404 {object} domain.Problem
+// @Failure 410 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
+func (h *Handler) Preview(c echo.Context) error {
+	shortCode := c.Param("shortCode")
+
+	url, err := h.service.GetURLPreview(c.Request().Context(), shortCode)
+	if err != nil {
+		if errors.Is(err, service.ErrPasswordRequired) {
+			return renderPasswordForm(c, http.StatusOK, "/"+shortCode, "")
+		}
+		return err
+	}
+
+	return renderPreview(c, url)
 }
 
 // GetStats handles retrieving statistics for a short URL.
 // @Summary Get Short URL Stats
-// @Description Retrieve statistics for a short URL
+// @Description Retrieve statistics for a short URL, optionally with a time-bucketed click series
 // @Param shortCode path string true "Short URL code"
+// @Param from query string false "Start of the series (RFC3339 or YYYY-MM-DD)"
+// @Param to query string false "End of the series (RFC3339 or YYYY-MM-DD)"
+// @Param interval query string false "Bucket size: hour, day, week or month"
 // @Success 200 {object} domain.URLStats
-// @Failure 404 {object} map[string]string
+// @Failure 400 {object} domain.Problem
+// @Failure 404 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
 func (h *Handler) GetStats(c echo.Context) error {
 	shortCode := c.Param("shortCode")
 
-	stats, err := h.service.GetURLStats(c.Request().Context(), shortCode)
+	series, err := parseSeriesQuery(c)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "Short URL not found",
-		})
+		return err
+	}
+
+	stats, err := h.service.GetURLStats(c.Request().Context(), shortCode, series)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+// ListURLs handles listing the short URLs of the caller, one page at a time.
+// @Summary List Short URLs
+// @Description List short URLs with cursor pagination, sorting and filters
+// @Param sort query string false "Sort order: created_at (default) or clicks, both descending"
+// @Param q query string false "Substring of the long URL"
+// @Param domain query string false "Domain of the long URL, subdomains included"
+// @Param from query string false "Created at or after (RFC3339 or YYYY-MM-DD)"
+// @Param to query string false "Created before (RFC3339 or YYYY-MM-DD)"
+// @Param limit query int false "Page size, up to 100"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Produce json
+// @Success 200 {object} domain.ListURLsResponse
+// @Failure 422 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
+func (h *Handler) ListURLs(c echo.Context) error {
+	query, err := parseListQuery(c)
+	if err != nil {
+		return err
+	}
+
+	response, err := h.service.ListURLs(c.Request().Context(), query)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetQRCode handles rendering the QR code of a short URL.
+// @Summary Get Short URL QR Code
+// @Description Render a QR code that encodes the full short URL
+// @Param shortCode path string true "Short URL code"
+// @Param size query int false "Width and height in pixels, 64 to 2048 (default 256)"
+// @Param format query string false "Image format: png (default) or svg"
+// @Param level query string false "Error correction level: L, M (default), Q or H"
+// @Param margin query int false "Quiet zone in modules, 0 to 16 (default 4)"
+// @Produce png,image/svg+xml
+// @Success 200 {file} binary
+// @Failure 400 {object} domain.Problem
+// @Failure 404 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 500 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
+func (h *Handler) GetQRCode(c echo.Context) error {
+	shortCode := c.Param("shortCode")
+
+	query, err := parseQRCodeQuery(c)
+	if err != nil {
+		return err
+	}
+
+	code, err := h.service.GetQRCode(c.Request().Context(), shortCode, query)
+	if err != nil {
+		return err
+	}
+
+	return c.Blob(http.StatusOK, code.ContentType, code.Data)
+}
+
+// DeleteURL handles the permanent deletion of a short URL.
+// @Summary Delete Short URL
+// @Description Delete a short URL and evict it from the cache
+// @Param shortCode path string true "Short URL code"
+// @Success 204
+// @Failure 400 {object} domain.Problem
+// @Failure 404 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
+func (h *Handler) DeleteURL(c echo.Context) error {
+	shortCode := c.Param("shortCode")
+
+	if err := h.service.DeleteURL(c.Request().Context(), shortCode); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// UpdateURL handles changing the destination of a short URL without changing its short code.
+// @Summary Update Short URL Destination
+// @Description Change the long URL of a short URL; previous destinations are kept in the stats history
+// @Param shortCode path string true "Short URL code"
+// @Param request body domain.UpdateURLRequest true "Update URL Request"
+// @Accept json
+// @Produce json
+// @Success 200 {object} domain.StatsResponse
+// @Failure 400 {object} domain.Problem
+// @Failure 404 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
+func (h *Handler) UpdateURL(c echo.Context) error {
+	shortCode := c.Param("shortCode")
+	var request domain.UpdateURLRequest
+
+	if err := c.Bind(&request); err != nil {
+		return errInvalidPayload
+	}
+	if err := c.Validate(&request); err != nil {
+		return fmt.Errorf("%w: %w", errValidation, err)
+	}
+
+	stats, err := h.service.UpdateLongURL(c.Request().Context(), shortCode, request.LongURL)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+// UpdateURLStatus handles enabling or disabling a short URL.
+// @Summary Enable or Disable Short URL
+// @Description Enable or disable a short URL; a disabled URL answers the redirect with 410
+// @Param shortCode path string true "Short URL code"
+// @Param request body domain.PatchURLRequest true "Patch URL Request"
+// @Accept json
+// @Produce json
+// @Success 200 {object} domain.StatsResponse
+// @Failure 400 {object} domain.Problem
+// @Failure 404 {object} domain.Problem
+// @Failure 422 {object} domain.Problem
+// @Failure 503 {object} domain.Problem
+func (h *Handler) UpdateURLStatus(c echo.Context) error {
+	shortCode := c.Param("shortCode")
+	var request domain.PatchURLRequest
+
+	if err := c.Bind(&request); err != nil {
+		return errInvalidPayload
+	}
+	if err := c.Validate(&request); err != nil {
+		return fmt.Errorf("%w: %w", errValidation, err)
+	}
+
+	stats, err := h.service.SetURLEnabled(c.Request().Context(), shortCode, *request.Enabled)
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, stats)
@@ -107,3 +397,147 @@ func (h *Handler) HealthCheck(c echo.Context) error {
 		"status": "healthy",
 	})
 }
+
+// ---------------------------------------------------------------------------------------------
+//                                      PRIVATE FUNCTIONS
+// ---------------------------------------------------------------------------------------------
+
+// newVisit builds the metadata of the redirect served by the given request.
+func newVisit(c echo.Context) *domain.Visit {
+	return &domain.Visit{
+		Referrer:       c.Request().Referer(),
+		UserAgent:      c.Request().UserAgent(),
+		ClientIP:       c.RealIP(),
+		Path:           trailingPath(c),
+		RawQuery:       c.Request().URL.RawQuery,
+		AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+		Variant:        variantFromCookie(c),
+		Probe:          c.Request().Method == http.MethodHead,
+	}
+}
+
+// variantFromCookie returns the variant the visitor was assigned on an earlier visit to the short URL,
+// or zero if the request has no valid variant cookie for it.
+func variantFromCookie(c echo.Context) int {
+	cookie, err := c.Cookie(variantCookiePrefix + c.Param("shortCode"))
+	if err != nil {
+		return 0
+	}
+	variant, err := strconv.Atoi(cookie.Value)
+	if err != nil || variant < 0 {
+		return 0
+	}
+
+	return variant
+}
+
+// setVariantCookie remembers the variant a visitor was sent to, for short URLs with sticky variants.
+// The cookie is scoped to the path of the short URL, so each link keeps its own assignment.
+func setVariantCookie(c echo.Context, url *domain.URL) {
+	if !url.StickyVariants || url.Variant == 0 {
+		return
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     variantCookiePrefix + url.ShortCode,
+		Value:    strconv.Itoa(url.Variant),
+		Path:     "/" + url.ShortCode,
+		MaxAge:   int(variantCookieMaxAge.Seconds()),
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// trailingPath returns the escaped path that follows the short code in the request, without its leading slash.
+func trailingPath(c echo.Context) string {
+	_, path, _ := strings.Cut(strings.TrimPrefix(c.Request().URL.EscapedPath(), "/"), "/")
+	return path
+}
+
+// parseSeriesQuery reads the optional from, to and interval query parameters of the stats endpoint.
+// It returns nil if none of them is present, so no click series is requested.
+// Dates can be given as RFC3339 timestamps or as plain YYYY-MM-DD dates in UTC.
+func parseSeriesQuery(c echo.Context) (*domain.SeriesQuery, error) {
+	from, to, interval := c.QueryParam("from"), c.QueryParam("to"), c.QueryParam("interval")
+
+	if from == "" && to == "" && interval == "" {
+		return nil, nil
+	}
+
+	query := &domain.SeriesQuery{Interval: interval}
+	var err error
+	if query.From, err = parseQueryTime(from); err != nil {
+		return nil, fmt.Errorf("%w: invalid from parameter: %s", service.ErrInvalidStatsQuery, from)
+	}
+	if query.To, err = parseQueryTime(to); err != nil {
+		return nil, fmt.Errorf("%w: invalid to parameter: %s", service.ErrInvalidStatsQuery, to)
+	}
+
+	return query, nil
+}
+
+// parseQRCodeQuery reads the size, format, level and margin query parameters of the QR code endpoint.
+// Absent parameters are left to the defaults of the service.
+func parseQRCodeQuery(c echo.Context) (*domain.QRCodeQuery, error) {
+	query := &domain.QRCodeQuery{
+		Format: c.QueryParam("format"),
+		Level:  c.QueryParam("level"),
+	}
+
+	var err error
+	if size := c.QueryParam("size"); size != "" {
+		if query.Size, err = strconv.Atoi(size); err != nil || query.Size <= 0 {
+			return nil, fmt.Errorf("%w: invalid size parameter: %s", service.ErrInvalidQRCode, size)
+		}
+	}
+	if margin := c.QueryParam("margin"); margin != "" {
+		value, err := strconv.Atoi(margin)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid margin parameter: %s", service.ErrInvalidQRCode, margin)
+		}
+		query.Margin = &value
+	}
+
+	return query, nil
+}
+
+// parseListQuery reads the sort, filter and pagination query parameters of the listing endpoint.
+// Dates can be given as RFC3339 timestamps or as plain YYYY-MM-DD dates in UTC.
+func parseListQuery(c echo.Context) (*domain.ListURLsQuery, error) {
+	query := &domain.ListURLsQuery{
+		Sort:   c.QueryParam("sort"),
+		Search: c.QueryParam("q"),
+		Domain: c.QueryParam("domain"),
+		Cursor: c.QueryParam("cursor"),
+	}
+
+	var err error
+	if query.CreatedFrom, err = parseQueryTime(c.QueryParam("from")); err != nil {
+		return nil, fmt.Errorf("%w: invalid from parameter: %s", service.ErrInvalidListQuery, c.QueryParam("from"))
+	}
+	if query.CreatedTo, err = parseQueryTime(c.QueryParam("to")); err != nil {
+		return nil, fmt.Errorf("%w: invalid to parameter: %s", service.ErrInvalidListQuery, c.QueryParam("to"))
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			return nil, fmt.Errorf("%w: invalid limit parameter: %s", service.ErrInvalidListQuery, limit)
+		}
+	}
+
+	return query, nil
+}
+
+// parseQueryTime parses an RFC3339 timestamp or a YYYY-MM-DD date.
+// An empty value returns the zero time.
+func parseQueryTime(value string) (time.Time, error) {
+
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.DateOnly, value)
+}
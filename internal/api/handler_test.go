@@ -3,13 +3,19 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/repo"
+	"github.com/Elisandil/go-snap/internal/service"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 )
@@ -19,32 +25,85 @@ import (
 // ------------------------------------------------------------------------------------------
 
 type mockShortenerService struct {
-	createFunc   func(ctx context.Context, longURL string) (*domain.CreateURLResponse, error)
-	getLongFunc  func(ctx context.Context, shortCode string) (string, error)
-	getStatsFunc func(ctx context.Context, shortCode string) (*domain.StatsResponse, error)
+	createFunc   func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error)
+	batchFunc    func(ctx context.Context, requests []*domain.CreateURLRequest) (*domain.BatchCreateURLResponse, error)
+	getLongFunc  func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error)
+	unlockFunc   func(ctx context.Context, shortCode, password string, visit *domain.Visit) (*domain.URL, error)
+	previewFunc  func(ctx context.Context, shortCode string) (*domain.URL, error)
+	getStatsFunc func(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error)
+	deleteFunc   func(ctx context.Context, shortCode string) error
+	enableFunc   func(ctx context.Context, shortCode string, enabled bool) (*domain.StatsResponse, error)
+	updateFunc   func(ctx context.Context, shortCode, longURL string) (*domain.StatsResponse, error)
+	listFunc     func(ctx context.Context, query *domain.ListURLsQuery) (*domain.ListURLsResponse, error)
+	qrFunc       func(ctx context.Context, shortCode string, query *domain.QRCodeQuery) (*domain.QRCode, error)
 }
 
-func (m *mockShortenerService) CreateShortURL(ctx context.Context, longURL string) (*domain.CreateURLResponse, error) {
+func (m *mockShortenerService) GetQRCode(ctx context.Context, shortCode string, query *domain.QRCodeQuery) (*domain.QRCode, error) {
+	if m.qrFunc != nil {
+		return m.qrFunc(ctx, shortCode, query)
+	}
+	return &domain.QRCode{ContentType: "image/png", Data: []byte("png")}, nil
+}
+
+func (m *mockShortenerService) ListURLs(ctx context.Context, query *domain.ListURLsQuery) (*domain.ListURLsResponse, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, query)
+	}
+	return &domain.ListURLsResponse{URLs: []domain.URLSummary{}}, nil
+}
+
+func (m *mockShortenerService) CreateShortURL(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
 	if m.createFunc != nil {
-		return m.createFunc(ctx, longURL)
+		return m.createFunc(ctx, request)
 	}
 	return &domain.CreateURLResponse{
 		ShortCode: "abc123",
 		ShortURL:  "http://localhost:8080/abc123",
-		LongURL:   longURL,
+		LongURL:   request.LongURL,
 	}, nil
 }
 
-func (m *mockShortenerService) GetLongURL(ctx context.Context, shortCode string) (string, error) {
+func (m *mockShortenerService) CreateShortURLs(ctx context.Context, requests []*domain.CreateURLRequest) (*domain.BatchCreateURLResponse, error) {
+	if m.batchFunc != nil {
+		return m.batchFunc(ctx, requests)
+	}
+	response := &domain.BatchCreateURLResponse{Created: len(requests)}
+	for i, request := range requests {
+		response.Results = append(response.Results, domain.BatchCreateURLResult{
+			Index: i,
+			CreateURLResponse: &domain.CreateURLResponse{
+				ShortCode: fmt.Sprintf("code%d", i),
+				LongURL:   request.LongURL,
+			},
+		})
+	}
+	return response, nil
+}
+
+func (m *mockShortenerService) GetLongURL(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
 	if m.getLongFunc != nil {
-		return m.getLongFunc(ctx, shortCode)
+		return m.getLongFunc(ctx, shortCode, visit)
+	}
+	return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", RedirectStatus: http.StatusFound}, nil
+}
+
+func (m *mockShortenerService) UnlockURL(ctx context.Context, shortCode, password string, visit *domain.Visit) (*domain.URL, error) {
+	if m.unlockFunc != nil {
+		return m.unlockFunc(ctx, shortCode, password, visit)
 	}
-	return "https://example.com", nil
+	return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", RedirectStatus: http.StatusFound}, nil
 }
 
-func (m *mockShortenerService) GetURLStats(ctx context.Context, shortCode string) (*domain.StatsResponse, error) {
+func (m *mockShortenerService) GetURLPreview(ctx context.Context, shortCode string) (*domain.URL, error) {
+	if m.previewFunc != nil {
+		return m.previewFunc(ctx, shortCode)
+	}
+	return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com"}, nil
+}
+
+func (m *mockShortenerService) GetURLStats(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error) {
 	if m.getStatsFunc != nil {
-		return m.getStatsFunc(ctx, shortCode)
+		return m.getStatsFunc(ctx, shortCode, series)
 	}
 	return &domain.StatsResponse{
 		ShortCode: shortCode,
@@ -54,17 +113,160 @@ func (m *mockShortenerService) GetURLStats(ctx context.Context, shortCode string
 	}, nil
 }
 
+func (m *mockShortenerService) DeleteURL(ctx context.Context, shortCode string) error {
+	if m.deleteFunc != nil {
+		return m.deleteFunc(ctx, shortCode)
+	}
+	return nil
+}
+
+func (m *mockShortenerService) SetURLEnabled(ctx context.Context, shortCode string, enabled bool) (*domain.StatsResponse, error) {
+	if m.enableFunc != nil {
+		return m.enableFunc(ctx, shortCode, enabled)
+	}
+	return &domain.StatsResponse{
+		ShortCode: shortCode,
+		LongURL:   "https://example.com",
+		CreatedAt: time.Now(),
+		Enabled:   enabled,
+	}, nil
+}
+
+func (m *mockShortenerService) UpdateLongURL(ctx context.Context, shortCode, longURL string) (*domain.StatsResponse, error) {
+	if m.updateFunc != nil {
+		return m.updateFunc(ctx, shortCode, longURL)
+	}
+	return &domain.StatsResponse{
+		ShortCode: shortCode,
+		LongURL:   longURL,
+		CreatedAt: time.Now(),
+		Enabled:   true,
+	}, nil
+}
+
+type mockAuthenticator struct {
+	authenticateFunc func(ctx context.Context, rawKey string) (*domain.APIKey, error)
+}
+
+func (m *mockAuthenticator) Authenticate(ctx context.Context, rawKey string) (*domain.APIKey, error) {
+	if m.authenticateFunc != nil {
+		return m.authenticateFunc(ctx, rawKey)
+	}
+	if rawKey != "gsk_valid" {
+		return nil, service.ErrInvalidAPIKey
+	}
+	return &domain.APIKey{ID: 1, OwnerID: 7}, nil
+}
+
+// ------------------------------------------------------------------------------------------
+//                              TESTS: Authentication
+// ------------------------------------------------------------------------------------------
+
+func TestSetupRoutes_APIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		authorization  string
+		authErr        error
+		expectedStatus int
+	}{
+		{
+			name:           "api route without key",
+			method:         http.MethodGet,
+			path:           "/api/stats/abc123",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "api route with unknown key",
+			method:         http.MethodGet,
+			path:           "/api/stats/abc123",
+			authorization:  "Bearer gsk_unknown",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "api route with wrong scheme",
+			method:         http.MethodGet,
+			path:           "/api/stats/abc123",
+			authorization:  "Basic gsk_valid",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "api route with valid key",
+			method:         http.MethodGet,
+			path:           "/api/stats/abc123",
+			authorization:  "Bearer gsk_valid",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "key store unavailable",
+			method:         http.MethodGet,
+			path:           "/api/stats/abc123",
+			authorization:  "Bearer gsk_valid",
+			authErr:        fmt.Errorf("error authenticating API key: %w", service.ErrUnavailable),
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "public redirect without key",
+			method:         http.MethodGet,
+			path:           "/abc123",
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "public health check without key",
+			method:         http.MethodGet,
+			path:           "/health",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getStatsFunc: func(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error) {
+					if ownerID, ok := service.OwnerFromContext(ctx); !ok || ownerID != 7 {
+						t.Errorf("expected owner 7 in the request context, got %d (%v)", ownerID, ok)
+					}
+					return &domain.StatsResponse{ShortCode: shortCode}, nil
+				},
+			}
+			authenticator := &mockAuthenticator{}
+			if tt.authErr != nil {
+				authenticator.authenticateFunc = func(ctx context.Context, rawKey string) (*domain.APIKey, error) {
+					return nil, tt.authErr
+				}
+			}
+
+			e := echo.New()
+			SetupRoutes(e, NewHandler(mockService), authenticator)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assertStatusCode(t, rec, tt.expectedStatus)
+			if tt.expectedStatus == http.StatusUnauthorized {
+				assertHeader(t, rec, echo.HeaderWWWAuthenticate, "Bearer")
+				assertErrorResponse(t, rec, "API key")
+			}
+		})
+	}
+}
+
 // ------------------------------------------------------------------------------------------
 //                              TESTS: CreateShortURL
 // ------------------------------------------------------------------------------------------
 
 func TestHandler_CreateShortURL_Success(t *testing.T) {
 	mockService := &mockShortenerService{
-		createFunc: func(ctx context.Context, longURL string) (*domain.CreateURLResponse, error) {
+		createFunc: func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
 			return &domain.CreateURLResponse{
 				ShortCode: "abc123",
 				ShortURL:  "http://localhost:8080/abc123",
-				LongURL:   longURL,
+				LongURL:   request.LongURL,
 			}, nil
 		},
 	}
@@ -110,188 +312,1565 @@ func TestHandler_CreateShortURL_ValidationError_MissingURL(t *testing.T) {
 	handler := NewHandler(mockService)
 	e := setupEcho()
 
-	reqBody := `{"long_url": ""}`
-	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+	reqBody := `{"long_url": ""}`
+	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+
+	handleRequest(t, handler.CreateShortURL, c)
+	assertStatusCode(t, rec, http.StatusUnprocessableEntity)
+	assertErrorResponse(t, rec, "validation failed")
+	assertProblemCode(t, rec, "validation_failed")
+}
+
+func TestHandler_CreateShortURL_ValidationError_InvalidURL(t *testing.T) {
+	mockService := &mockShortenerService{}
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	reqBody := `{"long_url": "not-a-valid-url"}`
+	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+
+	handleRequest(t, handler.CreateShortURL, c)
+	assertStatusCode(t, rec, http.StatusUnprocessableEntity)
+}
+
+func TestHandler_CreateShortURL_ServiceError(t *testing.T) {
+	mockService := &mockShortenerService{
+		createFunc: func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
+			return nil, fmt.Errorf("error creating short URL: %w", service.ErrUnavailable)
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	reqBody := `{"long_url": "https://example.com"}`
+	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+
+	handleRequest(t, handler.CreateShortURL, c)
+	assertStatusCode(t, rec, http.StatusServiceUnavailable)
+	assertErrorResponse(t, rec, "temporarily unavailable")
+	assertProblemCode(t, rec, "unavailable")
+}
+
+func TestHandler_CreateShortURL_WithAlias(t *testing.T) {
+	mockService := &mockShortenerService{
+		createFunc: func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
+			return &domain.CreateURLResponse{
+				ShortCode: request.Alias,
+				ShortURL:  "http://localhost:8080/" + request.Alias,
+				LongURL:   request.LongURL,
+			}, nil
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	reqBody := `{"long_url": "https://example.com", "alias": "springsale"}`
+	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+
+	handleRequest(t, handler.CreateShortURL, c)
+	assertStatusCode(t, rec, http.StatusCreated)
+
+	var response domain.CreateURLResponse
+	assertJSONResponse(t, rec, &response)
+
+	if response.ShortCode != "springsale" {
+		t.Errorf("expected short_code 'springsale', got '%s'", response.ShortCode)
+	}
+}
+
+func TestHandler_CreateShortURL_Reused(t *testing.T) {
+	mockService := &mockShortenerService{
+		createFunc: func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
+			if request.ReuseExisting == nil || !*request.ReuseExisting {
+				t.Errorf("expected reuse_existing to be passed to the service")
+			}
+			return &domain.CreateURLResponse{
+				ShortCode: "abc123",
+				ShortURL:  "http://localhost:8080/abc123",
+				LongURL:   request.LongURL,
+				Reused:    true,
+			}, nil
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	reqBody := `{"long_url": "https://example.com", "reuse_existing": true}`
+	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+
+	handleRequest(t, handler.CreateShortURL, c)
+	assertStatusCode(t, rec, http.StatusOK)
+
+	var response domain.CreateURLResponse
+	assertJSONResponse(t, rec, &response)
+
+	if !response.Reused {
+		t.Error("expected reused to be true")
+	}
+}
+
+func TestHandler_CreateShortURL_AliasErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		serviceErr     error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "alias already taken",
+			serviceErr:     fmt.Errorf("%w: springsale", service.ErrAliasTaken),
+			expectedStatus: http.StatusConflict,
+			expectedError:  "alias is already taken: springsale",
+		},
+		{
+			name:           "invalid alias",
+			serviceErr:     fmt.Errorf("%w: spring-sale", service.ErrInvalidAlias),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "invalid alias",
+		},
+		{
+			name:           "expiration in the past",
+			serviceErr:     fmt.Errorf("%w: 2020-01-01T00:00:00Z", service.ErrInvalidExpiration),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "invalid expiration",
+		},
+		{
+			name:           "reserved alias",
+			serviceErr:     fmt.Errorf("%w: api", service.ErrReservedAlias),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "alias is reserved",
+		},
+		{
+			name:           "unsupported redirect status",
+			serviceErr:     fmt.Errorf("%w: 303", service.ErrInvalidRedirect),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "invalid redirect status",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				createFunc: func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
+					return nil, tt.serviceErr
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
+
+			reqBody := `{"long_url": "https://example.com", "alias": "springsale"}`
+			rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+
+			handleRequest(t, handler.CreateShortURL, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
+			assertErrorResponse(t, rec, tt.expectedError)
+		})
+	}
+}
+
+func TestHandler_UnsafeURL(t *testing.T) {
+	unsafe := &service.UnsafeURLError{Code: service.UnsafeBlockedDomain, Host: "evil.example"}
+	mockService := &mockShortenerService{
+		createFunc: func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
+			return nil, unsafe
+		},
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return nil, unsafe
+		},
+	}
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	createRec, createCtx := testRequest(t, e, http.MethodPost, "/api/shorten", `{"long_url": "https://evil.example"}`)
+	handleRequest(t, handler.CreateShortURL, createCtx)
+
+	redirectRec, redirectCtx := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
+	handleRequest(t, handler.Redirect, redirectCtx)
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{"create": createRec, "redirect": redirectRec} {
+		assertStatusCode(t, rec, http.StatusUnprocessableEntity)
+
+		var problem domain.Problem
+		assertJSONResponse(t, rec, &problem)
+		if problem.Code != "blocked_domain" {
+			t.Errorf("%s: expected code 'blocked_domain', got '%s'", name, problem.Code)
+		}
+		if problem.Detail != "unsafe URL: domain evil.example is blocked" {
+			t.Errorf("%s: unexpected detail '%s'", name, problem.Detail)
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------
+//                              TESTS: CreateShortURLs
+// ------------------------------------------------------------------------------------------
+
+func TestHandler_CreateShortURLs_Formats(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    []domain.CreateURLRequest
+	}{
+		{
+			name:        "json",
+			contentType: echo.MIMEApplicationJSON,
+			body:        `{"urls": [{"long_url": "https://example.com/a"}, {"long_url": "https://example.com/b", "alias": "promo"}]}`,
+			expected: []domain.CreateURLRequest{
+				{LongURL: "https://example.com/a"},
+				{LongURL: "https://example.com/b", Alias: "promo"},
+			},
+		},
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body:        "{\"long_url\": \"https://example.com/a\"}\n\n{\"long_url\": \"https://example.com/b\", \"expires_at\": \"72h\"}\n",
+			expected: []domain.CreateURLRequest{
+				{LongURL: "https://example.com/a"},
+				{LongURL: "https://example.com/b", ExpiresAt: "72h"},
+			},
+		},
+		{
+			name:        "csv without header",
+			contentType: "text/csv",
+			body:        "https://example.com/a\nhttps://example.com/b,promo\n",
+			expected: []domain.CreateURLRequest{
+				{LongURL: "https://example.com/a"},
+				{LongURL: "https://example.com/b", Alias: "promo"},
+			},
+		},
+		{
+			name:        "csv with header",
+			contentType: "text/csv; charset=utf-8",
+			body:        "expires_at,long_url\n24h,https://example.com/a\n,https://example.com/b\n",
+			expected: []domain.CreateURLRequest{
+				{LongURL: "https://example.com/a", ExpiresAt: "24h"},
+				{LongURL: "https://example.com/b"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []*domain.CreateURLRequest
+			mockService := &mockShortenerService{
+				batchFunc: func(ctx context.Context, requests []*domain.CreateURLRequest) (*domain.BatchCreateURLResponse, error) {
+					received = requests
+					return &domain.BatchCreateURLResponse{}, nil
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, tt.contentType)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handleRequest(t, handler.CreateShortURLs, c)
+			assertStatusCode(t, rec, http.StatusOK)
+
+			if len(received) != len(tt.expected) {
+				t.Fatalf("expected %d requests, got %d", len(tt.expected), len(received))
+			}
+			for i, expected := range tt.expected {
+				if !reflect.DeepEqual(*received[i], expected) {
+					t.Errorf("request %d: expected %+v, got %+v", i, expected, *received[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_CreateShortURLs_PerItemResults(t *testing.T) {
+	mockService := &mockShortenerService{
+		batchFunc: func(ctx context.Context, requests []*domain.CreateURLRequest) (*domain.BatchCreateURLResponse, error) {
+			return &domain.BatchCreateURLResponse{
+				Created: 1,
+				Failed:  1,
+				Results: []domain.BatchCreateURLResult{
+					{Index: 0, CreateURLResponse: &domain.CreateURLResponse{ShortCode: "abc123", LongURL: "https://example.com"}},
+					{Index: 1, Error: "invalid URL: not-a-url"},
+				},
+			}, nil
+		},
+	}
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	reqBody := `{"urls": [{"long_url": "https://example.com"}, {"long_url": "not-a-url"}]}`
+	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten/batch", reqBody)
+
+	handleRequest(t, handler.CreateShortURLs, c)
+	assertStatusCode(t, rec, http.StatusOK)
+
+	var response struct {
+		Results []map[string]any `json:"results"`
+	}
+	assertJSONResponse(t, rec, &response)
+
+	if len(response.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(response.Results))
+	}
+	if response.Results[0]["short_code"] != "abc123" || response.Results[0]["error"] != nil {
+		t.Errorf("expected first item to be created, got %v", response.Results[0])
+	}
+	if response.Results[1]["error"] != "invalid URL: not-a-url" || response.Results[1]["short_code"] != nil {
+		t.Errorf("expected second item to fail, got %v", response.Results[1])
+	}
+}
+
+func TestHandler_CreateShortURLs_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		serviceErr     error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "malformed json",
+			contentType:    echo.MIMEApplicationJSON,
+			body:           `{"urls": [`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid request payload",
+		},
+		{
+			name:           "malformed ndjson line",
+			contentType:    "application/x-ndjson",
+			body:           "{\"long_url\": \"https://example.com\"}\n{oops\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "line 2",
+		},
+		{
+			name:           "unsupported content type",
+			contentType:    "application/xml",
+			body:           "<urls/>",
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedError:  "unsupported content type",
+		},
+		{
+			name:           "batch too large",
+			contentType:    echo.MIMEApplicationJSON,
+			body:           `{"urls": [{"long_url": "https://example.com"}]}`,
+			serviceErr:     fmt.Errorf("%w: at most 1000 URLs are allowed per batch", service.ErrInvalidBatch),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "at most 1000 URLs",
+		},
+		{
+			name:           "database failure",
+			contentType:    echo.MIMEApplicationJSON,
+			body:           `{"urls": [{"long_url": "https://example.com"}]}`,
+			serviceErr:     fmt.Errorf("error creating short URLs: %w", service.ErrUnavailable),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedError:  "temporarily unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				batchFunc: func(ctx context.Context, requests []*domain.CreateURLRequest) (*domain.BatchCreateURLResponse, error) {
+					return nil, tt.serviceErr
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, tt.contentType)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handleRequest(t, handler.CreateShortURLs, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
+			assertErrorResponse(t, rec, tt.expectedError)
+		})
+	}
+}
+
+// ------------------------------------------------------------------------------------------
+//                                    TESTS: ListURLs
+// ------------------------------------------------------------------------------------------
+
+func TestHandler_ListURLs(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		serviceErr     error
+		expectedStatus int
+		expectedQuery  *domain.ListURLsQuery
+	}{
+		{
+			name:           "defaults",
+			path:           "/api/urls",
+			expectedStatus: http.StatusOK,
+			expectedQuery:  &domain.ListURLsQuery{},
+		},
+		{
+			name:           "filters and pagination",
+			path:           "/api/urls?sort=clicks&q=promo&domain=example.com&from=2025-12-01&to=2025-12-08T00:00:00Z&limit=50&cursor=abc",
+			expectedStatus: http.StatusOK,
+			expectedQuery: &domain.ListURLsQuery{
+				Sort:        "clicks",
+				Search:      "promo",
+				Domain:      "example.com",
+				CreatedFrom: time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC),
+				CreatedTo:   time.Date(2025, 12, 8, 0, 0, 0, 0, time.UTC),
+				Limit:       50,
+				Cursor:      "abc",
+			},
+		},
+		{
+			name:           "invalid limit",
+			path:           "/api/urls?limit=lots",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid date",
+			path:           "/api/urls?from=yesterday",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid query",
+			path:           "/api/urls?sort=long_url",
+			serviceErr:     fmt.Errorf("%w: unsupported sort long_url", service.ErrInvalidListQuery),
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "database failure",
+			path:           "/api/urls",
+			serviceErr:     fmt.Errorf("error listing short URLs: %w", service.ErrUnavailable),
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				listFunc: func(ctx context.Context, query *domain.ListURLsQuery) (*domain.ListURLsResponse, error) {
+					if tt.serviceErr != nil {
+						return nil, tt.serviceErr
+					}
+					if tt.expectedQuery != nil && *query != *tt.expectedQuery {
+						t.Errorf("expected query %+v, got %+v", *tt.expectedQuery, *query)
+					}
+					return &domain.ListURLsResponse{
+						URLs:       []domain.URLSummary{{ShortCode: "abc123"}},
+						NextCursor: "next",
+					}, nil
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
+
+			rec, c := testRequest(t, e, http.MethodGet, tt.path, "")
+			handleRequest(t, handler.ListURLs, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
+
+			if tt.expectedStatus != http.StatusOK {
+				assertErrorResponse(t, rec, "")
+				return
+			}
+			var response domain.ListURLsResponse
+			assertJSONResponse(t, rec, &response)
+			if len(response.URLs) != 1 || response.NextCursor != "next" {
+				t.Errorf("unexpected response %+v", response)
+			}
+		})
+	}
+}
+
+// ------------------------------------------------------------------------------------------
+//                                    TESTS: Redirect
+// ------------------------------------------------------------------------------------------
+
+func TestHandler_Redirect_Success(t *testing.T) {
+	mockService := &mockShortenerService{
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", RedirectStatus: http.StatusFound}, nil
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
+
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusFound)
+	assertHeader(t, rec, "Location", "https://example.com")
+}
+
+func TestHandler_Redirect_PassesVisitMetadata(t *testing.T) {
+	var received *domain.Visit
+	mockService := &mockShortenerService{
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			received = visit
+			return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", RedirectStatus: http.StatusFound}, nil
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
+	c.Request().Header.Set("Referer", "https://news.example.org/post")
+	c.Request().Header.Set("User-Agent", "Mozilla/5.0 (iPhone)")
+	c.Request().Header.Set("Accept-Language", "es-MX,es;q=0.9")
+	c.Request().Header.Set(echo.HeaderXRealIP, "203.0.113.42")
+
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusFound)
+
+	if received == nil {
+		t.Fatal("expected visit metadata to be passed to the service")
+	}
+	if received.Referrer != "https://news.example.org/post" {
+		t.Errorf("expected referrer 'https://news.example.org/post', got '%s'", received.Referrer)
+	}
+	if received.UserAgent != "Mozilla/5.0 (iPhone)" {
+		t.Errorf("expected user agent 'Mozilla/5.0 (iPhone)', got '%s'", received.UserAgent)
+	}
+	if received.ClientIP != "203.0.113.42" {
+		t.Errorf("expected client IP '203.0.113.42', got '%s'", received.ClientIP)
+	}
+	if received.AcceptLanguage != "es-MX,es;q=0.9" {
+		t.Errorf("expected accept language 'es-MX,es;q=0.9', got '%s'", received.AcceptLanguage)
+	}
+	if received.Probe {
+		t.Error("expected a GET request not to be a probe")
+	}
+
+	_, c = testRequestWithParam(t, e, http.MethodHead, "/abc123", "shortCode", "abc123")
+	handleRequest(t, handler.Redirect, c)
+	if !received.Probe {
+		t.Error("expected a HEAD request to be a probe")
+	}
+}
+
+func TestHandler_Redirect_StickyVariantCookie(t *testing.T) {
+	tests := []struct {
+		name           string
+		sticky         bool
+		cookie         string
+		expectedVisit  int
+		expectedCookie bool
+	}{
+		{name: "sticky link sets the cookie", sticky: true, expectedVisit: 0, expectedCookie: true},
+		{name: "cookie is passed to the service", sticky: true, cookie: "2", expectedVisit: 2, expectedCookie: true},
+		{name: "malformed cookie is ignored", sticky: true, cookie: "b", expectedVisit: 0, expectedCookie: true},
+		{name: "link without sticky variants", sticky: false, expectedVisit: 0, expectedCookie: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+					if visit.Variant != tt.expectedVisit {
+						t.Errorf("expected visit variant %d, got %d", tt.expectedVisit, visit.Variant)
+					}
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com/b",
+						RedirectStatus: http.StatusFound, StickyVariants: tt.sticky, Variant: 2}, nil
+				},
+			}
+
+			handler := NewHandler(mockService)
+			e := setupEcho()
+
+			rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
+			if tt.cookie != "" {
+				c.Request().AddCookie(&http.Cookie{Name: "gosnap_variant_abc123", Value: tt.cookie})
+			}
+
+			handleRequest(t, handler.Redirect, c)
+			assertStatusCode(t, rec, http.StatusFound)
+
+			cookies := rec.Result().Cookies()
+			if !tt.expectedCookie {
+				if len(cookies) != 0 {
+					t.Errorf("expected no cookie, got %v", cookies)
+				}
+				return
+			}
+			if len(cookies) != 1 {
+				t.Fatalf("expected one cookie, got %v", cookies)
+			}
+			cookie := cookies[0]
+			if cookie.Name != "gosnap_variant_abc123" || cookie.Value != "2" || cookie.Path != "/abc123" {
+				t.Errorf("expected variant cookie for variant 2 on /abc123, got %+v", cookie)
+			}
+			if !cookie.HttpOnly || cookie.MaxAge <= 0 {
+				t.Errorf("expected a persistent HttpOnly cookie, got %+v", cookie)
+			}
+		})
+	}
+}
+
+func TestHandler_Redirect_NotFound(t *testing.T) {
+	mockService := &mockShortenerService{
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return nil, service.ErrNotFound
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/notfound", "shortCode", "notfound")
+
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusNotFound)
+	assertErrorResponse(t, rec, "Short URL not found")
+}
+
+func TestHandler_Redirect_Expired(t *testing.T) {
+	mockService := &mockShortenerService{
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return nil, service.ErrLinkExpired
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
+
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusGone)
+	assertErrorResponse(t, rec, "Short URL has expired")
+}
+
+func TestHandler_Redirect_InvalidShortCode(t *testing.T) {
+	mockService := &mockShortenerService{
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return nil, service.ErrInvalidShortCode
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/invalid@code!", "shortCode", "invalid@code!")
+
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusBadRequest)
+	assertProblemCode(t, rec, "invalid_short_code")
+}
+
+func TestHandler_Redirect_EmptyShortCode(t *testing.T) {
+	mockService := &mockShortenerService{
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return nil, service.ErrInvalidShortCode
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/", "shortCode", "")
+
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusBadRequest)
+}
+
+func TestHandler_Redirect_StatusCodes(t *testing.T) {
+	for _, status := range []int{
+		http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect,
+	} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com/hook", RedirectStatus: status}, nil
+				},
+			}
+
+			handler := NewHandler(mockService)
+			e := setupEcho()
+
+			rec, c := testRequestWithParam(t, e, http.MethodPost, "/abc123", "shortCode", "abc123")
+
+			handleRequest(t, handler.Redirect, c)
+			assertStatusCode(t, rec, status)
+			assertHeader(t, rec, "Location", "https://example.com/hook")
+		})
+	}
+}
+
+func TestHandler_Redirect_PreviewEnabled(t *testing.T) {
+	mockService := &mockShortenerService{
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return &domain.URL{ShortCode: shortCode, LongURL: "https://docs.example.com/a?b=<c>", Preview: true}, nil
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
+
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusOK)
+	assertHeader(t, rec, echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "docs.example.com") {
+		t.Errorf("expected the destination host in the page, got %s", body)
+	}
+	if !strings.Contains(body, `href="https://docs.example.com/a?b=%3cc%3e"`) {
+		t.Errorf("expected an escaped continue link to the destination, got %s", body)
+	}
+	if strings.Contains(body, "<c>") {
+		t.Errorf("expected the destination to be escaped, got %s", body)
+	}
+}
+
+func TestSetupRoutes_Preview(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		previewErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "preview page",
+			path:           "/abc123+",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "expired link",
+			path:           "/abc123+",
+			previewErr:     service.ErrLinkExpired,
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "unknown link",
+			path:           "/abc123+",
+			previewErr:     service.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "plain redirect",
+			path:           "/abc123",
+			expectedStatus: http.StatusFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+					if shortCode != "abc123" {
+						t.Errorf("expected short code 'abc123', got '%s'", shortCode)
+					}
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", RedirectStatus: http.StatusFound}, nil
+				},
+				previewFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					if shortCode != "abc123" {
+						t.Errorf("expected short code 'abc123', got '%s'", shortCode)
+					}
+					if tt.previewErr != nil {
+						return nil, tt.previewErr
+					}
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com/landing"}, nil
+				},
+			}
+
+			e := echo.New()
+			SetupRoutes(e, NewHandler(mockService), &mockAuthenticator{})
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assertStatusCode(t, rec, tt.expectedStatus)
+			if tt.expectedStatus == http.StatusOK && !strings.Contains(rec.Body.String(), "https://example.com/landing") {
+				t.Errorf("expected the destination in the preview page, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSetupRoutes_Passthrough(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		expectedPath     string
+		expectedRawQuery string
+		expectedStatus   int
+	}{
+		{
+			name:             "query string",
+			path:             "/abc123?utm_source=x",
+			expectedRawQuery: "utm_source=x",
+			expectedStatus:   http.StatusFound,
+		},
+		{
+			name:             "trailing path",
+			path:             "/abc123/extra/path%2Fpart?q=1",
+			expectedPath:     "extra/path%2Fpart",
+			expectedRawQuery: "q=1",
+			expectedStatus:   http.StatusFound,
+		},
+		{
+			name:           "trailing slash",
+			path:           "/abc123/",
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "preview keeps priority",
+			path:           "/abc123/+",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+					if shortCode != "abc123" {
+						t.Errorf("expected short code 'abc123', got '%s'", shortCode)
+					}
+					if visit.Path != tt.expectedPath {
+						t.Errorf("expected path '%s', got '%s'", tt.expectedPath, visit.Path)
+					}
+					if visit.RawQuery != tt.expectedRawQuery {
+						t.Errorf("expected query '%s', got '%s'", tt.expectedRawQuery, visit.RawQuery)
+					}
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", RedirectStatus: http.StatusFound}, nil
+				},
+				previewFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com"}, nil
+				},
+			}
+
+			e := echo.New()
+			SetupRoutes(e, NewHandler(mockService), &mockAuthenticator{})
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assertStatusCode(t, rec, tt.expectedStatus)
+		})
+	}
+}
+
+func TestSetupRoutes_PasswordProtected(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		path             string
+		password         string
+		expectedStatus   int
+		expectedLocation string
+		expectedBody     string
+	}{
+		{
+			name:           "form is served instead of redirecting",
+			method:         http.MethodGet,
+			path:           "/abc123?ref=mail",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `action="/abc123?ref=mail"`,
+		},
+		{
+			name:           "preview serves the form",
+			method:         http.MethodGet,
+			path:           "/abc123+",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `action="/abc123"`,
+		},
+		{
+			name:             "correct password redirects",
+			method:           http.MethodPost,
+			path:             "/abc123",
+			password:         "s3cret",
+			expectedStatus:   http.StatusSeeOther,
+			expectedLocation: "https://example.com/internal.pdf",
+		},
+		{
+			name:           "wrong password serves the form again",
+			method:         http.MethodPost,
+			path:           "/abc123",
+			password:       "guess",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "Incorrect password",
+		},
+		{
+			name:           "too many attempts",
+			method:         http.MethodPost,
+			path:           "/abc123",
+			password:       "locked",
+			expectedStatus: http.StatusTooManyRequests,
+			expectedBody:   "Too many incorrect passwords",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+					return nil, service.ErrPasswordRequired
+				},
+				previewFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, service.ErrPasswordRequired
+				},
+				unlockFunc: func(ctx context.Context, shortCode, password string, visit *domain.Visit) (*domain.URL, error) {
+					switch password {
+					case "s3cret":
+						return &domain.URL{
+							ShortCode:      shortCode,
+							LongURL:        "https://example.com/internal.pdf",
+							RedirectStatus: http.StatusPermanentRedirect,
+						}, nil
+					case "locked":
+						return nil, service.ErrTooManyAttempts
+					default:
+						return nil, service.ErrWrongPassword
+					}
+				},
+			}
+
+			e := echo.New()
+			SetupRoutes(e, NewHandler(mockService), &mockAuthenticator{})
+
+			form := url.Values{"password": {tt.password}}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assertStatusCode(t, rec, tt.expectedStatus)
+			if location := rec.Header().Get(echo.HeaderLocation); location != tt.expectedLocation {
+				t.Errorf("expected location '%s', got '%s'", tt.expectedLocation, location)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expectedBody) {
+				t.Errorf("expected body to contain '%s', got %s", tt.expectedBody, rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), "internal.pdf") {
+				t.Errorf("expected the destination to stay hidden, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSetupRoutes_PostToUnprotectedLink(t *testing.T) {
+	for _, status := range []int{http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com/hook", RedirectStatus: status}, nil
+				},
+				unlockFunc: func(ctx context.Context, shortCode, password string, visit *domain.Visit) (*domain.URL, error) {
+					t.Error("expected the POST not to be handled as a password form")
+					return nil, service.ErrWrongPassword
+				},
+			}
+
+			e := echo.New()
+			SetupRoutes(e, NewHandler(mockService), &mockAuthenticator{})
+
+			req := httptest.NewRequest(http.MethodPost, "/abc123", strings.NewReader(`{"event":"push"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assertStatusCode(t, rec, status)
+			assertHeader(t, rec, "Location", "https://example.com/hook")
+		})
+	}
+}
+
+func TestSetupRoutes_RedirectMethods(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		t.Run(method, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+					return &domain.URL{
+						ShortCode:      shortCode,
+						LongURL:        "https://example.com/hook/" + visit.Path,
+						RedirectStatus: http.StatusPermanentRedirect,
+					}, nil
+				},
+			}
+
+			e := echo.New()
+			SetupRoutes(e, NewHandler(mockService), &mockAuthenticator{})
+
+			for path, location := range map[string]string{
+				"/abc123":        "https://example.com/hook/",
+				"/abc123/events": "https://example.com/hook/events",
+			} {
+				req := httptest.NewRequest(method, path, strings.NewReader(`{"event":"push"}`))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+
+				assertStatusCode(t, rec, http.StatusPermanentRedirect)
+				assertHeader(t, rec, "Location", location)
+			}
+		})
+	}
+}
+
+// ------------------------------------------------------------------------------------------
+//                                    TESTS: GetStats
+// ------------------------------------------------------------------------------------------
+
+func TestHandler_GetStats_Success(t *testing.T) {
+	expectedTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	mockService := &mockShortenerService{
+		getStatsFunc: func(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error) {
+			return &domain.StatsResponse{
+				ShortCode: shortCode,
+				LongURL:   "https://example.com",
+				Clicks:    42,
+				CreatedAt: expectedTime,
+			}, nil
+		},
+	}
+
+	handler := NewHandler(mockService)
+	e := setupEcho()
+
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/api/stats/abc123", "shortCode", "abc123")
+	c.SetPath("/api/stats/:shortCode")
+
+	handleRequest(t, handler.GetStats, c)
+	assertStatusCode(t, rec, http.StatusOK)
+
+	var response domain.StatsResponse
+	assertJSONResponse(t, rec, &response)
+
+	if response.ShortCode != "abc123" {
+		t.Errorf("expected short_code 'abc123', got '%s'", response.ShortCode)
+	}
+	if response.LongURL != "https://example.com" {
+		t.Errorf("expected long_url 'https://example.com', got '%s'", response.LongURL)
+	}
+	if response.Clicks != 42 {
+		t.Errorf("expected clicks 42, got %d", response.Clicks)
+	}
+}
+
+func TestHandler_GetStats_Series(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		serviceErr     error
+		expectSeries   bool
+		expectedStatus int
+	}{
+		{
+			name:           "no series requested",
+			query:          "",
+			expectSeries:   false,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "daily series with dates",
+			query:          "?from=2024-01-01&to=2024-01-31&interval=day",
+			expectSeries:   true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "series with RFC3339 timestamps",
+			query:          "?from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z&interval=hour",
+			expectSeries:   true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid from date",
+			query:          "?from=yesterday&interval=day",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid interval from service",
+			query:          "?interval=minute",
+			expectSeries:   true,
+			serviceErr:     fmt.Errorf("%w: unsupported interval minute", service.ErrInvalidStatsQuery),
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				getStatsFunc: func(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error) {
+					if (series != nil) != tt.expectSeries {
+						t.Errorf("expected series requested %v, got %v", tt.expectSeries, series != nil)
+					}
+					if tt.serviceErr != nil {
+						return nil, tt.serviceErr
+					}
+					return &domain.StatsResponse{ShortCode: shortCode}, nil
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
+
+			rec, c := testRequestWithParam(t, e, http.MethodGet, "/api/stats/abc123"+tt.query, "shortCode", "abc123")
+			c.SetPath("/api/stats/:shortCode")
 
-	handleRequest(t, handler.CreateShortURL, c)
-	assertStatusCode(t, rec, http.StatusBadRequest)
-	assertErrorResponse(t, rec, "Validation failed")
+			handleRequest(t, handler.GetStats, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
+		})
+	}
 }
 
-func TestHandler_CreateShortURL_ValidationError_InvalidURL(t *testing.T) {
-	mockService := &mockShortenerService{}
+func TestHandler_GetStats_NotFound(t *testing.T) {
+	mockService := &mockShortenerService{
+		getStatsFunc: func(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error) {
+			return nil, service.ErrNotFound
+		},
+	}
+
 	handler := NewHandler(mockService)
 	e := setupEcho()
 
-	reqBody := `{"long_url": "not-a-valid-url"}`
-	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/api/stats/notfound", "shortCode", "notfound")
+	c.SetPath("/api/stats/:shortCode")
 
-	handleRequest(t, handler.CreateShortURL, c)
-	assertStatusCode(t, rec, http.StatusBadRequest)
+	handleRequest(t, handler.GetStats, c)
+	assertStatusCode(t, rec, http.StatusNotFound)
+	assertErrorResponse(t, rec, "Short URL not found")
 }
 
-func TestHandler_CreateShortURL_ServiceError(t *testing.T) {
+func TestHandler_GetStats_InvalidShortCode(t *testing.T) {
 	mockService := &mockShortenerService{
-		createFunc: func(ctx context.Context, longURL string) (*domain.CreateURLResponse, error) {
-			return nil, echo.NewHTTPError(http.StatusInternalServerError, "database connection failed")
+		getStatsFunc: func(ctx context.Context, shortCode string, series *domain.SeriesQuery) (*domain.StatsResponse, error) {
+			return nil, service.ErrInvalidShortCode
 		},
 	}
 
 	handler := NewHandler(mockService)
 	e := setupEcho()
 
-	reqBody := `{"long_url": "https://example.com"}`
-	rec, c := testRequest(t, e, http.MethodPost, "/api/shorten", reqBody)
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/api/stats/invalid@code!", "shortCode", "invalid@code!")
+	c.SetPath("/api/stats/:shortCode")
 
-	handleRequest(t, handler.CreateShortURL, c)
-	assertStatusCode(t, rec, http.StatusInternalServerError)
-	assertErrorResponse(t, rec, "Failed to create short URL")
+	handleRequest(t, handler.GetStats, c)
+	assertStatusCode(t, rec, http.StatusBadRequest)
+	assertErrorResponse(t, rec, "invalid short code format")
 }
 
 // ------------------------------------------------------------------------------------------
-//                                    TESTS: Redirect
+//                                    TESTS: GetQRCode
 // ------------------------------------------------------------------------------------------
 
-func TestHandler_Redirect_Success(t *testing.T) {
-	mockService := &mockShortenerService{
-		getLongFunc: func(ctx context.Context, shortCode string) (string, error) {
-			return "https://example.com", nil
+func TestHandler_GetQRCode(t *testing.T) {
+	margin := 0
+
+	tests := []struct {
+		name           string
+		path           string
+		serviceErr     error
+		expectedStatus int
+		expectedQuery  *domain.QRCodeQuery
+	}{
+		{
+			name:           "defaults",
+			path:           "/api/qr/abc123",
+			expectedStatus: http.StatusOK,
+			expectedQuery:  &domain.QRCodeQuery{},
+		},
+		{
+			name:           "all options",
+			path:           "/api/qr/abc123?size=512&format=svg&level=H&margin=0",
+			expectedStatus: http.StatusOK,
+			expectedQuery:  &domain.QRCodeQuery{Size: 512, Format: "svg", Level: "H", Margin: &margin},
+		},
+		{
+			name:           "invalid size",
+			path:           "/api/qr/abc123?size=big",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid margin",
+			path:           "/api/qr/abc123?margin=wide",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid options",
+			path:           "/api/qr/abc123?format=gif",
+			serviceErr:     fmt.Errorf("%w: unsupported format gif", service.ErrInvalidQRCode),
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "not found",
+			path:           "/api/qr/abc123",
+			serviceErr:     fmt.Errorf("%w: %w", service.ErrNotFound, repo.ErrNotFound),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "rendering failure",
+			path:           "/api/qr/abc123",
+			serviceErr:     fmt.Errorf("error rendering QR code"),
+			expectedStatus: http.StatusInternalServerError,
 		},
 	}
 
-	handler := NewHandler(mockService)
-	e := setupEcho()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				qrFunc: func(ctx context.Context, shortCode string, query *domain.QRCodeQuery) (*domain.QRCode, error) {
+					if tt.serviceErr != nil {
+						return nil, tt.serviceErr
+					}
+					if shortCode != "abc123" {
+						t.Errorf("expected short code 'abc123', got '%s'", shortCode)
+					}
+					if tt.expectedQuery != nil && !reflect.DeepEqual(query, tt.expectedQuery) {
+						t.Errorf("expected query %+v, got %+v", *tt.expectedQuery, *query)
+					}
+					return &domain.QRCode{ContentType: "image/svg+xml", Data: []byte("<svg/>")}, nil
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
 
-	rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
+			rec, c := testRequestWithParam(t, e, http.MethodGet, tt.path, "shortCode", "abc123")
+			c.SetPath("/api/qr/:shortCode")
 
-	handleRequest(t, handler.Redirect, c)
-	assertStatusCode(t, rec, http.StatusFound)
-	assertHeader(t, rec, "Location", "https://example.com")
+			handleRequest(t, handler.GetQRCode, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
+
+			if tt.expectedStatus != http.StatusOK {
+				assertErrorResponse(t, rec, "")
+				return
+			}
+			if contentType := rec.Header().Get(echo.HeaderContentType); contentType != "image/svg+xml" {
+				t.Errorf("expected content type 'image/svg+xml', got '%s'", contentType)
+			}
+			if rec.Body.String() != "<svg/>" {
+				t.Errorf("expected the rendered image as body, got '%s'", rec.Body.String())
+			}
+		})
+	}
 }
 
-func TestHandler_Redirect_NotFound(t *testing.T) {
-	mockService := &mockShortenerService{
-		getLongFunc: func(ctx context.Context, shortCode string) (string, error) {
-			return "", echo.NewHTTPError(http.StatusNotFound, "short URL not found")
+// ------------------------------------------------------------------------------------------
+//                              TESTS: DeleteURL / UpdateURLStatus
+// ------------------------------------------------------------------------------------------
+
+func TestHandler_DeleteURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "success",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "not found",
+			serviceErr:     fmt.Errorf("%w: %w", service.ErrNotFound, repo.ErrNotFound),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid short code",
+			serviceErr:     fmt.Errorf("%w: %w", service.ErrInvalidShortCode, repo.ErrInvalidShortCode),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "service error",
+			serviceErr:     fmt.Errorf("error deleting short URL: %w", service.ErrUnavailable),
+			expectedStatus: http.StatusServiceUnavailable,
 		},
 	}
 
-	handler := NewHandler(mockService)
-	e := setupEcho()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				deleteFunc: func(ctx context.Context, shortCode string) error {
+					return tt.serviceErr
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
 
-	rec, c := testRequestWithParam(t, e, http.MethodGet, "/notfound", "shortCode", "notfound")
+			rec, c := testRequestWithParam(t, e, http.MethodDelete, "/api/urls/abc123", "shortCode", "abc123")
+			c.SetPath("/api/urls/:shortCode")
 
-	handleRequest(t, handler.Redirect, c)
-	assertStatusCode(t, rec, http.StatusNotFound)
-	assertErrorResponse(t, rec, "Short URL not found")
+			handleRequest(t, handler.DeleteURL, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
+		})
+	}
 }
 
-func TestHandler_Redirect_InvalidShortCode(t *testing.T) {
-	mockService := &mockShortenerService{
-		getLongFunc: func(ctx context.Context, shortCode string) (string, error) {
-			return "", echo.NewHTTPError(http.StatusBadRequest, "invalid short code format")
+func TestHandler_UpdateURLStatus(t *testing.T) {
+	tests := []struct {
+		name            string
+		requestBody     string
+		serviceErr      error
+		expectedStatus  int
+		expectedEnabled bool
+	}{
+		{
+			name:            "disable",
+			requestBody:     `{"enabled": false}`,
+			expectedStatus:  http.StatusOK,
+			expectedEnabled: false,
+		},
+		{
+			name:            "enable",
+			requestBody:     `{"enabled": true}`,
+			expectedStatus:  http.StatusOK,
+			expectedEnabled: true,
+		},
+		{
+			name:           "missing enabled field",
+			requestBody:    `{}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "not found",
+			requestBody:    `{"enabled": false}`,
+			serviceErr:     fmt.Errorf("%w: %w", service.ErrNotFound, repo.ErrNotFound),
+			expectedStatus: http.StatusNotFound,
 		},
 	}
 
-	handler := NewHandler(mockService)
-	e := setupEcho()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				enableFunc: func(ctx context.Context, shortCode string, enabled bool) (*domain.StatsResponse, error) {
+					if tt.serviceErr != nil {
+						return nil, tt.serviceErr
+					}
+					return &domain.StatsResponse{ShortCode: shortCode, Enabled: enabled}, nil
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
 
-	rec, c := testRequestWithParam(t, e, http.MethodGet, "/invalid@code!", "shortCode", "invalid@code!")
+			rec, c := testRequest(t, e, http.MethodPatch, "/api/urls/abc123", tt.requestBody)
+			c.SetPath("/api/urls/:shortCode")
+			c.SetParamNames("shortCode")
+			c.SetParamValues("abc123")
 
-	handleRequest(t, handler.Redirect, c)
-	assertStatusCode(t, rec, http.StatusNotFound)
+			handleRequest(t, handler.UpdateURLStatus, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response domain.StatsResponse
+				assertJSONResponse(t, rec, &response)
+				if response.Enabled != tt.expectedEnabled {
+					t.Errorf("expected enabled %v, got %v", tt.expectedEnabled, response.Enabled)
+				}
+			}
+		})
+	}
 }
 
-func TestHandler_Redirect_EmptyShortCode(t *testing.T) {
-	mockService := &mockShortenerService{
-		getLongFunc: func(ctx context.Context, shortCode string) (string, error) {
-			return "", echo.NewHTTPError(http.StatusBadRequest, "invalid short code format")
+func TestHandler_UpdateURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "success",
+			requestBody:    `{"long_url": "https://example.com/new"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing long_url",
+			requestBody:    `{}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid url from service",
+			requestBody:    `{"long_url": "https://example.com/new"}`,
+			serviceErr:     fmt.Errorf("%w: https://", service.ErrInvalidURL),
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "not found",
+			requestBody:    `{"long_url": "https://example.com/new"}`,
+			serviceErr:     fmt.Errorf("%w: %w", service.ErrNotFound, repo.ErrNotFound),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "service error",
+			requestBody:    `{"long_url": "https://example.com/new"}`,
+			serviceErr:     fmt.Errorf("error updating short URL: %w", service.ErrUnavailable),
+			expectedStatus: http.StatusServiceUnavailable,
 		},
 	}
 
-	handler := NewHandler(mockService)
-	e := setupEcho()
-
-	rec, c := testRequestWithParam(t, e, http.MethodGet, "/", "shortCode", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := &mockShortenerService{
+				updateFunc: func(ctx context.Context, shortCode, longURL string) (*domain.StatsResponse, error) {
+					if tt.serviceErr != nil {
+						return nil, tt.serviceErr
+					}
+					return &domain.StatsResponse{ShortCode: shortCode, LongURL: longURL, Enabled: true}, nil
+				},
+			}
+			handler := NewHandler(mockService)
+			e := setupEcho()
 
-	handleRequest(t, handler.Redirect, c)
-	assertStatusCode(t, rec, http.StatusNotFound)
-}
+			rec, c := testRequest(t, e, http.MethodPut, "/api/urls/abc123", tt.requestBody)
+			c.SetPath("/api/urls/:shortCode")
+			c.SetParamNames("shortCode")
+			c.SetParamValues("abc123")
 
-// ------------------------------------------------------------------------------------------
-//                                    TESTS: GetStats
-// ------------------------------------------------------------------------------------------
+			handleRequest(t, handler.UpdateURL, c)
+			assertStatusCode(t, rec, tt.expectedStatus)
 
-func TestHandler_GetStats_Success(t *testing.T) {
-	expectedTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+			if tt.expectedStatus == http.StatusOK {
+				var response domain.StatsResponse
+				assertJSONResponse(t, rec, &response)
+				if response.LongURL != "https://example.com/new" {
+					t.Errorf("expected long_url 'https://example.com/new', got '%s'", response.LongURL)
+				}
+			}
+		})
+	}
+}
 
+func TestHandler_Redirect_Disabled(t *testing.T) {
 	mockService := &mockShortenerService{
-		getStatsFunc: func(ctx context.Context, shortCode string) (*domain.StatsResponse, error) {
-			return &domain.StatsResponse{
-				ShortCode: shortCode,
-				LongURL:   "https://example.com",
-				Clicks:    42,
-				CreatedAt: expectedTime,
-			}, nil
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return nil, service.ErrLinkDisabled
 		},
 	}
 
 	handler := NewHandler(mockService)
 	e := setupEcho()
 
-	rec, c := testRequestWithParam(t, e, http.MethodGet, "/api/stats/abc123", "shortCode", "abc123")
-	c.SetPath("/api/stats/:shortCode")
-
-	handleRequest(t, handler.GetStats, c)
-	assertStatusCode(t, rec, http.StatusOK)
-
-	var response domain.StatsResponse
-	assertJSONResponse(t, rec, &response)
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
 
-	if response.ShortCode != "abc123" {
-		t.Errorf("expected short_code 'abc123', got '%s'", response.ShortCode)
-	}
-	if response.LongURL != "https://example.com" {
-		t.Errorf("expected long_url 'https://example.com', got '%s'", response.LongURL)
-	}
-	if response.Clicks != 42 {
-		t.Errorf("expected clicks 42, got %d", response.Clicks)
-	}
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusGone)
+	assertErrorResponse(t, rec, "Short URL has been disabled")
 }
 
-func TestHandler_GetStats_NotFound(t *testing.T) {
+func TestHandler_Redirect_ClickLimitReached(t *testing.T) {
 	mockService := &mockShortenerService{
-		getStatsFunc: func(ctx context.Context, shortCode string) (*domain.StatsResponse, error) {
-			return nil, echo.NewHTTPError(http.StatusNotFound, "short URL not found")
+		getLongFunc: func(ctx context.Context, shortCode string, visit *domain.Visit) (*domain.URL, error) {
+			return nil, service.ErrClickLimitReached
 		},
 	}
 
 	handler := NewHandler(mockService)
 	e := setupEcho()
 
-	rec, c := testRequestWithParam(t, e, http.MethodGet, "/api/stats/notfound", "shortCode", "notfound")
-	c.SetPath("/api/stats/:shortCode")
+	rec, c := testRequestWithParam(t, e, http.MethodGet, "/abc123", "shortCode", "abc123")
 
-	handleRequest(t, handler.GetStats, c)
-	assertStatusCode(t, rec, http.StatusNotFound)
-	assertErrorResponse(t, rec, "Short URL not found")
+	handleRequest(t, handler.Redirect, c)
+	assertStatusCode(t, rec, http.StatusGone)
+	assertErrorResponse(t, rec, "Short URL has reached its click limit")
 }
 
-func TestHandler_GetStats_InvalidShortCode(t *testing.T) {
-	mockService := &mockShortenerService{
-		getStatsFunc: func(ctx context.Context, shortCode string) (*domain.StatsResponse, error) {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid short code format")
+// ------------------------------------------------------------------------------------------
+//                                 TESTS: HTTPErrorHandler
+// ------------------------------------------------------------------------------------------
+
+func TestHTTPErrorHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+	}{
+		{
+			name:           "invalid short code",
+			err:            fmt.Errorf("%w: %w", service.ErrInvalidShortCode, repo.ErrInvalidShortCode),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_short_code",
+			expectedDetail: "invalid short code format: invalid short code: must be between 1 and 10 characters",
+		},
+		{
+			name:           "not found",
+			err:            fmt.Errorf("%w: %w", service.ErrNotFound, repo.ErrNotFound),
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "not_found",
+			expectedDetail: "Short URL not found",
+		},
+		{
+			name:           "invalid targeting destination",
+			err:            fmt.Errorf("%w: rule 0: %w", service.ErrInvalidTargeting, service.ErrInvalidURL),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "invalid_targeting",
+			expectedDetail: "invalid targeting rule: rule 0: invalid URL",
+		},
+		{
+			name:           "unsafe destination",
+			err:            &service.UnsafeURLError{Code: service.UnsafePrivateAddress, Host: "10.0.0.1"},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   service.UnsafePrivateAddress,
+			expectedDetail: "unsafe URL: 10.0.0.1 is a private, loopback or link-local address",
+		},
+		{
+			name:           "backend outage",
+			err:            fmt.Errorf("error retrieving long URL: %w", service.ErrUnavailable),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "unavailable",
+			expectedDetail: "The service is temporarily unavailable, please try again later",
+		},
+		{
+			name:           "echo error",
+			err:            echo.ErrTooManyRequests,
+			expectedStatus: http.StatusTooManyRequests,
+			expectedDetail: "Too Many Requests",
+		},
+		{
+			name:           "unexpected error",
+			err:            fmt.Errorf("connection refused by 10.0.0.5:5432"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   "internal_error",
 		},
 	}
 
-	handler := NewHandler(mockService)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := setupEcho()
+			rec, c := testRequest(t, e, http.MethodGet, "/abc123?utm_source=mail", "")
+
+			HTTPErrorHandler(tt.err, c)
+
+			assertStatusCode(t, rec, tt.expectedStatus)
+			assertHeader(t, rec, echo.HeaderContentType, problemContentType)
+
+			var problem domain.Problem
+			assertJSONResponse(t, rec, &problem)
+			expected := domain.Problem{
+				Type:     "about:blank",
+				Title:    http.StatusText(tt.expectedStatus),
+				Status:   tt.expectedStatus,
+				Detail:   tt.expectedDetail,
+				Instance: "/abc123",
+				Code:     tt.expectedCode,
+			}
+			if problem != expected {
+				t.Errorf("expected problem %+v, got %+v", expected, problem)
+			}
+		})
+	}
+}
+
+func TestHTTPErrorHandler_Head(t *testing.T) {
 	e := setupEcho()
+	rec, c := testRequest(t, e, http.MethodHead, "/abc123", "")
 
-	rec, c := testRequestWithParam(t, e, http.MethodGet, "/api/stats/invalid@code!", "shortCode", "invalid@code!")
-	c.SetPath("/api/stats/:shortCode")
+	HTTPErrorHandler(service.ErrNotFound, c)
 
-	handleRequest(t, handler.GetStats, c)
 	assertStatusCode(t, rec, http.StatusNotFound)
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected no body for a HEAD request, got '%s'", rec.Body.String())
+	}
 }
 
 // ------------------------------------------------------------------------------------------
@@ -332,11 +1911,11 @@ func TestHandler_CreateShortURL_TableDriven(t *testing.T) {
 			name:        "valid request",
 			requestBody: `{"long_url": "https://example.com"}`,
 			mockService: &mockShortenerService{
-				createFunc: func(ctx context.Context, longURL string) (*domain.CreateURLResponse, error) {
+				createFunc: func(ctx context.Context, request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
 					return &domain.CreateURLResponse{
 						ShortCode: "abc123",
 						ShortURL:  "http://localhost:8080/abc123",
-						LongURL:   longURL,
+						LongURL:   request.LongURL,
 					}, nil
 				},
 			},
@@ -347,32 +1926,32 @@ func TestHandler_CreateShortURL_TableDriven(t *testing.T) {
 			requestBody:    `""`,
 			mockService:    &mockShortenerService{},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "Invalid request payload",
+			expectedError:  "invalid request payload",
 		},
 		{
 			name:           "invalid json",
 			requestBody:    `{"long_url": }`,
 			mockService:    &mockShortenerService{},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "Invalid request payload",
+			expectedError:  "invalid request payload",
 		},
 		{
 			name:           "empty json object",
 			requestBody:    `{}`,
-			expectedStatus: http.StatusBadRequest,
-			expectedError:  "Validation failed",
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "validation failed",
 		},
 		{
 			name:           "malformed json",
 			requestBody:    `{not valid json}`,
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "Invalid request payload",
+			expectedError:  "invalid request payload",
 		},
 		{
 			name:           "missing long_url field",
 			requestBody:    `{"url": "https://example.com"}`,
 			mockService:    &mockShortenerService{},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 	}
 
@@ -402,6 +1981,7 @@ func setupEcho() *echo.Echo {
 	e.Validator = &CustomValidator{
 		validator: validator.New(),
 	}
+	e.HTTPErrorHandler = HTTPErrorHandler
 	return e
 }
 
@@ -453,18 +2033,30 @@ func assertJSONResponse(t *testing.T, rec *httptest.ResponseRecorder, v interfac
 	}
 }
 
-// assertErrorResponse checks if the response contains an error message
+// assertErrorResponse checks if the response is a problem whose detail contains an error message
 func assertErrorResponse(t *testing.T, rec *httptest.ResponseRecorder, expectedMsg string) {
 	t.Helper()
-	var response map[string]string
-	assertJSONResponse(t, rec, &response)
+	assertHeader(t, rec, echo.HeaderContentType, problemContentType)
 
-	if errMsg, exists := response["error"]; exists {
-		if expectedMsg != "" && !strings.Contains(errMsg, expectedMsg) {
-			t.Errorf("expected error containing '%s', got '%s'", expectedMsg, errMsg)
-		}
-	} else {
-		t.Error("expected error field in response")
+	var problem domain.Problem
+	assertJSONResponse(t, rec, &problem)
+
+	if problem.Status != rec.Code {
+		t.Errorf("expected problem status %d, got %d", rec.Code, problem.Status)
+	}
+	if expectedMsg != "" && !strings.Contains(problem.Detail, expectedMsg) {
+		t.Errorf("expected detail containing '%s', got '%s'", expectedMsg, problem.Detail)
+	}
+}
+
+// assertProblemCode checks the machine-readable code of a problem response
+func assertProblemCode(t *testing.T, rec *httptest.ResponseRecorder, expected string) {
+	t.Helper()
+	var problem domain.Problem
+	assertJSONResponse(t, rec, &problem)
+
+	if problem.Code != expected {
+		t.Errorf("expected problem code '%s', got '%s'", expected, problem.Code)
 	}
 }
 
@@ -477,10 +2069,10 @@ func assertHeader(t *testing.T, rec *httptest.ResponseRecorder, header, expected
 	}
 }
 
-// handleRequest executes a handler and returns an error if any
+// handleRequest executes a handler and answers the error it returns, if any, like the server does
 func handleRequest(t *testing.T, handler func(echo.Context) error, c echo.Context) {
 	t.Helper()
 	if err := handler(c); err != nil {
-		t.Fatalf("unexpected error: %v", err)
+		c.Echo().HTTPErrorHandler(err, c)
 	}
 }
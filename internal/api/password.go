@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+
+	"github.com/labstack/echo/v4"
+)
+
+// passwordTemplate is the form visitors of a password protected short URL fill in before being redirected.
+// It shows nothing about the destination.
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>GoSnap - Password required</title>
+<style>
+body { font-family: sans-serif; max-width: 24rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+input { width: 100%; box-sizing: border-box; padding: 0.6rem; margin: 0.5rem 0 1rem; font-size: 1rem; }
+button { padding: 0.6rem 1.2rem; background: #1a73e8; color: #fff; border: none; border-radius: 4px; font-size: 1rem; }
+.error { color: #c5221f; }
+</style>
+</head>
+<body>
+<p>This link is password protected.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// renderPasswordForm answers with the password form, submitted to action, and an optional error message.
+func renderPasswordForm(c echo.Context, status int, action, message string) error {
+	var page bytes.Buffer
+	err := passwordTemplate.Execute(&page, map[string]string{
+		"Action": action,
+		"Error":  message,
+	})
+	if err != nil {
+		return fmt.Errorf("error rendering password form: %w", err)
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	return c.HTMLBlob(status, page.Bytes())
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// previewSuffix is appended to a short URL to see its destination instead of being redirected.
+const previewSuffix = "+"
+
+// previewTemplate is the interstitial page that shows the destination of a short URL.
+// The host is shown on its own so it can't be hidden in a long path or query string.
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>GoSnap - Link preview</title>
+<style>
+body { font-family: sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+.host { font-size: 1.5rem; font-weight: bold; }
+.url { word-break: break-all; color: #555; }
+.continue { display: inline-block; margin-top: 1.5rem; padding: 0.6rem 1.2rem; background: #1a73e8; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+<p>The short link <strong>{{.ShortCode}}</strong> leads to:</p>
+<p class="host">{{.Host}}</p>
+<p class="url">{{.LongURL}}</p>
+<a class="continue" href="{{.LongURL}}" rel="noopener noreferrer">Continue to {{.Host}}</a>
+</body>
+</html>
+`))
+
+// renderPreview answers with the preview page of the given URL.
+func renderPreview(c echo.Context, url *domain.URL) error {
+	var page bytes.Buffer
+	err := previewTemplate.Execute(&page, map[string]string{
+		"ShortCode": url.ShortCode,
+		"Host":      destinationHost(url.LongURL),
+		"LongURL":   url.LongURL,
+	})
+	if err != nil {
+		return fmt.Errorf("error rendering preview page of %s: %w", url.ShortCode, err)
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	return c.HTMLBlob(http.StatusOK, page.Bytes())
+}
+
+// destinationHost returns the host of a long URL, without the port, or the URL itself if it can't be parsed.
+func destinationHost(longURL string) string {
+	parsed, err := url.Parse(longURL)
+	if err != nil || parsed.Hostname() == "" {
+		return longURL
+	}
+
+	return parsed.Hostname()
+}
+
+// rewritePreviewPath rewrites "/:shortCode+" to the "/:shortCode/+" preview route before routing,
+// since the router can't match a path parameter followed by a static suffix.
+func rewritePreviewPath(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := c.Request()
+
+		shortCode, ok := strings.CutSuffix(request.URL.Path, previewSuffix)
+		if ok && len(shortCode) > 1 && strings.LastIndex(shortCode, "/") == 0 {
+			request.URL.Path = shortCode + "/" + previewSuffix
+			request.URL.RawPath = ""
+		}
+
+		return next(c)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -8,6 +9,17 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 )
 
+// redirectMethods are the methods short URLs answer to, so that links redirecting with 307 or 308 keep
+// the method and body of webhooks and forms.
+var redirectMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
 type CustomValidator struct {
 	validator *validator.Validate
 }
@@ -17,14 +29,22 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 }
 
 // SetupRoutes configures the API routes and middleware.
-// It takes an Echo instance and a Handler as parameters.
-// It sets up middlewares for logging, recovery, CORS, and rate limiting.
-// It also defines the routes for health checks, URL shortening, redirection, and statistics retrieval.
-func SetupRoutes(e *echo.Echo, handler *Handler) {
+// It takes an Echo instance, a Handler and the Authenticator of the API keys as parameters.
+// It sets up middlewares for logging, recovery, CORS, and rate limiting, and answers errors with
+// RFC 7807 problem details through HTTPErrorHandler.
+// It also defines the routes for health checks, URL shortening (single and in bulk), redirection (for every
+// method in redirectMethods, with a trailing path for links with passthrough), the password form of protected
+// links, destination previews, statistics retrieval, QR codes, listing, and URL management (destination changes,
+// deletion and disabling).
+// Every route of the /api group requires an API key, while the redirect, the password form, the preview and
+// the health check stay public.
+func SetupRoutes(e *echo.Echo, handler *Handler, authenticator Authenticator) {
 	e.Validator = &CustomValidator{
 		validator: validator.New(),
 	}
+	e.HTTPErrorHandler = HTTPErrorHandler
 
+	e.Pre(rewritePreviewPath)
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
@@ -34,11 +54,20 @@ func SetupRoutes(e *echo.Echo, handler *Handler) {
 	e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(100)))
 
 	e.GET("/health", handler.HealthCheck)
-	e.GET("/:shortCode", handler.Redirect)
+	e.Match(redirectMethods, "/:shortCode", handler.Redirect)
+	e.Match(redirectMethods, "/:shortCode/*", handler.Redirect)
+	// "/:shortCode+", rewritten by rewritePreviewPath
+	e.GET("/:shortCode/"+previewSuffix, handler.Preview)
 
-	api := e.Group("/api")
+	api := e.Group("/api", APIKeyAuth(authenticator))
 	{
 		api.POST("/shorten", handler.CreateShortURL)
+		api.POST("/shorten/batch", handler.CreateShortURLs)
 		api.GET("/stats/:shortCode", handler.GetStats)
+		api.GET("/qr/:shortCode", handler.GetQRCode)
+		api.GET("/urls", handler.ListURLs)
+		api.PUT("/urls/:shortCode", handler.UpdateURL)
+		api.DELETE("/urls/:shortCode", handler.DeleteURL)
+		api.PATCH("/urls/:shortCode", handler.UpdateURLStatus)
 	}
 }
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    BREAKER
+// ----------------------------------------------------------------------------------------
+
+// breaker is a circuit breaker that stops calling a failing backend.
+// It opens after threshold consecutive failures, and once cooldown has passed, lets a single probe call through:
+// the breaker closes again if the probe succeeds and reopens for another cooldown if it fails.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call can be made to the backend. Every allowed call must be followed by
+// Success, Failure or Release, so that a half-open breaker doesn't wait for its probe forever.
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		fallthrough
+	default:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+}
+
+// Success records a successful call, closing the breaker.
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.setState(breakerClosed)
+}
+
+// Failure records a failed call, opening the breaker once there have been threshold of them in a row,
+// or right away for a failed probe.
+func (b *breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+// Release records a call whose outcome says nothing about the backend, such as one canceled by its caller.
+func (b *breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// setState changes the state of the breaker and logs the transition. The lock must be held.
+func (b *breaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	event := log.Info()
+	if state == breakerOpen {
+		event = log.Warn()
+	}
+	event.Str("from", b.state.String()).Str("to", state.String()).Int("failures", b.failures).
+		Msg("Redis circuit breaker changed state")
+	b.state = state
+}
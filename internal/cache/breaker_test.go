@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(2, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatalf("expected call %d to be allowed while closed", i+1)
+		}
+		b.Failure()
+	}
+	if b.Allow() {
+		t.Fatal("expected the breaker to open after 2 failures")
+	}
+
+	now = now.Add(10 * time.Second)
+	if !b.Allow() {
+		t.Fatal("expected a probe once the cooldown has passed")
+	}
+	if b.Allow() {
+		t.Error("expected a single probe at a time")
+	}
+	b.Failure()
+	if b.Allow() {
+		t.Fatal("expected a failed probe to reopen the breaker")
+	}
+
+	now = now.Add(10 * time.Second)
+	if !b.Allow() {
+		t.Fatal("expected a probe once the cooldown has passed again")
+	}
+	b.Release()
+	if !b.Allow() {
+		t.Fatal("expected another probe after a released one")
+	}
+	b.Success()
+	if !b.Allow() || !b.Allow() {
+		t.Error("expected a successful probe to close the breaker")
+	}
+}
+
+func TestBreaker_SuccessResetsFailures(t *testing.T) {
+	b := newBreaker(2, time.Minute)
+
+	b.Failure()
+	b.Success()
+	b.Failure()
+	if !b.Allow() {
+		t.Error("expected failures that aren't in a row not to open the breaker")
+	}
+}
@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/repo"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultTimeout          = 100 * time.Millisecond
+	defaultFailureThreshold = 5
+	defaultCooldown         = 10 * time.Second
+	defaultLocalSize        = 10000
+	defaultLocalTTL         = time.Minute
+)
+
+// errStoreRefused is returned by do when the circuit breaker doesn't let the call through.
+var errStoreRefused = errors.New("cache store unavailable")
+
+// ----------------------------------------------------------------------------------------
+//                                    INTERFACES
+// ----------------------------------------------------------------------------------------
+
+// Store is the shared cache wrapped by Cache, implemented by repo.RedisRepo.
+type Store interface {
+	Get(ctx context.Context, shortCode string) (*domain.URL, error)
+	GetWithTTL(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error)
+	Set(ctx context.Context, shortCode string, url *domain.URL) error
+	Add(ctx context.Context, shortCode string, url *domain.URL) error
+	Replace(ctx context.Context, shortCode string, previous, url *domain.URL) error
+	Delete(ctx context.Context, shortCode string) error
+	Exists(ctx context.Context, shortCode string) (bool, error)
+	SetNotFound(ctx context.Context, shortCode string, ttl time.Duration) error
+	SetDeleted(ctx context.Context, shortCode string, ttl time.Duration) error
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    CACHE
+// ----------------------------------------------------------------------------------------
+
+// Config configures a Cache. Zero fields take their default value.
+type Config struct {
+	// Timeout bounds every call to the store. Defaults to 100ms.
+	Timeout time.Duration
+	// FailureThreshold is the number of failed calls in a row that opens the circuit breaker. Defaults to 5.
+	FailureThreshold int
+	// Cooldown is how long the open circuit breaker refuses calls before probing the store again.
+	// Defaults to 10s.
+	Cooldown time.Duration
+	// LocalSize is the maximum number of URLs cached in process. Defaults to 10000.
+	LocalSize int
+	// LocalTTL is how long a URL is cached in process. Defaults to 1m.
+	LocalTTL time.Duration
+}
+
+// Cache is a two-tier URL cache: the shared store, usually Redis, backed by a bounded in-process LRU.
+// Calls to the store are bounded by a timeout and go through a circuit breaker, so a slow or down store
+// costs at most a few timeouts before lookups go straight to the in-process tier and the database.
+// Every URL set or found in the store is also cached in process, and served from there while the store is
+// unavailable; an in-process entry can therefore lag behind changes made by other instances for up to LocalTTL.
+// Short codes that couldn't be set in or evicted from the store are never read from it until they are evicted,
+// so that the store doesn't serve an outdated URL, or an outdated not found, once it is back.
+type Cache struct {
+	store   Store
+	timeout time.Duration
+	breaker *breaker
+	local   *lru
+
+	mu           sync.Mutex
+	outdated     map[string]bool
+	outdatedFull bool
+}
+
+// New wraps the given store with the given configuration. A nil store caches URLs in process only.
+func New(store Store, config Config) *Cache {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaultCooldown
+	}
+	if config.LocalSize <= 0 {
+		config.LocalSize = defaultLocalSize
+	}
+	if config.LocalTTL <= 0 {
+		config.LocalTTL = defaultLocalTTL
+	}
+
+	return &Cache{
+		store:    store,
+		timeout:  config.Timeout,
+		breaker:  newBreaker(config.FailureThreshold, config.Cooldown),
+		local:    newLRU(config.LocalSize, config.LocalTTL),
+		outdated: make(map[string]bool),
+	}
+}
+
+// Get retrieves the URL cached for the given short code, from the store first and from the in-process tier
+// when the store is unavailable or outdated for the short code. A miss returns repo.ErrNotFound, and a short code
+// cached as not found repo.ErrCachedNotFound.
+func (c *Cache) Get(ctx context.Context, shortCode string) (*domain.URL, error) {
+
+	url, _, err := c.GetWithTTL(ctx, shortCode)
+	return url, err
+}
+
+// GetWithTTL retrieves the URL cached for the given short code like Get, along with the time left before
+// its entry in the store expires. The TTL is zero for URLs served by the in-process tier.
+func (c *Cache) GetWithTTL(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error) {
+
+	if c.isOutdated(shortCode) {
+		// Only the in-process tier, refreshed since the short code was evicted, is up to date
+		_ = c.evict(ctx, shortCode)
+	} else {
+		var url *domain.URL
+		var ttl time.Duration
+		err := c.do(ctx, func(ctx context.Context) (err error) {
+			url, ttl, err = c.store.GetWithTTL(ctx, shortCode)
+			return err
+		})
+		if err == nil {
+			c.local.Set(shortCode, url)
+			return url, ttl, nil
+		}
+		if errors.Is(err, repo.ErrNotFound) || errors.Is(err, repo.ErrInvalidShortCode) {
+			return nil, 0, err
+		}
+	}
+
+	url, ok := c.local.Get(shortCode)
+	if !ok {
+		return nil, 0, repo.ErrNotFound
+	}
+	if url == nil {
+		return nil, 0, repo.ErrCachedNotFound
+	}
+
+	return url, 0, nil
+}
+
+// Set caches the URL for the given short code, in process and in the store.
+// While the store is unavailable, the URL is only cached in process and no error is returned.
+func (c *Cache) Set(ctx context.Context, shortCode string, url *domain.URL) error {
+
+	c.local.Set(shortCode, url)
+
+	return c.write(ctx, shortCode, func(ctx context.Context) error {
+		return c.store.Set(ctx, shortCode, url)
+	})
+}
+
+// Add caches the URL for the given short code like Set, but only where nothing is cached for it yet,
+// see repo.RedisRepo.Add. While the short code is outdated in the store, it is only cached in process,
+// since the outdated entry would be kept.
+func (c *Cache) Add(ctx context.Context, shortCode string, url *domain.URL) error {
+
+	c.local.Add(shortCode, url)
+	if c.isOutdated(shortCode) {
+		return nil
+	}
+
+	return c.write(ctx, shortCode, func(ctx context.Context) error {
+		return c.store.Add(ctx, shortCode, url)
+	})
+}
+
+// Replace caches the URL for the given short code in the store if its entry there is still the previous URL,
+// see repo.RedisRepo.Replace. The in-process tier is left as is, since it is refreshed by every store hit.
+func (c *Cache) Replace(ctx context.Context, shortCode string, previous, url *domain.URL) error {
+
+	err := c.do(ctx, func(ctx context.Context) error {
+		return c.store.Replace(ctx, shortCode, previous, url)
+	})
+	if errors.Is(err, errStoreRefused) {
+		return nil
+	}
+
+	return err
+}
+
+// SetNotFound caches the given short code as not found for the given TTL, in process and in the store.
+// While the store is unavailable, it is only cached in process and no error is returned.
+func (c *Cache) SetNotFound(ctx context.Context, shortCode string, ttl time.Duration) error {
+
+	c.local.SetNotFound(shortCode, ttl)
+
+	return c.write(ctx, shortCode, func(ctx context.Context) error {
+		return c.store.SetNotFound(ctx, shortCode, ttl)
+	})
+}
+
+// SetDeleted caches the given short code as not found for the given TTL like SetNotFound, but replaces any URL
+// cached for it, see repo.RedisRepo.SetDeleted.
+// While the store is unavailable, it is only cached in process and no error is returned.
+func (c *Cache) SetDeleted(ctx context.Context, shortCode string, ttl time.Duration) error {
+
+	c.local.SetNotFound(shortCode, ttl)
+
+	return c.write(ctx, shortCode, func(ctx context.Context) error {
+		return c.store.SetDeleted(ctx, shortCode, ttl)
+	})
+}
+
+// Delete removes the URL cached for the given short code, in process and in the store.
+// While the store is unavailable, the short code is remembered and evicted from the store once it is back,
+// and no error is returned.
+func (c *Cache) Delete(ctx context.Context, shortCode string) error {
+
+	c.local.Delete(shortCode)
+
+	err := c.evict(ctx, shortCode)
+	if errors.Is(err, errStoreRefused) {
+		return nil
+	}
+
+	return err
+}
+
+// Exists checks if a URL is cached for the given short code, in the store first and in process when
+// the store is unavailable.
+func (c *Cache) Exists(ctx context.Context, shortCode string) (bool, error) {
+
+	if !c.isOutdated(shortCode) {
+		var exists bool
+		err := c.do(ctx, func(ctx context.Context) (err error) {
+			exists, err = c.store.Exists(ctx, shortCode)
+			return err
+		})
+		if err == nil || errors.Is(err, repo.ErrInvalidShortCode) {
+			return exists, err
+		}
+	}
+
+	url, _ := c.local.Get(shortCode)
+	return url != nil, nil
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE METHODS
+// ----------------------------------------------------------------------------------------
+
+// do calls the store through the circuit breaker, with the call timeout. It returns errStoreRefused without
+// calling the store if there is none or the breaker is open.
+// Misses and invalid short codes count as successful calls, and calls canceled by the caller aren't counted.
+func (c *Cache) do(ctx context.Context, call func(ctx context.Context) error) error {
+
+	if c.store == nil || !c.breaker.Allow() {
+		return errStoreRefused
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	err := call(callCtx)
+	switch {
+	case err == nil || errors.Is(err, repo.ErrNotFound) || errors.Is(err, repo.ErrInvalidShortCode):
+		c.breaker.Success()
+	case ctx.Err() != nil:
+		c.breaker.Release()
+	default:
+		c.breaker.Failure()
+	}
+
+	return err
+}
+
+// write sets the entry of the given short code in the store with call. If that fails, the short code is
+// marked as outdated in the store, and the error is returned unless the store was unavailable.
+func (c *Cache) write(ctx context.Context, shortCode string, call func(ctx context.Context) error) error {
+
+	err := c.do(ctx, call)
+	if err == nil {
+		c.setOutdated(shortCode, false)
+		return nil
+	}
+	c.setOutdated(shortCode, c.store != nil && !errors.Is(err, repo.ErrInvalidShortCode))
+	if errors.Is(err, errStoreRefused) {
+		return nil
+	}
+
+	return err
+}
+
+// evict removes the given short code from the store, and marks it as outdated until that succeeds.
+func (c *Cache) evict(ctx context.Context, shortCode string) error {
+
+	err := c.do(ctx, func(ctx context.Context) error {
+		return c.store.Delete(ctx, shortCode)
+	})
+	c.setOutdated(shortCode, err != nil && !errors.Is(err, repo.ErrInvalidShortCode) && c.store != nil)
+
+	return err
+}
+
+func (c *Cache) isOutdated(shortCode string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.outdated[shortCode]
+}
+
+// setOutdated marks or unmarks the given short code as outdated in the store. At most as many short codes
+// as the in-process tier holds are remembered, beyond which the store may serve them until they expire;
+// this is logged once each time the limit is reached.
+func (c *Cache) setOutdated(shortCode string, outdated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !outdated {
+		delete(c.outdated, shortCode)
+		c.outdatedFull = false
+		return
+	}
+	if !c.outdated[shortCode] && len(c.outdated) >= c.local.size {
+		if !c.outdatedFull {
+			log.Warn().Int("short_codes", len(c.outdated)).Msg("too many short codes outdated in Redis, " +
+				"it may serve outdated entries for the next ones until they expire")
+			c.outdatedFull = true
+		}
+		return
+	}
+	c.outdated[shortCode] = true
+}
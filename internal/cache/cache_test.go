@@ -0,0 +1,371 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/repo"
+)
+
+// fakeStore is an in-memory Store whose calls fail with err, if set.
+type fakeStore struct {
+	urls     map[string]*domain.URL
+	notFound map[string]bool
+	err      error
+	delay    time.Duration
+	calls    int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{urls: make(map[string]*domain.URL), notFound: make(map[string]bool)}
+}
+
+func (s *fakeStore) call(ctx context.Context) error {
+	s.calls++
+	if s.delay > 0 {
+		select {
+		case <-time.After(s.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.err
+}
+
+func (s *fakeStore) Get(ctx context.Context, shortCode string) (*domain.URL, error) {
+	url, _, err := s.GetWithTTL(ctx, shortCode)
+	return url, err
+}
+
+func (s *fakeStore) GetWithTTL(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error) {
+	if err := s.call(ctx); err != nil {
+		return nil, 0, err
+	}
+	if s.notFound[shortCode] {
+		return nil, 0, repo.ErrCachedNotFound
+	}
+	url, ok := s.urls[shortCode]
+	if !ok {
+		return nil, 0, repo.ErrNotFound
+	}
+	copied := *url
+	return &copied, time.Hour, nil
+}
+
+func (s *fakeStore) Set(ctx context.Context, shortCode string, url *domain.URL) error {
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+	copied := *url
+	s.urls[shortCode] = &copied
+	delete(s.notFound, shortCode)
+	return nil
+}
+
+func (s *fakeStore) Add(ctx context.Context, shortCode string, url *domain.URL) error {
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+	if _, ok := s.urls[shortCode]; !ok && !s.notFound[shortCode] {
+		copied := *url
+		s.urls[shortCode] = &copied
+	}
+	return nil
+}
+
+func (s *fakeStore) Replace(ctx context.Context, shortCode string, previous, url *domain.URL) error {
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+	if cached, ok := s.urls[shortCode]; ok && reflect.DeepEqual(cached, previous) {
+		copied := *url
+		s.urls[shortCode] = &copied
+	}
+	return nil
+}
+
+func (s *fakeStore) Delete(ctx context.Context, shortCode string) error {
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+	delete(s.urls, shortCode)
+	delete(s.notFound, shortCode)
+	return nil
+}
+
+func (s *fakeStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	if err := s.call(ctx); err != nil {
+		return false, err
+	}
+	_, ok := s.urls[shortCode]
+	return ok, nil
+}
+
+func (s *fakeStore) SetNotFound(ctx context.Context, shortCode string, ttl time.Duration) error {
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+	if _, ok := s.urls[shortCode]; !ok {
+		s.notFound[shortCode] = true
+	}
+	return nil
+}
+
+func (s *fakeStore) SetDeleted(ctx context.Context, shortCode string, ttl time.Duration) error {
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+	delete(s.urls, shortCode)
+	s.notFound[shortCode] = true
+	return nil
+}
+
+func TestCache_StoreAvailable(t *testing.T) {
+	store := newFakeStore()
+	store.urls["abc123"] = &domain.URL{ShortCode: "abc123", LongURL: "https://example.com"}
+	cache := New(store, Config{})
+	ctx := context.Background()
+
+	url, ttl, err := cache.GetWithTTL(ctx, "abc123")
+	if err != nil || url.LongURL != "https://example.com" {
+		t.Fatalf("expected the URL from the store, got %v, %v", url, err)
+	}
+	if ttl != time.Hour {
+		t.Errorf("expected the TTL of the store entry, got %s", ttl)
+	}
+	if _, err := cache.Get(ctx, "zzz999"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a miss, got %v", err)
+	}
+
+	if err := cache.Set(ctx, "def456", &domain.URL{LongURL: "https://example.org"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists, err := cache.Exists(ctx, "def456"); err != nil || !exists {
+		t.Errorf("expected def456 to exist in the store, got %v, %v", exists, err)
+	}
+
+	if err := cache.Delete(ctx, "abc123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.urls["abc123"]; ok {
+		t.Error("expected the URL to be deleted from the store")
+	}
+}
+
+func TestCache_StoreDown(t *testing.T) {
+	store := newFakeStore()
+	cache := New(store, Config{FailureThreshold: 2, Cooldown: time.Minute})
+	ctx := context.Background()
+
+	if err := cache.Set(ctx, "abc123", &domain.URL{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store.err = errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		url, ttl, err := cache.GetWithTTL(ctx, "abc123")
+		if err != nil || url.LongURL != "https://example.com" {
+			t.Fatalf("expected the URL from the in-process tier, got %v, %v", url, err)
+		}
+		if ttl != 0 {
+			t.Errorf("expected no TTL for the in-process tier, got %s", ttl)
+		}
+	}
+
+	calls := store.calls
+	if _, err := cache.Get(ctx, "abc123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cache.Get(ctx, "zzz999"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an in-process miss, got %v", err)
+	}
+	if err := cache.Set(ctx, "def456", &domain.URL{LongURL: "https://example.org"}); err != nil {
+		t.Errorf("expected no error while the breaker is open, got %v", err)
+	}
+	if store.calls != calls {
+		t.Errorf("expected the store not to be called while the breaker is open, got %d calls", store.calls-calls)
+	}
+	if url, err := cache.Get(ctx, "def456"); err != nil || url.LongURL != "https://example.org" {
+		t.Errorf("expected the URL set while the store is down, got %v, %v", url, err)
+	}
+}
+
+func TestCache_Timeout(t *testing.T) {
+	store := newFakeStore()
+	store.delay = time.Second
+	cache := New(store, Config{Timeout: 10 * time.Millisecond, FailureThreshold: 1, Cooldown: time.Minute})
+
+	start := time.Now()
+	if _, err := cache.Get(context.Background(), "abc123"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the call to time out, took %s", elapsed)
+	}
+	if cache.breaker.state != breakerOpen {
+		t.Error("expected the timeout to open the breaker")
+	}
+}
+
+func TestCache_CanceledCallsDontOpenBreaker(t *testing.T) {
+	store := newFakeStore()
+	store.delay = time.Second
+	cache := New(store, Config{FailureThreshold: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _ = cache.Get(ctx, "abc123")
+
+	if cache.breaker.state != breakerClosed {
+		t.Error("expected a call canceled by the caller not to count as a failure")
+	}
+}
+
+func TestCache_OutdatedAfterFailedDelete(t *testing.T) {
+	store := newFakeStore()
+	store.urls["abc123"] = &domain.URL{LongURL: "https://example.com"}
+	now := time.Now()
+	cache := New(store, Config{FailureThreshold: 1, Cooldown: time.Second})
+	cache.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.err = errors.New("connection refused")
+	if err := cache.Delete(ctx, "abc123"); err == nil {
+		t.Error("expected the failed eviction to be reported")
+	}
+	store.err = nil
+	now = now.Add(time.Second)
+
+	if _, err := cache.Get(ctx, "abc123"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected the outdated URL not to be served once the store is back, got %v", err)
+	}
+	if _, ok := store.urls["abc123"]; ok {
+		t.Error("expected the outdated URL to be evicted from the store once it is back")
+	}
+	if cache.isOutdated("abc123") {
+		t.Error("expected the short code not to be outdated after its eviction")
+	}
+}
+
+func TestCache_WithoutStore(t *testing.T) {
+	cache := New(nil, Config{})
+	ctx := context.Background()
+
+	if err := cache.Set(ctx, "abc123", &domain.URL{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if url, err := cache.Get(ctx, "abc123"); err != nil || url.LongURL != "https://example.com" {
+		t.Errorf("expected the URL from the in-process tier, got %v, %v", url, err)
+	}
+	if err := cache.Delete(ctx, "abc123"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if exists, _ := cache.Exists(ctx, "abc123"); exists {
+		t.Error("expected the URL to be deleted")
+	}
+	if cache.isOutdated("abc123") {
+		t.Error("expected no short code to be outdated without a store")
+	}
+}
+
+func TestCache_NotFound(t *testing.T) {
+	store := newFakeStore()
+	now := time.Now()
+	cache := New(store, Config{FailureThreshold: 1, Cooldown: time.Second})
+	cache.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := cache.SetNotFound(ctx, "abc123", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cache.Get(ctx, "abc123"); !errors.Is(err, repo.ErrCachedNotFound) {
+		t.Errorf("expected ErrCachedNotFound from the store, got %v", err)
+	}
+
+	store.err = errors.New("connection refused")
+	if _, err := cache.Get(ctx, "abc123"); !errors.Is(err, repo.ErrCachedNotFound) {
+		t.Errorf("expected ErrCachedNotFound from the in-process tier, got %v", err)
+	}
+	if exists, _ := cache.Exists(ctx, "abc123"); exists {
+		t.Error("expected a short code cached as not found not to exist")
+	}
+
+	// Creating the short code while the store is down must not leave it cached as not found there
+	if err := cache.Set(ctx, "abc123", &domain.URL{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("expected no error while the breaker is open, got %v", err)
+	}
+	store.err = nil
+	now = now.Add(time.Second)
+
+	url, err := cache.Get(ctx, "abc123")
+	if err != nil || url.LongURL != "https://example.com" {
+		t.Errorf("expected the created URL once the store is back, got %v, %v", url, err)
+	}
+	if store.notFound["abc123"] {
+		t.Error("expected the outdated not found entry to be evicted from the store")
+	}
+}
+
+func TestCache_AddDoesNotReplace(t *testing.T) {
+	store := newFakeStore()
+	cache := New(store, Config{})
+	ctx := context.Background()
+
+	// A lookup that read the URL before its change fills the cache after the change was written
+	if err := cache.Set(ctx, "abc123", &domain.URL{LongURL: "https://example.com/new"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cache.Add(ctx, "abc123", &domain.URL{LongURL: "https://example.com/old"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if url, err := cache.Get(ctx, "abc123"); err != nil || url.LongURL != "https://example.com/new" {
+		t.Errorf("expected the changed URL to be kept, got %v, %v", url, err)
+	}
+
+	if err := cache.SetDeleted(ctx, "abc123", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cache.Add(ctx, "abc123", &domain.URL{LongURL: "https://example.com/new"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cache.Get(ctx, "abc123"); !errors.Is(err, repo.ErrCachedNotFound) {
+		t.Errorf("expected the deleted short code to stay cached as not found, got %v", err)
+	}
+
+	if err := cache.Add(ctx, "def456", &domain.URL{LongURL: "https://example.com/def"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if url, err := cache.Get(ctx, "def456"); err != nil || url.LongURL != "https://example.com/def" {
+		t.Errorf("expected a cache miss to be filled, got %v, %v", url, err)
+	}
+}
+
+func TestCache_Replace(t *testing.T) {
+	store := newFakeStore()
+	store.urls["abc123"] = &domain.URL{LongURL: "https://example.com/cached"}
+	cache := New(store, Config{})
+	ctx := context.Background()
+
+	cached, err := cache.Get(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cache.Replace(ctx, "abc123", cached, &domain.URL{LongURL: "https://example.com/fresh"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.urls["abc123"].LongURL != "https://example.com/fresh" {
+		t.Errorf("expected the unchanged entry to be replaced, got %s", store.urls["abc123"].LongURL)
+	}
+
+	// The entry changed since it was read, so the refresh is dropped
+	if err := cache.Replace(ctx, "abc123", cached, &domain.URL{LongURL: "https://example.com/stale"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.urls["abc123"].LongURL != "https://example.com/fresh" {
+		t.Errorf("expected the changed entry to be kept, got %s", store.urls["abc123"].LongURL)
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+)
+
+// ----------------------------------------------------------------------------------------
+//                                    LRU
+// ----------------------------------------------------------------------------------------
+
+// lru is a bounded in-process cache of URLs. Once it holds size entries, the least recently used one
+// is evicted, and entries expire after ttl, or at the expiration of their URL if it comes first.
+// Short codes can also be cached as not found.
+// URLs are copied in and out, since callers modify the URLs they get.
+type lru struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	shortCode string
+	url       domain.URL
+	notFound  bool
+	expiresAt time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// Get returns a copy of the URL cached for the given short code, or false if there is none or it expired.
+// A short code cached as not found returns a nil URL and true.
+func (c *lru) Get(shortCode string) (*domain.URL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[shortCode]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	if entry.notFound {
+		return nil, true
+	}
+
+	url := entry.url
+	return &url, true
+}
+
+// Set caches a copy of the URL for the given short code. URLs that have already expired are removed instead.
+func (c *lru) Set(shortCode string, url *domain.URL) {
+	c.put(c.newEntry(shortCode, url), true)
+}
+
+// Add caches a copy of the URL for the given short code like Set, unless an entry that hasn't expired yet is
+// already cached for it, even as not found.
+func (c *lru) Add(shortCode string, url *domain.URL) {
+	c.put(c.newEntry(shortCode, url), false)
+}
+
+// SetNotFound caches the given short code as not found, for ttl or the TTL of the cache if it is shorter.
+func (c *lru) SetNotFound(shortCode string, ttl time.Duration) {
+	c.put(&lruEntry{shortCode: shortCode, notFound: true, expiresAt: c.now().Add(min(ttl, c.ttl))}, true)
+}
+
+// Delete removes the URL cached for the given short code, if any.
+func (c *lru) Delete(shortCode string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[shortCode]; ok {
+		c.remove(element)
+	}
+}
+
+// Len returns the number of cached entries, including the expired ones not removed yet.
+func (c *lru) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// newEntry builds the entry of a copy of the URL for the given short code, which expires after the TTL of
+// the cache or at the expiration of the URL if it comes first.
+func (c *lru) newEntry(shortCode string, url *domain.URL) *lruEntry {
+	expiresAt := c.now().Add(c.ttl)
+	if url.ExpiresAt != nil && url.ExpiresAt.Before(expiresAt) {
+		expiresAt = *url.ExpiresAt
+	}
+
+	return &lruEntry{shortCode: shortCode, url: *url, expiresAt: expiresAt}
+}
+
+// put stores an entry, replacing the one of its short code, and evicts the least recently used entry if
+// the cache is full. Entries that have already expired are removed instead.
+// Without replace, nothing is stored if the short code already has an entry that hasn't expired.
+func (c *lru) put(entry *lruEntry, replace bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[entry.shortCode]
+	if ok && !replace && c.now().Before(element.Value.(*lruEntry).expiresAt) {
+		return
+	}
+	if !c.now().Before(entry.expiresAt) {
+		if ok {
+			c.remove(element)
+		}
+		return
+	}
+	if ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[entry.shortCode] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops an entry from the cache. The lock must be held.
+func (c *lru) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).shortCode)
+}
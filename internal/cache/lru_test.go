@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+)
+
+func TestLRU_Eviction(t *testing.T) {
+	cache := newLRU(2, time.Minute)
+
+	cache.Set("aaa111", &domain.URL{LongURL: "https://example.com/a"})
+	cache.Set("bbb222", &domain.URL{LongURL: "https://example.com/b"})
+	if _, ok := cache.Get("aaa111"); !ok {
+		t.Fatal("expected aaa111 to be cached")
+	}
+	cache.Set("ccc333", &domain.URL{LongURL: "https://example.com/c"})
+
+	if _, ok := cache.Get("bbb222"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, ok := cache.Get("aaa111"); !ok {
+		t.Error("expected the recently used entry to be kept")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.Len())
+	}
+
+	cache.Delete("aaa111")
+	if _, ok := cache.Get("aaa111"); ok {
+		t.Error("expected the deleted entry to be gone")
+	}
+}
+
+func TestLRU_Expiration(t *testing.T) {
+	now := time.Now()
+	cache := newLRU(10, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	expiresAt := now.Add(30 * time.Second)
+	cache.Set("aaa111", &domain.URL{LongURL: "https://example.com/a"})
+	cache.Set("bbb222", &domain.URL{LongURL: "https://example.com/b", ExpiresAt: &expiresAt})
+
+	now = now.Add(30 * time.Second)
+	if _, ok := cache.Get("bbb222"); ok {
+		t.Error("expected the entry to expire with its URL")
+	}
+	if _, ok := cache.Get("aaa111"); !ok {
+		t.Error("expected the entry to be kept until its TTL")
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, ok := cache.Get("aaa111"); ok {
+		t.Error("expected the entry to expire after its TTL")
+	}
+
+	cache.Set("ccc333", &domain.URL{LongURL: "https://example.com/c", ExpiresAt: &expiresAt})
+	if cache.Len() != 0 {
+		t.Errorf("expected an expired URL not to be cached, got %d entries", cache.Len())
+	}
+}
+
+func TestLRU_Copies(t *testing.T) {
+	cache := newLRU(10, time.Minute)
+
+	url := &domain.URL{LongURL: "https://example.com"}
+	cache.Set("aaa111", url)
+	url.LongURL = "https://example.com/changed"
+
+	cached, _ := cache.Get("aaa111")
+	cached.LongURL = "https://example.com/variant"
+
+	if cached, _ := cache.Get("aaa111"); cached.LongURL != "https://example.com" {
+		t.Errorf("expected the cached URL to be unaffected by changes, got '%s'", cached.LongURL)
+	}
+}
+
+func TestLRU_NotFound(t *testing.T) {
+	now := time.Now()
+	cache := newLRU(10, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.SetNotFound("aaa111", 10*time.Second)
+	if url, ok := cache.Get("aaa111"); !ok || url != nil {
+		t.Errorf("expected the short code to be cached as not found, got %v, %v", url, ok)
+	}
+
+	now = now.Add(10 * time.Second)
+	if _, ok := cache.Get("aaa111"); ok {
+		t.Error("expected the not found entry to expire after its TTL")
+	}
+
+	cache.SetNotFound("bbb222", time.Hour)
+	cache.Set("bbb222", &domain.URL{LongURL: "https://example.com/b"})
+	if url, ok := cache.Get("bbb222"); !ok || url == nil {
+		t.Error("expected the URL to replace the not found entry")
+	}
+}
+
+func TestLRU_Add(t *testing.T) {
+	now := time.Now()
+	cache := newLRU(10, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Add("aaa111", &domain.URL{LongURL: "https://example.com/a"})
+	cache.Add("aaa111", &domain.URL{LongURL: "https://example.com/stale"})
+	if url, ok := cache.Get("aaa111"); !ok || url.LongURL != "https://example.com/a" {
+		t.Errorf("expected the first URL to be kept, got %v, %v", url, ok)
+	}
+
+	cache.SetNotFound("bbb222", time.Hour)
+	cache.Add("bbb222", &domain.URL{LongURL: "https://example.com/b"})
+	if url, ok := cache.Get("bbb222"); !ok || url != nil {
+		t.Errorf("expected the not found entry to be kept, got %v, %v", url, ok)
+	}
+
+	now = now.Add(time.Minute)
+	cache.Add("aaa111", &domain.URL{LongURL: "https://example.com/fresh"})
+	if url, ok := cache.Get("aaa111"); !ok || url.LongURL != "https://example.com/fresh" {
+		t.Errorf("expected the URL to replace the expired entry, got %v, %v", url, ok)
+	}
+}
@@ -4,29 +4,326 @@ import "time"
 
 // URL Represents a shortened URL entity
 type URL struct {
-	ID        int64     `json:"id"`
-	ShortCode string    `json:"short_code"`
-	LongURL   string    `json:"long_url"`
-	CreatedAt time.Time `json:"created_at"`
-	Clicks    int64     `json:"clicks"`
+	ID         int64      `json:"id"`
+	ShortCode  string     `json:"short_code"`
+	LongURL    string     `json:"long_url"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Clicks     int64      `json:"clicks"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	OwnerID    *int64     `json:"owner_id,omitempty"`
+	// Preview shows an interstitial page with the destination instead of redirecting immediately
+	Preview bool `json:"preview,omitempty"`
+	// RedirectStatus is 301, 302, 307 or 308; zero uses the server default
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Passthrough forwards the query string and trailing path of the request to the long URL
+	Passthrough bool `json:"passthrough,omitempty"`
+	// PasswordHash is the bcrypt hash of the password visitors must give; empty for public links
+	PasswordHash string `json:"password_hash,omitempty"`
+	// MaxClicks is the number of redirects after which the URL stops redirecting; zero means unlimited
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	// Targeting sends visitors that match a rule to its destination instead; the first matching rule wins
+	Targeting []TargetingRule `json:"targeting,omitempty"`
+	// Variants splits visitors between several destinations by weight instead of sending them to the long URL
+	Variants []Variant `json:"variants,omitempty"`
+	// StickyVariants sends returning visitors to the variant they were first assigned, through a cookie
+	StickyVariants bool `json:"sticky_variants,omitempty"`
+	// Variant is the 1-based index of the variant chosen for the current visit; zero when none was.
+	// It is set on the URL returned for a redirect and never stored
+	Variant int `json:"-"`
+}
+
+// Variant Represents one of the destinations of an A/B split.
+// Each visit goes to a variant with a probability of its weight over the sum of the weights
+type Variant struct {
+	LongURL string `json:"long_url"`
+	Weight  int    `json:"weight"`
+}
+
+// TargetingRule Represents a condition on the visitor that sends them to another destination than the long URL.
+// Match is "device" (mobile, tablet, desktop or bot), "os" (ios, android, windows, macos or linux),
+// "language" (the preferred language of Accept-Language, where "es" also matches "es-MX") or "country"
+// (ISO 3166-1 alpha-2 code of the client IP). The rule applies when the visitor matches any of Values
+type TargetingRule struct {
+	Match   string   `json:"match"`
+	Values  []string `json:"values"`
+	LongURL string   `json:"long_url"`
+}
+
+// IsExhausted reports whether the URL has a click limit that it has already reached.
+// Clicks may lag behind the stored counter, so a URL that isn't exhausted here may still be in the database
+func (u *URL) IsExhausted() bool {
+	return u.MaxClicks > 0 && u.Clicks >= u.MaxClicks
+}
+
+// IsProtected reports whether visitors must give a password to be redirected
+func (u *URL) IsProtected() bool {
+	return u.PasswordHash != ""
+}
+
+// IsDisabled reports whether the URL has been disabled
+func (u *URL) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// IsExpired reports whether the URL has an expiration time that is already in the past
+func (u *URL) IsExpired() bool {
+	return u.ExpiresAt != nil && !time.Now().Before(*u.ExpiresAt)
+}
+
+// APIKey Represents a key that authenticates requests to the API on behalf of an owner.
+// Only the SHA-256 hash of the key is stored
+type APIKey struct {
+	ID        int64      `json:"id"`
+	OwnerID   int64      `json:"owner_id"`
+	Name      string     `json:"name"`
+	KeyHash   string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Actions of a DomainRule
+const (
+	DomainRuleBlock = "block"
+	DomainRuleAllow = "allow"
+)
+
+// DomainRule Represents a stored rule that blocks or allows a domain, and its subdomains, as destination.
+// Once any domain is allowed, destinations outside the allowed domains are rejected
+type DomainRule struct {
+	Domain string
+	Action string
 }
 
 // CreateURLRequest Represents the request payload for creating a shortened URL
 type CreateURLRequest struct {
 	LongURL string `json:"long_url" validate:"required,url"`
+	Alias   string `json:"alias,omitempty"`
+	// ExpiresAt accepts either a duration relative to now (e.g. "72h") or an RFC3339 timestamp
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// ReuseExisting returns the existing short URL of the same long URL instead of creating a new one.
+	// When omitted, the server-wide default applies
+	ReuseExisting *bool `json:"reuse_existing,omitempty"`
+	// Preview shows visitors the destination on an interstitial page before they continue to it
+	Preview bool `json:"preview,omitempty"`
+	// RedirectStatus is the status code of the redirect: 301, 302, 307 or 308.
+	// When omitted, the server-wide default applies
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Passthrough forwards the query string and any path after the short code to the long URL.
+	// Query parameters already present in the long URL take precedence over incoming ones with the same key
+	Passthrough bool `json:"passthrough,omitempty"`
+	// UTM adds campaign tracking parameters to the long URL
+	UTM *UTMParams `json:"utm,omitempty"`
+	// Password makes visitors enter it on a form before being redirected
+	Password string `json:"password,omitempty"`
+	// MaxClicks makes the URL stop redirecting after that many clicks, e.g. 1 for single-use links
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	// Targeting is an ordered list of rules that send some visitors to other destinations
+	Targeting []TargetingRule `json:"targeting,omitempty"`
+	// Variants splits visitors between several weighted destinations; the long URL only takes part if listed
+	Variants []Variant `json:"variants,omitempty"`
+	// StickyVariants keeps returning visitors on the variant they were first sent to
+	StickyVariants bool `json:"sticky_variants,omitempty"`
+}
+
+// UTMParams Represents the campaign tracking parameters added to a long URL on creation.
+// Empty fields are left out
+type UTMParams struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// BatchCreateURLRequest Represents the JSON request payload for shortening several URLs at once
+type BatchCreateURLRequest struct {
+	URLs []*CreateURLRequest `json:"urls"`
+}
+
+// UpdateURLRequest Represents the request payload for changing the destination of a shortened URL
+type UpdateURLRequest struct {
+	LongURL string `json:"long_url" validate:"required,url"`
+}
+
+// PatchURLRequest Represents the request payload for enabling or disabling a shortened URL
+type PatchURLRequest struct {
+	Enabled *bool `json:"enabled" validate:"required"`
 }
 
 // CreateURLResponse Represents the response payload after creating a shortened URL
 type CreateURLResponse struct {
-	ShortCode string `json:"short_code"`
-	ShortURL  string `json:"short_url"`
-	LongURL   string `json:"long_url"`
+	ShortCode string     `json:"short_code"`
+	ShortURL  string     `json:"short_url"`
+	LongURL   string     `json:"long_url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Reused reports whether an existing short URL was returned instead of creating a new one
+	Reused bool `json:"reused"`
+}
+
+// BatchCreateURLResult Represents the outcome of a single item of a batch creation.
+// Index is the position of the item in the request; either the response fields or Error are set
+type BatchCreateURLResult struct {
+	Index int `json:"index"`
+	*CreateURLResponse
+	Error string `json:"error,omitempty"`
+	// Code is the machine-readable reason of a rejected destination, such as "blocked_domain"
+	Code string `json:"code,omitempty"`
+}
+
+// BatchCreateURLResponse Represents the response payload after shortening several URLs at once
+type BatchCreateURLResponse struct {
+	Created int                    `json:"created"`
+	Reused  int                    `json:"reused"`
+	Failed  int                    `json:"failed"`
+	Results []BatchCreateURLResult `json:"results"`
+}
+
+// Problem Represents an error response as an RFC 7807 problem details object.
+// Code is a machine-readable reason, such as "not_found" or "blocked_domain", that clients can switch on
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code,omitempty"`
 }
 
 // StatsResponse Represents the response payload for URL statistics
 type StatsResponse struct {
-	ShortCode string    `json:"short_code"`
-	LongURL   string    `json:"long_url"`
-	Clicks    int64     `json:"clicks"`
-	CreatedAt time.Time `json:"created_at"`
+	ShortCode      string              `json:"short_code"`
+	LongURL        string              `json:"long_url"`
+	Clicks         int64               `json:"clicks"`
+	CreatedAt      time.Time           `json:"created_at"`
+	ExpiresAt      *time.Time          `json:"expires_at,omitempty"`
+	Enabled        bool                `json:"enabled"`
+	Preview        bool                `json:"preview"`
+	RedirectStatus int                 `json:"redirect_status,omitempty"`
+	Passthrough    bool                `json:"passthrough"`
+	Protected      bool                `json:"protected"`
+	MaxClicks      int64               `json:"max_clicks,omitempty"`
+	Targeting      []TargetingRule     `json:"targeting,omitempty"`
+	Variants       []VariantStats      `json:"variants,omitempty"`
+	StickyVariants bool                `json:"sticky_variants,omitempty"`
+	History        []DestinationChange `json:"history,omitempty"`
+	Interval       string              `json:"interval,omitempty"`
+	Series         []ClickBucket       `json:"series,omitempty"`
+}
+
+// VariantStats Represents a variant of an A/B split with the clicks it received
+type VariantStats struct {
+	LongURL string `json:"long_url"`
+	Weight  int    `json:"weight"`
+	Clicks  int64  `json:"clicks"`
+}
+
+// DestinationChange Represents a past change of the destination of a shortened URL
+type DestinationChange struct {
+	PreviousLongURL string    `json:"previous_long_url"`
+	NewLongURL      string    `json:"new_long_url"`
+	ChangedAt       time.Time `json:"changed_at"`
+}
+
+// ClickBucket Represents the number of clicks in one interval of a time-bucketed series
+type ClickBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+// SeriesQuery Represents the time range and bucket size requested for a click series
+type SeriesQuery struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+}
+
+// ListURLsQuery Represents the filters, sort order and page requested when listing shortened URLs
+type ListURLsQuery struct {
+	// Sort is either "created_at" or "clicks"; both sort in descending order
+	Sort string
+	// Search matches a substring of the long URL, case-insensitively
+	Search string
+	// Domain matches the host of the long URL and its subdomains
+	Domain      string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Limit       int
+	// Cursor is the opaque next_cursor of the previous page
+	Cursor string
+	// After is the decoded Cursor, and OwnerID restricts the list to one owner
+	After   *URLCursor
+	OwnerID *int64
+}
+
+// URLCursor Represents the position of the last URL of a page, in the sort order of the listing
+type URLCursor struct {
+	ID        int64
+	CreatedAt time.Time
+	Clicks    int64
+}
+
+// URLSummary Represents a shortened URL in a listing
+type URLSummary struct {
+	ShortCode string     `json:"short_code"`
+	ShortURL  string     `json:"short_url"`
+	LongURL   string     `json:"long_url"`
+	Clicks    int64      `json:"clicks"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	Preview   bool       `json:"preview"`
+}
+
+// ListURLsResponse Represents a page of shortened URLs.
+// NextCursor is empty on the last page
+type ListURLsResponse struct {
+	URLs       []URLSummary `json:"urls"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// QRCodeQuery Represents the rendering options requested for the QR code of a shortened URL
+type QRCodeQuery struct {
+	// Size is the width and height of the image in pixels
+	Size int
+	// Format is either "png" or "svg"
+	Format string
+	// Level is the error correction level: "L", "M", "Q" or "H"
+	Level string
+	// Margin is the width of the quiet zone in modules; nil uses the default
+	Margin *int
+}
+
+// QRCode Represents a rendered QR code image
+type QRCode struct {
+	ContentType string
+	Data        []byte
+}
+
+// Visit Represents the request metadata of a single redirect
+type Visit struct {
+	Referrer  string
+	UserAgent string
+	ClientIP  string
+	// Path is the escaped path that follows the short code, without its leading slash
+	Path string
+	// RawQuery is the encoded query string of the request, without the '?'
+	RawQuery string
+	// AcceptLanguage is the Accept-Language header of the request
+	AcceptLanguage string
+	// Variant is the 1-based index of the variant the visitor was assigned before, from its cookie; zero if none
+	Variant int
+	// Probe is set for HEAD requests, which check the link without following it and aren't counted as clicks
+	Probe bool
+}
+
+// ClickEvent Represents a recorded redirect, with anonymized client information
+type ClickEvent struct {
+	URLID          int64
+	ClickedAt      time.Time
+	ReferrerHost   string
+	UserAgentClass string
+	IPPrefix       string
+	// Variant is the 1-based index of the variant the visitor was sent to; zero for URLs without variants
+	Variant int
 }
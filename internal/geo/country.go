@@ -0,0 +1,113 @@
+package geo
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CountryDB looks up the country of IP addresses in a table of address ranges held in memory,
+// so lookups work offline and cost a binary search.
+type CountryDB struct {
+	ranges []countryRange
+}
+
+// countryRange is an inclusive range of addresses of the same family that belong to a country.
+type countryRange struct {
+	start   netip.Addr
+	end     netip.Addr
+	country string
+}
+
+// LoadCountryCSV reads a country database from the CSV file at path, see ParseCountryCSV.
+func LoadCountryCSV(path string) (*CountryDB, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening country database: %w", err)
+	}
+	defer file.Close()
+
+	return ParseCountryCSV(file)
+}
+
+// ParseCountryCSV reads a country database with one range per row: the first and last address of the range,
+// IPv4 or IPv6, and the ISO 3166-1 alpha-2 code of its country. This is the layout of the free DB-IP
+// "IP to Country Lite" CSV. Blank lines and lines starting with '#' are skipped.
+// It returns an error if a row is malformed, so a broken file is noticed on startup.
+func ParseCountryCSV(r io.Reader) (*CountryDB, error) {
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	var ranges []countryRange
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading country database: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		start, err := netip.ParseAddr(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid start address: %w", line, err)
+		}
+		end, err := netip.ParseAddr(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid end address: %w", line, err)
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.BitLen() != end.BitLen() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid range %s - %s", line, start, end)
+		}
+
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if len(country) != 2 {
+			return nil, fmt.Errorf("line %d: invalid country code %q", line, record[2])
+		}
+
+		ranges = append(ranges, countryRange{start: start, end: end, country: country})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Less(ranges[j].start)
+	})
+
+	return &CountryDB{ranges: ranges}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of the given address, in upper case.
+// IPv4-mapped IPv6 addresses are looked up as IPv4. The second result is false if no range contains it.
+func (db *CountryDB) Country(ip netip.Addr) (string, bool) {
+
+	ip = ip.Unmap()
+	// First range that starts after ip; the one before it is the only one that can contain it
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return ip.Less(db.ranges[i].start)
+	})
+	if i == 0 {
+		return "", false
+	}
+
+	candidate := db.ranges[i-1]
+	if candidate.start.BitLen() != ip.BitLen() || candidate.end.Less(ip) {
+		return "", false
+	}
+
+	return candidate.country, true
+}
+
+// Len returns the number of ranges in the database.
+func (db *CountryDB) Len() int {
+	return len(db.ranges)
+}
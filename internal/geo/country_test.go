@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCountryCSV = `# start,end,country
+1.0.0.0,1.0.0.255,AU
+2.16.0.0,2.16.255.255,es
+2001:db8::,2001:db8:ffff:ffff:ffff:ffff:ffff:ffff,DE
+
+8.8.8.0,8.8.8.255,US
+`
+
+func TestCountryDB_Country(t *testing.T) {
+	db, err := ParseCountryCSV(strings.NewReader(testCountryCSV))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if db.Len() != 4 {
+		t.Errorf("expected 4 ranges, got %d", db.Len())
+	}
+
+	tests := []struct {
+		ip       string
+		expected string
+		found    bool
+	}{
+		{ip: "1.0.0.0", expected: "AU", found: true},
+		{ip: "1.0.0.255", expected: "AU", found: true},
+		{ip: "2.16.42.1", expected: "ES", found: true},
+		{ip: "8.8.8.8", expected: "US", found: true},
+		{ip: "::ffff:8.8.8.8", expected: "US", found: true},
+		{ip: "2001:db8::1", expected: "DE", found: true},
+		{ip: "1.0.1.0", found: false},
+		{ip: "0.0.0.1", found: false},
+		{ip: "2001:db9::1", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			country, found := db.Country(netip.MustParseAddr(tt.ip))
+			if found != tt.found || country != tt.expected {
+				t.Errorf("Country(%s) = %q, %v; want %q, %v", tt.ip, country, found, tt.expected, tt.found)
+			}
+		})
+	}
+}
+
+func TestParseCountryCSV_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "missing column", input: "1.0.0.0,1.0.0.255\n"},
+		{name: "invalid address", input: "1.0.0.x,1.0.0.255,AU\n"},
+		{name: "mixed families", input: "1.0.0.0,2001:db8::,AU\n"},
+		{name: "reversed range", input: "1.0.0.255,1.0.0.0,AU\n"},
+		{name: "invalid country", input: "1.0.0.0,1.0.0.255,AUS\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCountryCSV(strings.NewReader(tt.input)); err == nil {
+				t.Error("expected error but got nil")
+			}
+		})
+	}
+}
+
+func TestLoadCountryCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "countries.csv")
+	if err := os.WriteFile(path, []byte(testCountryCSV), 0o600); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	db, err := LoadCountryCSV(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if country, _ := db.Country(netip.MustParseAddr("8.8.8.8")); country != "US" {
+		t.Errorf("expected US, got %q", country)
+	}
+
+	if _, err := LoadCountryCSV(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
@@ -2,15 +2,19 @@ package integration
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Elisandil/go-snap/internal/domain"
 	"github.com/Elisandil/go-snap/internal/repo"
 	"github.com/Elisandil/go-snap/internal/service"
 	"github.com/Elisandil/go-snap/internal/shortid"
+	"github.com/Elisandil/go-snap/resources"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
@@ -73,6 +77,10 @@ func setupTestEnvironment(t *testing.T) {
 func teardownTestEnvironment(t *testing.T) {
 	t.Helper()
 
+	if testService != nil {
+		testService.Close(context.Background())
+	}
+
 	if testPgPool != nil {
 		testPgPool.Close()
 	}
@@ -91,7 +99,7 @@ func cleanupTestData(t *testing.T) {
 
 	ctx := context.Background()
 
-	_, err := testPgPool.Exec(ctx, "TRUNCATE TABLE urls RESTART IDENTITY CASCADE")
+	_, err := testPgPool.Exec(ctx, "TRUNCATE TABLE urls, api_keys, domain_rules RESTART IDENTITY CASCADE")
 	if err != nil {
 		t.Logf("Warning: Failed to clean test database: %v", err)
 	}
@@ -114,7 +122,7 @@ func TestIntegration_CreateAndRetrieveURL(t *testing.T) {
 	ctx := context.Background()
 	longURL := "https://example.com/test/integration"
 
-	result, err := testService.CreateShortURL(ctx, longURL)
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: longURL})
 	if err != nil {
 		t.Fatalf("Failed to create short URL: %v", err)
 	}
@@ -128,15 +136,15 @@ func TestIntegration_CreateAndRetrieveURL(t *testing.T) {
 		t.Errorf("Expected long URL '%s', got '%s'", longURL, result.LongURL)
 	}
 
-	retrievedURL, err := testService.GetLongURL(ctx, result.ShortCode)
+	retrievedURL, err := testService.GetLongURL(ctx, result.ShortCode, nil)
 	if err != nil {
 		t.Fatalf("Failed to retrieve long URL: %v", err)
 	}
-	if retrievedURL != longURL {
-		t.Errorf("Expected retrieved URL '%s', got '%s'", longURL, retrievedURL)
+	if retrievedURL.LongURL != longURL {
+		t.Errorf("Expected retrieved URL '%s', got '%s'", longURL, retrievedURL.LongURL)
 	}
 
-	stats, err := testService.GetURLStats(ctx, result.ShortCode)
+	stats, err := testService.GetURLStats(ctx, result.ShortCode, nil)
 	if err != nil {
 		t.Fatalf("Failed to get stats: %v", err)
 	}
@@ -155,12 +163,12 @@ func TestIntegration_CreateDuplicateShortCode(t *testing.T) {
 
 	ctx := context.Background()
 
-	result1, err := testService.CreateShortURL(ctx, "https://example.com/first")
+	result1, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/first"})
 	if err != nil {
 		t.Fatalf("Failed to create first URL: %v", err)
 	}
 
-	result2, err := testService.CreateShortURL(ctx, "https://example.com/second")
+	result2, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/second"})
 	if err != nil {
 		t.Fatalf("Failed to create second URL: %v", err)
 	}
@@ -168,13 +176,13 @@ func TestIntegration_CreateDuplicateShortCode(t *testing.T) {
 		t.Error("Expected different short codes for different URLs")
 	}
 
-	url1, err := testService.GetLongURL(ctx, result1.ShortCode)
-	if err != nil || url1 != "https://example.com/first" {
+	url1, err := testService.GetLongURL(ctx, result1.ShortCode, nil)
+	if err != nil || url1.LongURL != "https://example.com/first" {
 		t.Errorf("Failed to retrieve first URL: %v", err)
 	}
 
-	url2, err := testService.GetLongURL(ctx, result2.ShortCode)
-	if err != nil || url2 != "https://example.com/second" {
+	url2, err := testService.GetLongURL(ctx, result2.ShortCode, nil)
+	if err != nil || url2.LongURL != "https://example.com/second" {
 		t.Errorf("Failed to retrieve second URL: %v", err)
 	}
 }
@@ -187,19 +195,19 @@ func TestIntegration_RedisCache_HitAndMiss(t *testing.T) {
 	ctx := context.Background()
 	longURL := "https://example.com/cache-test"
 
-	result, err := testService.CreateShortURL(ctx, longURL)
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: longURL})
 	if err != nil {
 		t.Fatalf("Failed to create URL: %v", err)
 	}
 
 	start1 := time.Now()
-	url1, err := testService.GetLongURL(ctx, result.ShortCode)
+	url1, err := testService.GetLongURL(ctx, result.ShortCode, nil)
 	duration1 := time.Since(start1)
 	if err != nil {
 		t.Fatalf("Failed to retrieve URL (cache hit): %v", err)
 	}
-	if url1 != longURL {
-		t.Errorf("Expected URL '%s', got '%s'", longURL, url1)
+	if url1.LongURL != longURL {
+		t.Errorf("Expected URL '%s', got '%s'", longURL, url1.LongURL)
 	}
 
 	err = testRedisClient.Del(ctx, result.ShortCode).Err()
@@ -208,13 +216,13 @@ func TestIntegration_RedisCache_HitAndMiss(t *testing.T) {
 	}
 
 	start2 := time.Now()
-	url2, err := testService.GetLongURL(ctx, result.ShortCode)
+	url2, err := testService.GetLongURL(ctx, result.ShortCode, nil)
 	duration2 := time.Since(start2)
 	if err != nil {
 		t.Fatalf("Failed to retrieve URL (cache miss): %v", err)
 	}
-	if url2 != longURL {
-		t.Errorf("Expected URL '%s', got '%s'", longURL, url2)
+	if url2.LongURL != longURL {
+		t.Errorf("Expected URL '%s', got '%s'", longURL, url2.LongURL)
 	}
 
 	t.Logf("Cache hit duration: %v, Cache miss duration: %v", duration1, duration2)
@@ -228,12 +236,12 @@ func TestIntegration_ClickCounterIncrement(t *testing.T) {
 	ctx := context.Background()
 	longURL := "https://example.com/click-test"
 
-	result, err := testService.CreateShortURL(ctx, longURL)
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: longURL})
 	if err != nil {
 		t.Fatalf("Failed to create URL: %v", err)
 	}
 
-	stats, err := testService.GetURLStats(ctx, result.ShortCode)
+	stats, err := testService.GetURLStats(ctx, result.ShortCode, nil)
 	if err != nil {
 		t.Fatalf("Failed to get initial stats: %v", err)
 	}
@@ -243,14 +251,14 @@ func TestIntegration_ClickCounterIncrement(t *testing.T) {
 
 	numAccesses := 5
 	for i := 0; i < numAccesses; i++ {
-		_, err := testService.GetLongURL(ctx, result.ShortCode)
+		_, err := testService.GetLongURL(ctx, result.ShortCode, nil)
 		if err != nil {
 			t.Fatalf("Failed to access URL (iteration %d): %v", i, err)
 		}
 	}
 	time.Sleep(2 * time.Second)
 
-	stats, err = testService.GetURLStats(ctx, result.ShortCode)
+	stats, err = testService.GetURLStats(ctx, result.ShortCode, nil)
 	if err != nil {
 		t.Fatalf("Failed to get final stats: %v", err)
 	}
@@ -259,6 +267,274 @@ func TestIntegration_ClickCounterIncrement(t *testing.T) {
 	}
 }
 
+func TestIntegration_CreateShortURL_ReuseExisting(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+	reuse := true
+
+	first, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/reuse"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if first.Reused {
+		t.Error("Expected first URL to be newly created")
+	}
+
+	second, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL:       "example.com/reuse",
+		ReuseExisting: &reuse,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if !second.Reused || second.ShortCode != first.ShortCode {
+		t.Errorf("Expected short code %s to be reused, got %s (reused: %v)", first.ShortCode, second.ShortCode, second.Reused)
+	}
+
+	if _, err := testService.SetURLEnabled(ctx, first.ShortCode, false); err != nil {
+		t.Fatalf("Failed to disable URL: %v", err)
+	}
+	third, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL:       "https://example.com/reuse",
+		ReuseExisting: &reuse,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if third.Reused || third.ShortCode == first.ShortCode {
+		t.Error("Expected a disabled URL not to be reused")
+	}
+}
+
+func TestIntegration_APIKeyOwnership(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+	authService := service.NewAuthService(repo.NewPostgresRepo(testPgPool))
+
+	rawKey, _, err := authService.CreateAPIKey(ctx, 1, "integration")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	key, err := authService.Authenticate(ctx, rawKey)
+	if err != nil {
+		t.Fatalf("Failed to authenticate API key: %v", err)
+	}
+	if _, err := authService.Authenticate(ctx, rawKey+"x"); !errors.Is(err, service.ErrInvalidAPIKey) {
+		t.Errorf("Expected ErrInvalidAPIKey for an unknown key, got %v", err)
+	}
+
+	ownerCtx := service.WithOwner(ctx, key.OwnerID)
+	otherCtx := service.WithOwner(ctx, key.OwnerID+1)
+
+	result, err := testService.CreateShortURL(ownerCtx, &domain.CreateURLRequest{LongURL: "https://example.com/owned"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	if _, err := testService.GetURLStats(ownerCtx, result.ShortCode, nil); err != nil {
+		t.Errorf("Expected owner to read stats, got %v", err)
+	}
+	if _, err := testService.GetURLStats(otherCtx, result.ShortCode, nil); err == nil {
+		t.Error("Expected another owner not to read stats")
+	}
+	if err := testService.DeleteURL(otherCtx, result.ShortCode); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Expected another owner not to delete the URL, got %v", err)
+	}
+	if _, err := testService.GetLongURL(ctx, result.ShortCode, nil); err != nil {
+		t.Errorf("Expected the redirect to stay public, got %v", err)
+	}
+	if err := testService.DeleteURL(ownerCtx, result.ShortCode); err != nil {
+		t.Errorf("Expected owner to delete the URL, got %v", err)
+	}
+}
+
+func TestIntegration_ListURLs(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := service.WithOwner(context.Background(), 1)
+
+	longURLs := []string{
+		"https://example.com/promo/1",
+		"https://shop.example.com/promo/2",
+		"https://other.org/promo/3",
+		"https://example.com/about",
+		"https://notexample.com/promo_5",
+	}
+	for _, longURL := range longURLs {
+		if _, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: longURL}); err != nil {
+			t.Fatalf("Failed to create URL: %v", err)
+		}
+	}
+	if _, err := testService.CreateShortURL(service.WithOwner(context.Background(), 2),
+		&domain.CreateURLRequest{LongURL: "https://example.com/promo/other-owner"}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	var listed []string
+	cursor := ""
+	for {
+		page, err := testService.ListURLs(ctx, &domain.ListURLsQuery{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("Failed to list URLs: %v", err)
+		}
+		for _, url := range page.URLs {
+			listed = append(listed, url.LongURL)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(listed) != len(longURLs) {
+		t.Fatalf("Expected %d URLs of the owner, got %d: %v", len(longURLs), len(listed), listed)
+	}
+	for i, longURL := range listed {
+		if expected := longURLs[len(longURLs)-1-i]; longURL != expected {
+			t.Errorf("Expected URL %d to be '%s', got '%s'", i, expected, longURL)
+		}
+	}
+
+	filtered, err := testService.ListURLs(ctx, &domain.ListURLsQuery{Search: "PROMO", Domain: "example.com"})
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(filtered.URLs) != 2 {
+		t.Errorf("Expected 2 promo URLs on example.com and its subdomains, got %d: %+v", len(filtered.URLs), filtered.URLs)
+	}
+
+	literal, err := testService.ListURLs(ctx, &domain.ListURLsQuery{Search: "promo_"})
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(literal.URLs) != 1 {
+		t.Errorf("Expected the search to match '_' literally, got %d URLs", len(literal.URLs))
+	}
+
+	future, err := testService.ListURLs(ctx, &domain.ListURLsQuery{CreatedFrom: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(future.URLs) != 0 {
+		t.Errorf("Expected no URLs created in the future, got %d", len(future.URLs))
+	}
+}
+
+func TestIntegration_CreateShortURLs_Batch(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	if _, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/existing",
+		Alias:   "taken",
+	}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	requests := []*domain.CreateURLRequest{
+		{LongURL: "https://example.com/batch/1"},
+		{LongURL: "https://example.com/batch/2", Alias: "batchtwo"},
+		{LongURL: "https://example.com/batch/3", Alias: "taken"},
+		{LongURL: "not a url"},
+		{LongURL: "https://example.com/batch/5", ExpiresAt: "24h"},
+	}
+	response, err := testService.CreateShortURLs(ctx, requests)
+	if err != nil {
+		t.Fatalf("Failed to create URL batch: %v", err)
+	}
+	if response.Created != 3 || response.Failed != 2 {
+		t.Fatalf("Expected 3 created and 2 failed, got %d and %d", response.Created, response.Failed)
+	}
+
+	for _, i := range []int{0, 1, 4} {
+		result := response.Results[i]
+		if result.CreateURLResponse == nil {
+			t.Fatalf("Expected item %d to be created, got error %q", i, result.Error)
+		}
+		retrievedURL, err := testService.GetLongURL(ctx, result.ShortCode, nil)
+		if err != nil {
+			t.Fatalf("Failed to retrieve long URL of item %d: %v", i, err)
+		}
+		if retrievedURL.LongURL != requests[i].LongURL {
+			t.Errorf("Expected retrieved URL '%s', got '%s'", requests[i].LongURL, retrievedURL.LongURL)
+		}
+	}
+	if response.Results[4].ExpiresAt == nil {
+		t.Error("Expected item 4 to have an expiration")
+	}
+	for _, i := range []int{2, 3} {
+		if response.Results[i].Error == "" {
+			t.Errorf("Expected item %d to fail", i)
+		}
+	}
+}
+
+func TestIntegration_ClickEventsSeries(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/series"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	visit := &domain.Visit{
+		Referrer:  "https://news.example.org/post",
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148",
+		ClientIP:  "203.0.113.42",
+	}
+	numAccesses := 3
+	for i := 0; i < numAccesses; i++ {
+		if _, err := testService.GetLongURL(ctx, result.ShortCode, visit); err != nil {
+			t.Fatalf("Failed to access URL (iteration %d): %v", i, err)
+		}
+	}
+	time.Sleep(2 * time.Second)
+
+	now := time.Now()
+	stats, err := testService.GetURLStats(ctx, result.ShortCode, &domain.SeriesQuery{
+		From:     now.Add(-time.Hour),
+		To:       now.Add(time.Hour),
+		Interval: "hour",
+	})
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+
+	var total int64
+	for _, bucket := range stats.Series {
+		total += bucket.Clicks
+	}
+	if total != int64(numAccesses) {
+		t.Errorf("Expected %d clicks in the series, got %d", numAccesses, total)
+	}
+
+	var ipPrefix, userAgentClass string
+	err = testPgPool.QueryRow(ctx, "SELECT ip_prefix, user_agent_class FROM clicks LIMIT 1").
+		Scan(&ipPrefix, &userAgentClass)
+	if err != nil {
+		t.Fatalf("Failed to read click event: %v", err)
+	}
+	if ipPrefix != "203.0.113.0/24" {
+		t.Errorf("Expected anonymized IP prefix '203.0.113.0/24', got '%s'", ipPrefix)
+	}
+	if userAgentClass != "mobile" {
+		t.Errorf("Expected user agent class 'mobile', got '%s'", userAgentClass)
+	}
+}
+
 func TestIntegration_ConcurrentRequests(t *testing.T) {
 	setupTestEnvironment(t)
 	defer teardownTestEnvironment(t)
@@ -277,7 +553,7 @@ func TestIntegration_ConcurrentRequests(t *testing.T) {
 			defer wg.Done()
 			for j := 0; j < numRequestsPerGoroutine; j++ {
 				longURL := fmt.Sprintf("https://example.com/concurrent/%d/%d", id, j)
-				_, err := testService.CreateShortURL(ctx, longURL)
+				_, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: longURL})
 				if err != nil {
 					errors <- fmt.Errorf("goroutine %d, request %d: %w", id, j, err)
 				}
@@ -307,6 +583,58 @@ func TestIntegration_ConcurrentRequests(t *testing.T) {
 	}
 }
 
+func TestIntegration_MaxClicks_Concurrent(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+	maxClicks := 3
+	numGoroutines := 20
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL:   "https://example.com/download/report.zip",
+		MaxClicks: int64(maxClicks),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var redirected, refused atomic.Int64
+
+	wg.Add(numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := testService.GetLongURL(ctx, result.ShortCode, nil)
+			switch {
+			case err == nil:
+				redirected.Add(1)
+			case errors.Is(err, service.ErrClickLimitReached):
+				refused.Add(1)
+			default:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if redirected.Load() != int64(maxClicks) || refused.Load() != int64(numGoroutines-maxClicks) {
+		t.Errorf("Expected %d redirects and %d refusals, got %d and %d",
+			maxClicks, numGoroutines-maxClicks, redirected.Load(), refused.Load())
+	}
+
+	var clicks int64
+	err = testPgPool.QueryRow(ctx, "SELECT clicks FROM urls WHERE short_code = $1", result.ShortCode).Scan(&clicks)
+	if err != nil {
+		t.Fatalf("Failed to get clicks: %v", err)
+	}
+	if clicks != int64(maxClicks) {
+		t.Errorf("Expected %d clicks in the database, got %d", maxClicks, clicks)
+	}
+}
+
 func TestIntegration_MultipleURLs(t *testing.T) {
 	setupTestEnvironment(t)
 	defer teardownTestEnvironment(t)
@@ -318,7 +646,7 @@ func TestIntegration_MultipleURLs(t *testing.T) {
 
 	for i := 0; i < numURLs; i++ {
 		longURL := fmt.Sprintf("https://example.com/test/%d", i)
-		result, err := testService.CreateShortURL(ctx, longURL)
+		result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: longURL})
 		if err != nil {
 			t.Fatalf("Failed to create URL %d: %v", i, err)
 		}
@@ -326,18 +654,18 @@ func TestIntegration_MultipleURLs(t *testing.T) {
 	}
 
 	for shortCode, expectedLongURL := range urls {
-		actualLongURL, err := testService.GetLongURL(ctx, shortCode)
+		actualURL, err := testService.GetLongURL(ctx, shortCode, nil)
 		if err != nil {
 			t.Errorf("Failed to retrieve URL for short code '%s': %v", shortCode, err)
 			continue
 		}
-		if actualLongURL != expectedLongURL {
-			t.Errorf("Short code '%s': expected URL '%s', got '%s'", shortCode, expectedLongURL, actualLongURL)
+		if actualURL.LongURL != expectedLongURL {
+			t.Errorf("Short code '%s': expected URL '%s', got '%s'", shortCode, expectedLongURL, actualURL.LongURL)
 		}
 	}
 
 	for shortCode, expectedLongURL := range urls {
-		stats, err := testService.GetURLStats(ctx, shortCode)
+		stats, err := testService.GetURLStats(ctx, shortCode, nil)
 		if err != nil {
 			t.Errorf("Failed to get stats for short code '%s': %v", shortCode, err)
 			continue
@@ -392,7 +720,7 @@ func TestIntegration_URLNormalization(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := testService.CreateShortURL(ctx, tt.input)
+			result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: tt.input})
 
 			if tt.shouldFail {
 				if err == nil {
@@ -407,12 +735,12 @@ func TestIntegration_URLNormalization(t *testing.T) {
 				t.Errorf("Expected normalized URL '%s', got '%s'", tt.expectedURL, result.LongURL)
 			}
 
-			retrievedURL, err := testService.GetLongURL(ctx, result.ShortCode)
+			retrievedURL, err := testService.GetLongURL(ctx, result.ShortCode, nil)
 			if err != nil {
 				t.Fatalf("Failed to retrieve URL: %v", err)
 			}
-			if retrievedURL != tt.expectedURL {
-				t.Errorf("Expected retrieved URL '%s', got '%s'", tt.expectedURL, retrievedURL)
+			if retrievedURL.LongURL != tt.expectedURL {
+				t.Errorf("Expected retrieved URL '%s', got '%s'", tt.expectedURL, retrievedURL.LongURL)
 			}
 		})
 	}
@@ -436,12 +764,12 @@ func TestIntegration_InvalidShortCode(t *testing.T) {
 
 	for _, code := range invalidCodes {
 		t.Run(fmt.Sprintf("invalid_code_%s", code), func(t *testing.T) {
-			_, err := testService.GetLongURL(ctx, code)
+			_, err := testService.GetLongURL(ctx, code, nil)
 			if err == nil {
 				t.Errorf("Expected error for invalid short code '%s', got none", code)
 			}
 
-			_, err = testService.GetURLStats(ctx, code)
+			_, err = testService.GetURLStats(ctx, code, nil)
 			if err == nil {
 				t.Errorf("Expected error for invalid short code '%s' in stats, got none", code)
 			}
@@ -456,17 +784,509 @@ func TestIntegration_NonExistentShortCode(t *testing.T) {
 
 	ctx := context.Background()
 
-	_, err := testService.GetLongURL(ctx, "notfound")
+	_, err := testService.GetLongURL(ctx, "notfound", nil)
 	if err == nil {
 		t.Error("Expected error for non-existent short code, got none")
 	}
 
-	_, err = testService.GetURLStats(ctx, "notfound")
+	_, err = testService.GetURLStats(ctx, "notfound", nil)
 	if err == nil {
 		t.Error("Expected error for non-existent short code in stats, got none")
 	}
 }
 
+func TestIntegration_ExpiredURL(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL:   "https://example.com/campaign",
+		ExpiresAt: "1s",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if result.ExpiresAt == nil {
+		t.Fatal("Expected expiration in the response")
+	}
+
+	if _, err := testService.GetLongURL(ctx, result.ShortCode, nil); err != nil {
+		t.Fatalf("Failed to retrieve URL before expiration: %v", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+
+	_, err = testService.GetLongURL(ctx, result.ShortCode, nil)
+	if !errors.Is(err, service.ErrLinkExpired) {
+		t.Errorf("Expected ErrLinkExpired after expiration, got %v", err)
+	}
+
+	exists, err := testRedisClient.Exists(ctx, result.ShortCode).Result()
+	if err != nil {
+		t.Fatalf("Failed to check Redis key: %v", err)
+	}
+	if exists != 0 {
+		t.Error("Expected expired URL to be evicted from Redis")
+	}
+}
+
+func TestIntegration_UpdateLongURL(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/old"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if _, err := testService.GetLongURL(ctx, result.ShortCode, nil); err != nil {
+		t.Fatalf("Failed to retrieve URL: %v", err)
+	}
+
+	stats, err := testService.UpdateLongURL(ctx, result.ShortCode, "https://example.com/new")
+	if err != nil {
+		t.Fatalf("Failed to update URL: %v", err)
+	}
+	if len(stats.History) != 1 {
+		t.Fatalf("Expected 1 history entry, got %d", len(stats.History))
+	}
+	if stats.History[0].PreviousLongURL != "https://example.com/old" {
+		t.Errorf("Expected previous URL 'https://example.com/old', got '%s'", stats.History[0].PreviousLongURL)
+	}
+
+	retrievedURL, err := testService.GetLongURL(ctx, result.ShortCode, nil)
+	if err != nil {
+		t.Fatalf("Failed to retrieve updated URL: %v", err)
+	}
+	if retrievedURL.LongURL != "https://example.com/new" {
+		t.Errorf("Expected updated URL 'https://example.com/new', got '%s'", retrievedURL.LongURL)
+	}
+}
+
+func TestIntegration_PreviewURL(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/report",
+		Preview: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if err := testRedisClient.Del(ctx, result.ShortCode).Err(); err != nil {
+		t.Fatalf("Failed to evict URL from Redis: %v", err)
+	}
+
+	url, err := testService.GetURLPreview(ctx, result.ShortCode)
+	if err != nil {
+		t.Fatalf("Failed to retrieve URL preview: %v", err)
+	}
+	if !url.Preview || url.LongURL != "https://example.com/report" {
+		t.Errorf("Expected the preview flag to be stored, got %+v", url)
+	}
+
+	stats, err := testService.GetURLStats(ctx, result.ShortCode, nil)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if !stats.Preview {
+		t.Error("Expected the stats to report the preview flag")
+	}
+	if stats.Clicks != 0 {
+		t.Errorf("Expected the preview not to count as a click, got %d clicks", stats.Clicks)
+	}
+}
+
+func TestIntegration_PassthroughURL(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL:     "https://example.com/docs?lang=en",
+		Passthrough: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if err := testRedisClient.Del(ctx, result.ShortCode).Err(); err != nil {
+		t.Fatalf("Failed to evict URL from Redis: %v", err)
+	}
+
+	visit := &domain.Visit{Path: "guides/setup", RawQuery: "lang=es&utm_source=mail"}
+	for _, source := range []string{"Postgres", "Redis"} {
+		url, err := testService.GetLongURL(ctx, result.ShortCode, visit)
+		if err != nil {
+			t.Fatalf("Failed to retrieve URL from %s: %v", source, err)
+		}
+		if expected := "https://example.com/docs/guides/setup?lang=en&utm_source=mail"; url.LongURL != expected {
+			t.Errorf("Expected %s from %s, got %s", expected, source, url.LongURL)
+		}
+	}
+}
+
+func TestIntegration_PasswordProtectedURL(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL:  "https://example.com/handbook.pdf",
+		Password: "hr-only",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if err := testRedisClient.Del(ctx, result.ShortCode).Err(); err != nil {
+		t.Fatalf("Failed to evict URL from Redis: %v", err)
+	}
+
+	for _, source := range []string{"Postgres", "Redis"} {
+		if _, err := testService.GetLongURL(ctx, result.ShortCode, nil); !errors.Is(err, service.ErrPasswordRequired) {
+			t.Errorf("Expected ErrPasswordRequired from %s, got %v", source, err)
+		}
+		if _, err := testService.UnlockURL(ctx, result.ShortCode, "wrong", nil); !errors.Is(err, service.ErrWrongPassword) {
+			t.Errorf("Expected ErrWrongPassword from %s, got %v", source, err)
+		}
+	}
+
+	url, err := testService.UnlockURL(ctx, result.ShortCode, "hr-only", nil)
+	if err != nil {
+		t.Fatalf("Failed to unlock URL: %v", err)
+	}
+	if url.LongURL != "https://example.com/handbook.pdf" {
+		t.Errorf("Expected the destination after unlocking, got %s", url.LongURL)
+	}
+
+	stats, err := testService.GetURLStats(ctx, result.ShortCode, nil)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if !stats.Protected {
+		t.Error("Expected the stats to report the link as protected")
+	}
+}
+
+func TestIntegration_TargetingURL(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/app",
+		Targeting: []domain.TargetingRule{
+			{Match: "os", Values: []string{"iOS"}, LongURL: "https://apps.apple.com/app/id1"},
+			{Match: "language", Values: []string{"es"}, LongURL: "https://example.com/es/app"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if err := testRedisClient.Del(ctx, result.ShortCode).Err(); err != nil {
+		t.Fatalf("Failed to evict URL from Redis: %v", err)
+	}
+
+	iPhone := &domain.Visit{UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"}
+	spanish := &domain.Visit{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)", AcceptLanguage: "es-AR"}
+	for _, source := range []string{"Postgres", "Redis"} {
+		url, err := testService.GetLongURL(ctx, result.ShortCode, iPhone)
+		if err != nil {
+			t.Fatalf("Failed to retrieve URL from %s: %v", source, err)
+		}
+		if url.LongURL != "https://apps.apple.com/app/id1" {
+			t.Errorf("Expected the iOS destination from %s, got %s", source, url.LongURL)
+		}
+
+		url, err = testService.GetLongURL(ctx, result.ShortCode, spanish)
+		if err != nil {
+			t.Fatalf("Failed to retrieve URL from %s: %v", source, err)
+		}
+		if url.LongURL != "https://example.com/es/app" {
+			t.Errorf("Expected the Spanish destination from %s, got %s", source, url.LongURL)
+		}
+	}
+
+	reuse := true
+	reused, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/app", ReuseExisting: &reuse})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if reused.ShortCode == result.ShortCode {
+		t.Error("Expected a URL without targeting not to reuse a targeted one")
+	}
+
+	stats, err := testService.GetURLStats(ctx, result.ShortCode, nil)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if len(stats.Targeting) != 2 || stats.Targeting[0].Values[0] != "ios" {
+		t.Errorf("Expected the stats to report the normalized rules, got %+v", stats.Targeting)
+	}
+}
+
+func TestIntegration_VariantsURL(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/landing",
+		Variants: []domain.Variant{
+			{LongURL: "https://example.com/landing-a", Weight: 50},
+			{LongURL: "https://example.com/landing-b", Weight: 50},
+		},
+		StickyVariants: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if err := testRedisClient.Del(ctx, result.ShortCode).Err(); err != nil {
+		t.Fatalf("Failed to evict URL from Redis: %v", err)
+	}
+
+	returning := &domain.Visit{Variant: 2}
+	for _, source := range []string{"Postgres", "Redis"} {
+		url, err := testService.GetLongURL(ctx, result.ShortCode, returning)
+		if err != nil {
+			t.Fatalf("Failed to retrieve URL from %s: %v", source, err)
+		}
+		if url.Variant != 2 || url.LongURL != "https://example.com/landing-b" {
+			t.Errorf("Expected the sticky variant from %s, got %d (%s)", source, url.Variant, url.LongURL)
+		}
+	}
+	time.Sleep(2 * time.Second)
+
+	stats, err := testService.GetURLStats(ctx, result.ShortCode, nil)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if len(stats.Variants) != 2 {
+		t.Fatalf("Expected 2 variants in the stats, got %+v", stats.Variants)
+	}
+	if stats.Variants[0].Clicks != 0 || stats.Variants[1].Clicks != 2 {
+		t.Errorf("Expected 0 and 2 clicks per variant, got %+v", stats.Variants)
+	}
+}
+
+func TestIntegration_DomainRules(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://login.phish.example/"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	_, err = testPgPool.Exec(ctx, "INSERT INTO domain_rules (domain, action) VALUES ('phish.example', 'block')")
+	if err != nil {
+		t.Fatalf("Failed to insert domain rule: %v", err)
+	}
+	if err := testService.ReloadDomainRules(ctx); err != nil {
+		t.Fatalf("Failed to reload domain rules: %v", err)
+	}
+	defer func() {
+		cleanupTestData(t)
+		if err := testService.ReloadDomainRules(ctx); err != nil {
+			t.Errorf("Failed to reload domain rules: %v", err)
+		}
+	}()
+
+	_, err = testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://phish.example/account"})
+	var unsafe *service.UnsafeURLError
+	if !errors.As(err, &unsafe) || unsafe.Code != service.UnsafeBlockedDomain {
+		t.Errorf("Expected a blocked domain error on creation, got %v", err)
+	}
+
+	_, err = testService.GetLongURL(ctx, result.ShortCode, nil)
+	if !errors.As(err, &unsafe) || unsafe.Code != service.UnsafeBlockedDomain {
+		t.Errorf("Expected a blocked domain error on redirect, got %v", err)
+	}
+}
+
+func TestIntegration_MigrateSchema(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+	pgRepo := repo.NewPostgresRepo(testPgPool)
+
+	if _, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/before",
+		Alias:   "before",
+	}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	// A database of the first release, without the columns added since
+	if _, err := testPgPool.Exec(ctx, `ALTER TABLE urls DROP COLUMN long_url_hash, DROP COLUMN expires_at, 
+		DROP COLUMN sticky_variants`); err != nil {
+		t.Fatalf("Failed to drop columns: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := pgRepo.Migrate(ctx, resources.Schema); err != nil {
+			t.Fatalf("Failed to migrate the schema, attempt %d: %v", i+1, err)
+		}
+	}
+
+	url, err := pgRepo.GetByLongURL(ctx, "https://example.com/before", nil)
+	if err != nil {
+		t.Fatalf("Expected the existing URL to be found by its backfilled hash, got %v", err)
+	}
+	if url.ShortCode != "before" {
+		t.Errorf("Expected short code before, got %s", url.ShortCode)
+	}
+}
+
+func TestIntegration_ClaimUnownedURLs(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+	pgRepo := repo.NewPostgresRepo(testPgPool)
+
+	if _, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/legacy",
+		Alias:   "legacy",
+	}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	owned := service.WithOwner(ctx, 2)
+	if _, err := testService.CreateShortURL(owned, &domain.CreateURLRequest{
+		LongURL: "https://example.com/owned",
+		Alias:   "owned",
+	}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	claimed, err := pgRepo.ClaimUnownedURLs(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed to claim URLs: %v", err)
+	}
+	if claimed != 1 {
+		t.Errorf("Expected 1 claimed URL, got %d", claimed)
+	}
+
+	for shortCode, ownerID := range map[string]int64{"legacy": 1, "owned": 2} {
+		url, err := pgRepo.GetByShortCode(ctx, shortCode)
+		if err != nil {
+			t.Fatalf("Failed to get URL: %v", err)
+		}
+		if url.OwnerID == nil || *url.OwnerID != ownerID {
+			t.Errorf("Expected %s to belong to owner %d, got %v", shortCode, ownerID, url.OwnerID)
+		}
+	}
+}
+
+func TestIntegration_NotFoundCache(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+
+	if _, err := testService.GetLongURL(ctx, "launch", nil); !errors.Is(err, service.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for an unknown alias, got %v", err)
+	}
+	if _, err := repo.NewRedisRepo(testRedisClient, time.Hour).Get(ctx, "launch"); !errors.Is(err, repo.ErrCachedNotFound) {
+		t.Errorf("Expected the unknown alias to be cached as not found, got %v", err)
+	}
+
+	if _, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/launch",
+		Alias:   "launch",
+	}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	url, err := testService.GetLongURL(ctx, "launch", nil)
+	if err != nil {
+		t.Fatalf("Expected the created alias to replace its not found entry, got %v", err)
+	}
+	if url.LongURL != "https://example.com/launch" {
+		t.Errorf("Expected https://example.com/launch, got %s", url.LongURL)
+	}
+}
+
+func TestIntegration_SequentialCodes(t *testing.T) {
+	setupTestEnvironment(t)
+	defer teardownTestEnvironment(t)
+	cleanupTestData(t)
+
+	ctx := context.Background()
+	pgRepo := repo.NewPostgresRepo(testPgPool)
+	generator := shortid.NewGenerator()
+	sequential := service.NewShortenerService(pgRepo, repo.NewRedisRepo(testRedisClient, time.Hour), generator,
+		"http://localhost:8080", service.WithSequentialCodes(nil))
+	defer sequential.Close(ctx)
+
+	// The alias row takes the next ID, and its code is the one of the ID after, which must be skipped
+	nextID, err := pgRepo.GetNextID(ctx)
+	if err != nil {
+		t.Fatalf("Failed to draw an ID: %v", err)
+	}
+	if _, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL: "https://example.com/alias",
+		Alias:   generator.Encode(nextID + 2),
+	}); err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	result, err := sequential.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/sequential"})
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	url, err := pgRepo.GetByShortCode(ctx, result.ShortCode)
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if result.ShortCode != generator.Encode(url.ID) || url.ID <= nextID+2 {
+		t.Errorf("Expected the code of a new ID after %d, got %s for ID %d", nextID+2, result.ShortCode, url.ID)
+	}
+
+	batch, err := sequential.CreateShortURLs(ctx, []*domain.CreateURLRequest{
+		{LongURL: "https://example.com/sequential/1"},
+		{LongURL: "https://example.com/sequential/2"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create URLs: %v", err)
+	}
+	for _, item := range batch.Results {
+		if item.Error != "" {
+			t.Fatalf("Failed to create URL: %s", item.Error)
+		}
+		url, err := pgRepo.GetByShortCode(ctx, item.ShortCode)
+		if err != nil {
+			t.Fatalf("Failed to get URL: %v", err)
+		}
+		if item.ShortCode != generator.Encode(url.ID) {
+			t.Errorf("Expected short code %s for ID %d, got %s", generator.Encode(url.ID), url.ID, item.ShortCode)
+		}
+	}
+}
+
 // ------------------------------------------------------------------------------------------
 //                                    BENCHMARK TESTS
 // ------------------------------------------------------------------------------------------
@@ -481,7 +1301,7 @@ func BenchmarkIntegration_CreateShortURL(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		longURL := fmt.Sprintf("https://example.com/benchmark/%d", i)
-		_, err := testService.CreateShortURL(ctx, longURL)
+		_, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: longURL})
 		if err != nil {
 			b.Fatalf("Failed to create URL: %v", err)
 		}
@@ -495,14 +1315,14 @@ func BenchmarkIntegration_GetLongURL_CacheHit(b *testing.B) {
 
 	ctx := context.Background()
 
-	result, err := testService.CreateShortURL(ctx, "https://example.com/benchmark")
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/benchmark"})
 	if err != nil {
 		b.Fatalf("Failed to create URL: %v", err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := testService.GetLongURL(ctx, result.ShortCode)
+		_, err := testService.GetLongURL(ctx, result.ShortCode, nil)
 		if err != nil {
 			b.Fatalf("Failed to get URL: %v", err)
 		}
@@ -516,7 +1336,7 @@ func BenchmarkIntegration_GetLongURL_CacheMiss(b *testing.B) {
 
 	ctx := context.Background()
 
-	result, err := testService.CreateShortURL(ctx, "https://example.com/benchmark")
+	result, err := testService.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/benchmark"})
 	if err != nil {
 		b.Fatalf("Failed to create URL: %v", err)
 	}
@@ -525,7 +1345,7 @@ func BenchmarkIntegration_GetLongURL_CacheMiss(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		testRedisClient.Del(ctx, result.ShortCode)
 
-		_, err := testService.GetLongURL(ctx, result.ShortCode)
+		_, err := testService.GetLongURL(ctx, result.ShortCode, nil)
 		if err != nil {
 			b.Fatalf("Failed to get URL: %v", err)
 		}
@@ -0,0 +1,142 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	goqrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+var ErrUnknownLevel = errors.New("unknown error correction level")
+
+// levels maps the error correction levels accepted by Render to the ones of the encoder.
+// Higher levels survive more damage to the printed code, at the cost of a denser symbol.
+var levels = map[string]goqrcode.RecoveryLevel{
+	"L": goqrcode.Low,
+	"M": goqrcode.Medium,
+	"Q": goqrcode.High,
+	"H": goqrcode.Highest,
+}
+
+// Options controls how a QR code is rendered.
+type Options struct {
+	// Size is the width and height of the image in pixels.
+	Size int
+	// Format is FormatPNG or FormatSVG.
+	Format string
+	// Level is the error correction level: L, M, Q or H.
+	Level string
+	// Margin is the width of the quiet zone around the symbol, in modules.
+	Margin int
+}
+
+// IsValidLevel reports whether the given error correction level is supported.
+func IsValidLevel(level string) bool {
+	_, ok := levels[level]
+	return ok
+}
+
+// Render encodes content as a QR code and renders it in the requested format.
+// It returns ErrUnknownLevel if the error correction level is not supported, or an error if the content
+// doesn't fit in a QR code.
+func Render(content string, opts Options) ([]byte, error) {
+	level, ok := levels[opts.Level]
+	if !ok {
+		return nil, ErrUnknownLevel
+	}
+
+	code, err := goqrcode.New(content, level)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding QR code: %w", err)
+	}
+	code.DisableBorder = true
+	modules := withMargin(code.Bitmap(), opts.Margin)
+
+	switch opts.Format {
+	case FormatPNG:
+		return renderPNG(modules, opts.Size)
+	case FormatSVG:
+		return renderSVG(modules, opts.Size), nil
+	default:
+		return nil, fmt.Errorf("unknown QR code format: %s", opts.Format)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------
+//                                      PRIVATE FUNCTIONS
+// ---------------------------------------------------------------------------------------------
+
+// withMargin surrounds the modules of a symbol with a quiet zone of the given width.
+func withMargin(bitmap [][]bool, margin int) [][]bool {
+	size := len(bitmap) + 2*margin
+
+	modules := make([][]bool, size)
+	for y := range modules {
+		modules[y] = make([]bool, size)
+	}
+	for y, row := range bitmap {
+		copy(modules[y+margin][margin:], row)
+	}
+
+	return modules
+}
+
+// renderPNG draws the modules on a black and white image of size by size pixels.
+// Modules are stretched to fill the image exactly, so they may differ by one pixel if size is not
+// a multiple of the number of modules.
+func renderPNG(modules [][]bool, size int) ([]byte, error) {
+	palette := color.Palette{color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+
+	count := len(modules)
+	for y := 0; y < size; y++ {
+		row := modules[y*count/size]
+		for x := 0; x < size; x++ {
+			if row[x*count/size] {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("error encoding PNG: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// renderSVG draws the modules as a single path, using one user unit per module.
+// The image scales without loss, so size only sets its default width and height.
+func renderSVG(modules [][]bool, size int) []byte {
+	count := len(modules)
+
+	var path strings.Builder
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, count, count)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#ffffff"/>`, count, count)
+	fmt.Fprintf(&buf, `<path d="%s" fill="#000000"/>`, path.String())
+	buf.WriteString("</svg>\n")
+
+	return buf.Bytes()
+}
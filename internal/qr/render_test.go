@@ -0,0 +1,92 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+const testContent = "http://localhost:8080/abc123"
+
+func TestRender_PNG(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		margin int
+	}{
+		{
+			name:   "default margin",
+			size:   256,
+			margin: 4,
+		},
+		{
+			name:   "no margin",
+			size:   100,
+			margin: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Render(testContent, Options{Size: tt.size, Format: FormatPNG, Level: "M", Margin: tt.margin})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("expected a valid PNG: %v", err)
+			}
+			if bounds := img.Bounds(); bounds.Dx() != tt.size || bounds.Dy() != tt.size {
+				t.Errorf("expected %dx%d image, got %dx%d", tt.size, tt.size, bounds.Dx(), bounds.Dy())
+			}
+
+			// The top left pixel belongs to the quiet zone, or to a finder pattern without margin
+			r, _, _, _ := img.At(0, 0).RGBA()
+			if dark := r == 0; dark != (tt.margin == 0) {
+				t.Errorf("expected top left pixel dark=%v, got dark=%v", tt.margin == 0, dark)
+			}
+		})
+	}
+}
+
+func TestRender_SVG(t *testing.T) {
+	viewBox := regexp.MustCompile(`viewBox="0 0 (\d+) (\d+)"`)
+
+	modules := func(margin int) int {
+		data, err := Render(testContent, Options{Size: 256, Format: FormatSVG, Level: "Q", Margin: margin})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Contains(data, []byte(`width="256" height="256"`)) {
+			t.Errorf("expected the SVG to default to 256 pixels, got %s", data)
+		}
+
+		match := viewBox.FindSubmatch(data)
+		if match == nil {
+			t.Fatalf("expected a viewBox, got %s", data)
+		}
+		count, _ := strconv.Atoi(string(match[1]))
+		return count
+	}
+
+	withoutMargin, withMargin := modules(0), modules(2)
+	if withMargin-withoutMargin != 4 {
+		t.Errorf("expected a margin of 2 modules on each side, got %d and %d modules", withoutMargin, withMargin)
+	}
+}
+
+func TestRender_Errors(t *testing.T) {
+	_, err := Render(testContent, Options{Size: 256, Format: FormatPNG, Level: "X"})
+	if !errors.Is(err, ErrUnknownLevel) {
+		t.Errorf("expected ErrUnknownLevel, got %v", err)
+	}
+
+	_, err = Render(testContent, Options{Size: 256, Format: "gif", Level: "M"})
+	if err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
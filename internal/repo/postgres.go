@@ -2,7 +2,12 @@ package repo
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Elisandil/go-snap/internal/domain"
@@ -16,8 +21,20 @@ var (
 	ErrNotFound         = errors.New("url not found")
 	ErrAlreadyExists    = errors.New("short code for URL already exists")
 	ErrInvalidShortCode = errors.New("invalid short code: must be between 1 and 10 characters")
+	ErrAPIKeyNotFound   = errors.New("api key not found")
+	ErrLimitReached     = errors.New("url has reached its click limit")
 )
 
+// urlColumns lists the columns read by scanURL, in the same order.
+const urlColumns = `id, short_code, long_url, created_at, clicks, expires_at, disabled_at, owner_id, preview,
+	redirect_status, passthrough, password_hash, max_clicks, targeting, variants, sticky_variants`
+
+// apiKeyColumns lists the columns read by scanAPIKey, in the same order.
+const apiKeyColumns = `id, owner_id, name, key_hash, created_at, revoked_at`
+
+// schemaLockID is the key of the advisory lock taken while the schema is migrated.
+const schemaLockID int64 = 0x676f736e6170
+
 // PostgresRepo is a repository that uses PostgreSQL as the backend.
 type PostgresRepo struct {
 	pool *pgxpool.Pool
@@ -30,22 +47,55 @@ func NewPostgresRepo(pool *pgxpool.Pool) *PostgresRepo {
 	}
 }
 
+// Migrate applies the given schema, whose statements must be idempotent, in a single transaction.
+// Instances starting at the same time apply it one after the other, under an advisory lock.
+func (r *PostgresRepo) Migrate(ctx context.Context, schema string) error {
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, schemaLockID); err != nil {
+		return err
+	}
+	// Without arguments, the statements of the schema are sent together with the simple protocol
+	if _, err := tx.Exec(ctx, schema); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
 // Create inserts a new URL mapping into the database.
-// Use DEFAULT for id to auto-generate it.
-// The id parameter is kept for compatibility but is not used.
-func (r *PostgresRepo) Create(ctx context.Context, id int64, shortCode, longURL string) (*domain.URL, error) {
+// The ID is auto-generated by the sequence, unless url.ID is set to a value already drawn from it with GetNextID.
+// It returns the stored URL with its ID and creation date.
+func (r *PostgresRepo) Create(ctx context.Context, url *domain.URL) (*domain.URL, error) {
 
-	if !validator.IsValidShortCode(shortCode) {
+	if !validator.IsValidShortCode(url.ShortCode) {
 		return nil, ErrInvalidShortCode
 	}
-	query := `INSERT INTO urls (id, short_code, long_url, created_at, clicks) 
-			VALUES (DEFAULT, $1, $2, $3, 0) 
-			RETURNING id, short_code, long_url, created_at, clicks`
-
-	var url domain.URL
-	err := r.pool.QueryRow(ctx, query, shortCode, longURL, time.Now()).
-		Scan(&url.ID, &url.ShortCode, &url.LongURL, &url.CreatedAt, &url.Clicks)
+	targeting, err := marshalJSONList(url.Targeting)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding targeting rules: %w", err)
+	}
+	variants, err := marshalJSONList(url.Variants)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding variants: %w", err)
+	}
+	query := `INSERT INTO urls (id, short_code, long_url, long_url_hash, created_at, clicks, expires_at, owner_id, 
+				preview, redirect_status, passthrough, password_hash, max_clicks, targeting, variants, sticky_variants) 
+			VALUES (COALESCE($15::bigint, nextval('urls_id_seq')), $1, $2, $3, $4, 0, $5, $6, $7, $8, $9, $10, $11, 
+				$12::jsonb, $13::jsonb, $14) 
+			RETURNING ` + urlColumns
 
+	created, err := scanURL(r.pool.QueryRow(ctx, query,
+		url.ShortCode, url.LongURL, hashLongURL(url.LongURL), time.Now(), url.ExpiresAt, url.OwnerID, url.Preview,
+		url.RedirectStatus, url.Passthrough, url.PasswordHash, url.MaxClicks, targeting, variants, url.StickyVariants,
+		drawnID(url.ID)))
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
@@ -54,7 +104,98 @@ func (r *PostgresRepo) Create(ctx context.Context, id int64, shortCode, longURL
 		return nil, err
 	}
 
-	return &url, nil
+	return created, nil
+}
+
+// CreateBatch inserts several URL mappings with a single multi-row INSERT.
+// As in Create, IDs are auto-generated by the sequence unless they were drawn from it beforehand.
+// Rows whose short code is already taken, including by an earlier row of the same batch, are skipped
+// instead of failing the whole statement, so the caller can retry them with new codes.
+// It returns the stored URLs, in no particular order.
+func (r *PostgresRepo) CreateBatch(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error) {
+
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]*int64, 0, len(urls))
+	shortCodes := make([]string, 0, len(urls))
+	longURLs := make([]string, 0, len(urls))
+	hashes := make([]string, 0, len(urls))
+	expirations := make([]*time.Time, 0, len(urls))
+	owners := make([]*int64, 0, len(urls))
+	previews := make([]bool, 0, len(urls))
+	statuses := make([]int, 0, len(urls))
+	passthroughs := make([]bool, 0, len(urls))
+	passwordHashes := make([]string, 0, len(urls))
+	maxClicks := make([]int64, 0, len(urls))
+	targetings := make([]*string, 0, len(urls))
+	variantLists := make([]*string, 0, len(urls))
+	stickies := make([]bool, 0, len(urls))
+	for _, url := range urls {
+		if !validator.IsValidShortCode(url.ShortCode) {
+			return nil, ErrInvalidShortCode
+		}
+		ids = append(ids, drawnID(url.ID))
+		shortCodes = append(shortCodes, url.ShortCode)
+		longURLs = append(longURLs, url.LongURL)
+		hashes = append(hashes, hashLongURL(url.LongURL))
+		expirations = append(expirations, url.ExpiresAt)
+		owners = append(owners, url.OwnerID)
+		previews = append(previews, url.Preview)
+		statuses = append(statuses, url.RedirectStatus)
+		passthroughs = append(passthroughs, url.Passthrough)
+		passwordHashes = append(passwordHashes, url.PasswordHash)
+		maxClicks = append(maxClicks, url.MaxClicks)
+		stickies = append(stickies, url.StickyVariants)
+
+		targeting, err := marshalJSONList(url.Targeting)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding targeting rules: %w", err)
+		}
+		targetings = append(targetings, targeting)
+
+		variants, err := marshalJSONList(url.Variants)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding variants: %w", err)
+		}
+		variantLists = append(variantLists, variants)
+	}
+
+	query := `INSERT INTO urls (id, short_code, long_url, long_url_hash, created_at, clicks, expires_at, owner_id, 
+					preview, redirect_status, passthrough, password_hash, max_clicks, targeting, variants, sticky_variants) 
+				SELECT COALESCE(batch.id, nextval('urls_id_seq')), batch.short_code, batch.long_url, batch.long_url_hash, 
+					$14, 0, batch.expires_at, batch.owner_id, batch.preview, batch.redirect_status, batch.passthrough, 
+					batch.password_hash, batch.max_clicks, batch.targeting::jsonb, batch.variants::jsonb, 
+					batch.sticky_variants 
+				FROM unnest($1::text[], $2::text[], $3::text[], $4::timestamptz[], $5::bigint[], $6::boolean[], 
+					$7::smallint[], $8::boolean[], $9::text[], $10::bigint[], $11::text[], $12::text[], $13::boolean[], 
+					$15::bigint[]) 
+					AS batch(short_code, long_url, long_url_hash, expires_at, owner_id, preview, redirect_status, 
+						passthrough, password_hash, max_clicks, targeting, variants, sticky_variants, id) 
+				ON CONFLICT (short_code) DO NOTHING 
+				RETURNING ` + urlColumns
+
+	rows, err := r.pool.Query(ctx, query, shortCodes, longURLs, hashes, expirations, owners, previews, statuses,
+		passthroughs, passwordHashes, maxClicks, targetings, variantLists, stickies, time.Now(), ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	created := make([]*domain.URL, 0, len(urls))
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
 }
 
 // GetByShortCode retrieves a URL mapping by its short code.
@@ -63,14 +204,38 @@ func (r *PostgresRepo) GetByShortCode(ctx context.Context, shortCode string) (*d
 	if !validator.IsValidShortCode(shortCode) {
 		return nil, ErrInvalidShortCode
 	}
-	query := `SELECT id, short_code, long_url, created_at, clicks
+	query := `SELECT ` + urlColumns + `
 				FROM urls
 				WHERE short_code = $1`
 
-	var url domain.URL
-	err := r.pool.QueryRow(ctx, query, shortCode).
-		Scan(&url.ID, &url.ShortCode, &url.LongURL, &url.CreatedAt, &url.Clicks)
+	url, err := scanURL(r.pool.QueryRow(ctx, query, shortCode))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
 
+	return url, nil
+}
+
+// GetByLongURL retrieves the oldest URL mapping for the given long URL that can be shared with a new request:
+// one of the same owner, which may be nil for URLs without owner, that is enabled, never expires and
+// redirects with the server default status, without a preview page, passthrough, password, click limit,
+// targeting rules or variants.
+// The lookup goes through the indexed hash of the long URL, so longURL must be normalized like on creation.
+// If there is no such mapping, it returns ErrNotFound.
+func (r *PostgresRepo) GetByLongURL(ctx context.Context, longURL string, ownerID *int64) (*domain.URL, error) {
+	query := `SELECT ` + urlColumns + `
+				FROM urls
+				WHERE long_url_hash = $1 AND long_url = $2 AND owner_id IS NOT DISTINCT FROM $3 
+					AND disabled_at IS NULL AND expires_at IS NULL AND NOT preview AND redirect_status = 0 
+					AND NOT passthrough AND password_hash = '' AND max_clicks = 0 
+					AND targeting IS NULL AND variants IS NULL AND NOT sticky_variants
+				ORDER BY id
+				LIMIT 1`
+
+	url, err := scanURL(r.pool.QueryRow(ctx, query, hashLongURL(longURL), longURL, ownerID))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotFound
@@ -78,17 +243,16 @@ func (r *PostgresRepo) GetByShortCode(ctx context.Context, shortCode string) (*d
 		return nil, err
 	}
 
-	return &url, nil
+	return url, nil
 }
 
-// IncrementClicksCounter increments the click counter for a given short code.
-func (r *PostgresRepo) IncrementClicksCounter(ctx context.Context, shortCode string) error {
+// Delete permanently removes the URL mapping for a given short code.
+func (r *PostgresRepo) Delete(ctx context.Context, shortCode string) error {
 
 	if !validator.IsValidShortCode(shortCode) {
 		return ErrInvalidShortCode
 	}
-	query := `UPDATE urls 
-				SET clicks = clicks + 1 
+	query := `DELETE FROM urls 
 				WHERE short_code = $1`
 
 	result, err := r.pool.Exec(ctx, query, shortCode)
@@ -102,6 +266,407 @@ func (r *PostgresRepo) IncrementClicksCounter(ctx context.Context, shortCode str
 	return nil
 }
 
+// ClaimUnownedURLs assigns the URL mappings without an owner, created before API keys existed, to the given owner.
+// It returns the number of claimed URL mappings.
+func (r *PostgresRepo) ClaimUnownedURLs(ctx context.Context, ownerID int64) (int64, error) {
+	query := `UPDATE urls 
+				SET owner_id = $1 
+				WHERE owner_id IS NULL`
+
+	result, err := r.pool.Exec(ctx, query, ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// SetDisabled marks the URL mapping for a given short code as disabled or enabled again.
+// Disabling keeps the first disable time if the URL was already disabled.
+func (r *PostgresRepo) SetDisabled(ctx context.Context, shortCode string, disabled bool) (*domain.URL, error) {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return nil, ErrInvalidShortCode
+	}
+	query := `UPDATE urls 
+				SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END 
+				WHERE short_code = $1 
+				RETURNING ` + urlColumns
+
+	url, err := scanURL(r.pool.QueryRow(ctx, query, shortCode, disabled))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return url, nil
+}
+
+// UpdateLongURL changes the destination of the URL mapping for a given short code.
+// The previous destination is recorded in url_destination_changes within the same transaction.
+// If the destination doesn't change, no history entry is recorded.
+func (r *PostgresRepo) UpdateLongURL(ctx context.Context, shortCode, longURL string) (*domain.URL, error) {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return nil, ErrInvalidShortCode
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var urlID int64
+	var previousLongURL string
+	selectQuery := `SELECT id, long_url 
+				FROM urls 
+				WHERE short_code = $1 
+				FOR UPDATE`
+
+	if err := tx.QueryRow(ctx, selectQuery, shortCode).Scan(&urlID, &previousLongURL); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if previousLongURL != longURL {
+		historyQuery := `INSERT INTO url_destination_changes (url_id, previous_long_url, new_long_url, changed_at) 
+				VALUES ($1, $2, $3, $4)`
+
+		if _, err := tx.Exec(ctx, historyQuery, urlID, previousLongURL, longURL, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	updateQuery := `UPDATE urls 
+				SET long_url = $2, long_url_hash = $3 
+				WHERE id = $1 
+				RETURNING ` + urlColumns
+
+	url, err := scanURL(tx.QueryRow(ctx, updateQuery, urlID, longURL, hashLongURL(longURL)))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return url, nil
+}
+
+// GetDestinationHistory retrieves the destination changes of a URL, oldest first.
+func (r *PostgresRepo) GetDestinationHistory(ctx context.Context, urlID int64) ([]domain.DestinationChange, error) {
+	query := `SELECT previous_long_url, new_long_url, changed_at 
+				FROM url_destination_changes 
+				WHERE url_id = $1 
+				ORDER BY changed_at, id`
+
+	rows, err := r.pool.Query(ctx, query, urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]domain.DestinationChange, 0)
+	for rows.Next() {
+		var change domain.DestinationChange
+		if err := rows.Scan(&change.PreviousLongURL, &change.NewLongURL, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+// IncrementClicksBatch adds the given click deltas to their short codes in a single statement.
+// Short codes that no longer exist are skipped.
+func (r *PostgresRepo) IncrementClicksBatch(ctx context.Context, deltas map[string]int64) error {
+
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	shortCodes := make([]string, 0, len(deltas))
+	counts := make([]int64, 0, len(deltas))
+	for shortCode, delta := range deltas {
+		shortCodes = append(shortCodes, shortCode)
+		counts = append(counts, delta)
+	}
+
+	query := `UPDATE urls 
+				SET clicks = urls.clicks + batch.delta 
+				FROM unnest($1::text[], $2::bigint[]) AS batch(short_code, delta) 
+				WHERE urls.short_code = batch.short_code`
+
+	_, err := r.pool.Exec(ctx, query, shortCodes, counts)
+
+	return err
+}
+
+// IncrementClickWithinLimit adds one click to a short code with a click limit, in a single statement that
+// only succeeds while the counter is below the limit, so concurrent redirects can't go over it.
+// If the limit has been reached, or the short code no longer exists, it returns ErrLimitReached.
+func (r *PostgresRepo) IncrementClickWithinLimit(ctx context.Context, shortCode string) error {
+	query := `UPDATE urls 
+				SET clicks = clicks + 1 
+				WHERE short_code = $1 AND clicks < max_clicks 
+				RETURNING clicks`
+
+	var clicks int64
+	if err := r.pool.QueryRow(ctx, query, shortCode).Scan(&clicks); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrLimitReached
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RecordClicks stores a batch of click events using the Postgres COPY protocol.
+// Events whose URL has been deleted in the meantime are skipped.
+func (r *PostgresRepo) RecordClicks(ctx context.Context, events []*domain.ClickEvent) error {
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	createQuery := `CREATE TEMPORARY TABLE clicks_batch 
+				(LIKE clicks INCLUDING DEFAULTS) 
+				ON COMMIT DROP`
+
+	if _, err := tx.Exec(ctx, createQuery); err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"clicks_batch"},
+		[]string{"url_id", "clicked_at", "referrer_host", "user_agent_class", "ip_prefix", "variant"},
+		pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+			event := events[i]
+			return []any{event.URLID, event.ClickedAt, event.ReferrerHost, event.UserAgentClass, event.IPPrefix,
+				int16(event.Variant)}, nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	insertQuery := `INSERT INTO clicks (url_id, clicked_at, referrer_host, user_agent_class, ip_prefix, variant) 
+				SELECT batch.url_id, batch.clicked_at, batch.referrer_host, batch.user_agent_class, batch.ip_prefix, 
+					batch.variant 
+				FROM clicks_batch AS batch 
+				JOIN urls ON urls.id = batch.url_id`
+
+	if _, err := tx.Exec(ctx, insertQuery); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetClickSeries counts the click events of a URL in buckets of the given interval.
+// The interval must be a valid date_trunc field such as "hour" or "day".
+// Every bucket between from (inclusive) and to (exclusive) is returned, including empty ones.
+func (r *PostgresRepo) GetClickSeries(ctx context.Context,
+	urlID int64,
+	query *domain.SeriesQuery) ([]domain.ClickBucket, error) {
+
+	sql := `SELECT buckets.start, COUNT(clicks.id) 
+				FROM generate_series(
+					date_trunc($2, $3::timestamptz), 
+					$4::timestamptz - interval '1 microsecond', 
+					('1 ' || $2)::interval
+				) AS buckets(start) 
+				LEFT JOIN clicks ON clicks.url_id = $1 
+					AND clicks.clicked_at >= $3 
+					AND clicks.clicked_at < $4 
+					AND date_trunc($2, clicks.clicked_at) = buckets.start 
+				GROUP BY buckets.start 
+				ORDER BY buckets.start`
+
+	rows, err := r.pool.Query(ctx, sql, urlID, query.Interval, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := make([]domain.ClickBucket, 0)
+	for rows.Next() {
+		var bucket domain.ClickBucket
+		if err := rows.Scan(&bucket.Start, &bucket.Clicks); err != nil {
+			return nil, err
+		}
+		series = append(series, bucket)
+	}
+
+	return series, rows.Err()
+}
+
+// GetVariantClicks counts the click events of a URL by the 1-based index of the variant they were sent to.
+// Variants without clicks, and clicks recorded without a variant, are left out.
+func (r *PostgresRepo) GetVariantClicks(ctx context.Context, urlID int64) (map[int]int64, error) {
+	query := `SELECT variant, COUNT(*) 
+				FROM clicks 
+				WHERE url_id = $1 AND variant > 0 
+				GROUP BY variant`
+
+	rows, err := r.pool.Query(ctx, query, urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clicks := make(map[int]int64)
+	for rows.Next() {
+		var variant int
+		var count int64
+		if err := rows.Scan(&variant, &count); err != nil {
+			return nil, err
+		}
+		clicks[variant] = count
+	}
+
+	return clicks, rows.Err()
+}
+
+// ListDomainRules retrieves every stored domain rule.
+func (r *PostgresRepo) ListDomainRules(ctx context.Context) ([]domain.DomainRule, error) {
+	query := `SELECT domain, action 
+				FROM domain_rules 
+				ORDER BY domain`
+
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]domain.DomainRule, 0)
+	for rows.Next() {
+		var rule domain.DomainRule
+		if err := rows.Scan(&rule.Domain, &rule.Action); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// ListURLs retrieves a page of URL mappings matching the given query, with keyset pagination.
+// URLs are sorted by query.Sort, "created_at" or "clicks", in descending order with the ID as tiebreaker,
+// and the page starts right after query.After when it is set.
+// Filters that are left empty are ignored. At most query.Limit URLs are returned.
+func (r *PostgresRepo) ListURLs(ctx context.Context, query *domain.ListURLsQuery) ([]*domain.URL, error) {
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.OwnerID != nil {
+		conditions = append(conditions, "owner_id = "+arg(*query.OwnerID))
+	}
+	if query.Search != "" {
+		conditions = append(conditions, "long_url ILIKE "+arg("%"+escapeLike(query.Search)+"%"))
+	}
+	if query.Domain != "" {
+		host := `lower(substring(long_url FROM '^[a-zA-Z][a-zA-Z0-9+.-]*://(?:[^@/]*@)?([^/:?#]+)'))`
+		domainArg := arg(strings.ToLower(query.Domain))
+		conditions = append(conditions, "("+host+" = "+domainArg+" OR "+host+" LIKE '%.' || "+domainArg+")")
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(query.CreatedFrom))
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(query.CreatedTo))
+	}
+
+	sortColumn := "created_at"
+	if query.Sort == "clicks" {
+		sortColumn = "clicks"
+	}
+	if query.After != nil {
+		var after any = query.After.CreatedAt
+		if sortColumn == "clicks" {
+			after = query.After.Clicks
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) < (%s, %s)", sortColumn, arg(after), arg(query.After.ID)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	sql := `SELECT ` + urlColumns + `
+				FROM urls 
+				` + where + ` 
+				ORDER BY ` + sortColumn + ` DESC, id DESC 
+				LIMIT ` + arg(query.Limit)
+
+	rows, err := r.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]*domain.URL, 0, query.Limit)
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+// CreateAPIKey stores a new API key. Only the hash of the key is stored.
+// It returns the stored key with the generated ID and creation date.
+func (r *PostgresRepo) CreateAPIKey(ctx context.Context, key *domain.APIKey) (*domain.APIKey, error) {
+	query := `INSERT INTO api_keys (owner_id, name, key_hash, created_at) 
+				VALUES ($1, $2, $3, $4) 
+				RETURNING ` + apiKeyColumns
+
+	return scanAPIKey(r.pool.QueryRow(ctx, query, key.OwnerID, key.Name, key.KeyHash, time.Now()))
+}
+
+// GetAPIKeyByHash retrieves the API key with the given hash, as long as it hasn't been revoked.
+// If there is no such key, it returns ErrAPIKeyNotFound.
+func (r *PostgresRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + `
+				FROM api_keys
+				WHERE key_hash = $1 AND revoked_at IS NULL`
+
+	key, err := scanAPIKey(r.pool.QueryRow(ctx, query, keyHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
 // GetNextID retrieves the next value from the URL ID sequence.
 func (r *PostgresRepo) GetNextID(ctx context.Context) (int64, error) {
 	query := `SELECT nextval('urls_id_seq')`
@@ -114,3 +679,100 @@ func (r *PostgresRepo) GetNextID(ctx context.Context) (int64, error) {
 
 	return id, nil
 }
+
+// GetNextIDs retrieves the given number of next values from the URL ID sequence, in a single query.
+func (r *PostgresRepo) GetNextIDs(ctx context.Context, count int) ([]int64, error) {
+	query := `SELECT nextval('urls_id_seq') FROM generate_series(1, $1)`
+
+	rows, err := r.pool.Query(ctx, query, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, count)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// drawnID returns the given ID of a URL to insert, or nil to let the sequence generate it if it is not set.
+func drawnID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+// hashLongURL returns the hex-encoded SHA-256 of a long URL, as stored in the long_url_hash column.
+// Long URLs are unbounded, so lookups by destination go through this fixed-size hash instead.
+func hashLongURL(longURL string) string {
+	sum := sha256.Sum256([]byte(longURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, so the value is matched literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// scanURL reads a single row selected with urlColumns into a domain.URL.
+func scanURL(row pgx.Row) (*domain.URL, error) {
+	var url domain.URL
+	var targeting, variants []byte
+	err := row.Scan(&url.ID, &url.ShortCode, &url.LongURL, &url.CreatedAt, &url.Clicks,
+		&url.ExpiresAt, &url.DisabledAt, &url.OwnerID, &url.Preview, &url.RedirectStatus,
+		&url.Passthrough, &url.PasswordHash, &url.MaxClicks, &targeting, &variants, &url.StickyVariants)
+	if err != nil {
+		return nil, err
+	}
+	if targeting != nil {
+		if err := json.Unmarshal(targeting, &url.Targeting); err != nil {
+			return nil, fmt.Errorf("error decoding targeting rules: %w", err)
+		}
+	}
+	if variants != nil {
+		if err := json.Unmarshal(variants, &url.Variants); err != nil {
+			return nil, fmt.Errorf("error decoding variants: %w", err)
+		}
+	}
+
+	return &url, nil
+}
+
+// marshalJSONList encodes a list, such as targeting rules or variants, for a nullable JSONB column.
+// Empty lists are stored as NULL and return nil.
+func marshalJSONList[T any](values []T) (*string, error) {
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	encoded := string(data)
+
+	return &encoded, nil
+}
+
+// scanAPIKey reads a single row selected with apiKeyColumns into a domain.APIKey.
+func scanAPIKey(row pgx.Row) (*domain.APIKey, error) {
+	var key domain.APIKey
+	err := row.Scan(&key.ID, &key.OwnerID, &key.Name, &key.KeyHash, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/Elisandil/go-snap/internal/domain"
@@ -11,6 +12,17 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrCachedNotFound is returned by Get for a short code cached as not found by SetNotFound. It wraps ErrNotFound.
+var ErrCachedNotFound = fmt.Errorf("%w: cached", ErrNotFound)
+
+// replaceScript sets KEYS[1] to ARGV[2], with a TTL of ARGV[3] milliseconds, only if it still holds ARGV[1].
+var replaceScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return false
+`)
+
 type RedisRepo struct {
 	client *redis.Client
 	ttl    time.Duration
@@ -24,21 +36,83 @@ func NewRedisRepo(client *redis.Client, ttl time.Duration) *RedisRepo {
 }
 
 // Set stores in cache the URL associated with the given short code in Redis with a TTL.
+// The TTL is capped at the remaining lifetime of the URL, so an expired link is never served from cache.
 func (r *RedisRepo) Set(ctx context.Context, shortCode string, url *domain.URL) error {
 
 	if !validator.IsValidShortCode(shortCode) {
 		return ErrInvalidShortCode
 	}
 
+	ttl, ok := r.entryTTL(url)
+	if !ok {
+		return r.client.Del(ctx, shortCode).Err()
+	}
+
+	data, err := json.Marshal(url)
+	if err != nil {
+		return err
+	}
+
+	return r.client.Set(ctx, shortCode, data, ttl).Err()
+}
+
+// Add stores in cache the URL associated with the given short code in Redis like Set, but only if nothing is
+// cached for the short code yet, not even as not found. It fills cache misses, so that a URL read from the
+// database before a change can't replace the entry written after it. An expired URL isn't stored.
+func (r *RedisRepo) Add(ctx context.Context, shortCode string, url *domain.URL) error {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return ErrInvalidShortCode
+	}
+
+	ttl, ok := r.entryTTL(url)
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(url)
+	if err != nil {
+		return err
+	}
+
+	return r.client.SetNX(ctx, shortCode, data, ttl).Err()
+}
+
+// Replace stores in cache the URL associated with the given short code in Redis like Set, but only if the
+// cached entry is still the previous URL, as returned by Get. It refreshes entries ahead of their expiration,
+// so that a URL read from the database before a change can't replace the entry written after it.
+// Nothing is stored if the entry changed or the URL expired.
+func (r *RedisRepo) Replace(ctx context.Context, shortCode string, previous, url *domain.URL) error {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return ErrInvalidShortCode
+	}
+
+	ttl, ok := r.entryTTL(url)
+	if !ok {
+		return nil
+	}
+
+	expected, err := json.Marshal(previous)
+	if err != nil {
+		return err
+	}
 	data, err := json.Marshal(url)
 	if err != nil {
 		return err
 	}
 
-	return r.client.Set(ctx, shortCode, data, r.ttl).Err()
+	err = replaceScript.Run(ctx, r.client, []string{shortCode}, expected, data,
+		max(ttl.Milliseconds(), 1)).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+
+	return err
 }
 
 // Get retrieves from cache the URL associated with the given short code in Redis.
+// A short code cached as not found returns ErrCachedNotFound.
 func (r *RedisRepo) Get(ctx context.Context, shortCode string) (*domain.URL, error) {
 
 	if !validator.IsValidShortCode(shortCode) {
@@ -53,12 +127,62 @@ func (r *RedisRepo) Get(ctx context.Context, shortCode string) (*domain.URL, err
 		return nil, err
 	}
 
-	var url domain.URL
-	if err := json.Unmarshal(data, &url); err != nil {
-		return nil, err
+	return decodeURL(data)
+}
+
+// GetWithTTL retrieves from cache the URL associated with the given short code in Redis, like Get,
+// along with the time left before its entry expires, in a single round trip.
+// The TTL is zero if it is unknown, such as for an entry without expiration.
+func (r *RedisRepo) GetWithTTL(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error) {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return nil, 0, ErrInvalidShortCode
 	}
 
-	return &url, nil
+	pipe := r.client.Pipeline()
+	get := pipe.Get(ctx, shortCode)
+	pttl := pipe.PTTL(ctx, shortCode)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+
+	data, err := get.Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+	url, err := decodeURL(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return url, max(pttl.Val(), 0), nil
+}
+
+// SetNotFound caches in Redis that the given short code doesn't exist, for the given TTL, as an empty value.
+// A URL already cached for the short code is kept, so that a lookup that missed a URL while it was being
+// created doesn't hide it.
+func (r *RedisRepo) SetNotFound(ctx context.Context, shortCode string, ttl time.Duration) error {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return ErrInvalidShortCode
+	}
+
+	return r.client.SetNX(ctx, shortCode, "", ttl).Err()
+}
+
+// SetDeleted caches in Redis that the given short code doesn't exist, for the given TTL, like SetNotFound,
+// but replaces any URL cached for it. It is meant for a URL that was just deleted, so that a lookup that read
+// it from the database before the deletion can't cache it again with Add.
+func (r *RedisRepo) SetDeleted(ctx context.Context, shortCode string, ttl time.Duration) error {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return ErrInvalidShortCode
+	}
+
+	return r.client.Set(ctx, shortCode, "", ttl).Err()
 }
 
 // Delete removes from cache the URL associated with the given short code in Redis.
@@ -71,17 +195,44 @@ func (r *RedisRepo) Delete(ctx context.Context, shortCode string) error {
 	return r.client.Del(ctx, shortCode).Err()
 }
 
-// Exists checks if a short code exists in Redis.
+// Exists checks if a URL is cached for the short code in Redis. Short codes cached as not found don't exist.
 func (r *RedisRepo) Exists(ctx context.Context, shortCode string) (bool, error) {
 
 	if !validator.IsValidShortCode(shortCode) {
 		return false, ErrInvalidShortCode
 	}
 
-	result, err := r.client.Exists(ctx, shortCode).Result()
+	result, err := r.client.StrLen(ctx, shortCode).Result()
 	if err != nil {
 		return false, err
 	}
 
 	return result != 0, nil
 }
+
+// entryTTL returns the TTL of the cache entry of the given URL, capped at its remaining lifetime,
+// or false if it has already expired.
+func (r *RedisRepo) entryTTL(url *domain.URL) (time.Duration, bool) {
+
+	if url.ExpiresAt == nil {
+		return r.ttl, true
+	}
+	remaining := time.Until(*url.ExpiresAt)
+
+	return min(r.ttl, remaining), remaining > 0
+}
+
+// decodeURL decodes a URL cached by Set, or returns ErrCachedNotFound for the empty value of SetNotFound.
+func decodeURL(data []byte) (*domain.URL, error) {
+
+	if len(data) == 0 {
+		return nil, ErrCachedNotFound
+	}
+
+	var url domain.URL
+	if err := json.Unmarshal(data, &url); err != nil {
+		return nil, err
+	}
+
+	return &url, nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/repo"
+	"github.com/rs/zerolog/log"
+)
+
+// apiKeyPrefix marks GoSnap API keys, so they are easy to recognize in configuration files and secret scanners.
+const apiKeyPrefix = "gsk_"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// ownerContextKey is the context key under which the authenticated owner ID is stored.
+type ownerContextKey struct{}
+
+// ----------------------------------------------------------------------------------------
+//                                    INTERFACES
+// ----------------------------------------------------------------------------------------
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) (*domain.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error)
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    SERVICE
+// ----------------------------------------------------------------------------------------
+
+type AuthService struct {
+	keyRepo APIKeyRepository
+}
+
+func NewAuthService(keyRepo APIKeyRepository) *AuthService {
+	return &AuthService{
+		keyRepo: keyRepo,
+	}
+}
+
+// Authenticate checks the given raw API key against the stored key hashes.
+// If the key is unknown or has been revoked, it returns ErrInvalidAPIKey, and if the key store fails,
+// an error wrapping ErrUnavailable.
+// On success, it returns the stored API key, whose OwnerID identifies the caller.
+func (s *AuthService) Authenticate(ctx context.Context, rawKey string) (*domain.APIKey, error) {
+
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.keyRepo.GetAPIKeyByHash(ctx, HashAPIKey(rawKey))
+	if err != nil {
+		if errors.Is(err, repo.ErrAPIKeyNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		log.Error().Err(err).Msg("error retrieving API key from the database")
+
+		return nil, fmt.Errorf("error authenticating API key: %w", ErrUnavailable)
+	}
+
+	return key, nil
+}
+
+// CreateAPIKey generates a new API key for the given owner and stores its hash.
+// The raw key is only returned here and can't be recovered later.
+// On success, it returns the raw key and the stored API key.
+func (s *AuthService) CreateAPIKey(ctx context.Context, ownerID int64, name string) (string, *domain.APIKey, error) {
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("error generating API key: %w", err)
+	}
+	rawKey := apiKeyPrefix + hex.EncodeToString(secret)
+
+	key, err := s.keyRepo.CreateAPIKey(ctx, &domain.APIKey{
+		OwnerID: ownerID,
+		Name:    name,
+		KeyHash: HashAPIKey(rawKey),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("error storing API key: %w", err)
+	}
+
+	return rawKey, key, nil
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    FUNCTIONS
+// ----------------------------------------------------------------------------------------
+
+// HashAPIKey returns the hex-encoded SHA-256 of a raw API key, as stored in the api_keys table.
+func HashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// WithOwner returns a copy of ctx that carries the ID of the authenticated owner.
+// The ShortenerService assigns new URLs to this owner and restricts access to existing URLs to it.
+func WithOwner(ctx context.Context, ownerID int64) context.Context {
+	return context.WithValue(ctx, ownerContextKey{}, ownerID)
+}
+
+// OwnerFromContext returns the ID of the authenticated owner carried by ctx, if any.
+func OwnerFromContext(ctx context.Context) (int64, bool) {
+	ownerID, ok := ctx.Value(ownerContextKey{}).(int64)
+	return ownerID, ok
+}
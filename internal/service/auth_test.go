@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/repo"
+)
+
+// ------------------------------------------------------------------------------------------
+//                                        MOCKS
+// ------------------------------------------------------------------------------------------
+
+type mockAPIKeyRepo struct {
+	keys map[string]*domain.APIKey
+	err  error
+}
+
+func (m *mockAPIKeyRepo) CreateAPIKey(ctx context.Context, key *domain.APIKey) (*domain.APIKey, error) {
+
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.keys == nil {
+		m.keys = make(map[string]*domain.APIKey)
+	}
+	key.ID = int64(len(m.keys) + 1)
+	m.keys[key.KeyHash] = key
+
+	return key, nil
+}
+
+func (m *mockAPIKeyRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+
+	if m.err != nil {
+		return nil, m.err
+	}
+	key, ok := m.keys[keyHash]
+	if !ok {
+		return nil, repo.ErrAPIKeyNotFound
+	}
+
+	return key, nil
+}
+
+// ------------------------------------------------------------------------------------------
+//                                        TESTS
+// ------------------------------------------------------------------------------------------
+
+func TestAuthService_CreateAndAuthenticate(t *testing.T) {
+	keyRepo := &mockAPIKeyRepo{}
+	service := NewAuthService(keyRepo)
+	ctx := context.Background()
+
+	rawKey, created, err := service.CreateAPIKey(ctx, 7, "newsletter")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		t.Errorf("expected key to start with %s, got %s", apiKeyPrefix, rawKey)
+	}
+	if created.KeyHash == rawKey || created.KeyHash != HashAPIKey(rawKey) {
+		t.Error("expected only the hash of the key to be stored")
+	}
+
+	key, err := service.Authenticate(ctx, rawKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key.OwnerID != 7 {
+		t.Errorf("expected owner 7, got %d", key.OwnerID)
+	}
+
+	otherKey, _, err := service.CreateAPIKey(ctx, 7, "desktop")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if otherKey == rawKey {
+		t.Error("expected every key to be unique")
+	}
+}
+
+func TestAuthService_Authenticate_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		rawKey        string
+		repoErr       error
+		expectInvalid bool
+	}{
+		{name: "unknown key", rawKey: apiKeyPrefix + "unknown", expectInvalid: true},
+		{name: "missing prefix", rawKey: "unknown", expectInvalid: true},
+		{name: "database failure", rawKey: apiKeyPrefix + "unknown", repoErr: errors.New("db connection error")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewAuthService(&mockAPIKeyRepo{err: tt.repoErr})
+
+			_, err := service.Authenticate(context.Background(), tt.rawKey)
+			if err == nil {
+				t.Fatal("expected error but got nil")
+			}
+			if errors.Is(err, ErrInvalidAPIKey) != tt.expectInvalid {
+				t.Errorf("expected ErrInvalidAPIKey %v, got %v", tt.expectInvalid, err)
+			}
+		})
+	}
+}
+
+func TestOwnerFromContext(t *testing.T) {
+
+	if _, ok := OwnerFromContext(context.Background()); ok {
+		t.Error("expected no owner in an empty context")
+	}
+
+	ownerID, ok := OwnerFromContext(WithOwner(context.Background(), 42))
+	if !ok || ownerID != 42 {
+		t.Errorf("expected owner 42, got %d (%v)", ownerID, ok)
+	}
+}
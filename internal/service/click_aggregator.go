@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/rs/zerolog/log"
+)
+
+// ----------------------------------------------------------------------------------------
+//                                    INTERFACES
+// ----------------------------------------------------------------------------------------
+
+type ClickStore interface {
+	IncrementClicksBatch(ctx context.Context, deltas map[string]int64) error
+	RecordClicks(ctx context.Context, events []*domain.ClickEvent) error
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    AGGREGATOR
+// ----------------------------------------------------------------------------------------
+
+// clickAggregator accumulates clicks in memory and flushes them to the store on an interval.
+// Counters are kept as one delta per short code, so a hot link costs a single row update per flush
+// no matter how many redirects it served. Deltas that fail to flush are merged back and retried,
+// while click events are dropped once maxPendingEvents is reached to bound memory.
+type clickAggregator struct {
+	store            ClickStore
+	interval         time.Duration
+	maxPendingEvents int
+
+	mu     sync.Mutex
+	deltas map[string]int64
+	events []*domain.ClickEvent
+
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newClickAggregator(store ClickStore, interval time.Duration, maxPendingEvents int) *clickAggregator {
+	return &clickAggregator{
+		store:            store,
+		interval:         interval,
+		maxPendingEvents: maxPendingEvents,
+		deltas:           make(map[string]int64),
+		stop:             make(chan struct{}),
+		stopped:          make(chan struct{}),
+	}
+}
+
+// Start runs the periodic flush loop in a separate goroutine until Close is called.
+func (a *clickAggregator) Start() {
+	go func() {
+		defer close(a.stopped)
+
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), a.interval+5*time.Second)
+				a.Flush(ctx)
+				cancel()
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Add records one click on the given short code, along with its event.
+func (a *clickAggregator) Add(shortCode string, event *domain.ClickEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.deltas[shortCode]++
+	a.addEvent(shortCode, event)
+}
+
+// AddEvent records the event of a click whose counter has already been incremented in the store.
+func (a *clickAggregator) AddEvent(shortCode string, event *domain.ClickEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.addEvent(shortCode, event)
+}
+
+// addEvent queues an event unless maxPendingEvents is reached. It must be called with mu held.
+func (a *clickAggregator) addEvent(shortCode string, event *domain.ClickEvent) {
+	if len(a.events) < a.maxPendingEvents {
+		a.events = append(a.events, event)
+	} else {
+		log.Warn().Str("short_code", shortCode).Msg("click event buffer full, dropping event")
+	}
+}
+
+// Flush writes all accumulated deltas in one batched statement and all pending events in one copy.
+// If writing the deltas fails, they are merged back so the next flush retries them.
+func (a *clickAggregator) Flush(ctx context.Context) {
+	a.mu.Lock()
+	deltas, events := a.deltas, a.events
+	a.deltas = make(map[string]int64)
+	a.events = nil
+	a.mu.Unlock()
+
+	if len(deltas) > 0 {
+		if err := a.store.IncrementClicksBatch(ctx, deltas); err != nil {
+			log.Warn().Err(err).Int("short_codes", len(deltas)).Msg("error flushing click counters, " +
+				"retrying on next flush")
+			a.requeue(deltas, events)
+
+			return
+		}
+	}
+
+	if len(events) > 0 {
+		if err := a.store.RecordClicks(ctx, events); err != nil {
+			log.Warn().Err(err).Int("events", len(events)).Msg("error flushing click events, " +
+				"retrying on next flush")
+			a.requeue(nil, events)
+		}
+	}
+}
+
+// Close stops the flush loop and flushes whatever is still pending.
+// It is safe to call Close more than once.
+func (a *clickAggregator) Close(ctx context.Context) {
+	a.once.Do(func() {
+		close(a.stop)
+		<-a.stopped
+		a.Flush(ctx)
+	})
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE METHODS
+// ----------------------------------------------------------------------------------------
+
+// requeue merges deltas and events that failed to flush back into the pending buffers.
+func (a *clickAggregator) requeue(deltas map[string]int64, events []*domain.ClickEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for shortCode, delta := range deltas {
+		a.deltas[shortCode] += delta
+	}
+
+	room := a.maxPendingEvents - len(a.events)
+	if room < len(events) {
+		log.Warn().Int("dropped", len(events)-max(room, 0)).Msg("click event buffer full, dropping events")
+		events = events[:max(room, 0)]
+	}
+	a.events = append(events, a.events...)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+)
+
+// ------------------------------------------------------------------------------------------
+//                                        MOCKS
+// ------------------------------------------------------------------------------------------
+
+type mockClickStore struct {
+	mu           sync.Mutex
+	failBatches  int
+	batches      []map[string]int64
+	events       []*domain.ClickEvent
+	batchCalls   int
+	recordCalls  int
+	recordErrors int
+}
+
+func (m *mockClickStore) IncrementClicksBatch(ctx context.Context, deltas map[string]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.batchCalls++
+	if m.failBatches > 0 {
+		m.failBatches--
+		return errors.New("database unavailable")
+	}
+	m.batches = append(m.batches, deltas)
+
+	return nil
+}
+
+func (m *mockClickStore) RecordClicks(ctx context.Context, events []*domain.ClickEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordCalls++
+	if m.recordErrors > 0 {
+		m.recordErrors--
+		return errors.New("database unavailable")
+	}
+	m.events = append(m.events, events...)
+
+	return nil
+}
+
+func (m *mockClickStore) total(shortCode string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total int64
+	for _, batch := range m.batches {
+		total += batch[shortCode]
+	}
+	return total
+}
+
+// ------------------------------------------------------------------------------------------
+//                                        TESTS
+// ------------------------------------------------------------------------------------------
+
+func TestClickAggregator_FlushBatchesDeltasPerShortCode(t *testing.T) {
+	store := &mockClickStore{}
+	aggregator := newClickAggregator(store, time.Hour, 100)
+
+	for i := 0; i < 5; i++ {
+		aggregator.Add("abc123", &domain.ClickEvent{URLID: 1})
+	}
+	aggregator.Add("xyz789", &domain.ClickEvent{URLID: 2})
+	aggregator.Flush(context.Background())
+
+	if store.batchCalls != 1 {
+		t.Errorf("expected 1 batched update, got %d", store.batchCalls)
+	}
+	if store.total("abc123") != 5 {
+		t.Errorf("expected 5 clicks for 'abc123', got %d", store.total("abc123"))
+	}
+	if store.total("xyz789") != 1 {
+		t.Errorf("expected 1 click for 'xyz789', got %d", store.total("xyz789"))
+	}
+	if len(store.events) != 6 {
+		t.Errorf("expected 6 click events, got %d", len(store.events))
+	}
+
+	aggregator.Flush(context.Background())
+	if store.batchCalls != 1 {
+		t.Errorf("expected no update for an empty flush, got %d calls", store.batchCalls)
+	}
+}
+
+func TestClickAggregator_RetriesFailedFlush(t *testing.T) {
+	store := &mockClickStore{failBatches: 1}
+	aggregator := newClickAggregator(store, time.Hour, 100)
+
+	aggregator.Add("abc123", &domain.ClickEvent{URLID: 1})
+	aggregator.Add("abc123", &domain.ClickEvent{URLID: 1})
+	aggregator.Flush(context.Background())
+
+	if store.total("abc123") != 0 {
+		t.Fatalf("expected failed flush to write nothing, got %d", store.total("abc123"))
+	}
+
+	aggregator.Add("abc123", &domain.ClickEvent{URLID: 1})
+	aggregator.Flush(context.Background())
+
+	if store.total("abc123") != 3 {
+		t.Errorf("expected 3 clicks after retry, got %d", store.total("abc123"))
+	}
+	if len(store.events) != 3 {
+		t.Errorf("expected 3 click events after retry, got %d", len(store.events))
+	}
+}
+
+func TestClickAggregator_DropsEventsBeyondLimitButKeepsCounts(t *testing.T) {
+	store := &mockClickStore{}
+	aggregator := newClickAggregator(store, time.Hour, 2)
+
+	for i := 0; i < 5; i++ {
+		aggregator.Add("abc123", &domain.ClickEvent{URLID: 1})
+	}
+	aggregator.Flush(context.Background())
+
+	if store.total("abc123") != 5 {
+		t.Errorf("expected 5 clicks, got %d", store.total("abc123"))
+	}
+	if len(store.events) != 2 {
+		t.Errorf("expected 2 click events, got %d", len(store.events))
+	}
+}
+
+func TestClickAggregator_FlushesOnInterval(t *testing.T) {
+	store := &mockClickStore{}
+	aggregator := newClickAggregator(store, 10*time.Millisecond, 100)
+	aggregator.Start()
+	defer aggregator.Close(context.Background())
+
+	aggregator.Add("abc123", &domain.ClickEvent{URLID: 1})
+
+	deadline := time.Now().Add(time.Second)
+	for store.total("abc123") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected clicks to be flushed on interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClickAggregator_CloseFlushesPendingClicks(t *testing.T) {
+	store := &mockClickStore{}
+	aggregator := newClickAggregator(store, time.Hour, 100)
+	aggregator.Start()
+
+	aggregator.Add("abc123", &domain.ClickEvent{URLID: 1})
+	aggregator.Close(context.Background())
+	aggregator.Close(context.Background())
+
+	if store.total("abc123") != 1 {
+		t.Errorf("expected pending click to be flushed on close, got %d", store.total("abc123"))
+	}
+}
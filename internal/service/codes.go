@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/repo"
+	"github.com/Elisandil/go-snap/internal/shortid"
+	"github.com/Elisandil/go-snap/pkg/validator"
+	"github.com/rs/zerolog/log"
+)
+
+// drawnCode is a short code derived from an ID drawn from the URL sequence, see sequentialCode.
+type drawnCode struct {
+	id        int64
+	shortCode string
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE METHODS
+// ----------------------------------------------------------------------------------------
+
+// createShortURLWithSequence creates a short URL whose short code is derived from the next ID of the URL
+// sequence, and inserts it with that ID.
+// Sequential codes never collide with each other: a code can only be taken by an alias, or by a random code
+// created before the strategy was chosen, in which case the next ID is used. Reserved codes are skipped as well.
+// Up to maxRetries IDs are tried, after which it returns an error.
+// On success, it returns a CreateURLResponse containing the short code, short URL, and long URL.
+func (s *ShortenerService) createShortURLWithSequence(ctx context.Context,
+	url *domain.URL) (*domain.CreateURLResponse, error) {
+
+	for attempt := 0; attempt < s.maxRetries; attempt++ {
+		id, err := s.pgRepo.GetNextID(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("error drawing the next ID of the URL sequence")
+
+			return nil, fmt.Errorf("error creating short URL: %w", ErrUnavailable)
+		}
+		shortCode, err := s.sequentialCode(id)
+		if err != nil {
+			log.Error().Err(err).Int64("id", id).Msg("error generating sequential short code")
+
+			return nil, fmt.Errorf("error generating short code")
+		}
+		if validator.IsReservedShortCode(shortCode) {
+			continue
+		}
+		url.ID, url.ShortCode = id, shortCode
+
+		created, err := s.pgRepo.Create(ctx, url)
+		if err != nil {
+			if errors.Is(err, repo.ErrAlreadyExists) {
+				log.Warn().Str("short_code", shortCode).Msg("sequential short code already taken, using the next ID")
+				continue
+			}
+			log.Error().Err(err).Msg("error inserting URL into the database")
+
+			return nil, fmt.Errorf("error creating short URL: %w", ErrUnavailable)
+		}
+
+		return s.cacheCreatedURL(ctx, created), nil
+	}
+
+	return nil, fmt.Errorf("max retries reached for creating short URL")
+}
+
+// nextSequentialCodes draws count IDs from the URL sequence, in a single query, and returns their short codes.
+// IDs whose short code is reserved are skipped, and replaced by other ones in up to maxRetries queries.
+func (s *ShortenerService) nextSequentialCodes(ctx context.Context, count int) ([]drawnCode, error) {
+
+	codes := make([]drawnCode, 0, count)
+	for attempt := 0; len(codes) < count; attempt++ {
+		if attempt >= s.maxRetries {
+			return nil, fmt.Errorf("max retries reached for generating short codes")
+		}
+		ids, err := s.pgRepo.GetNextIDs(ctx, count-len(codes))
+		if err != nil {
+			log.Error().Err(err).Int("count", count).Msg("error drawing IDs of the URL sequence")
+
+			return nil, fmt.Errorf("error generating short codes: %w", ErrUnavailable)
+		}
+		for _, id := range ids {
+			shortCode, err := s.sequentialCode(id)
+			if err != nil {
+				log.Error().Err(err).Int64("id", id).Msg("error generating sequential short code")
+
+				return nil, fmt.Errorf("error generating short code")
+			}
+			if !validator.IsReservedShortCode(shortCode) {
+				codes = append(codes, drawnCode{id: id, shortCode: shortCode})
+			}
+		}
+	}
+
+	return codes, nil
+}
+
+// sequentialCode returns the short code of an ID of the URL sequence: its base62 encoding or, with an obfuscator,
+// the base62 encoding of the ID shuffled by it, padded to shortid.ObfuscatedLength characters.
+// Obfuscated codes fail for IDs beyond shortid.MaxObfuscatedID.
+func (s *ShortenerService) sequentialCode(id int64) (string, error) {
+
+	if s.obfuscator == nil {
+		return s.generator.Encode(id), nil
+	}
+
+	obfuscated, err := s.obfuscator.Obfuscate(id)
+	if err != nil {
+		return "", err
+	}
+
+	return s.generator.EncodePadded(obfuscated, shortid.ObfuscatedLength), nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/repo"
+	"github.com/Elisandil/go-snap/internal/shortid"
+)
+
+func TestShortenerService_CreateShortURL_SequentialCodes(t *testing.T) {
+	generator := shortid.NewGenerator()
+	apiID := generator.Decode("api")
+
+	tests := []struct {
+		name          string
+		ids           []int64
+		taken         map[string]bool
+		expectedID    int64
+		expectedDrawn int
+	}{
+		{
+			name:          "next ID",
+			ids:           []int64{125},
+			expectedID:    125,
+			expectedDrawn: 1,
+		},
+		{
+			name:          "code taken by an alias",
+			ids:           []int64{125, 126},
+			taken:         map[string]bool{generator.Encode(125): true},
+			expectedID:    126,
+			expectedDrawn: 2,
+		},
+		{
+			name:          "reserved code",
+			ids:           []int64{apiID, apiID + 1},
+			expectedID:    apiID + 1,
+			expectedDrawn: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drawn := 0
+			var inserted *domain.URL
+			mockPg := &mockPostgresRepo{
+				getNextIDFunc: func(ctx context.Context) (int64, error) {
+					drawn++
+					return tt.ids[drawn-1], nil
+				},
+				createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+					if tt.taken[url.ShortCode] {
+						return nil, repo.ErrAlreadyExists
+					}
+					inserted = url
+					return url, nil
+				},
+			}
+			service := NewShortenerService(mockPg, &mockRedisRepo{}, generator, "http://localhost:8080",
+				WithSequentialCodes(nil),
+			)
+
+			result, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+				LongURL: "https://example.com",
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if drawn != tt.expectedDrawn {
+				t.Errorf("expected %d drawn IDs, got %d", tt.expectedDrawn, drawn)
+			}
+			if inserted == nil || inserted.ID != tt.expectedID {
+				t.Fatalf("expected the URL to be inserted with ID %d, got %+v", tt.expectedID, inserted)
+			}
+			if expected := generator.Encode(tt.expectedID); result.ShortCode != expected {
+				t.Errorf("expected short code %s, got %s", expected, result.ShortCode)
+			}
+		})
+	}
+}
+
+func TestShortenerService_CreateShortURL_ObfuscatedCodes(t *testing.T) {
+	generator := shortid.NewGenerator()
+	obfuscator, err := shortid.NewObfuscator([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	service := NewShortenerService(&mockPostgresRepo{}, &mockRedisRepo{}, generator, "http://localhost:8080",
+		WithSequentialCodes(obfuscator),
+	)
+
+	seen := make(map[string]bool)
+	for id := int64(1); id <= 20; id++ {
+		result, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+			LongURL: "https://example.com",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(result.ShortCode) != shortid.ObfuscatedLength {
+			t.Errorf("expected a %d characters short code, got %s", shortid.ObfuscatedLength, result.ShortCode)
+		}
+		if seen[result.ShortCode] {
+			t.Errorf("short code %s returned twice", result.ShortCode)
+		}
+		seen[result.ShortCode] = true
+
+		decoded, err := obfuscator.Deobfuscate(generator.Decode(result.ShortCode))
+		if err != nil || decoded != id {
+			t.Errorf("expected short code %s to decode to ID %d, got %d (%v)", result.ShortCode, id, decoded, err)
+		}
+	}
+}
+
+func TestShortenerService_CreateShortURL_SequentialCodesMaxRetries(t *testing.T) {
+	inserts := 0
+	mockPg := &mockPostgresRepo{
+		createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+			inserts++
+			return nil, repo.ErrAlreadyExists
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080",
+		WithSequentialCodes(nil),
+	)
+
+	_, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{LongURL: "https://example.com"})
+	if err == nil {
+		t.Fatal("expected an error once every drawn code was taken")
+	}
+	if inserts != service.maxRetries {
+		t.Errorf("expected %d inserts, got %d", service.maxRetries, inserts)
+	}
+}
+
+func TestShortenerService_CreateShortURL_SequentialCodesUnavailable(t *testing.T) {
+	mockPg := &mockPostgresRepo{
+		getNextIDFunc: func(ctx context.Context) (int64, error) {
+			return 0, errors.New("connection refused")
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080",
+		WithSequentialCodes(nil),
+	)
+
+	_, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{LongURL: "https://example.com"})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable, got %v", err)
+	}
+}
+
+func TestShortenerService_CreateShortURLs_SequentialCodes(t *testing.T) {
+	generator := shortid.NewGenerator()
+	requested := 0
+	mockPg := &mockPostgresRepo{
+		getNextIDsFunc: func(ctx context.Context, count int) ([]int64, error) {
+			requested += count
+			ids := make([]int64, count)
+			for i := range ids {
+				ids[i] = int64(requested - count + i + 1)
+			}
+			return ids, nil
+		},
+		createBatchFunc: func(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error) {
+			var created []*domain.URL
+			for _, url := range urls {
+				// The code of the first ID is already taken by an alias
+				if url.ShortCode == generator.Encode(1) {
+					continue
+				}
+				if url.ID == 0 && url.ShortCode != "promo" {
+					t.Errorf("expected short code %s to be inserted with its ID", url.ShortCode)
+				}
+				created = append(created, url)
+			}
+			return created, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, generator, "http://localhost:8080",
+		WithSequentialCodes(nil),
+	)
+
+	response, err := service.CreateShortURLs(context.Background(), []*domain.CreateURLRequest{
+		{LongURL: "https://example.com/a"},
+		{LongURL: "https://example.com/b"},
+		{LongURL: "https://example.com/promo", Alias: "promo"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if response.Created != 3 || response.Failed != 0 {
+		t.Fatalf("expected 3 created and 0 failed, got %d and %d", response.Created, response.Failed)
+	}
+	if requested != 3 {
+		t.Errorf("expected 3 drawn IDs, got %d", requested)
+	}
+	codes := map[string]bool{}
+	for _, result := range response.Results[:2] {
+		codes[result.ShortCode] = true
+	}
+	if !codes[generator.Encode(2)] || !codes[generator.Encode(3)] {
+		t.Errorf("expected the codes of IDs 2 and 3, got %v", codes)
+	}
+}
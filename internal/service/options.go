@@ -0,0 +1,128 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/shortid"
+)
+
+// Option configures optional behaviour of the ShortenerService.
+type Option func(*ShortenerService)
+
+// WithClickFlushInterval sets how often accumulated clicks are flushed to Postgres.
+// Non-positive intervals are ignored and the default of one second is kept.
+func WithClickFlushInterval(interval time.Duration) Option {
+	return func(s *ShortenerService) {
+		if interval > 0 {
+			s.clickFlushInterval = interval
+		}
+	}
+}
+
+// WithMaxBatchSize sets how many URLs can be shortened with a single batch request.
+// Non-positive sizes are ignored and the default of 1000 is kept.
+func WithMaxBatchSize(size int) Option {
+	return func(s *ShortenerService) {
+		if size > 0 {
+			s.maxBatchSize = size
+		}
+	}
+}
+
+// WithReuseExisting sets whether creating a short URL for a long URL that was already shortened
+// returns the existing short URL by default. Requests can still override it with reuse_existing.
+func WithReuseExisting(reuse bool) Option {
+	return func(s *ShortenerService) {
+		s.reuseExisting = reuse
+	}
+}
+
+// WithRedirectStatus sets the status code of the redirect of short URLs that don't choose their own.
+// Only 301, 302, 307 and 308 are supported; other codes are ignored and the default of 302 is kept.
+func WithRedirectStatus(status int) Option {
+	return func(s *ShortenerService) {
+		if redirectStatuses[status] {
+			s.redirectStatus = status
+		}
+	}
+}
+
+// WithPasswordAttempts sets how many wrong passwords a protected short URL accepts from a client network within
+// window before further attempts of that network are refused until the window ends. Ten times as many are
+// accepted from all networks together.
+// Non-positive values are ignored and the defaults of 5 attempts per 15 minutes are kept.
+func WithPasswordAttempts(maxAttempts int, window time.Duration) Option {
+	return func(s *ShortenerService) {
+		if maxAttempts > 0 {
+			s.maxPasswordTries = maxAttempts
+		}
+		if window > 0 {
+			s.passwordTryWindow = window
+		}
+	}
+}
+
+// WithCountryLookup sets how client IPs are resolved to countries for the country targeting rules.
+// Without it, country rules never match.
+func WithCountryLookup(lookup CountryLookup) Option {
+	return func(s *ShortenerService) {
+		s.countries = lookup
+	}
+}
+
+// WithDomainLists sets the domains, read from the domain list files, that short URLs can't point to,
+// and those they are restricted to. Each domain covers its subdomains, and an empty allowed list allows
+// every domain that isn't blocked. Rules stored in the database are added to these lists.
+func WithDomainLists(blocked, allowed []string) Option {
+	return func(s *ShortenerService) {
+		s.blockedDomains = blocked
+		s.allowedDomains = allowed
+	}
+}
+
+// WithDomainRulesRefresh sets how often the domain rules stored in the database are reloaded.
+// Non-positive intervals are ignored and the default of one minute is kept.
+func WithDomainRulesRefresh(interval time.Duration) Option {
+	return func(s *ShortenerService) {
+		if interval > 0 {
+			s.domainRulesRefresh = interval
+		}
+	}
+}
+
+// WithNotFoundTTL sets how long a short code that doesn't exist is cached as not found in Redis.
+// Creating the short code replaces or evicts the entry, so the TTL mostly bounds how long a short code
+// created by another path, or during a cache outage, can still answer as not found.
+// Deleting a short URL caches its short code as not found for the same TTL.
+// Non-positive TTLs are ignored and the default of 30 seconds is kept.
+func WithNotFoundTTL(ttl time.Duration) Option {
+	return func(s *ShortenerService) {
+		if ttl > 0 {
+			s.notFoundTTL = ttl
+		}
+	}
+}
+
+// WithEarlyRefresh enables refreshing cached URLs ahead of the expiration of their Redis entry, with a
+// probability that grows as it gets closer, on the scale of window: an entry expiring in window is refreshed
+// by about one hit out of three, and one expiring in three windows by one out of twenty.
+// The refresh happens in the background while the cached URL keeps being served.
+// Non-positive windows are ignored and early refresh stays disabled.
+func WithEarlyRefresh(window time.Duration) Option {
+	return func(s *ShortenerService) {
+		if window > 0 {
+			s.earlyRefresh = window
+		}
+	}
+}
+
+// WithSequentialCodes makes short URLs without an alias get a short code derived from the next ID of the URL
+// sequence instead of a random one: its base62 encoding or, with an obfuscator, the base62 encoding of the ID
+// shuffled by it, so that codes can't be guessed from one another. Obfuscated codes are 6 characters long.
+// Sequential codes never collide with each other, so creating them doesn't retry on collisions.
+func WithSequentialCodes(obfuscator *shortid.Obfuscator) Option {
+	return func(s *ShortenerService) {
+		s.sequentialCodes = true
+		s.obfuscator = obfuscator
+	}
+}
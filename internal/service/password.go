@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/analytics"
+	"github.com/Elisandil/go-snap/internal/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 4
+	// maxPasswordLength is the longest password bcrypt can hash, in bytes.
+	maxPasswordLength = 72
+
+	defaultMaxPasswordAttempts   = 5
+	defaultPasswordAttemptWindow = 15 * time.Minute
+	// codeAttemptsFactor is how many times the wrong passwords allowed to a client are allowed on a short code
+	// across all clients.
+	codeAttemptsFactor = 10
+	// maxTrackedPasswordKeys bounds how many clients, and short codes, the attempt limiter tracks windows for.
+	maxTrackedPasswordKeys = 10_000
+)
+
+var (
+	ErrInvalidPassword  = errors.New("invalid password: must be between 4 and 72 bytes")
+	ErrPasswordRequired = errors.New("short URL is password protected")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrTooManyAttempts  = errors.New("too many wrong password attempts")
+)
+
+// hashPassword hashes the password of a create request with bcrypt.
+// An empty password means the URL is not protected and returns an empty hash.
+// A password that is too short or too long for bcrypt returns an error wrapping ErrInvalidPassword.
+func hashPassword(password string) (string, error) {
+
+	if password == "" {
+		return "", nil
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", ErrInvalidPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    ATTEMPT LIMITER
+// ----------------------------------------------------------------------------------------
+
+// attemptLimiter limits the wrong password attempts on each short code, per client and overall.
+// Once maxAttempts wrong passwords have been given by a client within window of its first one, further attempts
+// of that client on that code are refused until the window ends, while the other clients can still unlock it.
+// Clients are network prefixes, see attemptClient, and once maxCodeAttempts wrong passwords have been given on
+// a code by all of them together, every attempt on it is refused, so that rotating addresses doesn't give
+// unlimited guesses. The counts are kept in memory, so each server instance limits attempts on its own.
+type attemptLimiter struct {
+	maxAttempts     int
+	maxCodeAttempts int
+	window          time.Duration
+
+	mu      sync.Mutex
+	clients attemptWindows
+	codes   attemptWindows
+}
+
+// attemptWindow counts the wrong attempts of a client on a short code, or of every client, since start.
+type attemptWindow struct {
+	failures int
+	start    time.Time
+}
+
+// attemptWindows maps keys to the window of their wrong attempts.
+type attemptWindows map[string]*attemptWindow
+
+func newAttemptLimiter(maxAttempts int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		maxAttempts:     maxAttempts,
+		maxCodeAttempts: maxAttempts * codeAttemptsFactor,
+		window:          window,
+		clients:         make(attemptWindows),
+		codes:           make(attemptWindows),
+	}
+}
+
+// Allow reports whether a password attempt of the given client on the given short code can be checked
+// at the given time.
+func (l *attemptLimiter) Allow(shortCode, client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.clients.failures(shortCode+" "+client, now, l.window) < l.maxAttempts &&
+		l.codes.failures(shortCode, now, l.window) < l.maxCodeAttempts
+}
+
+// Fail records a wrong password of the given client on the given short code at the given time.
+func (l *attemptLimiter) Fail(shortCode, client string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.clients.fail(shortCode+" "+client, now, l.window)
+	l.codes.fail(shortCode, now, l.window)
+}
+
+// failures returns the wrong attempts under the given key in its current window.
+func (w attemptWindows) failures(key string, now time.Time, window time.Duration) int {
+	attempt, ok := w[key]
+	if !ok || !now.Before(attempt.start.Add(window)) {
+		return 0
+	}
+
+	return attempt.failures
+}
+
+// fail records a wrong attempt under the given key, starting a new window if its previous one has ended.
+// A new key beyond maxTrackedPasswordKeys first prunes the windows that have ended, or if none has,
+// evicts the one that started first.
+func (w attemptWindows) fail(key string, now time.Time, window time.Duration) {
+	attempt, ok := w[key]
+	if !ok && len(w) >= maxTrackedPasswordKeys {
+		w.prune(now, window)
+	}
+	if !ok || !now.Before(attempt.start.Add(window)) {
+		attempt = &attemptWindow{start: now}
+		w[key] = attempt
+	}
+	attempt.failures++
+}
+
+// prune drops the windows that have ended, or the one that started first if none has.
+func (w attemptWindows) prune(now time.Time, window time.Duration) {
+	oldestKey := ""
+	var oldest *attemptWindow
+	for key, attempt := range w {
+		if !now.Before(attempt.start.Add(window)) {
+			delete(w, key)
+		} else if oldest == nil || attempt.start.Before(oldest.start) {
+			oldestKey, oldest = key, attempt
+		}
+	}
+	if len(w) >= maxTrackedPasswordKeys {
+		delete(w, oldestKey)
+	}
+}
+
+// attemptClient returns the client the wrong passwords of a visit are counted for: the /24 or /48 network
+// of its client IP, so that addresses of the same network share their attempts. Visits without metadata or
+// with an unknown IP share the same client.
+func attemptClient(visit *domain.Visit) string {
+	if visit == nil {
+		return ""
+	}
+
+	return analytics.AnonymizeIP(visit.ClientIP)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("")
+	if err != nil || hash != "" {
+		t.Errorf("expected no hash for an empty password, got '%s', %v", hash, err)
+	}
+
+	hash, err = hashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash == "s3cret" || bcrypt.CompareHashAndPassword([]byte(hash), []byte("s3cret")) != nil {
+		t.Errorf("expected a bcrypt hash of the password, got '%s'", hash)
+	}
+
+	for _, password := range []string{"abc", strings.Repeat("a", maxPasswordLength+1)} {
+		if _, err := hashPassword(password); !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("expected ErrInvalidPassword for a password of %d bytes, got %v", len(password), err)
+		}
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	limiter := newAttemptLimiter(2, time.Minute)
+	now := time.Date(2025, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	limiter.Fail("abc123", "203.0.113.0/24", now)
+	if !limiter.Allow("abc123", "203.0.113.0/24", now.Add(time.Second)) {
+		t.Error("expected an attempt to be allowed below the limit")
+	}
+
+	limiter.Fail("abc123", "203.0.113.0/24", now.Add(time.Second))
+	if limiter.Allow("abc123", "203.0.113.0/24", now.Add(2*time.Second)) {
+		t.Error("expected attempts to be refused once the limit is reached")
+	}
+	if !limiter.Allow("abc123", "198.51.100.0/24", now.Add(2*time.Second)) {
+		t.Error("expected other clients not to be limited")
+	}
+	if !limiter.Allow("xyz789", "203.0.113.0/24", now.Add(2*time.Second)) {
+		t.Error("expected other short codes not to be limited")
+	}
+
+	if !limiter.Allow("abc123", "203.0.113.0/24", now.Add(time.Minute)) {
+		t.Error("expected attempts to be allowed again once the window ends")
+	}
+	limiter.Fail("abc123", "203.0.113.0/24", now.Add(time.Minute))
+	if !limiter.Allow("abc123", "203.0.113.0/24", now.Add(time.Minute+time.Second)) {
+		t.Error("expected a new window to start after the previous one ended")
+	}
+}
+
+func TestAttemptLimiter_CodeCeiling(t *testing.T) {
+	limiter := newAttemptLimiter(2, time.Minute)
+	now := time.Date(2025, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	// Every client stays below its own limit, but together they reach the one of the short code
+	for i := 0; i < 2*codeAttemptsFactor; i++ {
+		client := fmt.Sprintf("2001:db8:%x::/48", i/2)
+		if !limiter.Allow("abc123", client, now) {
+			t.Fatalf("expected attempt %d to be allowed below the limit of the short code", i+1)
+		}
+		limiter.Fail("abc123", client, now)
+	}
+
+	if limiter.Allow("abc123", "2001:db8:ffff::/48", now) {
+		t.Error("expected new clients to be refused once the limit of the short code is reached")
+	}
+	if !limiter.Allow("xyz789", "2001:db8:ffff::/48", now) {
+		t.Error("expected other short codes not to be limited")
+	}
+}
+
+func TestAttemptLimiter_TrackedKeys(t *testing.T) {
+	limiter := newAttemptLimiter(2, time.Hour)
+	now := time.Date(2025, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxTrackedPasswordKeys+10; i++ {
+		limiter.Fail(fmt.Sprintf("code%d", i), "203.0.113.0/24", now.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	if len(limiter.clients) > maxTrackedPasswordKeys || len(limiter.codes) > maxTrackedPasswordKeys {
+		t.Errorf("expected at most %d tracked keys, got %d clients and %d codes",
+			maxTrackedPasswordKeys, len(limiter.clients), len(limiter.codes))
+	}
+	if _, ok := limiter.codes["code0"]; ok {
+		t.Error("expected the window that started first to be evicted")
+	}
+}
+
+func TestAttemptClient(t *testing.T) {
+	first := attemptClient(&domain.Visit{ClientIP: "2001:db8:1:2::1"})
+	second := attemptClient(&domain.Visit{ClientIP: "2001:db8:1:3::abcd"})
+	if first != second {
+		t.Errorf("expected addresses of the same /48 to share their attempts, got %s and %s", first, second)
+	}
+	if attemptClient(nil) != "" {
+		t.Errorf("expected visits without metadata to share an empty client")
+	}
+}
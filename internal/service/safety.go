@@ -0,0 +1,298 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/pkg/validator"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/idna"
+)
+
+// Machine-readable reasons of an UnsafeURLError.
+const (
+	UnsafeCredentials      = "credentials_in_url"
+	UnsafePrivateAddress   = "private_address"
+	UnsafeBlockedDomain    = "blocked_domain"
+	UnsafeDomainNotAllowed = "domain_not_allowed"
+
+	defaultDomainRulesRefresh = time.Minute
+)
+
+var ErrUnsafeURL = errors.New("unsafe URL")
+
+// UnsafeURLError reports a destination URL that was rejected by the safety checks.
+// Code is one of the Unsafe constants and Host the host of the rejected URL. It wraps ErrUnsafeURL.
+type UnsafeURLError struct {
+	Code string
+	Host string
+}
+
+func (e *UnsafeURLError) Error() string {
+
+	switch e.Code {
+	case UnsafeCredentials:
+		return "unsafe URL: URLs with credentials are not allowed"
+	case UnsafePrivateAddress:
+		return fmt.Sprintf("unsafe URL: %s is a private, loopback or link-local address", e.Host)
+	case UnsafeBlockedDomain:
+		return fmt.Sprintf("unsafe URL: domain %s is blocked", e.Host)
+	case UnsafeDomainNotAllowed:
+		return fmt.Sprintf("unsafe URL: domain %s is not allowed", e.Host)
+	default:
+		return "unsafe URL"
+	}
+}
+
+func (e *UnsafeURLError) Unwrap() error {
+	return ErrUnsafeURL
+}
+
+// LoadDomainList reads a list of domains from the file at path, see ParseDomainList.
+func LoadDomainList(path string) ([]string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening domain list: %w", err)
+	}
+	defer file.Close()
+
+	return ParseDomainList(file)
+}
+
+// ParseDomainList reads a list of domains with one domain per line. Everything after a '#' is a comment,
+// and blank lines are skipped. Each domain also covers its subdomains, so a leading "*." is optional.
+// It returns an error if a line isn't a domain, so a broken list is noticed on startup.
+func ParseDomainList(r io.Reader) ([]string, error) {
+
+	var domains []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		domain, ok := normalizeDomain(entry)
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid domain %q", line, entry)
+		}
+		domains = append(domains, domain)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading domain list: %w", err)
+	}
+
+	return domains, nil
+}
+
+// ReloadDomainRules replaces the stored domain rules in use by the ones currently in Postgres.
+// The rules of the domain list files are kept. It is called on startup and then periodically,
+// so rules added to the database apply without restarting the server.
+func (s *ShortenerService) ReloadDomainRules(ctx context.Context) error {
+
+	rules, err := s.pgRepo.ListDomainRules(ctx)
+	if err != nil {
+		return fmt.Errorf("error loading domain rules: %w", err)
+	}
+	s.domains.update(rules)
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    DOMAIN POLICY
+// ----------------------------------------------------------------------------------------
+
+// domainPolicy decides which domains short URLs can point to, from the domain lists given on startup
+// and the rules stored in the database. A domain covers its subdomains, blocked domains are always rejected,
+// and once any domain is allowed, every domain outside the allowed ones is rejected as well.
+type domainPolicy struct {
+	listBlocked []string
+	listAllowed []string
+
+	mu      sync.RWMutex
+	blocked map[string]bool
+	allowed map[string]bool
+}
+
+func newDomainPolicy(blocked, allowed []string) *domainPolicy {
+	p := &domainPolicy{
+		listBlocked: blocked,
+		listAllowed: allowed,
+	}
+	p.update(nil)
+
+	return p
+}
+
+// update combines the domain lists with the given stored rules. Stored rules with an unknown action or
+// an invalid domain are skipped.
+func (p *domainPolicy) update(rules []domain.DomainRule) {
+	blocked := make(map[string]bool, len(p.listBlocked))
+	allowed := make(map[string]bool, len(p.listAllowed))
+	for _, entry := range p.listBlocked {
+		blocked[entry] = true
+	}
+	for _, entry := range p.listAllowed {
+		allowed[entry] = true
+	}
+
+	for _, rule := range rules {
+		entry, ok := normalizeDomain(rule.Domain)
+		if !ok {
+			log.Warn().Str("domain", rule.Domain).Msg("skipping stored domain rule with an invalid domain")
+			continue
+		}
+		switch rule.Action {
+		case domain.DomainRuleBlock:
+			blocked[entry] = true
+		case domain.DomainRuleAllow:
+			allowed[entry] = true
+		default:
+			log.Warn().Str("domain", rule.Domain).Str("action", rule.Action).Msg("skipping stored domain rule " +
+				"with an unknown action")
+		}
+	}
+
+	p.mu.Lock()
+	p.blocked, p.allowed = blocked, allowed
+	p.mu.Unlock()
+}
+
+// check returns the Unsafe code that rejects the given host, or an empty string if it is accepted.
+func (p *domainPolicy) check(host string) string {
+	host, ok := normalizeDomain(host)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if ok && matchesDomain(p.blocked, host) {
+		return UnsafeBlockedDomain
+	}
+	if len(p.allowed) > 0 && (!ok || !matchesDomain(p.allowed, host)) {
+		return UnsafeDomainNotAllowed
+	}
+
+	return ""
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE METHODS
+// ----------------------------------------------------------------------------------------
+
+// checkDestination checks that a normalized destination URL is safe to redirect to: it must not carry
+// credentials, point to a private, loopback or link-local address, or to a domain rejected by the domain rules.
+// Otherwise it returns an *UnsafeURLError. Host names are not resolved.
+func (s *ShortenerService) checkDestination(longURL string) error {
+
+	target, err := neturl.Parse(longURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidURL, longURL)
+	}
+	host := target.Hostname()
+
+	if target.User != nil {
+		return &UnsafeURLError{Code: UnsafeCredentials, Host: host}
+	}
+	if validator.IsPrivateHost(host) {
+		return &UnsafeURLError{Code: UnsafePrivateAddress, Host: host}
+	}
+	if code := s.domains.check(host); code != "" {
+		return &UnsafeURLError{Code: code, Host: host}
+	}
+
+	return nil
+}
+
+// checkDestinations checks every destination of a URL with checkDestination: the long URL and those of its
+// targeting rules and variants.
+func (s *ShortenerService) checkDestinations(url *domain.URL) error {
+
+	if err := s.checkDestination(url.LongURL); err != nil {
+		return err
+	}
+	for _, rule := range url.Targeting {
+		if err := s.checkDestination(rule.LongURL); err != nil {
+			return err
+		}
+	}
+	for _, variant := range url.Variants {
+		if err := s.checkDestination(variant.LongURL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshDomainRules reloads the stored domain rules every interval until stop is closed.
+// A failed reload is logged and the previous rules are kept.
+func (s *ShortenerService) refreshDomainRules(interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := s.ReloadDomainRules(ctx); err != nil {
+				log.Warn().Err(err).Msg("error refreshing domain rules, keeping the previous ones")
+			}
+			cancel()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE FUNCTIONS
+// ----------------------------------------------------------------------------------------
+
+// normalizeDomain puts a domain or host in the form domain rules are compared in: lowercase ASCII, with
+// internationalized names in punycode, and without a leading "*." or a trailing dot.
+// It returns false if the value isn't a valid domain or IP address.
+func normalizeDomain(value string) (string, bool) {
+	value = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(value), "*."), ".")
+	if value == "" || strings.ContainsAny(value, "/@ ") {
+		return "", false
+	}
+
+	ascii, err := idna.Lookup.ToASCII(value)
+	if err != nil {
+		if strings.Contains(value, ":") {
+			// IPv6 addresses aren't valid domain names, but can still be listed
+			return strings.ToLower(strings.Trim(value, "[]")), true
+		}
+		return "", false
+	}
+
+	return ascii, true
+}
+
+// matchesDomain reports whether host, or one of its parent domains, is in domains.
+func matchesDomain(domains map[string]bool, host string) bool {
+
+	for {
+		if domains[host] {
+			return true
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return false
+		}
+		host = parent
+	}
+}
@@ -2,15 +2,92 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Elisandil/go-snap/internal/analytics"
 	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/qr"
 	"github.com/Elisandil/go-snap/internal/repo"
 	"github.com/Elisandil/go-snap/internal/shortid"
 	"github.com/Elisandil/go-snap/pkg/validator"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrInvalidURL        = errors.New("invalid URL")
+	ErrInvalidAlias      = errors.New("invalid alias: must be between 1 and 10 alphanumeric characters")
+	ErrReservedAlias     = errors.New("alias is reserved")
+	ErrInvalidExpiration = errors.New("invalid expiration: must be a future RFC3339 time or a positive duration")
+	ErrLinkExpired       = errors.New("short URL has expired")
+	ErrLinkDisabled      = errors.New("short URL has been disabled")
+	ErrInvalidStatsQuery = errors.New("invalid stats query")
+	ErrInvalidBatch      = errors.New("invalid batch")
+	ErrInvalidListQuery  = errors.New("invalid list query")
+	ErrInvalidQRCode     = errors.New("invalid QR code options")
+	ErrInvalidRedirect   = errors.New("invalid redirect status: must be 301, 302, 307 or 308")
+	ErrInvalidMaxClicks  = errors.New("invalid max clicks: must be positive")
+	ErrClickLimitReached = errors.New("short URL has reached its click limit")
+	ErrInvalidShortCode  = errors.New("invalid short code format")
+	ErrNotFound          = errors.New("short URL not found")
+	ErrAliasTaken        = errors.New("alias is already taken")
+	// ErrUnavailable is wrapped by the errors of operations that failed because Postgres, or another backend
+	// the operation can't do without, is unavailable, so they may succeed if retried later.
+	ErrUnavailable = errors.New("service temporarily unavailable")
+)
+
+// redirectStatuses lists the status codes a short URL can redirect with.
+// 301 and 308 are permanent, and 307 and 308 keep the method and body of the request.
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// IsSupportedRedirectStatus reports whether short URLs can redirect with the given status code.
+func IsSupportedRedirectStatus(status int) bool {
+	return redirectStatuses[status]
+}
+
+// seriesIntervals maps the supported bucket sizes of a click series to their approximate length.
+var seriesIntervals = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+const (
+	defaultListLimit     = 20
+	maxListLimit         = 100
+	defaultMaxBatchSize  = 1000
+	defaultSeriesBuckets = 30
+	maxSeriesBuckets     = 1000
+	defaultQRCodeSize    = 256
+	minQRCodeSize        = 64
+	maxQRCodeSize        = 2048
+	defaultQRCodeMargin  = 4
+	maxQRCodeMargin      = 16
+	defaultNotFoundTTL   = 30 * time.Second
+	// loadTimeout bounds the database query of a coalesced lookup, which isn't canceled with its callers.
+	loadTimeout = 10 * time.Second
+	// refreshKeyPrefix keys the early refreshes of a short code apart from its loads in the loads group.
+	refreshKeyPrefix = "refresh:"
+	// maxBatchPasswords bounds the password protected URLs of a batch, whose passwords are hashed one after
+	// the other with bcrypt, at tens of milliseconds each, so a batch stays well within the request timeout.
+	maxBatchPasswords = 10
 )
 
 // ----------------------------------------------------------------------------------------
@@ -18,17 +95,35 @@ import (
 // ----------------------------------------------------------------------------------------
 
 type PostgresRepository interface {
-	Create(ctx context.Context, id int64, shortCode, longURL string) (*domain.URL, error)
+	Create(ctx context.Context, url *domain.URL) (*domain.URL, error)
+	CreateBatch(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error)
 	GetByShortCode(ctx context.Context, shortCode string) (*domain.URL, error)
-	IncrementClicksCounter(ctx context.Context, shortCode string) error
+	GetByLongURL(ctx context.Context, longURL string, ownerID *int64) (*domain.URL, error)
+	IncrementClicksBatch(ctx context.Context, deltas map[string]int64) error
+	IncrementClickWithinLimit(ctx context.Context, shortCode string) error
 	GetNextID(ctx context.Context) (int64, error)
+	GetNextIDs(ctx context.Context, count int) ([]int64, error)
+	Delete(ctx context.Context, shortCode string) error
+	SetDisabled(ctx context.Context, shortCode string, disabled bool) (*domain.URL, error)
+	UpdateLongURL(ctx context.Context, shortCode, longURL string) (*domain.URL, error)
+	GetDestinationHistory(ctx context.Context, urlID int64) ([]domain.DestinationChange, error)
+	RecordClicks(ctx context.Context, events []*domain.ClickEvent) error
+	GetClickSeries(ctx context.Context, urlID int64, query *domain.SeriesQuery) ([]domain.ClickBucket, error)
+	GetVariantClicks(ctx context.Context, urlID int64) (map[int]int64, error)
+	ListDomainRules(ctx context.Context) ([]domain.DomainRule, error)
+	ListURLs(ctx context.Context, query *domain.ListURLsQuery) ([]*domain.URL, error)
 }
 
 type RedisRepository interface {
 	Get(ctx context.Context, shortCode string) (*domain.URL, error)
+	GetWithTTL(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error)
 	Set(ctx context.Context, shortCode string, url *domain.URL) error
+	Add(ctx context.Context, shortCode string, url *domain.URL) error
+	Replace(ctx context.Context, shortCode string, previous, url *domain.URL) error
 	Delete(ctx context.Context, shortCode string) error
 	Exists(ctx context.Context, shortCode string) (bool, error)
+	SetNotFound(ctx context.Context, shortCode string, ttl time.Duration) error
+	SetDeleted(ctx context.Context, shortCode string, ttl time.Duration) error
 }
 
 // ----------------------------------------------------------------------------------------
@@ -36,172 +131,1549 @@ type RedisRepository interface {
 // ----------------------------------------------------------------------------------------
 
 type ShortenerService struct {
-	pgRepo       PostgresRepository
-	redisRepo    RedisRepository
-	generator    *shortid.Generator
-	baseURL      string
-	maxRetries   int
-	clickWorkers chan struct{}
+	pgRepo             PostgresRepository
+	redisRepo          RedisRepository
+	generator          *shortid.Generator
+	baseURL            string
+	maxRetries         int
+	maxBatchSize       int
+	reuseExisting      bool
+	redirectStatus     int
+	maxPasswordTries   int
+	passwordTryWindow  time.Duration
+	passwordAttempts   *attemptLimiter
+	countries          CountryLookup
+	blockedDomains     []string
+	allowedDomains     []string
+	domainRulesRefresh time.Duration
+	domains            *domainPolicy
+	stopDomainRefresh  chan struct{}
+	closeOnce          sync.Once
+	clickFlushInterval time.Duration
+	clicks             *clickAggregator
+	notFoundTTL        time.Duration
+	earlyRefresh       time.Duration
+	sequentialCodes    bool
+	obfuscator         *shortid.Obfuscator
+	loads              singleflight.Group
+	refreshes          sync.WaitGroup
 }
 
 func NewShortenerService(pgRepo PostgresRepository,
 	redisRepo RedisRepository,
 	generator *shortid.Generator,
-	baseURL string) *ShortenerService {
+	baseURL string,
+	opts ...Option) *ShortenerService {
+
+	s := &ShortenerService{
+		pgRepo:             pgRepo,
+		redisRepo:          redisRepo,
+		generator:          generator,
+		baseURL:            baseURL,
+		maxRetries:         5,
+		maxBatchSize:       defaultMaxBatchSize,
+		redirectStatus:     http.StatusFound,
+		maxPasswordTries:   defaultMaxPasswordAttempts,
+		passwordTryWindow:  defaultPasswordAttemptWindow,
+		domainRulesRefresh: defaultDomainRulesRefresh,
+		stopDomainRefresh:  make(chan struct{}),
+		clickFlushInterval: time.Second,
+		notFoundTTL:        defaultNotFoundTTL,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.passwordAttempts = newAttemptLimiter(s.maxPasswordTries, s.passwordTryWindow)
+	s.domains = newDomainPolicy(s.blockedDomains, s.allowedDomains)
+	go s.refreshDomainRules(s.domainRulesRefresh, s.stopDomainRefresh)
+
+	s.clicks = newClickAggregator(pgRepo, s.clickFlushInterval, 100_000)
+	s.clicks.Start()
 
-	return &ShortenerService{
-		pgRepo:       pgRepo,
-		redisRepo:    redisRepo,
-		generator:    generator,
-		baseURL:      baseURL,
-		maxRetries:   5,
-		clickWorkers: make(chan struct{}, 100),
+	return s
+}
+
+// Close waits for the early cache refreshes in flight, flushes the clicks that are still pending and stops
+// the background flush and domain rule refresh loops.
+// It must be called on shutdown, after the HTTP server has stopped accepting redirects.
+// It is safe to call Close more than once.
+func (s *ShortenerService) Close(ctx context.Context) {
+	s.closeOnce.Do(func() {
+		close(s.stopDomainRefresh)
+	})
+	s.refreshes.Wait()
+	s.clicks.Close(ctx)
+}
+
+// CreateShortURL creates a short URL for the long URL in the given request, validated and built by buildURL.
+// If the request carries an alias, it is used as the short code instead of a random one.
+// When reuse is enabled, by the request or server-wide, and the request has neither an alias nor an expiration,
+// the oldest enabled, non-expiring short URL of the same normalized long URL is returned instead,
+// with Reused set in the response.
+// If ctx carries an authenticated owner, the URL is assigned to it and only its own URLs are reused.
+func (s *ShortenerService) CreateShortURL(ctx context.Context,
+	request *domain.CreateURLRequest) (*domain.CreateURLResponse, error) {
+
+	url, err := s.buildURL(request, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	url.OwnerID = ownerIDFromContext(ctx)
+
+	if s.canReuse(request) {
+		if response := s.findReusableURL(ctx, url.LongURL); response != nil {
+			return response, nil
+		}
+	}
+
+	if url.ShortCode != "" {
+		return s.createShortURLWithAlias(ctx, url)
+	}
+	if s.sequentialCodes {
+		return s.createShortURLWithSequence(ctx, url)
 	}
+
+	return s.createShortURLWithRetries(ctx, url, 0, s.maxRetries)
 }
 
-// CreateShortURL creates a short URL for the given long URL.
-func (s *ShortenerService) CreateShortURL(ctx context.Context, longURL string) (*domain.CreateURLResponse, error) {
-	return s.createShortURLWithRetries(ctx, longURL, 0, s.maxRetries)
+// CreateShortURLs creates short URLs for a batch of create requests.
+// Each request is normalized and validated like in CreateShortURL, and the valid ones are inserted together,
+// with a single statement per round. Random short codes that collide are regenerated and retried in the
+// next round, up to maxRetries rounds, while a colliding alias fails its item. Sequential short codes never
+// collide with each other, but one taken by an alias is replaced by the next ID in the next round as well.
+// Items that fail don't affect the others: their error is reported in the result at the same index.
+// Items that can reuse an existing short URL, as in CreateShortURL, are looked up first, and repeated
+// long URLs within the batch share the short URL of their first occurrence.
+// If the batch is empty, exceeds the maximum batch size or has more than maxBatchPasswords password protected
+// URLs, it returns an error wrapping ErrInvalidBatch.
+// The created URLs are written to Redis like in CreateShortURL, replacing any entry caching their short codes as
+// not found.
+// As in CreateShortURL, they are assigned to the authenticated owner carried by ctx, if any.
+func (s *ShortenerService) CreateShortURLs(ctx context.Context,
+	requests []*domain.CreateURLRequest) (*domain.BatchCreateURLResponse, error) {
+
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("%w: no URLs given", ErrInvalidBatch)
+	}
+	if len(requests) > s.maxBatchSize {
+		return nil, fmt.Errorf("%w: at most %d URLs are allowed per batch", ErrInvalidBatch, s.maxBatchSize)
+	}
+	if countPasswords(requests) > maxBatchPasswords {
+		return nil, fmt.Errorf("%w: at most %d password protected URLs are allowed per batch",
+			ErrInvalidBatch, maxBatchPasswords)
+	}
+
+	response := &domain.BatchCreateURLResponse{
+		Results: make([]domain.BatchCreateURLResult, len(requests)),
+	}
+	pending := make(map[int]*batchItem, len(requests))
+	aliases := make(map[string]bool)
+	firstOccurrences := make(map[string]int)
+	repeated := make(map[int]int)
+	ownerID := ownerIDFromContext(ctx)
+	now := time.Now()
+
+	for i, request := range requests {
+		response.Results[i].Index = i
+
+		item, err := s.newBatchItem(request, now)
+		if err == nil && item.alias {
+			if aliases[item.url.ShortCode] {
+				err = fmt.Errorf("%w: %s", ErrAliasTaken, item.url.ShortCode)
+			}
+			aliases[item.url.ShortCode] = true
+		}
+		if err != nil {
+			response.Results[i].Error = err.Error()
+			var unsafe *UnsafeURLError
+			if errors.As(err, &unsafe) {
+				response.Results[i].Code = unsafe.Code
+			}
+			continue
+		}
+		item.url.OwnerID = ownerID
+
+		if s.canReuse(request) {
+			if first, ok := firstOccurrences[item.url.LongURL]; ok {
+				repeated[i] = first
+				continue
+			}
+			firstOccurrences[item.url.LongURL] = i
+
+			if existing := s.findReusableURL(ctx, item.url.LongURL); existing != nil {
+				response.Results[i].CreateURLResponse = existing
+				continue
+			}
+		}
+		pending[i] = item
+	}
+
+	for attempt := 0; attempt < s.maxRetries && len(pending) > 0; attempt++ {
+		if err := s.createBatchRound(ctx, pending, response); err != nil {
+			return nil, err
+		}
+	}
+	for i := range pending {
+		response.Results[i].Error = "max retries reached for creating short URL"
+	}
+	for i, first := range repeated {
+		if created := response.Results[first].CreateURLResponse; created != nil {
+			reused := *created
+			reused.Reused = true
+			response.Results[i].CreateURLResponse = &reused
+		} else {
+			response.Results[i].Error = response.Results[first].Error
+		}
+	}
+
+	for _, result := range response.Results {
+		switch {
+		case result.Error != "":
+			response.Failed++
+		case result.Reused:
+			response.Reused++
+		default:
+			response.Created++
+		}
+	}
+
+	return response, nil
 }
 
 // GetLongURL retrieves the long URL associated with the given short code.
 // It first checks the Redis cache for the short code.
-// If the short code is found in the cache, it returns the long URL and increments the click counter asynchronously.
+// If the short code is found in the cache, it returns the long URL and records the click,
+// using the request metadata in visit, which may be nil.
 // If the short code is not found in the cache, it queries the Postgres database.
-// If the short code is not found in the database, it returns an error.
-// If there is an error retrieving the URL from the database, it returns an error.
-// On success, it returns the long URL.
-func (s *ShortenerService) GetLongURL(ctx context.Context, shortCode string) (string, error) {
+// If the short code is malformed, it returns ErrInvalidShortCode, and if it is not found in the database,
+// ErrNotFound. If there is an error retrieving the URL from the database, it returns an error wrapping
+// ErrUnavailable.
+// If the URL has been disabled, it returns ErrLinkDisabled.
+// If the URL has expired, it returns ErrLinkExpired.
+// If the destination of the visit no longer passes the safety checks, for example because its domain has been
+// blocked since the URL was created, it returns an *UnsafeURLError without recording a click.
+// If the URL has reached its click limit, it returns ErrClickLimitReached. The clicks of limited URLs are
+// counted in Postgres before redirecting, in a statement that refuses to go over the limit, instead of
+// being aggregated in memory, so concurrent visits can't exceed it.
+// Probe visits, such as HEAD requests, are answered like the others but aren't recorded as clicks and don't
+// count toward the click limit. A visit to a link with a preview page is recorded, since the page links to
+// the destination directly.
+// If the URL is password protected, it returns ErrPasswordRequired without recording a click;
+// the visitor must go through UnlockURL instead.
+// Visitors that match a targeting rule of the URL are sent to the destination of the first such rule,
+// and the others, for URLs with variants, to a variant drawn by weight, or the one of their visit for sticky
+// URLs. The chosen variant is set in the Variant field of the returned URL and recorded with the click.
+// A visit with a trailing path is only accepted by links with passthrough enabled, whose long URL
+// then carries the path and the query string of the visit, see passthroughURL.
+// On success, it returns the URL, whose Preview flag tells whether the visitor must see the destination
+// on a preview page before continuing to it. Its RedirectStatus is resolved to the server default
+// when the link doesn't set one.
+func (s *ShortenerService) GetLongURL(ctx context.Context,
+	shortCode string,
+	visit *domain.Visit) (*domain.URL, error) {
 
-	if !validator.IsValidShortCode(shortCode) {
-		return "", fmt.Errorf("invalid short code format")
+	url, err := s.lookupVisitableURL(ctx, shortCode, visit)
+	if err != nil {
+		return nil, err
+	}
+	if url.IsProtected() {
+		return nil, ErrPasswordRequired
 	}
 
-	url, err := s.redisRepo.Get(ctx, shortCode)
-	if err == nil {
-		log.Debug().Str("short_code", shortCode).Msg("cache hit")
-		s.incrementClicksAsync(shortCode)
+	return s.completeVisit(ctx, shortCode, url, visit)
+}
 
-		return url.LongURL, nil
-	}
+// UnlockURL retrieves the long URL of a password protected short code, like GetLongURL, once the visitor
+// has given its password.
+// A wrong password returns ErrWrongPassword. Once too many wrong passwords have been given for the short code
+// from the network of the client IP of the visit, it returns ErrTooManyAttempts to that network, without checking
+// the password, until the attempt window ends. Past ten times as many wrong passwords from all networks together,
+// every attempt on the short code is refused.
+// Links without a password are returned as by GetLongURL, whatever the password.
+// The click is only recorded on success.
+func (s *ShortenerService) UnlockURL(ctx context.Context,
+	shortCode, password string,
+	visit *domain.Visit) (*domain.URL, error) {
 
-	log.Debug().Str("short_code", shortCode).Msg("cache miss, querying from Postgres")
-	url, err = s.pgRepo.GetByShortCode(ctx, shortCode)
+	url, err := s.lookupVisitableURL(ctx, shortCode, visit)
 	if err != nil {
-		if errors.Is(err, repo.ErrNotFound) {
-			return "", fmt.Errorf("short URL not found")
+		return nil, err
+	}
+
+	if url.IsProtected() {
+		now := time.Now()
+		client := attemptClient(visit)
+		if !s.passwordAttempts.Allow(shortCode, client, now) {
+			return nil, ErrTooManyAttempts
 		}
-		log.Error().Err(err).Str("short_code", shortCode).Msg("error retrieving URL from the database")
+		if err := bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte(password)); err != nil {
+			s.passwordAttempts.Fail(shortCode, client, now)
+			log.Info().Str("short_code", shortCode).Msg("wrong password for protected short URL")
 
-		return "", fmt.Errorf("error retrieving long URL")
+			return nil, ErrWrongPassword
+		}
 	}
 
-	if err := s.redisRepo.Set(ctx, shortCode, url); err != nil {
-		log.Warn().Err(err).Str("short_code", shortCode).Msg("error caching URL with Redis")
+	return s.completeVisit(ctx, shortCode, url, visit)
+}
+
+// GetURLPreview retrieves the URL associated with the given short code to show its destination
+// on a preview page.
+// It looks the URL up like GetLongURL, and fails in the same cases, but doesn't record a click,
+// since the visitor hasn't been sent to the destination yet.
+// Password protected URLs return ErrPasswordRequired, so their destination is never shown, and URLs whose
+// long URL no longer passes the safety checks an *UnsafeURLError.
+func (s *ShortenerService) GetURLPreview(ctx context.Context, shortCode string) (*domain.URL, error) {
+
+	url, err := s.lookupURL(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAvailable(url); err != nil {
+		return nil, err
+	}
+	if url.IsProtected() {
+		return nil, ErrPasswordRequired
+	}
+	if err := s.checkDestination(url.LongURL); err != nil {
+		return nil, err
 	}
-	s.incrementClicksAsync(shortCode)
 
-	return url.LongURL, nil
+	return url, nil
 }
 
 // GetURLStats retrieves statistics for the given short code.
 // It queries the Postgres database for the URL associated with the short code.
-// If the short code is not found, it returns an error.
-// If there is an error retrieving the URL from the database, it returns an error.
-// On success, it returns a StatsResponse containing the short code, long URL, click count, and creation date.
-func (s *ShortenerService) GetURLStats(ctx context.Context, shortCode string) (*domain.StatsResponse, error) {
+// If series is not nil, the response also includes the clicks bucketed by the requested interval.
+// An invalid series query returns an error wrapping ErrInvalidStatsQuery.
+// A malformed short code returns ErrInvalidShortCode.
+// If the short code is not found, or belongs to another owner than the one carried by ctx, it returns an error
+// wrapping ErrNotFound.
+// If there is an error retrieving the URL from the database, it returns an error wrapping ErrUnavailable.
+// On success, it returns a StatsResponse containing the short code, long URL, click count, creation date,
+// and the history of destination changes. URLs with variants also report the clicks of each variant, counted
+// from the click events, so they lag behind the total until the events are flushed.
+func (s *ShortenerService) GetURLStats(ctx context.Context,
+	shortCode string,
+	series *domain.SeriesQuery) (*domain.StatsResponse, error) {
 
 	if !validator.IsValidShortCode(shortCode) {
-		return nil, fmt.Errorf("invalid short code format")
+		return nil, ErrInvalidShortCode
+	}
+	if series != nil {
+		if err := normalizeSeriesQuery(series, time.Now()); err != nil {
+			return nil, err
+		}
 	}
 
 	url, err := s.pgRepo.GetByShortCode(ctx, shortCode)
 	if err != nil {
 		if errors.Is(err, repo.ErrNotFound) {
-			return nil, fmt.Errorf("short URL not found")
+			return nil, ErrNotFound
 		}
-		return nil, fmt.Errorf("error retrieving URL stats")
+		return nil, fmt.Errorf("error retrieving URL stats: %w", ErrUnavailable)
+	}
+	if err := checkOwner(ctx, url); err != nil {
+		return nil, err
 	}
 
-	return &domain.StatsResponse{
-		ShortCode: url.ShortCode,
-		LongURL:   url.LongURL,
-		Clicks:    url.Clicks,
-		CreatedAt: url.CreatedAt,
+	stats := toStatsResponse(url)
+	stats.History, err = s.pgRepo.GetDestinationHistory(ctx, url.ID)
+	if err != nil {
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("error retrieving destination history")
+	}
+
+	if len(url.Variants) > 0 {
+		clicks, err := s.pgRepo.GetVariantClicks(ctx, url.ID)
+		if err != nil {
+			log.Warn().Err(err).Str("short_code", shortCode).Msg("error retrieving variant clicks")
+		} else {
+			stats.Variants = toVariantStats(url.Variants, clicks)
+		}
+	}
+
+	if series != nil {
+		stats.Series, err = s.pgRepo.GetClickSeries(ctx, url.ID, series)
+		if err != nil {
+			log.Error().Err(err).Str("short_code", shortCode).Msg("error retrieving click series")
+
+			return nil, fmt.Errorf("error retrieving URL stats: %w", ErrUnavailable)
+		}
+		stats.Interval = series.Interval
+	}
+
+	return stats, nil
+}
+
+// ListURLs retrieves a page of short URLs matching the given query.
+// URLs are sorted by creation date or click count, newest or most clicked first, and the query defaults to
+// sorting by creation date with pages of defaultListLimit URLs.
+// If ctx carries an authenticated owner, only its URLs are listed.
+// An unknown sort, an out of range limit, an empty date range or a malformed cursor returns an error
+// wrapping ErrInvalidListQuery.
+// On success, it returns the page and, if there may be more URLs, the cursor of the next page.
+func (s *ShortenerService) ListURLs(ctx context.Context, query *domain.ListURLsQuery) (*domain.ListURLsResponse, error) {
+
+	if err := normalizeListQuery(query); err != nil {
+		return nil, err
+	}
+	query.OwnerID = ownerIDFromContext(ctx)
+
+	// One extra URL tells whether there is a next page
+	limit := query.Limit
+	query.Limit++
+	urls, err := s.pgRepo.ListURLs(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("error listing URLs from the database")
+
+		return nil, fmt.Errorf("error listing short URLs: %w", ErrUnavailable)
+	}
+
+	response := &domain.ListURLsResponse{
+		URLs: make([]domain.URLSummary, 0, min(len(urls), limit)),
+	}
+	for i, url := range urls {
+		if i == limit {
+			response.NextCursor = encodeListCursor(query.Sort, urls[i-1])
+			break
+		}
+		response.URLs = append(response.URLs, s.toURLSummary(url))
+	}
+
+	return response, nil
+}
+
+// GetQRCode renders a QR code that encodes the full short URL of the given short code.
+// The query defaults to a PNG of defaultQRCodeSize pixels, with error correction level M and the
+// standard quiet zone of defaultQRCodeMargin modules.
+// Invalid rendering options return an error wrapping ErrInvalidQRCode.
+// If the short code is not found, or belongs to another owner than the one carried by ctx, it returns an
+// error wrapping ErrNotFound and repo.ErrNotFound.
+// On success, it returns the image along with its content type.
+func (s *ShortenerService) GetQRCode(ctx context.Context,
+	shortCode string,
+	query *domain.QRCodeQuery) (*domain.QRCode, error) {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidShortCode, repo.ErrInvalidShortCode)
+	}
+	if err := normalizeQRCodeQuery(query); err != nil {
+		return nil, err
+	}
+
+	url, err := s.pgRepo.GetByShortCode(ctx, shortCode)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error retrieving URL from the database")
+
+		return nil, fmt.Errorf("error retrieving URL: %w", ErrUnavailable)
+	}
+	if err := checkOwner(ctx, url); err != nil {
+		return nil, err
+	}
+
+	data, err := qr.Render(fmt.Sprintf("%s/%s", s.baseURL, url.ShortCode), qr.Options{
+		Size:   query.Size,
+		Format: query.Format,
+		Level:  query.Level,
+		Margin: *query.Margin,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error rendering QR code")
+
+		return nil, fmt.Errorf("error rendering QR code")
+	}
+
+	contentType := "image/png"
+	if query.Format == qr.FormatSVG {
+		contentType = "image/svg+xml"
+	}
+
+	return &domain.QRCode{
+		ContentType: contentType,
+		Data:        data,
 	}, nil
 }
 
-// ----------------------------------------------------------------------------------------
-//                                    PRIVATE METHODS
-// ----------------------------------------------------------------------------------------
+// UpdateLongURL changes the destination of the short URL for the given short code.
+// The new long URL is normalized and validated like on creation.
+// The previous destination is kept as an audit trail and the updated URL is written to Redis
+// once the change is committed, replacing its cache entry, so the next redirect uses the new destination.
+// Only the owner carried by ctx, if any, can change the destination.
+// On success, it returns the updated statistics of the URL, including the destination history.
+func (s *ShortenerService) UpdateLongURL(ctx context.Context,
+	shortCode, longURL string) (*domain.StatsResponse, error) {
 
-// createShortURLWithRetries attempts to create a short URL for the given long URL.
-// It generates a random 6-character code and retries up to maxRetries times in case of collisions.
-// The database will auto-generate the ID via the sequence.
-// If the long URL is invalid, it returns an error.
-// If a collision occurs, it logs a warning and retries with a new random code.
-// If the maximum number of retries is reached, it returns an error.
-// On success, it returns a CreateURLResponse containing the short code, short URL, and long URL.
-func (s *ShortenerService) createShortURLWithRetries(ctx context.Context,
-	longURL string,
-	attempt, maxRetries int) (*domain.CreateURLResponse, error) {
+	if !validator.IsValidShortCode(shortCode) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidShortCode, repo.ErrInvalidShortCode)
+	}
 
-	if attempt >= maxRetries {
-		return nil, fmt.Errorf("max retries reached for creating short URL")
+	longURL, err := normalizeLongURL(longURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkDestination(longURL); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, shortCode); err != nil {
+		return nil, err
 	}
 
-	longURL = validator.NormalizeURL(longURL)
-	if !validator.IsValidURL(longURL) {
-		return nil, fmt.Errorf("invalid URL: %s", longURL)
+	url, err := s.pgRepo.UpdateLongURL(ctx, shortCode, longURL)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error updating URL destination in the database")
+
+		return nil, fmt.Errorf("error updating short URL: %w", ErrUnavailable)
 	}
+	s.cacheURL(ctx, url)
 
-	shortCode, err := s.generator.GenerateRandom()
+	stats := toStatsResponse(url)
+	stats.History, err = s.pgRepo.GetDestinationHistory(ctx, url.ID)
 	if err != nil {
-		log.Error().Err(err).Msg("error generating random short code")
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("error retrieving destination history")
+	}
 
-		return nil, fmt.Errorf("error generating short code")
+	return stats, nil
+}
+
+// DeleteURL permanently deletes the short URL for the given short code.
+// The URL is removed from Postgres first and then cached as not found in Redis for notFoundTTL,
+// replacing its cache entry.
+// If the short code is not found, or belongs to another owner than the one carried by ctx,
+// it returns an error wrapping ErrNotFound and repo.ErrNotFound.
+// A failure to replace the cache entry is only logged.
+func (s *ShortenerService) DeleteURL(ctx context.Context, shortCode string) error {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return fmt.Errorf("%w: %w", ErrInvalidShortCode, repo.ErrInvalidShortCode)
+	}
+	if err := s.authorize(ctx, shortCode); err != nil {
+		return err
+	}
+
+	if err := s.pgRepo.Delete(ctx, shortCode); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error deleting URL from the database")
+
+		return fmt.Errorf("error deleting short URL: %w", ErrUnavailable)
+	}
+	if err := s.redisRepo.SetDeleted(ctx, shortCode, s.notFoundTTL); err != nil {
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("error caching deleted short code with Redis")
+	}
+
+	return nil
+}
+
+// SetURLEnabled enables or disables the short URL for the given short code.
+// A disabled URL is kept in Postgres but its redirect answers with ErrLinkDisabled.
+// The updated URL is written to Redis, replacing its cache entry, so the change takes effect immediately.
+// Only the owner carried by ctx, if any, can enable or disable the URL.
+// On success, it returns the updated statistics of the URL.
+func (s *ShortenerService) SetURLEnabled(ctx context.Context,
+	shortCode string,
+	enabled bool) (*domain.StatsResponse, error) {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidShortCode, repo.ErrInvalidShortCode)
+	}
+	if err := s.authorize(ctx, shortCode); err != nil {
+		return nil, err
 	}
 
-	url, err := s.pgRepo.Create(ctx, 0, shortCode, longURL)
+	url, err := s.pgRepo.SetDisabled(ctx, shortCode, !enabled)
 	if err != nil {
-		if errors.Is(err, repo.ErrAlreadyExists) {
-			log.Warn().Str("short_code", shortCode).Msg("collision detected, retrying")
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error updating URL status in the database")
+
+		return nil, fmt.Errorf("error updating short URL: %w", ErrUnavailable)
+	}
+	s.cacheURL(ctx, url)
+
+	return toStatsResponse(url), nil
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE METHODS
+// ----------------------------------------------------------------------------------------
+
+// lookupURL retrieves the URL associated with the given short code, from the Redis cache first
+// and from Postgres on a cache miss, in which case the URL is cached for the next lookups, see loadURL.
+// Short codes that aren't found in Postgres are cached as not found for notFoundTTL, so that repeated
+// lookups of unknown short codes, such as those of scanners, don't reach the database.
+// With early refresh, a cache hit may also reload the URL in the background before its entry expires,
+// see shouldRefreshEarly.
+// If the short code is invalid or not found, it returns ErrInvalidShortCode or ErrNotFound, and if the
+// database fails, an error wrapping ErrUnavailable.
+func (s *ShortenerService) lookupURL(ctx context.Context, shortCode string) (*domain.URL, error) {
+
+	if !validator.IsValidShortCode(shortCode) {
+		return nil, ErrInvalidShortCode
+	}
 
-			return s.createShortURLWithRetries(ctx, longURL, attempt+1, maxRetries)
+	url, ttl, err := s.redisRepo.GetWithTTL(ctx, shortCode)
+	if err == nil {
+		log.Debug().Str("short_code", shortCode).Msg("cache hit")
+		if s.shouldRefreshEarly(ttl) {
+			s.refreshEarly(shortCode, url)
 		}
-		log.Error().Err(err).Msg("error inserting URL into the database")
 
-		return nil, fmt.Errorf("error creating short URL")
+		return url, nil
 	}
+	if errors.Is(err, repo.ErrCachedNotFound) {
+		log.Debug().Str("short_code", shortCode).Msg("cache hit for a short code not found")
 
-	if err := s.redisRepo.Set(ctx, shortCode, url); err != nil {
-		log.Warn().Err(err).Str("short_code", shortCode).Msg("error caching URL with Redis")
+		return nil, ErrNotFound
 	}
 
-	return &domain.CreateURLResponse{
-		ShortCode: shortCode,
-		ShortURL:  fmt.Sprintf("%s/%s", s.baseURL, shortCode),
-		LongURL:   longURL,
-	}, nil
+	log.Debug().Str("short_code", shortCode).Msg("cache miss, querying from Postgres")
+
+	return s.loadURL(ctx, shortCode)
 }
 
-// incrementClicksAsync increments the click counter for the given short code asynchronously.
-// It runs the increment operation in a separate goroutine with a timeout context.
-// If there is an error incrementing the counter, it logs a warning.
-func (s *ShortenerService) incrementClicksAsync(shortCode string) {
+// loadURL retrieves the URL associated with the given short code from Postgres and caches it, or caches
+// the short code as not found.
+// Concurrent loads of the same short code are coalesced into a single query, whose result is shared,
+// so that a hot link whose cache entry expired costs one query instead of one per waiting redirect.
+// The shared query isn't canceled with ctx, since other lookups may be waiting for it, and is bounded by
+// loadTimeout instead; ctx only stops the wait.
+func (s *ShortenerService) loadURL(ctx context.Context, shortCode string) (*domain.URL, error) {
+
+	loaded := s.loads.DoChan(shortCode, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+
+		return s.queryURL(ctx, shortCode)
+	})
 
 	select {
-	case s.clickWorkers <- struct{}{}:
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
+	case result := <-loaded:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		// Every caller gets its own copy, since visits modify the URL they are given
+		url := *result.Val.(*domain.URL)
+
+		return &url, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error retrieving long URL: %w: %w", ErrUnavailable, ctx.Err())
+	}
+}
+
+// queryURL retrieves the URL associated with the given short code from Postgres and caches it with Redis,
+// or caches the short code as not found for notFoundTTL and returns ErrNotFound.
+// The URL is only cached if nothing is cached for the short code yet, since the entry written by a change
+// committed after the query, see cacheURL, is more recent.
+// If the database fails, it returns an error wrapping ErrUnavailable.
+func (s *ShortenerService) queryURL(ctx context.Context, shortCode string) (*domain.URL, error) {
 
-			if err := s.pgRepo.IncrementClicksCounter(ctx, shortCode); err != nil {
-				log.Warn().Err(err).Str("short_code", shortCode).Msg("error incrementing clicks counter in " +
-					"background")
+	url, err := s.pgRepo.GetByShortCode(ctx, shortCode)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			if err := s.redisRepo.SetNotFound(ctx, shortCode, s.notFoundTTL); err != nil {
+				log.Warn().Err(err).Str("short_code", shortCode).Msg("error caching short code as not found with Redis")
 			}
-		}()
-	default:
-		log.Warn().Msg("click workers limit reached, skipping increment")
+			return nil, ErrNotFound
+		}
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error retrieving URL from the database")
+
+		return nil, fmt.Errorf("error retrieving long URL: %w", ErrUnavailable)
+	}
+	if err := s.redisRepo.Add(ctx, shortCode, url); err != nil {
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("error caching URL with Redis")
+	}
+
+	return url, nil
+}
+
+// shouldRefreshEarly decides whether a cache hit, whose entry expires in ttl, reloads the URL ahead of time.
+// It does with a probability of exp(-ttl/earlyRefresh): negligible for fresh entries and growing as the
+// expiration gets closer, so that a hot link is refreshed by one of its many redirects before its entry
+// expires, while rarely visited links simply expire. It never does without early refresh or an unknown TTL.
+func (s *ShortenerService) shouldRefreshEarly(ttl time.Duration) bool {
+
+	if s.earlyRefresh <= 0 || ttl <= 0 {
+		return false
+	}
+
+	return float64(ttl) < -float64(s.earlyRefresh)*math.Log(rand.Float64())
+}
+
+// refreshEarly reloads the URL of the given short code in the background, coalesced with the other refreshes
+// in flight for it, to replace its cached entry while the entry keeps being served, see reloadURL.
+func (s *ShortenerService) refreshEarly(shortCode string, cached *domain.URL) {
+
+	log.Debug().Str("short_code", shortCode).Msg("refreshing cache entry ahead of its expiration")
+
+	// Visits modify the URL they are given, so the refresh keeps the entry as it was read
+	previous := *cached
+
+	s.refreshes.Add(1)
+	go func() {
+		defer s.refreshes.Done()
+
+		_, _, _ = s.loads.Do(refreshKeyPrefix+shortCode, func() (any, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+			defer cancel()
+
+			s.reloadURL(ctx, shortCode, &previous)
+			return nil, nil
+		})
+	}()
+}
+
+// reloadURL retrieves the URL of the given short code from Postgres and replaces its cached entry with it,
+// only if the entry is still the previous URL, so that a change committed after the query, and written to
+// the cache by cacheURL, isn't replaced by the URL as it was before. A short code that no longer exists is
+// evicted instead. Failures are only logged, since the current entry still expires with its TTL.
+func (s *ShortenerService) reloadURL(ctx context.Context, shortCode string, previous *domain.URL) {
+
+	url, err := s.pgRepo.GetByShortCode(ctx, shortCode)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			s.evictFromCache(ctx, shortCode)
+			return
+		}
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("error refreshing URL from the database")
+
+		return
+	}
+	if err := s.redisRepo.Replace(ctx, shortCode, previous, url); err != nil {
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("error refreshing URL in Redis")
 	}
 }
+
+// lookupVisitableURL looks up the URL of a short code for the given visit, which may be nil.
+// Besides the errors of lookupURL, it fails with ErrLinkDisabled or ErrLinkExpired for URLs that can't be
+// redirected to, and as if the URL didn't exist for visits with a trailing path to links without passthrough.
+func (s *ShortenerService) lookupVisitableURL(ctx context.Context,
+	shortCode string,
+	visit *domain.Visit) (*domain.URL, error) {
+
+	url, err := s.lookupURL(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if visit != nil && visit.Path != "" && !url.Passthrough {
+		return nil, ErrNotFound
+	}
+	if err := checkAvailable(url); err != nil {
+		return nil, err
+	}
+
+	return url, nil
+}
+
+// completeVisit records the click of a visit to the given URL and resolves where and how it redirects:
+// the long URL is replaced by the destination of the first targeting rule the visit matches, if any,
+// or else by the variant chosen for the visit, then gets the path and query string of the visit for links
+// with passthrough, and the redirect status falls back to the server default.
+// It fails with an *UnsafeURLError if the destination doesn't pass the safety checks, and if the click can't
+// be recorded on a URL with a click limit, see recordClick. The clicks of probe visits aren't recorded.
+func (s *ShortenerService) completeVisit(ctx context.Context,
+	shortCode string,
+	url *domain.URL,
+	visit *domain.Visit) (*domain.URL, error) {
+
+	destination, targeted := s.targetURL(url.Targeting, visit)
+	if !targeted {
+		destination = url.LongURL
+		url.Variant = chooseVariant(url, visit)
+		if url.Variant > 0 {
+			destination = url.Variants[url.Variant-1].LongURL
+		}
+	}
+	if err := s.checkDestination(destination); err != nil {
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("refusing to redirect to unsafe destination")
+
+		return nil, err
+	}
+
+	if visit == nil || !visit.Probe {
+		if err := s.recordClick(ctx, shortCode, url, visit); err != nil {
+			return nil, err
+		}
+	}
+	url.LongURL = destination
+
+	if url.Passthrough && visit != nil {
+		url.LongURL = passthroughURL(url.LongURL, visit.Path, visit.RawQuery)
+	}
+	if url.RedirectStatus == 0 {
+		url.RedirectStatus = s.redirectStatus
+	}
+
+	return url, nil
+}
+
+// buildURL validates a create request and builds the URL to insert for it, without an owner.
+// The long URL is normalized and validated, then gets the UTM parameters of the request, if any,
+// and the optional expiration is resolved to an absolute time from now.
+// An unsupported redirect status returns an error wrapping ErrInvalidRedirect, and a password of an unsupported
+// length returns ErrInvalidPassword. The password itself is only stored as a bcrypt hash.
+// A negative click limit returns an error wrapping ErrInvalidMaxClicks, invalid targeting rules an error
+// wrapping ErrInvalidTargeting, and invalid variants an error wrapping ErrInvalidVariants.
+// Every destination must pass the safety checks of checkDestination, or an *UnsafeURLError is returned.
+// An alias must be a valid short code that isn't reserved, and is set as the short code of the URL.
+func (s *ShortenerService) buildURL(request *domain.CreateURLRequest, now time.Time) (*domain.URL, error) {
+
+	longURL, err := normalizeLongURL(request.LongURL)
+	if err != nil {
+		return nil, err
+	}
+	longURL = applyUTM(longURL, request.UTM)
+
+	expiresAt, err := parseExpiration(request.ExpiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRedirectStatus(request.RedirectStatus); err != nil {
+		return nil, err
+	}
+	if request.MaxClicks < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidMaxClicks, request.MaxClicks)
+	}
+	passwordHash, err := hashPassword(request.Password)
+	if err != nil {
+		return nil, err
+	}
+	targeting, err := normalizeTargeting(request.Targeting)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := normalizeVariants(request.Variants, request.StickyVariants)
+	if err != nil {
+		return nil, err
+	}
+
+	url := &domain.URL{
+		LongURL:        longURL,
+		ExpiresAt:      expiresAt,
+		Preview:        request.Preview,
+		RedirectStatus: request.RedirectStatus,
+		Passthrough:    request.Passthrough,
+		PasswordHash:   passwordHash,
+		MaxClicks:      request.MaxClicks,
+		Targeting:      targeting,
+		Variants:       variants,
+		StickyVariants: request.StickyVariants,
+	}
+	if err := s.checkDestinations(url); err != nil {
+		return nil, err
+	}
+	if request.Alias != "" {
+		if !validator.IsValidShortCode(request.Alias) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAlias, request.Alias)
+		}
+		if validator.IsReservedShortCode(request.Alias) {
+			return nil, fmt.Errorf("%w: %s", ErrReservedAlias, request.Alias)
+		}
+		url.ShortCode = request.Alias
+	}
+
+	return url, nil
+}
+
+// createShortURLWithRetries attempts to create a short URL for the given URL.
+// It generates a random 6-character code and retries up to maxRetries times in case of collisions.
+// The database will auto-generate the ID via the sequence.
+// If a collision occurs, it logs a warning and retries with a new random code.
+// If the maximum number of retries is reached, it returns an error.
+// On success, it returns a CreateURLResponse containing the short code, short URL, and long URL.
+func (s *ShortenerService) createShortURLWithRetries(ctx context.Context,
+	url *domain.URL,
+	attempt, maxRetries int) (*domain.CreateURLResponse, error) {
+
+	if attempt >= maxRetries {
+		return nil, fmt.Errorf("max retries reached for creating short URL")
+	}
+
+	shortCode, err := s.generator.GenerateRandom()
+	if err != nil {
+		log.Error().Err(err).Msg("error generating random short code")
+
+		return nil, fmt.Errorf("error generating short code")
+	}
+	url.ShortCode = shortCode
+
+	created, err := s.pgRepo.Create(ctx, url)
+	if err != nil {
+		if errors.Is(err, repo.ErrAlreadyExists) {
+			log.Warn().Str("short_code", shortCode).Msg("collision detected, retrying")
+
+			return s.createShortURLWithRetries(ctx, url, attempt+1, maxRetries)
+		}
+		log.Error().Err(err).Msg("error inserting URL into the database")
+
+		return nil, fmt.Errorf("error creating short URL: %w", ErrUnavailable)
+	}
+
+	return s.cacheCreatedURL(ctx, created), nil
+}
+
+// createBatchRound inserts the pending items of a batch in a single statement.
+// Items without an alias get a new random short code that is unique within the round, or with sequential codes,
+// the code of a new ID drawn from the URL sequence.
+// Created items are removed from pending and their response is stored in the result at the same index.
+// Items with an alias that is already taken fail, while the other leftover items stay pending for the next round.
+func (s *ShortenerService) createBatchRound(ctx context.Context,
+	pending map[int]*batchItem,
+	response *domain.BatchCreateURLResponse) error {
+
+	byShortCode := make(map[string]int, len(pending))
+	for i, item := range pending {
+		if item.alias {
+			byShortCode[item.url.ShortCode] = i
+		}
+	}
+
+	var drawn []drawnCode
+	if s.sequentialCodes {
+		var err error
+		if drawn, err = s.nextSequentialCodes(ctx, len(pending)-len(byShortCode)); err != nil {
+			return err
+		}
+	}
+
+	urls := make([]*domain.URL, 0, len(pending))
+	for i, item := range pending {
+		if !item.alias && s.sequentialCodes {
+			item.url.ID, item.url.ShortCode = drawn[0].id, drawn[0].shortCode
+			drawn = drawn[1:]
+			byShortCode[item.url.ShortCode] = i
+		} else if !item.alias {
+			for {
+				shortCode, err := s.generator.GenerateRandom()
+				if err != nil {
+					log.Error().Err(err).Msg("error generating random short code")
+
+					return fmt.Errorf("error generating short code")
+				}
+				if _, taken := byShortCode[shortCode]; !taken {
+					item.url.ShortCode = shortCode
+					break
+				}
+			}
+			byShortCode[item.url.ShortCode] = i
+		}
+		urls = append(urls, item.url)
+	}
+
+	created, err := s.pgRepo.CreateBatch(ctx, urls)
+	if err != nil {
+		log.Error().Err(err).Int("count", len(urls)).Msg("error inserting URL batch into the database")
+
+		return fmt.Errorf("error creating short URLs: %w", ErrUnavailable)
+	}
+
+	for _, url := range created {
+		i, ok := byShortCode[url.ShortCode]
+		if !ok {
+			continue
+		}
+		response.Results[i].CreateURLResponse = s.cacheCreatedURL(ctx, url)
+		delete(pending, i)
+	}
+
+	for i, item := range pending {
+		if item.alias {
+			response.Results[i].Error = fmt.Sprintf("%s: %s", ErrAliasTaken, item.url.ShortCode)
+			delete(pending, i)
+		} else {
+			log.Warn().Str("short_code", item.url.ShortCode).Msg("collision detected in batch, retrying")
+		}
+	}
+
+	return nil
+}
+
+// createShortURLWithAlias creates a short URL using its short code, an alias validated by buildURL.
+// If the alias is already in use, it returns an error wrapping ErrAliasTaken.
+// On success, it returns a CreateURLResponse containing the alias, short URL, and long URL.
+func (s *ShortenerService) createShortURLWithAlias(ctx context.Context,
+	url *domain.URL) (*domain.CreateURLResponse, error) {
+
+	created, err := s.pgRepo.Create(ctx, url)
+	if err != nil {
+		if errors.Is(err, repo.ErrAlreadyExists) {
+			return nil, fmt.Errorf("%w: %s", ErrAliasTaken, url.ShortCode)
+		}
+		log.Error().Err(err).Msg("error inserting URL into the database")
+
+		return nil, fmt.Errorf("error creating short URL: %w", ErrUnavailable)
+	}
+
+	return s.cacheCreatedURL(ctx, created), nil
+}
+
+// cacheCreatedURL stores a newly created URL in Redis, replacing any entry caching its short code as not found,
+// and builds the response for it, see cacheURL.
+func (s *ShortenerService) cacheCreatedURL(ctx context.Context, url *domain.URL) *domain.CreateURLResponse {
+
+	s.cacheURL(ctx, url)
+
+	return s.newCreateURLResponse(url)
+}
+
+// cacheURL stores a URL that was just created or changed in Redis, replacing the entry of its short code.
+// A caching failure is only logged, since the URL is already persisted in Postgres.
+func (s *ShortenerService) cacheURL(ctx context.Context, url *domain.URL) {
+
+	if err := s.redisRepo.Set(ctx, url.ShortCode, url); err != nil {
+		log.Warn().Err(err).Str("short_code", url.ShortCode).Msg("error caching URL with Redis")
+	}
+}
+
+// newCreateURLResponse builds the creation response for the given stored URL.
+func (s *ShortenerService) newCreateURLResponse(url *domain.URL) *domain.CreateURLResponse {
+	return &domain.CreateURLResponse{
+		ShortCode: url.ShortCode,
+		ShortURL:  fmt.Sprintf("%s/%s", s.baseURL, url.ShortCode),
+		LongURL:   url.LongURL,
+		ExpiresAt: url.ExpiresAt,
+	}
+}
+
+// canReuse reports whether the given create request can be answered with an existing short URL.
+// Reuse must be enabled, by the request or server-wide, and only applies to requests without an alias,
+// an expiration, a preview page, a redirect status, passthrough, a password, a click limit, targeting rules
+// or variants, since those ask for a link of their own.
+func (s *ShortenerService) canReuse(request *domain.CreateURLRequest) bool {
+
+	if request.Alias != "" || request.ExpiresAt != "" || request.Preview || request.RedirectStatus != 0 ||
+		request.Passthrough || request.Password != "" || request.MaxClicks != 0 || len(request.Targeting) > 0 ||
+		len(request.Variants) > 0 || request.StickyVariants {
+		return false
+	}
+	if request.ReuseExisting != nil {
+		return *request.ReuseExisting
+	}
+
+	return s.reuseExisting
+}
+
+// findReusableURL looks up an existing short URL for the given normalized long URL.
+// It returns nil if there is none, or if the lookup fails, in which case a new short URL is created.
+func (s *ShortenerService) findReusableURL(ctx context.Context, longURL string) *domain.CreateURLResponse {
+
+	url, err := s.pgRepo.GetByLongURL(ctx, longURL, ownerIDFromContext(ctx))
+	if err != nil {
+		if !errors.Is(err, repo.ErrNotFound) {
+			log.Warn().Err(err).Str("long_url", longURL).Msg("error looking up existing URL, creating a new one")
+		}
+		return nil
+	}
+
+	response := s.newCreateURLResponse(url)
+	response.Reused = true
+
+	return response
+}
+
+// authorize checks that the URL with the given short code can be managed by the owner carried by ctx.
+// Without an owner in ctx, as for internal callers, every URL can be managed.
+// If the URL doesn't exist or belongs to someone else, it returns an error wrapping ErrNotFound and
+// repo.ErrNotFound, so callers can't tell other owners' short codes apart from unused ones.
+func (s *ShortenerService) authorize(ctx context.Context, shortCode string) error {
+
+	if _, ok := OwnerFromContext(ctx); !ok {
+		return nil
+	}
+
+	url, err := s.pgRepo.GetByShortCode(ctx, shortCode)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error retrieving URL from the database")
+
+		return fmt.Errorf("error retrieving short URL: %w", ErrUnavailable)
+	}
+
+	return checkOwner(ctx, url)
+}
+
+// toURLSummary builds the listing entry of the given URL.
+func (s *ShortenerService) toURLSummary(url *domain.URL) domain.URLSummary {
+	return domain.URLSummary{
+		ShortCode: url.ShortCode,
+		ShortURL:  fmt.Sprintf("%s/%s", s.baseURL, url.ShortCode),
+		LongURL:   url.LongURL,
+		Clicks:    url.Clicks,
+		CreatedAt: url.CreatedAt,
+		ExpiresAt: url.ExpiresAt,
+		Enabled:   !url.IsDisabled(),
+		Preview:   url.Preview,
+	}
+}
+
+// evictFromCache removes the cached URL for the given short code from Redis.
+// If there is an error, it logs a warning, since the entry will still expire with its TTL.
+func (s *ShortenerService) evictFromCache(ctx context.Context, shortCode string) {
+
+	if err := s.redisRepo.Delete(ctx, shortCode); err != nil {
+		log.Warn().Err(err).Str("short_code", shortCode).Msg("error evicting URL from Redis")
+	}
+}
+
+// recordClick buffers a click on the given short code in the click aggregator.
+// The counter delta and the click event, with the anonymized visit metadata,
+// are written to Postgres by the next periodic flush.
+// URLs with a click limit have their counter incremented right away instead, only while it is below the limit,
+// and only their event is buffered. Once the limit is reached, it returns ErrClickLimitReached.
+func (s *ShortenerService) recordClick(ctx context.Context,
+	shortCode string,
+	url *domain.URL,
+	visit *domain.Visit) error {
+
+	event := newClickEvent(url, visit, time.Now())
+	if url.MaxClicks == 0 {
+		s.clicks.Add(shortCode, event)
+		return nil
+	}
+
+	if err := s.pgRepo.IncrementClickWithinLimit(ctx, shortCode); err != nil {
+		if errors.Is(err, repo.ErrLimitReached) {
+			return ErrClickLimitReached
+		}
+		log.Error().Err(err).Str("short_code", shortCode).Msg("error recording click of limited URL")
+
+		return fmt.Errorf("error recording click: %w", ErrUnavailable)
+	}
+	s.clicks.AddEvent(shortCode, event)
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE FUNCTIONS
+// ----------------------------------------------------------------------------------------
+
+// batchItem is a validated item of a batch creation that still has to be inserted.
+// When alias is false, the short code is regenerated on every round.
+type batchItem struct {
+	url   *domain.URL
+	alias bool
+}
+
+// newBatchItem validates a create request of a batch with buildURL, like CreateShortURL does.
+func (s *ShortenerService) newBatchItem(request *domain.CreateURLRequest, now time.Time) (*batchItem, error) {
+
+	if request == nil {
+		return nil, fmt.Errorf("%w: missing item", ErrInvalidURL)
+	}
+
+	url, err := s.buildURL(request, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &batchItem{url: url, alias: url.ShortCode != ""}, nil
+}
+
+// countPasswords returns the number of create requests of a batch that have a password.
+func countPasswords(requests []*domain.CreateURLRequest) int {
+
+	count := 0
+	for _, request := range requests {
+		if request != nil && request.Password != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// toStatsResponse builds the statistics response for the given URL.
+func toStatsResponse(url *domain.URL) *domain.StatsResponse {
+	return &domain.StatsResponse{
+		ShortCode:      url.ShortCode,
+		LongURL:        url.LongURL,
+		Clicks:         url.Clicks,
+		CreatedAt:      url.CreatedAt,
+		ExpiresAt:      url.ExpiresAt,
+		Enabled:        !url.IsDisabled(),
+		Preview:        url.Preview,
+		RedirectStatus: url.RedirectStatus,
+		Passthrough:    url.Passthrough,
+		Protected:      url.IsProtected(),
+		MaxClicks:      url.MaxClicks,
+		Targeting:      url.Targeting,
+		StickyVariants: url.StickyVariants,
+	}
+}
+
+// checkOwner checks that the given URL belongs to the owner carried by ctx, if any.
+// URLs without owner can't be managed by an authenticated owner.
+// Otherwise it returns an error wrapping ErrNotFound and repo.ErrNotFound.
+func checkOwner(ctx context.Context, url *domain.URL) error {
+
+	ownerID, ok := OwnerFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if url.OwnerID == nil || *url.OwnerID != ownerID {
+		return fmt.Errorf("%w: %w", ErrNotFound, repo.ErrNotFound)
+	}
+
+	return nil
+}
+
+// ownerIDFromContext returns the owner carried by ctx as the nullable owner of a URL.
+func ownerIDFromContext(ctx context.Context) *int64 {
+
+	ownerID, ok := OwnerFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return &ownerID
+}
+
+// checkAvailable checks whether a URL can still be redirected to.
+// It returns ErrLinkDisabled for disabled URLs, ErrLinkExpired for expired ones, and ErrClickLimitReached
+// for those known to have reached their click limit.
+func checkAvailable(url *domain.URL) error {
+
+	if url.IsDisabled() {
+		return ErrLinkDisabled
+	}
+	if url.IsExpired() {
+		return ErrLinkExpired
+	}
+	if url.IsExhausted() {
+		return ErrClickLimitReached
+	}
+
+	return nil
+}
+
+// passthroughURL appends the escaped trailing path and the query string of a visit to a long URL.
+// The path is joined to the path of the long URL, unless it has "." or ".." segments that could climb
+// above it, in which case it is dropped.
+// Incoming query parameters are added after the ones of the long URL, except those whose key the long URL
+// already has: the parameters chosen on creation always win. The fragment of the long URL is kept.
+// The long URL is returned unchanged if it can't be parsed.
+func passthroughURL(longURL, path, rawQuery string) string {
+
+	target, err := neturl.Parse(longURL)
+	if err != nil {
+		return longURL
+	}
+
+	if path != "" && !hasDotSegments(path) {
+		target = target.JoinPath(path)
+	}
+
+	if rawQuery != "" {
+		existing := target.Query()
+		incoming, _ := neturl.ParseQuery(rawQuery)
+
+		extra := neturl.Values{}
+		for key, values := range incoming {
+			if _, ok := existing[key]; !ok {
+				extra[key] = values
+			}
+		}
+		if len(extra) > 0 {
+			if target.RawQuery != "" {
+				target.RawQuery += "&"
+			}
+			target.RawQuery += extra.Encode()
+		}
+	}
+
+	return target.String()
+}
+
+// hasDotSegments reports whether a path has "." or ".." segments.
+func hasDotSegments(path string) bool {
+
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkRedirectStatus checks that a requested redirect status is supported.
+// Zero means the server default. Otherwise it returns an error wrapping ErrInvalidRedirect.
+func checkRedirectStatus(status int) error {
+
+	if status != 0 && !redirectStatuses[status] {
+		return fmt.Errorf("%w: %d", ErrInvalidRedirect, status)
+	}
+
+	return nil
+}
+
+// newClickEvent builds the click event of a visit to the given URL, with the variant chosen for it.
+// The referrer is reduced to its host, the User-Agent to a device class, and the client IP to a network prefix.
+func newClickEvent(url *domain.URL, visit *domain.Visit, clickedAt time.Time) *domain.ClickEvent {
+	event := &domain.ClickEvent{
+		URLID:     url.ID,
+		ClickedAt: clickedAt,
+		Variant:   url.Variant,
+	}
+
+	if visit != nil {
+		event.ReferrerHost = analytics.ReferrerHost(visit.Referrer)
+		event.UserAgentClass = analytics.UserAgentClass(visit.UserAgent)
+		event.IPPrefix = analytics.AnonymizeIP(visit.ClientIP)
+	}
+
+	return event
+}
+
+// normalizeSeriesQuery validates a click series query and fills in its defaults.
+// The interval defaults to "day", the end of the range to now,
+// and the start of the range to defaultSeriesBuckets intervals before the end.
+// It returns an error wrapping ErrInvalidStatsQuery for unknown intervals, empty ranges,
+// or ranges spanning more than maxSeriesBuckets intervals.
+func normalizeSeriesQuery(query *domain.SeriesQuery, now time.Time) error {
+
+	if query.Interval == "" {
+		query.Interval = "day"
+	}
+	length, ok := seriesIntervals[query.Interval]
+	if !ok {
+		return fmt.Errorf("%w: unsupported interval %s", ErrInvalidStatsQuery, query.Interval)
+	}
+
+	if query.To.IsZero() {
+		query.To = now
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultSeriesBuckets * length)
+	}
+	if !query.From.Before(query.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidStatsQuery)
+	}
+	if query.To.Sub(query.From) > maxSeriesBuckets*length {
+		return fmt.Errorf("%w: range exceeds %d %s buckets", ErrInvalidStatsQuery, maxSeriesBuckets, query.Interval)
+	}
+
+	return nil
+}
+
+// normalizeListQuery validates a URL listing query, fills in its defaults and decodes its cursor.
+// It returns an error wrapping ErrInvalidListQuery if the query can't be served.
+func normalizeListQuery(query *domain.ListURLsQuery) error {
+
+	if query.Sort == "" {
+		query.Sort = "created_at"
+	}
+	if query.Sort != "created_at" && query.Sort != "clicks" {
+		return fmt.Errorf("%w: unsupported sort %s", ErrInvalidListQuery, query.Sort)
+	}
+
+	if query.Limit == 0 {
+		query.Limit = defaultListLimit
+	}
+	if query.Limit < 0 || query.Limit > maxListLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListQuery, maxListLimit)
+	}
+
+	if !query.CreatedFrom.IsZero() && !query.CreatedTo.IsZero() && !query.CreatedFrom.Before(query.CreatedTo) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidListQuery)
+	}
+
+	query.Search = strings.TrimSpace(query.Search)
+	query.Domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(query.Domain)), "www.")
+
+	if query.Cursor != "" {
+		after, err := decodeListCursor(query.Sort, query.Cursor)
+		if err != nil {
+			return err
+		}
+		query.After = after
+	}
+
+	return nil
+}
+
+// normalizeQRCodeQuery validates the rendering options of a QR code and fills in their defaults.
+// It returns an error wrapping ErrInvalidQRCode if the options are out of range or unsupported.
+func normalizeQRCodeQuery(query *domain.QRCodeQuery) error {
+
+	if query.Size == 0 {
+		query.Size = defaultQRCodeSize
+	}
+	if query.Size < minQRCodeSize || query.Size > maxQRCodeSize {
+		return fmt.Errorf("%w: size must be between %d and %d pixels", ErrInvalidQRCode, minQRCodeSize, maxQRCodeSize)
+	}
+
+	query.Format = strings.ToLower(query.Format)
+	if query.Format == "" {
+		query.Format = qr.FormatPNG
+	}
+	if query.Format != qr.FormatPNG && query.Format != qr.FormatSVG {
+		return fmt.Errorf("%w: unsupported format %s", ErrInvalidQRCode, query.Format)
+	}
+
+	query.Level = strings.ToUpper(query.Level)
+	if query.Level == "" {
+		query.Level = "M"
+	}
+	if !qr.IsValidLevel(query.Level) {
+		return fmt.Errorf("%w: error correction level must be L, M, Q or H", ErrInvalidQRCode)
+	}
+
+	if query.Margin == nil {
+		margin := defaultQRCodeMargin
+		query.Margin = &margin
+	}
+	if *query.Margin < 0 || *query.Margin > maxQRCodeMargin {
+		return fmt.Errorf("%w: margin must be between 0 and %d modules", ErrInvalidQRCode, maxQRCodeMargin)
+	}
+
+	return nil
+}
+
+// encodeListCursor builds the opaque cursor that points right after the given URL.
+// It holds the sort, the value of the sort column and the ID of the URL, so a page can't be resumed
+// with another sort.
+func encodeListCursor(sort string, url *domain.URL) string {
+	value := url.CreatedAt.UnixNano()
+	if sort == "clicks" {
+		value = url.Clicks
+	}
+	raw := fmt.Sprintf("%s:%d:%d", sort, value, url.ID)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeListCursor reads a cursor built by encodeListCursor for the given sort.
+// It returns an error wrapping ErrInvalidListQuery if the cursor is malformed or was built for another sort.
+func decodeListCursor(sort, cursor string) (*domain.URLCursor, error) {
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidListQuery)
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalid
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 || parts[0] != sort {
+		return nil, invalid
+	}
+	value, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+
+	after := &domain.URLCursor{ID: id}
+	if sort == "clicks" {
+		after.Clicks = value
+	} else {
+		after.CreatedAt = time.Unix(0, value).UTC()
+	}
+
+	return after, nil
+}
+
+// normalizeLongURL normalizes the given long URL and checks that it is valid.
+// If it isn't, it returns an error wrapping ErrInvalidURL.
+func normalizeLongURL(longURL string) (string, error) {
+
+	longURL = validator.NormalizeURL(longURL)
+	if !validator.IsValidURL(longURL) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidURL, longURL)
+	}
+
+	return longURL, nil
+}
+
+// applyUTM adds the campaign tracking parameters of a create request to a normalized long URL.
+// The other parameters of the long URL are kept as they are, in their order, while a UTM parameter
+// the long URL already has is replaced by the one of the request. The added parameters always follow
+// the order source, medium, campaign, term, content.
+// The long URL is returned unchanged if utm is nil, has no fields set, or the URL can't be parsed.
+func applyUTM(longURL string, utm *domain.UTMParams) string {
+
+	if utm == nil {
+		return longURL
+	}
+
+	params := []struct{ key, value string }{
+		{"utm_source", utm.Source},
+		{"utm_medium", utm.Medium},
+		{"utm_campaign", utm.Campaign},
+		{"utm_term", utm.Term},
+		{"utm_content", utm.Content},
+	}
+
+	replaced := make(map[string]bool, len(params))
+	added := make([]string, 0, len(params))
+	for _, param := range params {
+		value := strings.TrimSpace(param.value)
+		if value == "" {
+			continue
+		}
+		replaced[param.key] = true
+		added = append(added, param.key+"="+neturl.QueryEscape(value))
+	}
+	if len(added) == 0 {
+		return longURL
+	}
+
+	target, err := neturl.Parse(longURL)
+	if err != nil {
+		return longURL
+	}
+
+	query := make([]string, 0, len(added))
+	for _, pair := range strings.Split(target.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := neturl.QueryUnescape(key); err == nil && replaced[unescaped] {
+			continue
+		}
+		query = append(query, pair)
+	}
+	target.RawQuery = strings.Join(append(query, added...), "&")
+
+	return target.String()
+}
+
+// parseExpiration resolves the expiration of a create request to an absolute time.
+// The value can be an RFC3339 timestamp or a duration relative to now, such as "72h".
+// An empty value means the URL never expires and returns nil.
+// Timestamps in the past and non-positive durations are rejected with ErrInvalidExpiration.
+func parseExpiration(value string, now time.Time) (*time.Time, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		duration, durationErr := time.ParseDuration(value)
+		if durationErr != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidExpiration, value)
+		}
+		expiresAt = now.Add(duration)
+	}
+	if !expiresAt.After(now) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExpiration, value)
+	}
+	expiresAt = expiresAt.UTC()
+
+	return &expiresAt, nil
+}
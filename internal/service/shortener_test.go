@@ -3,6 +3,11 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,28 +21,71 @@ import (
 // ------------------------------------------------------------------------------------------
 
 type mockPostgresRepo struct {
-	nextID              int64
-	createFunc          func(ctx context.Context, id int64, shortCode, longURL string) (*domain.URL, error)
-	getByShortCodeFunc  func(ctx context.Context, shortCode string) (*domain.URL, error)
-	incrementClicksFunc func(ctx context.Context, shortCode string) error
-	getNextIDFunc       func(ctx context.Context) (int64, error)
+	nextID                   int64
+	createFunc               func(ctx context.Context, url *domain.URL) (*domain.URL, error)
+	createBatchFunc          func(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error)
+	getByShortCodeFunc       func(ctx context.Context, shortCode string) (*domain.URL, error)
+	getByLongURLFunc         func(ctx context.Context, longURL string, ownerID *int64) (*domain.URL, error)
+	incrementClicksFunc      func(ctx context.Context, deltas map[string]int64) error
+	incrementWithinLimitFunc func(ctx context.Context, shortCode string) error
+	getNextIDFunc            func(ctx context.Context) (int64, error)
+	getNextIDsFunc           func(ctx context.Context, count int) ([]int64, error)
+	deleteFunc               func(ctx context.Context, shortCode string) error
+	setDisabledFunc          func(ctx context.Context, shortCode string, disabled bool) (*domain.URL, error)
+	updateLongURLFunc        func(ctx context.Context, shortCode, longURL string) (*domain.URL, error)
+	getHistoryFunc           func(ctx context.Context, urlID int64) ([]domain.DestinationChange, error)
+	recordClicksFunc         func(ctx context.Context, events []*domain.ClickEvent) error
+	getClickSeriesFunc       func(ctx context.Context, urlID int64, query *domain.SeriesQuery) ([]domain.ClickBucket, error)
+	getVariantClicksFunc     func(ctx context.Context, urlID int64) (map[int]int64, error)
+	listDomainRulesFunc      func(ctx context.Context) ([]domain.DomainRule, error)
+	listURLsFunc             func(ctx context.Context, query *domain.ListURLsQuery) ([]*domain.URL, error)
 }
 
-func (m *mockPostgresRepo) Create(ctx context.Context, id int64, shortCode, longURL string) (*domain.URL, error) {
+func (m *mockPostgresRepo) ListURLs(ctx context.Context, query *domain.ListURLsQuery) ([]*domain.URL, error) {
+
+	if m.listURLsFunc != nil {
+		return m.listURLsFunc(ctx, query)
+	}
+
+	return []*domain.URL{}, nil
+}
+
+func (m *mockPostgresRepo) Create(ctx context.Context, url *domain.URL) (*domain.URL, error) {
 
 	if m.createFunc != nil {
-		return m.createFunc(ctx, id, shortCode, longURL)
+		return m.createFunc(ctx, url)
 	}
 
 	return &domain.URL{
 		ID:        1,
-		ShortCode: shortCode,
-		LongURL:   longURL,
+		ShortCode: url.ShortCode,
+		LongURL:   url.LongURL,
 		CreatedAt: time.Now(),
 		Clicks:    0,
+		ExpiresAt: url.ExpiresAt,
 	}, nil
 }
 
+func (m *mockPostgresRepo) CreateBatch(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error) {
+
+	if m.createBatchFunc != nil {
+		return m.createBatchFunc(ctx, urls)
+	}
+
+	created := make([]*domain.URL, 0, len(urls))
+	for i, url := range urls {
+		created = append(created, &domain.URL{
+			ID:        int64(i + 1),
+			ShortCode: url.ShortCode,
+			LongURL:   url.LongURL,
+			CreatedAt: time.Now(),
+			ExpiresAt: url.ExpiresAt,
+		})
+	}
+
+	return created, nil
+}
+
 func (m *mockPostgresRepo) GetByShortCode(ctx context.Context, shortCode string) (*domain.URL, error) {
 
 	if m.getByShortCodeFunc != nil {
@@ -53,10 +101,28 @@ func (m *mockPostgresRepo) GetByShortCode(ctx context.Context, shortCode string)
 	}, nil
 }
 
-func (m *mockPostgresRepo) IncrementClicksCounter(ctx context.Context, shortCode string) error {
+func (m *mockPostgresRepo) GetByLongURL(ctx context.Context, longURL string, ownerID *int64) (*domain.URL, error) {
+
+	if m.getByLongURLFunc != nil {
+		return m.getByLongURLFunc(ctx, longURL, ownerID)
+	}
+
+	return nil, repo.ErrNotFound
+}
+
+func (m *mockPostgresRepo) IncrementClickWithinLimit(ctx context.Context, shortCode string) error {
+
+	if m.incrementWithinLimitFunc != nil {
+		return m.incrementWithinLimitFunc(ctx, shortCode)
+	}
+
+	return nil
+}
+
+func (m *mockPostgresRepo) IncrementClicksBatch(ctx context.Context, deltas map[string]int64) error {
 
 	if m.incrementClicksFunc != nil {
-		return m.incrementClicksFunc(ctx, shortCode)
+		return m.incrementClicksFunc(ctx, deltas)
 	}
 
 	return nil
@@ -72,11 +138,121 @@ func (m *mockPostgresRepo) GetNextID(ctx context.Context) (int64, error) {
 	return m.nextID, nil
 }
 
+func (m *mockPostgresRepo) GetNextIDs(ctx context.Context, count int) ([]int64, error) {
+
+	if m.getNextIDsFunc != nil {
+		return m.getNextIDsFunc(ctx, count)
+	}
+	ids := make([]int64, count)
+	for i := range ids {
+		m.nextID++
+		ids[i] = m.nextID
+	}
+
+	return ids, nil
+}
+
+func (m *mockPostgresRepo) Delete(ctx context.Context, shortCode string) error {
+
+	if m.deleteFunc != nil {
+		return m.deleteFunc(ctx, shortCode)
+	}
+
+	return nil
+}
+
+func (m *mockPostgresRepo) SetDisabled(ctx context.Context, shortCode string, disabled bool) (*domain.URL, error) {
+
+	if m.setDisabledFunc != nil {
+		return m.setDisabledFunc(ctx, shortCode, disabled)
+	}
+
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+
+	return &domain.URL{
+		ID:         1,
+		ShortCode:  shortCode,
+		LongURL:    "https://example.com",
+		CreatedAt:  time.Now(),
+		DisabledAt: disabledAt,
+	}, nil
+}
+
+func (m *mockPostgresRepo) UpdateLongURL(ctx context.Context, shortCode, longURL string) (*domain.URL, error) {
+
+	if m.updateLongURLFunc != nil {
+		return m.updateLongURLFunc(ctx, shortCode, longURL)
+	}
+
+	return &domain.URL{
+		ID:        1,
+		ShortCode: shortCode,
+		LongURL:   longURL,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func (m *mockPostgresRepo) GetDestinationHistory(ctx context.Context, urlID int64) ([]domain.DestinationChange, error) {
+
+	if m.getHistoryFunc != nil {
+		return m.getHistoryFunc(ctx, urlID)
+	}
+
+	return nil, nil
+}
+
+func (m *mockPostgresRepo) RecordClicks(ctx context.Context, events []*domain.ClickEvent) error {
+
+	if m.recordClicksFunc != nil {
+		return m.recordClicksFunc(ctx, events)
+	}
+
+	return nil
+}
+
+func (m *mockPostgresRepo) GetClickSeries(ctx context.Context,
+	urlID int64,
+	query *domain.SeriesQuery) ([]domain.ClickBucket, error) {
+
+	if m.getClickSeriesFunc != nil {
+		return m.getClickSeriesFunc(ctx, urlID, query)
+	}
+
+	return []domain.ClickBucket{}, nil
+}
+
+func (m *mockPostgresRepo) GetVariantClicks(ctx context.Context, urlID int64) (map[int]int64, error) {
+
+	if m.getVariantClicksFunc != nil {
+		return m.getVariantClicksFunc(ctx, urlID)
+	}
+
+	return map[int]int64{}, nil
+}
+
+func (m *mockPostgresRepo) ListDomainRules(ctx context.Context) ([]domain.DomainRule, error) {
+
+	if m.listDomainRulesFunc != nil {
+		return m.listDomainRulesFunc(ctx)
+	}
+
+	return []domain.DomainRule{}, nil
+}
+
 type mockRedisRepo struct {
-	setFunc    func(ctx context.Context, shortCode string, url *domain.URL) error
-	getFunc    func(ctx context.Context, shortCode string) (*domain.URL, error)
-	deleteFunc func(ctx context.Context, shortCode string) error
-	existsFunc func(ctx context.Context, shortCode string) (bool, error)
+	getWithTTLFunc  func(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error)
+	setFunc         func(ctx context.Context, shortCode string, url *domain.URL) error
+	addFunc         func(ctx context.Context, shortCode string, url *domain.URL) error
+	replaceFunc     func(ctx context.Context, shortCode string, previous, url *domain.URL) error
+	getFunc         func(ctx context.Context, shortCode string) (*domain.URL, error)
+	deleteFunc      func(ctx context.Context, shortCode string) error
+	existsFunc      func(ctx context.Context, shortCode string) (bool, error)
+	setNotFoundFunc func(ctx context.Context, shortCode string, ttl time.Duration) error
+	setDeletedFunc  func(ctx context.Context, shortCode string, ttl time.Duration) error
 }
 
 func (m *mockRedisRepo) Set(ctx context.Context, shortCode string, url *domain.URL) error {
@@ -88,6 +264,24 @@ func (m *mockRedisRepo) Set(ctx context.Context, shortCode string, url *domain.U
 	return nil
 }
 
+func (m *mockRedisRepo) Add(ctx context.Context, shortCode string, url *domain.URL) error {
+
+	if m.addFunc != nil {
+		return m.addFunc(ctx, shortCode, url)
+	}
+
+	return nil
+}
+
+func (m *mockRedisRepo) Replace(ctx context.Context, shortCode string, previous, url *domain.URL) error {
+
+	if m.replaceFunc != nil {
+		return m.replaceFunc(ctx, shortCode, previous, url)
+	}
+
+	return nil
+}
+
 func (m *mockRedisRepo) Get(ctx context.Context, shortCode string) (*domain.URL, error) {
 
 	if m.getFunc != nil {
@@ -97,6 +291,16 @@ func (m *mockRedisRepo) Get(ctx context.Context, shortCode string) (*domain.URL,
 	return nil, repo.ErrNotFound
 }
 
+func (m *mockRedisRepo) GetWithTTL(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error) {
+
+	if m.getWithTTLFunc != nil {
+		return m.getWithTTLFunc(ctx, shortCode)
+	}
+	url, err := m.Get(ctx, shortCode)
+
+	return url, 0, err
+}
+
 func (m *mockRedisRepo) Delete(ctx context.Context, shortCode string) error {
 
 	if m.deleteFunc != nil {
@@ -115,6 +319,24 @@ func (m *mockRedisRepo) Exists(ctx context.Context, shortCode string) (bool, err
 	return false, nil
 }
 
+func (m *mockRedisRepo) SetNotFound(ctx context.Context, shortCode string, ttl time.Duration) error {
+
+	if m.setNotFoundFunc != nil {
+		return m.setNotFoundFunc(ctx, shortCode, ttl)
+	}
+
+	return nil
+}
+
+func (m *mockRedisRepo) SetDeleted(ctx context.Context, shortCode string, ttl time.Duration) error {
+
+	if m.setDeletedFunc != nil {
+		return m.setDeletedFunc(ctx, shortCode, ttl)
+	}
+
+	return nil
+}
+
 // ------------------------------------------------------------------------------------------
 //                                  TABLE-DRIVEN TESTS
 // ------------------------------------------------------------------------------------------
@@ -154,7 +376,7 @@ func TestShortenerService_CreateShortURL(t *testing.T) {
 			name:    "database error",
 			longURL: "https://example.com",
 			mockPg: &mockPostgresRepo{
-				createFunc: func(ctx context.Context, id int64, shortCode, longURL string) (*domain.URL, error) {
+				createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
 					return nil, errors.New("database error")
 				},
 			},
@@ -168,7 +390,7 @@ func TestShortenerService_CreateShortURL(t *testing.T) {
 			generator := shortid.NewGenerator()
 			service := NewShortenerService(tt.mockPg, tt.mockRedis, generator, "http://localhost:8080")
 
-			result, err := service.CreateShortURL(context.Background(), tt.longURL)
+			result, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{LongURL: tt.longURL})
 
 			if tt.expectedError {
 				if err == nil {
@@ -228,15 +450,15 @@ func TestShortenerService_CreateShortURL_Collisions(t *testing.T) {
 			attempts := 0
 			mockPg := &mockPostgresRepo{
 				nextID: 0,
-				createFunc: func(ctx context.Context, id int64, shortCode, longURL string) (*domain.URL, error) {
+				createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
 					attempts++
 					if attempts <= tt.collisions {
 						return nil, repo.ErrAlreadyExists
 					}
 					return &domain.URL{
 						ID:        int64(attempts),
-						ShortCode: shortCode,
-						LongURL:   longURL,
+						ShortCode: url.ShortCode,
+						LongURL:   url.LongURL,
 						CreatedAt: time.Now(),
 					}, nil
 				},
@@ -245,7 +467,7 @@ func TestShortenerService_CreateShortURL_Collisions(t *testing.T) {
 			generator := shortid.NewGenerator()
 			service := NewShortenerService(mockPg, mockRedis, generator, "http://localhost:8080")
 
-			result, err := service.CreateShortURL(context.Background(), "https://example.com")
+			result, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{LongURL: "https://example.com"})
 
 			if tt.expectedError {
 				if err == nil {
@@ -266,94 +488,69 @@ func TestShortenerService_CreateShortURL_Collisions(t *testing.T) {
 	}
 }
 
-func TestShortenerService_GetLongURL(t *testing.T) {
+func TestShortenerService_CreateShortURL_WithAlias(t *testing.T) {
 	tests := []struct {
 		name          string
-		shortCode     string
+		alias         string
 		mockPg        *mockPostgresRepo
-		mockRedis     *mockRedisRepo
-		expectedURL   string
-		expectedError string
+		expectedErr   error
+		expectedShort string
 	}{
 		{
-			name:      "cache hit",
-			shortCode: "abc123",
-			mockRedis: &mockRedisRepo{
-				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return &domain.URL{LongURL: "https://example.com"}, nil
-				},
-			},
+			name:          "available alias",
+			alias:         "springsale",
+			mockPg:        &mockPostgresRepo{},
+			expectedShort: "springsale",
+		},
+		{
+			name:        "alias with invalid characters",
+			alias:       "spring-sale",
 			mockPg:      &mockPostgresRepo{},
-			expectedURL: "https://example.com",
+			expectedErr: ErrInvalidAlias,
 		},
 		{
-			name:      "cache miss - db hit",
-			shortCode: "xyz789",
-			mockRedis: &mockRedisRepo{
-				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return nil, repo.ErrNotFound
-				},
-			},
-			mockPg: &mockPostgresRepo{
-				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return &domain.URL{LongURL: "https://example.com"}, nil
-				},
-			},
-			expectedURL: "https://example.com",
+			name:        "alias too long",
+			alias:       "springsale2025",
+			mockPg:      &mockPostgresRepo{},
+			expectedErr: ErrInvalidAlias,
 		},
 		{
-			name:          "invalid short code format",
-			shortCode:     "invalid@code!",
-			mockRedis:     &mockRedisRepo{},
-			mockPg:        &mockPostgresRepo{},
-			expectedError: "invalid short code format",
+			name:        "reserved alias",
+			alias:       "api",
+			mockPg:      &mockPostgresRepo{},
+			expectedErr: ErrReservedAlias,
 		},
 		{
-			name:      "short code not found in db",
-			shortCode: "notfound",
-			mockRedis: &mockRedisRepo{
-				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return nil, repo.ErrNotFound
-				},
-			},
-			mockPg: &mockPostgresRepo{
-				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return nil, repo.ErrNotFound
-				},
-			},
-			expectedError: "short URL not found",
+			name:        "reserved alias in uppercase",
+			alias:       "HEALTH",
+			mockPg:      &mockPostgresRepo{},
+			expectedErr: ErrReservedAlias,
 		},
 		{
-			name:      "database error",
-			shortCode: "abc123",
-			mockRedis: &mockRedisRepo{
-				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return nil, repo.ErrNotFound
-				},
-			},
+			name:  "alias already taken",
+			alias: "springsale",
 			mockPg: &mockPostgresRepo{
-				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return nil, errors.New("db connection error")
+				createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+					return nil, repo.ErrAlreadyExists
 				},
 			},
-			expectedError: "error retrieving long URL",
+			expectedErr: ErrAliasTaken,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			generator := shortid.NewGenerator()
-			service := NewShortenerService(tt.mockPg, tt.mockRedis, generator, "http://localhost:8080")
+			service := NewShortenerService(tt.mockPg, &mockRedisRepo{}, generator, "http://localhost:8080")
 
-			longURL, err := service.GetLongURL(context.Background(), tt.shortCode)
+			result, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+				LongURL: "https://example.com",
+				Alias:   tt.alias,
+			})
 
-			if tt.expectedError != "" {
-				if err == nil {
-					t.Errorf("expected error containing '%s', got nil", tt.expectedError)
-					return
-				}
-				if !contains(err.Error(), tt.expectedError) {
-					t.Errorf("expected error '%s', got '%s'", tt.expectedError, err.Error())
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error '%v', got '%v'", tt.expectedErr, err)
 				}
 				return
 			}
@@ -362,119 +559,1886 @@ func TestShortenerService_GetLongURL(t *testing.T) {
 				t.Errorf("unexpected error: %v", err)
 				return
 			}
-
-			if longURL != tt.expectedURL {
-				t.Errorf("expected '%s', got '%s'", tt.expectedURL, longURL)
+			if result.ShortCode != tt.expectedShort {
+				t.Errorf("expected short code '%s', got '%s'", tt.expectedShort, result.ShortCode)
+			}
+			if result.ShortURL != "http://localhost:8080/"+tt.expectedShort {
+				t.Errorf("expected short URL 'http://localhost:8080/%s', got '%s'", tt.expectedShort, result.ShortURL)
 			}
 		})
 	}
 }
 
-func TestNewShortenerService_GetLongURL_CacheMiss(t *testing.T) {
-	mockPg := &mockPostgresRepo{
-		getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-			return &domain.URL{
-				ID:        1,
-				ShortCode: shortCode,
-				LongURL:   "https://example.com",
-				CreatedAt: time.Now(),
-				Clicks:    5,
-			}, nil
+func TestShortenerService_CreateShortURL_WithExpiration(t *testing.T) {
+	tests := []struct {
+		name        string
+		expiresAt   string
+		expectedErr error
+		expectNil   bool
+		minExpiry   time.Duration
+	}{
+		{
+			name:      "no expiration",
+			expiresAt: "",
+			expectNil: true,
 		},
-	}
-	mockRedis := &mockRedisRepo{
-		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-			return nil, repo.ErrNotFound
+		{
+			name:      "duration",
+			expiresAt: "72h",
+			minExpiry: 71 * time.Hour,
+		},
+		{
+			name:      "absolute timestamp",
+			expiresAt: time.Now().Add(48 * time.Hour).Format(time.RFC3339),
+			minExpiry: 47 * time.Hour,
+		},
+		{
+			name:        "timestamp in the past",
+			expiresAt:   time.Now().Add(-time.Hour).Format(time.RFC3339),
+			expectedErr: ErrInvalidExpiration,
+		},
+		{
+			name:        "negative duration",
+			expiresAt:   "-5m",
+			expectedErr: ErrInvalidExpiration,
+		},
+		{
+			name:        "unparseable value",
+			expiresAt:   "next week",
+			expectedErr: ErrInvalidExpiration,
 		},
 	}
-	generator := shortid.NewGenerator()
-	service := NewShortenerService(mockPg, mockRedis, generator, "http://localhost:8080")
 
-	longURL, err := service.GetLongURL(context.Background(), "xyz789")
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
-	}
-	if longURL != "https://example.com" {
-		t.Errorf("expected long URL 'https://example.com', got '%s'", longURL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := shortid.NewGenerator()
+			service := NewShortenerService(&mockPostgresRepo{}, &mockRedisRepo{}, generator, "http://localhost:8080")
+
+			result, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+				LongURL:   "https://example.com",
+				ExpiresAt: tt.expiresAt,
+			})
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error '%v', got '%v'", tt.expectedErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if tt.expectNil {
+				if result.ExpiresAt != nil {
+					t.Errorf("expected no expiration, got %v", result.ExpiresAt)
+				}
+				return
+			}
+			if result.ExpiresAt == nil {
+				t.Errorf("expected an expiration but got nil")
+				return
+			}
+			if time.Until(*result.ExpiresAt) < tt.minExpiry {
+				t.Errorf("expected expiration at least %v from now, got %v", tt.minExpiry, result.ExpiresAt)
+			}
+		})
 	}
 }
 
-func TestShortenerService_GetURLStats(t *testing.T) {
+func TestShortenerService_CreateShortURL_ReuseExisting(t *testing.T) {
+	reuse, noReuse := true, false
+	existing := &domain.URL{ID: 3, ShortCode: "exist1", LongURL: "https://example.com/page"}
+
 	tests := []struct {
-		name          string
-		shortCode     string
-		mockPg        *mockPostgresRepo
-		expectedStats *domain.StatsResponse
-		expectedError string
+		name           string
+		serverDefault  bool
+		request        *domain.CreateURLRequest
+		lookupErr      error
+		expectedReused bool
+		expectedLookup bool
 	}{
 		{
-			name:      "success",
-			shortCode: "abc123",
-			mockPg: &mockPostgresRepo{
-				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return &domain.URL{
-						ShortCode: shortCode,
-						LongURL:   "https://example.com",
-						Clicks:    42,
-						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-					}, nil
+			name:           "reuse requested and found",
+			request:        &domain.CreateURLRequest{LongURL: "example.com/page", ReuseExisting: &reuse},
+			expectedReused: true,
+			expectedLookup: true,
+		},
+		{
+			name:           "server-wide reuse",
+			serverDefault:  true,
+			request:        &domain.CreateURLRequest{LongURL: "https://example.com/page"},
+			expectedReused: true,
+			expectedLookup: true,
+		},
+		{
+			name:           "request opts out of server-wide reuse",
+			serverDefault:  true,
+			request:        &domain.CreateURLRequest{LongURL: "https://example.com/page", ReuseExisting: &noReuse},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name:           "reuse not requested",
+			request:        &domain.CreateURLRequest{LongURL: "https://example.com/page"},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name:           "no existing URL",
+			request:        &domain.CreateURLRequest{LongURL: "https://example.com/page", ReuseExisting: &reuse},
+			lookupErr:      repo.ErrNotFound,
+			expectedReused: false,
+			expectedLookup: true,
+		},
+		{
+			name:           "lookup failure falls back to creation",
+			request:        &domain.CreateURLRequest{LongURL: "https://example.com/page", ReuseExisting: &reuse},
+			lookupErr:      errors.New("db connection error"),
+			expectedReused: false,
+			expectedLookup: true,
+		},
+		{
+			name: "alias always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", Alias: "mine", ReuseExisting: &reuse,
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "expiration always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", ExpiresAt: "24h", ReuseExisting: &reuse,
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "preview always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", Preview: true, ReuseExisting: &reuse,
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "passthrough always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", Passthrough: true, ReuseExisting: &reuse,
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "password always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", Password: "s3cret", ReuseExisting: &reuse,
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "click limit always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", MaxClicks: 1, ReuseExisting: &reuse,
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "variants always create",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", ReuseExisting: &reuse,
+				Variants: []domain.Variant{
+					{LongURL: "https://example.com/a", Weight: 1},
+					{LongURL: "https://example.com/b", Weight: 1},
+				},
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "targeting always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", ReuseExisting: &reuse,
+				Targeting: []domain.TargetingRule{{Match: "device", Values: []string{"mobile"}, LongURL: "https://m.example.com"}},
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+		{
+			name: "redirect status always creates",
+			request: &domain.CreateURLRequest{
+				LongURL: "https://example.com/page", RedirectStatus: http.StatusMovedPermanently, ReuseExisting: &reuse,
+			},
+			expectedReused: false,
+			expectedLookup: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			looked := false
+			mockPg := &mockPostgresRepo{
+				getByLongURLFunc: func(ctx context.Context, longURL string, ownerID *int64) (*domain.URL, error) {
+					looked = true
+					if longURL != "https://example.com/page" {
+						t.Errorf("expected normalized long URL, got %s", longURL)
+					}
+					if tt.lookupErr != nil {
+						return nil, tt.lookupErr
+					}
+					return existing, nil
+				},
+			}
+			service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080",
+				WithReuseExisting(tt.serverDefault),
+			)
+
+			result, err := service.CreateShortURL(context.Background(), tt.request)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if looked != tt.expectedLookup {
+				t.Errorf("expected lookup %v, got %v", tt.expectedLookup, looked)
+			}
+			if result.Reused != tt.expectedReused {
+				t.Errorf("expected reused %v, got %v", tt.expectedReused, result.Reused)
+			}
+			if tt.expectedReused && result.ShortCode != existing.ShortCode {
+				t.Errorf("expected short code %s, got %s", existing.ShortCode, result.ShortCode)
+			}
+			if !tt.expectedReused && result.ShortCode == existing.ShortCode {
+				t.Errorf("expected a new short code, got the existing one")
+			}
+		})
+	}
+}
+
+func TestShortenerService_CreateShortURLs_ReuseExisting(t *testing.T) {
+	inserted := 0
+	mockPg := &mockPostgresRepo{
+		getByLongURLFunc: func(ctx context.Context, longURL string, ownerID *int64) (*domain.URL, error) {
+			if longURL == "https://example.com/old" {
+				return &domain.URL{ID: 1, ShortCode: "old123", LongURL: longURL}, nil
+			}
+			return nil, repo.ErrNotFound
+		},
+		createBatchFunc: func(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error) {
+			inserted += len(urls)
+			created := make([]*domain.URL, 0, len(urls))
+			for _, url := range urls {
+				created = append(created, &domain.URL{ShortCode: url.ShortCode, LongURL: url.LongURL})
+			}
+			return created, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080",
+		WithReuseExisting(true),
+	)
+
+	response, err := service.CreateShortURLs(context.Background(), []*domain.CreateURLRequest{
+		{LongURL: "https://example.com/old"},
+		{LongURL: "https://example.com/new"},
+		{LongURL: "example.com/new"},
+		{LongURL: "https://example.com/bad", ExpiresAt: "yesterday"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if inserted != 1 {
+		t.Errorf("expected 1 inserted URL, got %d", inserted)
+	}
+	if response.Created != 1 || response.Reused != 2 || response.Failed != 1 {
+		t.Errorf("expected 1 created, 2 reused and 1 failed, got %d, %d and %d",
+			response.Created, response.Reused, response.Failed)
+	}
+	if !response.Results[0].Reused || response.Results[0].ShortCode != "old123" {
+		t.Errorf("expected the existing URL to be reused, got %+v", response.Results[0])
+	}
+	if response.Results[1].Reused || !response.Results[2].Reused ||
+		response.Results[1].ShortCode != response.Results[2].ShortCode {
+
+		t.Errorf("expected the repeated URL to share the new short code, got %+v and %+v",
+			response.Results[1].CreateURLResponse, response.Results[2].CreateURLResponse)
+	}
+}
+
+func TestShortenerService_CreateShortURLs(t *testing.T) {
+	taken := map[string]bool{"promo": true}
+	calls := 0
+	mockPg := &mockPostgresRepo{
+		createBatchFunc: func(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error) {
+			calls++
+			var created []*domain.URL
+			for _, url := range urls {
+				// The first random code of the first round collides with an existing one
+				if taken[url.ShortCode] || (calls == 1 && url.LongURL == "https://example.com/collides") {
+					continue
+				}
+				taken[url.ShortCode] = true
+				created = append(created, &domain.URL{ID: int64(len(taken)), ShortCode: url.ShortCode, LongURL: url.LongURL})
+			}
+			return created, nil
+		},
+	}
+	cached := make(map[string]bool)
+	mockRedis := &mockRedisRepo{
+		setFunc: func(ctx context.Context, shortCode string, url *domain.URL) error {
+			if url.ShortCode != shortCode || url.ID == 0 {
+				t.Errorf("expected the created row to be cached for %s, got %+v", shortCode, url)
+			}
+			cached[shortCode] = true
+			return nil
+		},
+		deleteFunc: func(ctx context.Context, shortCode string) error {
+			t.Errorf("batch creation should not evict %s from the cache", shortCode)
+			return nil
+		},
+	}
+	service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+	response, err := service.CreateShortURLs(context.Background(), []*domain.CreateURLRequest{
+		{LongURL: "example.com/first"},
+		{LongURL: "not a url"},
+		{LongURL: "https://example.com/collides"},
+		{LongURL: "https://example.com/taken", Alias: "promo"},
+		{LongURL: "https://example.com/alias", Alias: "summer"},
+		{LongURL: "https://example.com/duplicate", Alias: "summer"},
+		{LongURL: "https://example.com/past", ExpiresAt: "2020-01-01T00:00:00Z"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected 2 insert rounds, got %d", calls)
+	}
+	if response.Created != 3 || response.Failed != 4 {
+		t.Errorf("expected 3 created and 4 failed, got %d and %d", response.Created, response.Failed)
+	}
+
+	for i, result := range response.Results {
+		if result.Index != i {
+			t.Errorf("result %d: expected index %d, got %d", i, i, result.Index)
+		}
+	}
+
+	first := response.Results[0]
+	if first.CreateURLResponse == nil || first.LongURL != "https://example.com/first" {
+		t.Errorf("expected first URL to be normalized and created, got %+v", first)
+	} else if first.ShortURL != "http://localhost:8080/"+first.ShortCode {
+		t.Errorf("unexpected short URL %s", first.ShortURL)
+	}
+	if response.Results[2].CreateURLResponse == nil {
+		t.Errorf("expected colliding URL to be retried, got error %q", response.Results[2].Error)
+	}
+	if response.Results[4].CreateURLResponse == nil || response.Results[4].ShortCode != "summer" {
+		t.Errorf("expected alias 'summer' to be created, got %+v", response.Results[4])
+	}
+	if len(cached) != 3 || !cached["summer"] {
+		t.Errorf("expected the 3 created URLs to be cached, got %v", cached)
+	}
+
+	expectedErrors := map[int]string{
+		1: "invalid URL",
+		3: "alias is already taken: promo",
+		5: "alias is already taken: summer",
+		6: "invalid expiration",
+	}
+	for i, expected := range expectedErrors {
+		result := response.Results[i]
+		if result.CreateURLResponse != nil || !strings.Contains(result.Error, expected) {
+			t.Errorf("result %d: expected error containing %q, got %+v", i, expected, result)
+		}
+	}
+}
+
+func TestShortenerService_CreateShortURLs_InvalidBatch(t *testing.T) {
+	service := NewShortenerService(&mockPostgresRepo{}, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080",
+		WithMaxBatchSize(2),
+	)
+
+	tests := []struct {
+		name     string
+		requests []*domain.CreateURLRequest
+	}{
+		{
+			name:     "empty batch",
+			requests: nil,
+		},
+		{
+			name: "too many URLs",
+			requests: []*domain.CreateURLRequest{
+				{LongURL: "https://example.com/1"},
+				{LongURL: "https://example.com/2"},
+				{LongURL: "https://example.com/3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreateShortURLs(context.Background(), tt.requests)
+			if !errors.Is(err, ErrInvalidBatch) {
+				t.Errorf("expected ErrInvalidBatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestShortenerService_CreateShortURLs_TooManyPasswords(t *testing.T) {
+	mockPg := &mockPostgresRepo{
+		createBatchFunc: func(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error) {
+			t.Error("expected the batch to be refused before inserting")
+			return nil, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+	requests := make([]*domain.CreateURLRequest, maxBatchPasswords+2)
+	for i := range requests {
+		requests[i] = &domain.CreateURLRequest{LongURL: fmt.Sprintf("https://example.com/%d", i), Password: "secret"}
+	}
+	requests[0].Password = ""
+
+	_, err := service.CreateShortURLs(context.Background(), requests)
+	if !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("expected ErrInvalidBatch, got %v", err)
+	}
+}
+
+func TestShortenerService_CreateShortURLs_GivesUpAfterMaxRetries(t *testing.T) {
+	calls := 0
+	mockPg := &mockPostgresRepo{
+		createBatchFunc: func(ctx context.Context, urls []*domain.URL) ([]*domain.URL, error) {
+			calls++
+			return nil, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+	response, err := service.CreateShortURLs(context.Background(), []*domain.CreateURLRequest{
+		{LongURL: "https://example.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls != service.maxRetries {
+		t.Errorf("expected %d insert rounds, got %d", service.maxRetries, calls)
+	}
+	if response.Failed != 1 || !strings.Contains(response.Results[0].Error, "max retries") {
+		t.Errorf("expected the item to fail after max retries, got %+v", response.Results[0])
+	}
+}
+
+func TestShortenerService_Ownership(t *testing.T) {
+	ownerID := int64(7)
+	otherID := int64(8)
+	urls := map[string]*domain.URL{
+		"mine":   {ID: 1, ShortCode: "mine", LongURL: "https://example.com/mine", OwnerID: &ownerID},
+		"theirs": {ID: 2, ShortCode: "theirs", LongURL: "https://example.com/theirs", OwnerID: &otherID},
+		"legacy": {ID: 3, ShortCode: "legacy", LongURL: "https://example.com/legacy"},
+	}
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		shortCode string
+		allowed   bool
+	}{
+		{name: "owner manages own URL", ctx: WithOwner(context.Background(), ownerID), shortCode: "mine", allowed: true},
+		{name: "owner can't manage other URL", ctx: WithOwner(context.Background(), ownerID), shortCode: "theirs"},
+		{name: "owner can't manage URL without owner", ctx: WithOwner(context.Background(), ownerID), shortCode: "legacy"},
+		{name: "internal caller manages any URL", ctx: context.Background(), shortCode: "theirs", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutated := 0
+			mockPg := &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return urls[shortCode], nil
+				},
+				deleteFunc: func(ctx context.Context, shortCode string) error {
+					mutated++
+					return nil
+				},
+				setDisabledFunc: func(ctx context.Context, shortCode string, disabled bool) (*domain.URL, error) {
+					mutated++
+					return urls[shortCode], nil
+				},
+				updateLongURLFunc: func(ctx context.Context, shortCode, longURL string) (*domain.URL, error) {
+					mutated++
+					return urls[shortCode], nil
+				},
+			}
+			service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+			_, statsErr := service.GetURLStats(tt.ctx, tt.shortCode, nil)
+			_, updateErr := service.UpdateLongURL(tt.ctx, tt.shortCode, "https://example.com/new")
+			_, enableErr := service.SetURLEnabled(tt.ctx, tt.shortCode, false)
+			deleteErr := service.DeleteURL(tt.ctx, tt.shortCode)
+
+			for name, err := range map[string]error{"update": updateErr, "enable": enableErr, "delete": deleteErr} {
+				if tt.allowed && err != nil {
+					t.Errorf("%s: unexpected error: %v", name, err)
+				}
+				if !tt.allowed && !errors.Is(err, repo.ErrNotFound) {
+					t.Errorf("%s: expected not found error, got %v", name, err)
+				}
+			}
+			if tt.allowed != (statsErr == nil) {
+				t.Errorf("stats: expected allowed %v, got error %v", tt.allowed, statsErr)
+			}
+			if !tt.allowed && mutated != 0 {
+				t.Errorf("expected no changes to another owner's URL, got %d", mutated)
+			}
+		})
+	}
+}
+
+func TestShortenerService_CreateShortURL_AssignsOwner(t *testing.T) {
+	var stored *domain.URL
+	var reuseOwner *int64
+	mockPg := &mockPostgresRepo{
+		createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+			stored = url
+			return url, nil
+		},
+		getByLongURLFunc: func(ctx context.Context, longURL string, ownerID *int64) (*domain.URL, error) {
+			reuseOwner = ownerID
+			return nil, repo.ErrNotFound
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080",
+		WithReuseExisting(true),
+	)
+
+	ctx := WithOwner(context.Background(), 42)
+	if _, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stored.OwnerID == nil || *stored.OwnerID != 42 {
+		t.Errorf("expected URL to be owned by 42, got %v", stored.OwnerID)
+	}
+	if reuseOwner == nil || *reuseOwner != 42 {
+		t.Errorf("expected reuse lookup to be scoped to owner 42, got %v", reuseOwner)
+	}
+}
+
+func TestShortenerService_GetLongURL(t *testing.T) {
+	tests := []struct {
+		name          string
+		shortCode     string
+		mockPg        *mockPostgresRepo
+		mockRedis     *mockRedisRepo
+		expectedURL   string
+		expectedError string
+	}{
+		{
+			name:      "cache hit",
+			shortCode: "abc123",
+			mockRedis: &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{LongURL: "https://example.com"}, nil
+				},
+			},
+			mockPg:      &mockPostgresRepo{},
+			expectedURL: "https://example.com",
+		},
+		{
+			name:      "cache miss - db hit",
+			shortCode: "xyz789",
+			mockRedis: &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, repo.ErrNotFound
+				},
+			},
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{LongURL: "https://example.com"}, nil
+				},
+			},
+			expectedURL: "https://example.com",
+		},
+		{
+			name:      "expired link in cache",
+			shortCode: "abc123",
+			mockRedis: &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					expiresAt := time.Now().Add(-time.Minute)
+					return &domain.URL{LongURL: "https://example.com", ExpiresAt: &expiresAt}, nil
+				},
+			},
+			mockPg:        &mockPostgresRepo{},
+			expectedError: "short URL has expired",
+		},
+		{
+			name:      "expired link in db",
+			shortCode: "abc123",
+			mockRedis: &mockRedisRepo{},
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					expiresAt := time.Now().Add(-time.Minute)
+					return &domain.URL{LongURL: "https://example.com", ExpiresAt: &expiresAt}, nil
+				},
+			},
+			expectedError: "short URL has expired",
+		},
+		{
+			name:      "link not yet expired",
+			shortCode: "abc123",
+			mockRedis: &mockRedisRepo{},
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					expiresAt := time.Now().Add(time.Hour)
+					return &domain.URL{LongURL: "https://example.com", ExpiresAt: &expiresAt}, nil
+				},
+			},
+			expectedURL: "https://example.com",
+		},
+		{
+			name:      "disabled link",
+			shortCode: "abc123",
+			mockRedis: &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					disabledAt := time.Now()
+					return &domain.URL{LongURL: "https://example.com", DisabledAt: &disabledAt}, nil
+				},
+			},
+			mockPg:        &mockPostgresRepo{},
+			expectedError: "short URL has been disabled",
+		},
+		{
+			name:          "invalid short code format",
+			shortCode:     "invalid@code!",
+			mockRedis:     &mockRedisRepo{},
+			mockPg:        &mockPostgresRepo{},
+			expectedError: "invalid short code format",
+		},
+		{
+			name:      "short code not found in db",
+			shortCode: "notfound",
+			mockRedis: &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, repo.ErrNotFound
+				},
+			},
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, repo.ErrNotFound
+				},
+			},
+			expectedError: "short URL not found",
+		},
+		{
+			name:      "database error",
+			shortCode: "abc123",
+			mockRedis: &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, repo.ErrNotFound
+				},
+			},
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, errors.New("db connection error")
+				},
+			},
+			expectedError: "error retrieving long URL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := shortid.NewGenerator()
+			service := NewShortenerService(tt.mockPg, tt.mockRedis, generator, "http://localhost:8080")
+
+			url, err := service.GetLongURL(context.Background(), tt.shortCode, nil)
+
+			if tt.expectedError != "" {
+				if err == nil {
+					t.Errorf("expected error containing '%s', got nil", tt.expectedError)
+					return
+				}
+				if !contains(err.Error(), tt.expectedError) {
+					t.Errorf("expected error '%s', got '%s'", tt.expectedError, err.Error())
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			if url.LongURL != tt.expectedURL {
+				t.Errorf("expected '%s', got '%s'", tt.expectedURL, url.LongURL)
+			}
+		})
+	}
+}
+
+func TestShortenerService_TypedErrors(t *testing.T) {
+	dbErr := errors.New("db connection error")
+	tests := []struct {
+		name        string
+		shortCode   string
+		pgErr       error
+		expectedErr error
+	}{
+		{
+			name:        "invalid short code",
+			shortCode:   "invalid@code!",
+			expectedErr: ErrInvalidShortCode,
+		},
+		{
+			name:        "not found",
+			shortCode:   "abc123",
+			pgErr:       repo.ErrNotFound,
+			expectedErr: ErrNotFound,
+		},
+		{
+			name:        "database outage",
+			shortCode:   "abc123",
+			pgErr:       dbErr,
+			expectedErr: ErrUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPg := &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, tt.pgErr
+				},
+				deleteFunc: func(ctx context.Context, shortCode string) error {
+					return tt.pgErr
+				},
+			}
+			mockRedis := &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, repo.ErrNotFound
+				},
+			}
+			service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+			defer service.Close(context.Background())
+
+			_, getErr := service.GetLongURL(context.Background(), tt.shortCode, nil)
+			_, statsErr := service.GetURLStats(context.Background(), tt.shortCode, nil)
+			deleteErr := service.DeleteURL(context.Background(), tt.shortCode)
+
+			for operation, err := range map[string]error{"GetLongURL": getErr, "GetURLStats": statsErr,
+				"DeleteURL": deleteErr} {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("%s: expected error wrapping '%v', got '%v'", operation, tt.expectedErr, err)
+				}
+				if errors.Is(err, dbErr) {
+					t.Errorf("%s: expected the database error not to be exposed, got '%v'", operation, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewShortenerService_GetLongURL_CacheMiss(t *testing.T) {
+	mockPg := &mockPostgresRepo{
+		getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			return &domain.URL{
+				ID:        1,
+				ShortCode: shortCode,
+				LongURL:   "https://example.com",
+				CreatedAt: time.Now(),
+				Clicks:    5,
+			}, nil
+		},
+	}
+	mockRedis := &mockRedisRepo{
+		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			return nil, repo.ErrNotFound
+		},
+	}
+	generator := shortid.NewGenerator()
+	service := NewShortenerService(mockPg, mockRedis, generator, "http://localhost:8080")
+
+	url, err := service.GetLongURL(context.Background(), "xyz789", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if url.LongURL != "https://example.com" {
+		t.Errorf("expected long URL 'https://example.com', got '%s'", url.LongURL)
+	}
+}
+
+func TestShortenerService_GetLongURL_NotFoundCache(t *testing.T) {
+	queries := 0
+	mockPg := &mockPostgresRepo{
+		getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			queries++
+			return nil, repo.ErrNotFound
+		},
+	}
+	cached := make(map[string]time.Duration)
+	mockRedis := &mockRedisRepo{
+		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			if _, ok := cached[shortCode]; ok {
+				return nil, repo.ErrCachedNotFound
+			}
+			return nil, repo.ErrNotFound
+		},
+		setNotFoundFunc: func(ctx context.Context, shortCode string, ttl time.Duration) error {
+			cached[shortCode] = ttl
+			return nil
+		},
+	}
+	service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080",
+		WithNotFoundTTL(time.Minute),
+	)
+	defer service.Close(context.Background())
+
+	for i := 0; i < 3; i++ {
+		if _, err := service.GetLongURL(context.Background(), "abc123", nil); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+
+	if queries != 1 {
+		t.Errorf("expected a single database query for an unknown short code, got %d", queries)
+	}
+	if cached["abc123"] != time.Minute {
+		t.Errorf("expected the short code to be cached as not found for 1m, got %v", cached["abc123"])
+	}
+}
+
+func TestShortenerService_GetLongURL_CoalescesCacheMisses(t *testing.T) {
+	var queries atomic.Int32
+	release := make(chan struct{})
+	mockPg := &mockPostgresRepo{
+		getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			queries.Add(1)
+			<-release
+			return &domain.URL{ID: 1, ShortCode: shortCode, LongURL: "https://example.com"}, nil
+		},
+	}
+	var sets atomic.Int32
+	mockRedis := &mockRedisRepo{
+		setFunc: func(ctx context.Context, shortCode string, url *domain.URL) error {
+			t.Error("expected the cache miss to be filled without replacing a newer entry")
+			return nil
+		},
+		addFunc: func(ctx context.Context, shortCode string, url *domain.URL) error {
+			sets.Add(1)
+			return nil
+		},
+	}
+	service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+	defer service.Close(context.Background())
+
+	const lookups = 20
+	urls := make(chan *domain.URL, lookups)
+	var wg sync.WaitGroup
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url, err := service.GetLongURL(context.Background(), "abc123", nil)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			urls <- url
+		}()
+	}
+
+	// A lookup whose caller gives up doesn't cancel the query the others wait for
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := service.GetLongURL(ctx, "abc123", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled lookup to stop waiting, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(urls)
+
+	if queries.Load() != 1 || sets.Load() != 1 {
+		t.Errorf("expected a single query and cache write, got %d and %d", queries.Load(), sets.Load())
+	}
+	seen := make(map[*domain.URL]bool)
+	for url := range urls {
+		if url == nil || seen[url] {
+			t.Fatal("expected every lookup to get its own copy of the URL")
+		}
+		seen[url] = true
+	}
+}
+
+func TestShortenerService_GetLongURL_EarlyRefresh(t *testing.T) {
+	tests := []struct {
+		name              string
+		options           []Option
+		ttl               time.Duration
+		expectedRefreshes int
+	}{
+		{name: "disabled", ttl: time.Nanosecond, expectedRefreshes: 0},
+		{name: "entry about to expire", options: []Option{WithEarlyRefresh(time.Hour)}, ttl: time.Nanosecond,
+			expectedRefreshes: 1},
+		{name: "unknown TTL", options: []Option{WithEarlyRefresh(time.Hour)}, ttl: 0, expectedRefreshes: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries atomic.Int32
+			mockPg := &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					queries.Add(1)
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com/fresh"}, nil
+				},
+			}
+			var refreshed atomic.Pointer[domain.URL]
+			mockRedis := &mockRedisRepo{
+				getWithTTLFunc: func(ctx context.Context, shortCode string) (*domain.URL, time.Duration, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com/cached"}, tt.ttl, nil
+				},
+				replaceFunc: func(ctx context.Context, shortCode string, previous, url *domain.URL) error {
+					if previous.LongURL != "https://example.com/cached" {
+						t.Errorf("expected the entry to be replaced only if unchanged, got %+v", previous)
+					}
+					refreshed.Store(url)
+					return nil
+				},
+			}
+			service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080",
+				tt.options...)
+
+			url, err := service.GetLongURL(context.Background(), "abc123", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			service.Close(context.Background())
+
+			if url.LongURL != "https://example.com/cached" {
+				t.Errorf("expected the cached URL to be served, got '%s'", url.LongURL)
+			}
+			if int(queries.Load()) != tt.expectedRefreshes {
+				t.Errorf("expected %d refreshes, got %d", tt.expectedRefreshes, queries.Load())
+			}
+			if tt.expectedRefreshes == 0 {
+				return
+			}
+			if entry := refreshed.Load(); entry == nil || entry.LongURL != "https://example.com/fresh" {
+				t.Errorf("expected the cache entry to be replaced, got %+v", entry)
+			}
+		})
+	}
+}
+
+func TestShortenerService_GetURLPreview(t *testing.T) {
+	var clicks int
+	mockPg := &mockPostgresRepo{
+		incrementClicksFunc: func(ctx context.Context, deltas map[string]int64) error {
+			for _, delta := range deltas {
+				clicks += int(delta)
+			}
+			return nil
+		},
+	}
+	mockRedis := &mockRedisRepo{
+		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			if shortCode == "gone" {
+				disabledAt := time.Now()
+				return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", DisabledAt: &disabledAt}, nil
+			}
+			return &domain.URL{ID: 1, ShortCode: shortCode, LongURL: "https://example.com/page", Preview: true}, nil
+		},
+	}
+	service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+	url, err := service.GetURLPreview(context.Background(), "abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if url.LongURL != "https://example.com/page" || !url.Preview {
+		t.Errorf("expected the preview URL, got %+v", url)
+	}
+
+	if _, err := service.GetURLPreview(context.Background(), "gone"); !errors.Is(err, ErrLinkDisabled) {
+		t.Errorf("expected ErrLinkDisabled, got %v", err)
+	}
+	if _, err := service.GetURLPreview(context.Background(), "invalid@code!"); err == nil {
+		t.Error("expected an error for an invalid short code")
+	}
+
+	service.Close(context.Background())
+	if clicks != 0 {
+		t.Errorf("expected previews not to record clicks, got %d", clicks)
+	}
+}
+
+func TestShortenerService_GetLongURL_RedirectStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		serverDefault  int
+		linkStatus     int
+		expectedStatus int
+	}{
+		{
+			name:           "built-in default",
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "server default",
+			serverDefault:  http.StatusMovedPermanently,
+			expectedStatus: http.StatusMovedPermanently,
+		},
+		{
+			name:           "unsupported server default is ignored",
+			serverDefault:  http.StatusSeeOther,
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "link overrides server default",
+			serverDefault:  http.StatusMovedPermanently,
+			linkStatus:     http.StatusTemporaryRedirect,
+			expectedStatus: http.StatusTemporaryRedirect,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRedis := &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", RedirectStatus: tt.linkStatus}, nil
+				},
+			}
+			service := NewShortenerService(&mockPostgresRepo{}, mockRedis, shortid.NewGenerator(), "http://localhost:8080",
+				WithRedirectStatus(tt.serverDefault),
+			)
+
+			url, err := service.GetLongURL(context.Background(), "abc123", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if url.RedirectStatus != tt.expectedStatus {
+				t.Errorf("expected redirect status %d, got %d", tt.expectedStatus, url.RedirectStatus)
+			}
+		})
+	}
+}
+
+func TestShortenerService_GetLongURL_Passthrough(t *testing.T) {
+	tests := []struct {
+		name            string
+		longURL         string
+		passthrough     bool
+		visit           *domain.Visit
+		expectedLongURL string
+		expectError     bool
+	}{
+		{
+			name:            "query string is appended",
+			longURL:         "https://example.com/page",
+			passthrough:     true,
+			visit:           &domain.Visit{RawQuery: "utm_source=x"},
+			expectedLongURL: "https://example.com/page?utm_source=x",
+		},
+		{
+			name:            "stored parameters win over incoming ones",
+			longURL:         "https://example.com/page?ref=owner#top",
+			passthrough:     true,
+			visit:           &domain.Visit{RawQuery: "ref=visitor&lang=es"},
+			expectedLongURL: "https://example.com/page?ref=owner&lang=es#top",
+		},
+		{
+			name:            "trailing path is appended",
+			longURL:         "https://example.com/docs/?v=2",
+			passthrough:     true,
+			visit:           &domain.Visit{Path: "guides/intro%20page", RawQuery: "q=1"},
+			expectedLongURL: "https://example.com/docs/guides/intro%20page?v=2&q=1",
+		},
+		{
+			name:            "dot segments are dropped",
+			longURL:         "https://example.com/docs",
+			passthrough:     true,
+			visit:           &domain.Visit{Path: "../admin", RawQuery: "q=1"},
+			expectedLongURL: "https://example.com/docs?q=1",
+		},
+		{
+			name:            "query string is ignored without passthrough",
+			longURL:         "https://example.com/page",
+			visit:           &domain.Visit{RawQuery: "utm_source=x"},
+			expectedLongURL: "https://example.com/page",
+		},
+		{
+			name:        "trailing path is rejected without passthrough",
+			longURL:     "https://example.com/page",
+			visit:       &domain.Visit{Path: "extra"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRedis := &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: tt.longURL, Passthrough: tt.passthrough}, nil
+				},
+			}
+			service := NewShortenerService(&mockPostgresRepo{}, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+			url, err := service.GetLongURL(context.Background(), "abc123", tt.visit)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if url.LongURL != tt.expectedLongURL {
+				t.Errorf("expected long URL '%s', got '%s'", tt.expectedLongURL, url.LongURL)
+			}
+		})
+	}
+}
+
+func TestShortenerService_PasswordProtected(t *testing.T) {
+	var stored *domain.URL
+	var deltas map[string]int64
+	mockPg := &mockPostgresRepo{
+		createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+			stored = url
+			return url, nil
+		},
+		incrementClicksFunc: func(ctx context.Context, batch map[string]int64) error {
+			deltas = batch
+			return nil
+		},
+	}
+	mockRedis := &mockRedisRepo{
+		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			url := *stored
+			return &url, nil
+		},
+	}
+	service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080",
+		WithPasswordAttempts(2, time.Hour),
+	)
+	ctx := context.Background()
+
+	_, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{LongURL: "https://example.com/doc", Password: "abc"})
+	if !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got %v", err)
+	}
+
+	response, err := service.CreateShortURL(ctx, &domain.CreateURLRequest{
+		LongURL:  "https://example.com/doc",
+		Password: "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !stored.IsProtected() || stored.PasswordHash == "s3cret" {
+		t.Fatalf("expected only a hash of the password to be stored, got '%s'", stored.PasswordHash)
+	}
+	shortCode := response.ShortCode
+
+	if _, err := service.GetLongURL(ctx, shortCode, nil); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected ErrPasswordRequired from GetLongURL, got %v", err)
+	}
+	if _, err := service.GetURLPreview(ctx, shortCode); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected ErrPasswordRequired from GetURLPreview, got %v", err)
+	}
+
+	url, err := service.UnlockURL(ctx, shortCode, "s3cret", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if url.LongURL != "https://example.com/doc" || url.RedirectStatus != http.StatusFound {
+		t.Errorf("expected the destination with the default status, got %+v", url)
+	}
+
+	guesser := &domain.Visit{ClientIP: "203.0.113.7"}
+	for i := 0; i < 2; i++ {
+		if _, err := service.UnlockURL(ctx, shortCode, "guess", guesser); !errors.Is(err, ErrWrongPassword) {
+			t.Errorf("expected ErrWrongPassword, got %v", err)
+		}
+	}
+	if _, err := service.UnlockURL(ctx, shortCode, "s3cret", guesser); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("expected ErrTooManyAttempts once the limit is reached, got %v", err)
+	}
+	if _, err := service.UnlockURL(ctx, shortCode, "s3cret", &domain.Visit{ClientIP: "198.51.100.2"}); err != nil {
+		t.Errorf("expected other clients not to be locked out, got %v", err)
+	}
+
+	service.Close(ctx)
+	if deltas[shortCode] != 2 {
+		t.Errorf("expected only the unlocked visits to count as clicks, got %v", deltas)
+	}
+}
+
+func TestShortenerService_GetLongURL_MaxClicks(t *testing.T) {
+	tests := []struct {
+		name              string
+		url               *domain.URL
+		visit             *domain.Visit
+		incrementErr      error
+		expectedErr       error
+		expectedIncrement bool
+		expectedDelta     int64
+	}{
+		{
+			name:          "unlimited link is aggregated",
+			url:           &domain.URL{Clicks: 10},
+			expectedDelta: 1,
+		},
+		{
+			name:              "limited link is counted right away",
+			url:               &domain.URL{Clicks: 0, MaxClicks: 1},
+			expectedIncrement: true,
+		},
+		{
+			name:              "limit reached in the database",
+			url:               &domain.URL{Clicks: 0, MaxClicks: 1},
+			incrementErr:      repo.ErrLimitReached,
+			expectedErr:       ErrClickLimitReached,
+			expectedIncrement: true,
+		},
+		{
+			name:              "database failure",
+			url:               &domain.URL{Clicks: 0, MaxClicks: 1},
+			incrementErr:      errors.New("db connection error"),
+			expectedErr:       fmt.Errorf("error recording click: %w", ErrUnavailable),
+			expectedIncrement: true,
+		},
+		{
+			name:        "known to be exhausted",
+			url:         &domain.URL{Clicks: 3, MaxClicks: 3},
+			expectedErr: ErrClickLimitReached,
+		},
+		{
+			name:  "probe of a limited link isn't counted",
+			url:   &domain.URL{Clicks: 0, MaxClicks: 1},
+			visit: &domain.Visit{Probe: true},
+		},
+		{
+			name:  "probe of an unlimited link isn't aggregated",
+			url:   &domain.URL{Clicks: 10},
+			visit: &domain.Visit{Probe: true},
+		},
+		{
+			name:        "probe of an exhausted link",
+			url:         &domain.URL{Clicks: 3, MaxClicks: 3},
+			visit:       &domain.Visit{Probe: true},
+			expectedErr: ErrClickLimitReached,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var incremented bool
+			var deltas map[string]int64
+			mockPg := &mockPostgresRepo{
+				incrementWithinLimitFunc: func(ctx context.Context, shortCode string) error {
+					incremented = true
+					return tt.incrementErr
+				},
+				incrementClicksFunc: func(ctx context.Context, batch map[string]int64) error {
+					deltas = batch
+					return nil
+				},
+			}
+			mockRedis := &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					url := *tt.url
+					url.ShortCode, url.LongURL = shortCode, "https://example.com/download"
+					return &url, nil
+				},
+			}
+			service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+			_, err := service.GetLongURL(context.Background(), "abc123", tt.visit)
+			service.Close(context.Background())
+
+			if tt.expectedErr != nil {
+				if err == nil || err.Error() != tt.expectedErr.Error() {
+					t.Errorf("expected error '%v', got %v", tt.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if incremented != tt.expectedIncrement {
+				t.Errorf("expected atomic increment %v, got %v", tt.expectedIncrement, incremented)
+			}
+			if deltas["abc123"] != tt.expectedDelta {
+				t.Errorf("expected an aggregated delta of %d, got %v", tt.expectedDelta, deltas)
+			}
+		})
+	}
+}
+
+func TestShortenerService_CreateShortURL_MaxClicks(t *testing.T) {
+	var stored *domain.URL
+	mockPg := &mockPostgresRepo{
+		createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+			stored = url
+			return url, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+	_, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+		LongURL:   "https://example.com/download",
+		MaxClicks: 1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored == nil || stored.MaxClicks != 1 {
+		t.Errorf("expected a click limit of 1 to be stored, got %+v", stored)
+	}
+
+	_, err = service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+		LongURL:   "https://example.com/download",
+		MaxClicks: -1,
+	})
+	if !errors.Is(err, ErrInvalidMaxClicks) {
+		t.Errorf("expected ErrInvalidMaxClicks, got %v", err)
+	}
+}
+
+func TestShortenerService_CreateShortURL_RedirectStatus(t *testing.T) {
+	var stored *domain.URL
+	mockPg := &mockPostgresRepo{
+		createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+			stored = url
+			return url, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+	_, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+		LongURL:        "https://example.com/seo",
+		RedirectStatus: http.StatusPermanentRedirect,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored == nil || stored.RedirectStatus != http.StatusPermanentRedirect {
+		t.Errorf("expected redirect status 308 to be stored, got %+v", stored)
+	}
+
+	_, err = service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+		LongURL:        "https://example.com/seo",
+		RedirectStatus: http.StatusSeeOther,
+	})
+	if !errors.Is(err, ErrInvalidRedirect) {
+		t.Errorf("expected ErrInvalidRedirect, got %v", err)
+	}
+}
+
+func TestShortenerService_CreateShortURL_UTM(t *testing.T) {
+	tests := []struct {
+		name            string
+		longURL         string
+		utm             *domain.UTMParams
+		expectedLongURL string
+	}{
+		{
+			name:            "no UTM parameters",
+			longURL:         "example.com/page?a=1",
+			expectedLongURL: "https://example.com/page?a=1",
+		},
+		{
+			name:            "empty UTM parameters",
+			longURL:         "example.com/page",
+			utm:             &domain.UTMParams{Source: "  "},
+			expectedLongURL: "https://example.com/page",
+		},
+		{
+			name:    "added after normalization in a fixed order",
+			longURL: "example.com/page",
+			utm: &domain.UTMParams{
+				Content: "banner", Campaign: "spring sale", Medium: "email", Source: "newsletter", Term: "shoes",
+			},
+			expectedLongURL: "https://example.com/page?utm_source=newsletter&utm_medium=email" +
+				"&utm_campaign=spring+sale&utm_term=shoes&utm_content=banner",
+		},
+		{
+			name:            "existing parameters are kept in order",
+			longURL:         "https://example.com/page?z=1&a=2#section",
+			utm:             &domain.UTMParams{Source: "newsletter"},
+			expectedLongURL: "https://example.com/page?z=1&a=2&utm_source=newsletter#section",
+		},
+		{
+			name:            "request replaces duplicate UTM parameters",
+			longURL:         "https://example.com/page?utm_source=old&id=7&utm_source=older&utm_medium=web",
+			utm:             &domain.UTMParams{Source: "newsletter"},
+			expectedLongURL: "https://example.com/page?id=7&utm_medium=web&utm_source=newsletter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored *domain.URL
+			mockPg := &mockPostgresRepo{
+				createFunc: func(ctx context.Context, url *domain.URL) (*domain.URL, error) {
+					stored = url
+					return url, nil
+				},
+			}
+			service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+			response, err := service.CreateShortURL(context.Background(), &domain.CreateURLRequest{
+				LongURL: tt.longURL,
+				UTM:     tt.utm,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stored == nil || stored.LongURL != tt.expectedLongURL {
+				t.Errorf("expected long URL '%s' to be stored, got %+v", tt.expectedLongURL, stored)
+			}
+			if response.LongURL != tt.expectedLongURL {
+				t.Errorf("expected long URL '%s' in the response, got '%s'", tt.expectedLongURL, response.LongURL)
+			}
+		})
+	}
+}
+
+func TestShortenerService_GetURLStats(t *testing.T) {
+	tests := []struct {
+		name          string
+		shortCode     string
+		mockPg        *mockPostgresRepo
+		expectedStats *domain.StatsResponse
+		expectedError string
+	}{
+		{
+			name:      "success",
+			shortCode: "abc123",
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{
+						ShortCode: shortCode,
+						LongURL:   "https://example.com",
+						Clicks:    42,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					}, nil
+				},
+			},
+			expectedStats: &domain.StatsResponse{
+				ShortCode: "abc123",
+				LongURL:   "https://example.com",
+				Clicks:    42,
+				CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:          "invalid short code",
+			shortCode:     "invalid@",
+			mockPg:        &mockPostgresRepo{},
+			expectedError: "invalid short code format",
+		},
+		{
+			name:      "not found",
+			shortCode: "notfound",
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, repo.ErrNotFound
+				},
+			},
+			expectedError: "short URL not found",
+		},
+		{
+			name:      "database error",
+			shortCode: "abc123",
+			mockPg: &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return nil, errors.New("db error")
+				},
+			},
+			expectedError: "error retrieving URL stats",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := shortid.NewGenerator()
+			service := NewShortenerService(tt.mockPg, &mockRedisRepo{}, generator, "http://localhost:8080")
+
+			stats, err := service.GetURLStats(context.Background(), tt.shortCode, nil)
+
+			if tt.expectedError != "" {
+				if err == nil || !contains(err.Error(), tt.expectedError) {
+					t.Errorf("expected error '%s', got %v", tt.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if stats.Clicks != tt.expectedStats.Clicks {
+				t.Errorf("expected %d clicks, got %d", tt.expectedStats.Clicks, stats.Clicks)
+			}
+		})
+	}
+}
+
+func TestShortenerService_DeleteURL(t *testing.T) {
+	tests := []struct {
+		name          string
+		shortCode     string
+		mockPg        *mockPostgresRepo
+		expectedErr   error
+		expectDeleted bool
+	}{
+		{
+			name:          "success",
+			shortCode:     "abc123",
+			mockPg:        &mockPostgresRepo{},
+			expectDeleted: true,
+		},
+		{
+			name:        "invalid short code",
+			shortCode:   "invalid@",
+			mockPg:      &mockPostgresRepo{},
+			expectedErr: repo.ErrInvalidShortCode,
+		},
+		{
+			name:      "not found",
+			shortCode: "notfound",
+			mockPg: &mockPostgresRepo{
+				deleteFunc: func(ctx context.Context, shortCode string) error {
+					return repo.ErrNotFound
 				},
 			},
-			expectedStats: &domain.StatsResponse{
-				ShortCode: "abc123",
-				LongURL:   "https://example.com",
-				Clicks:    42,
-				CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-			},
+			expectedErr: repo.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := false
+			mockRedis := &mockRedisRepo{
+				setDeletedFunc: func(ctx context.Context, shortCode string, ttl time.Duration) error {
+					deleted = shortCode == tt.shortCode && ttl == defaultNotFoundTTL
+					return nil
+				},
+			}
+			service := NewShortenerService(tt.mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+			err := service.DeleteURL(context.Background(), tt.shortCode)
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error '%v', got '%v'", tt.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if deleted != tt.expectDeleted {
+				t.Errorf("expected the short code cached as deleted %v, got %v", tt.expectDeleted, deleted)
+			}
+		})
+	}
+}
+
+func TestShortenerService_SetURLEnabled(t *testing.T) {
+	tests := []struct {
+		name        string
+		shortCode   string
+		enabled     bool
+		mockPg      *mockPostgresRepo
+		expectedErr error
+	}{
+		{
+			name:      "disable",
+			shortCode: "abc123",
+			enabled:   false,
+			mockPg:    &mockPostgresRepo{},
 		},
 		{
-			name:          "invalid short code",
-			shortCode:     "invalid@",
-			mockPg:        &mockPostgresRepo{},
-			expectedError: "invalid short code format",
+			name:      "enable",
+			shortCode: "abc123",
+			enabled:   true,
+			mockPg:    &mockPostgresRepo{},
 		},
 		{
 			name:      "not found",
 			shortCode: "notfound",
 			mockPg: &mockPostgresRepo{
-				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+				setDisabledFunc: func(ctx context.Context, shortCode string, disabled bool) (*domain.URL, error) {
 					return nil, repo.ErrNotFound
 				},
 			},
-			expectedError: "short URL not found",
+			expectedErr: repo.ErrNotFound,
 		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cached *domain.URL
+			mockRedis := &mockRedisRepo{
+				setFunc: func(ctx context.Context, shortCode string, url *domain.URL) error {
+					cached = url
+					return nil
+				},
+			}
+			service := NewShortenerService(tt.mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+			stats, err := service.SetURLEnabled(context.Background(), tt.shortCode, tt.enabled)
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error '%v', got '%v'", tt.expectedErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if stats.Enabled != tt.enabled {
+				t.Errorf("expected enabled %v, got %v", tt.enabled, stats.Enabled)
+			}
+			if cached == nil || cached.IsDisabled() == tt.enabled {
+				t.Errorf("expected the updated URL to replace the cache entry, got %+v", cached)
+			}
+		})
+	}
+}
+
+func TestShortenerService_UpdateLongURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		shortCode   string
+		longURL     string
+		mockPg      *mockPostgresRepo
+		expectedURL string
+		expectedErr error
+	}{
 		{
-			name:      "database error",
+			name:      "success with history",
 			shortCode: "abc123",
+			longURL:   "https://example.com/new",
 			mockPg: &mockPostgresRepo{
-				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
-					return nil, errors.New("db error")
+				getHistoryFunc: func(ctx context.Context, urlID int64) ([]domain.DestinationChange, error) {
+					return []domain.DestinationChange{{
+						PreviousLongURL: "https://example.com/old",
+						NewLongURL:      "https://example.com/new",
+						ChangedAt:       time.Now(),
+					}}, nil
 				},
 			},
-			expectedError: "error retrieving URL stats",
+			expectedURL: "https://example.com/new",
+		},
+		{
+			name:        "url is normalized",
+			shortCode:   "abc123",
+			longURL:     "  example.com/new  ",
+			mockPg:      &mockPostgresRepo{},
+			expectedURL: "https://example.com/new",
+		},
+		{
+			name:        "invalid url",
+			shortCode:   "abc123",
+			longURL:     "not a url",
+			mockPg:      &mockPostgresRepo{},
+			expectedErr: ErrInvalidURL,
+		},
+		{
+			name:        "invalid short code",
+			shortCode:   "invalid@",
+			longURL:     "https://example.com/new",
+			mockPg:      &mockPostgresRepo{},
+			expectedErr: repo.ErrInvalidShortCode,
+		},
+		{
+			name:      "not found",
+			shortCode: "notfound",
+			longURL:   "https://example.com/new",
+			mockPg: &mockPostgresRepo{
+				updateLongURLFunc: func(ctx context.Context, shortCode, longURL string) (*domain.URL, error) {
+					return nil, repo.ErrNotFound
+				},
+			},
+			expectedErr: repo.ErrNotFound,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			generator := shortid.NewGenerator()
-			service := NewShortenerService(tt.mockPg, &mockRedisRepo{}, generator, "http://localhost:8080")
+			var cached *domain.URL
+			mockRedis := &mockRedisRepo{
+				setFunc: func(ctx context.Context, shortCode string, url *domain.URL) error {
+					cached = url
+					return nil
+				},
+			}
+			service := NewShortenerService(tt.mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
 
-			stats, err := service.GetURLStats(context.Background(), tt.shortCode)
+			stats, err := service.UpdateLongURL(context.Background(), tt.shortCode, tt.longURL)
 
-			if tt.expectedError != "" {
-				if err == nil || !contains(err.Error(), tt.expectedError) {
-					t.Errorf("expected error '%s', got %v", tt.expectedError, err)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error '%v', got '%v'", tt.expectedErr, err)
+				}
+				if cached != nil {
+					t.Errorf("expected cache entry to be kept on error")
 				}
 				return
 			}
 
 			if err != nil {
 				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if stats.LongURL != tt.expectedURL {
+				t.Errorf("expected long URL '%s', got '%s'", tt.expectedURL, stats.LongURL)
 			}
+			if cached == nil || cached.LongURL != tt.expectedURL {
+				t.Errorf("expected the updated URL to replace the cache entry, got %+v", cached)
+			}
+		})
+	}
+}
 
-			if stats.Clicks != tt.expectedStats.Clicks {
-				t.Errorf("expected %d clicks, got %d", tt.expectedStats.Clicks, stats.Clicks)
+func TestShortenerService_GetLongURL_RecordsClickEvent(t *testing.T) {
+	var flushed []*domain.ClickEvent
+	var deltas map[string]int64
+	mockPg := &mockPostgresRepo{
+		incrementClicksFunc: func(ctx context.Context, batch map[string]int64) error {
+			deltas = batch
+			return nil
+		},
+		recordClicksFunc: func(ctx context.Context, events []*domain.ClickEvent) error {
+			flushed = events
+			return nil
+		},
+	}
+	mockRedis := &mockRedisRepo{
+		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			return &domain.URL{ID: 7, ShortCode: shortCode, LongURL: "https://example.com"}, nil
+		},
+	}
+	service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+	_, err := service.GetLongURL(context.Background(), "abc123", &domain.Visit{
+		Referrer:  "https://www.Reddit.com/r/golang",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+		ClientIP:  "198.51.100.23",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	service.Close(context.Background())
+
+	if deltas["abc123"] != 1 {
+		t.Errorf("expected a delta of 1 click for 'abc123', got %v", deltas)
+	}
+	if len(flushed) != 1 {
+		t.Fatalf("expected 1 click event to be flushed, got %d", len(flushed))
+	}
+
+	event := flushed[0]
+	if event.URLID != 7 {
+		t.Errorf("expected url id 7, got %d", event.URLID)
+	}
+	if event.ReferrerHost != "www.reddit.com" {
+		t.Errorf("expected referrer host 'www.reddit.com', got '%s'", event.ReferrerHost)
+	}
+	if event.UserAgentClass != "desktop" {
+		t.Errorf("expected user agent class 'desktop', got '%s'", event.UserAgentClass)
+	}
+	if event.IPPrefix != "198.51.100.0/24" {
+		t.Errorf("expected ip prefix '198.51.100.0/24', got '%s'", event.IPPrefix)
+	}
+}
+
+func TestShortenerService_GetURLStats_Series(t *testing.T) {
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		query            *domain.SeriesQuery
+		expectedErr      error
+		expectedInterval string
+		expectedFrom     time.Time
+	}{
+		{
+			name:             "defaults to daily buckets",
+			query:            &domain.SeriesQuery{To: to},
+			expectedInterval: "day",
+			expectedFrom:     to.Add(-30 * 24 * time.Hour),
+		},
+		{
+			name:             "hourly buckets",
+			query:            &domain.SeriesQuery{From: to.Add(-6 * time.Hour), To: to, Interval: "hour"},
+			expectedInterval: "hour",
+			expectedFrom:     to.Add(-6 * time.Hour),
+		},
+		{
+			name:        "unsupported interval",
+			query:       &domain.SeriesQuery{To: to, Interval: "minute"},
+			expectedErr: ErrInvalidStatsQuery,
+		},
+		{
+			name:        "from after to",
+			query:       &domain.SeriesQuery{From: to.Add(time.Hour), To: to, Interval: "day"},
+			expectedErr: ErrInvalidStatsQuery,
+		},
+		{
+			name:        "too many buckets",
+			query:       &domain.SeriesQuery{From: to.Add(-2000 * time.Hour), To: to, Interval: "hour"},
+			expectedErr: ErrInvalidStatsQuery,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received *domain.SeriesQuery
+			mockPg := &mockPostgresRepo{
+				getClickSeriesFunc: func(ctx context.Context, urlID int64, query *domain.SeriesQuery) ([]domain.ClickBucket, error) {
+					received = query
+					return []domain.ClickBucket{{Start: query.From, Clicks: 3}}, nil
+				},
+			}
+			service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+			stats, err := service.GetURLStats(context.Background(), "abc123", tt.query)
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error '%v', got '%v'", tt.expectedErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if received == nil {
+				t.Fatal("expected click series to be queried")
+			}
+			if !received.From.Equal(tt.expectedFrom) {
+				t.Errorf("expected from %v, got %v", tt.expectedFrom, received.From)
+			}
+			if stats.Interval != tt.expectedInterval {
+				t.Errorf("expected interval '%s', got '%s'", tt.expectedInterval, stats.Interval)
+			}
+			if len(stats.Series) != 1 || stats.Series[0].Clicks != 3 {
+				t.Errorf("expected series with one bucket of 3 clicks, got %v", stats.Series)
 			}
 		})
 	}
@@ -500,3 +2464,226 @@ func contains(s, substr string) bool {
 	}
 	return false
 }
+
+func TestShortenerService_ListURLs_Pagination(t *testing.T) {
+	base := time.Date(2025, 12, 1, 10, 0, 0, 0, time.UTC)
+	stored := make([]*domain.URL, 0, 5)
+	for i := 5; i >= 1; i-- {
+		stored = append(stored, &domain.URL{
+			ID:        int64(i),
+			ShortCode: fmt.Sprintf("code%d", i),
+			LongURL:   "https://example.com",
+			CreatedAt: base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	var queries []domain.ListURLsQuery
+	mockPg := &mockPostgresRepo{
+		listURLsFunc: func(ctx context.Context, query *domain.ListURLsQuery) ([]*domain.URL, error) {
+			queries = append(queries, *query)
+			start := 0
+			if query.After != nil {
+				for i, url := range stored {
+					if url.ID == query.After.ID {
+						start = i + 1
+					}
+				}
+			}
+			end := min(start+query.Limit, len(stored))
+			return stored[start:end], nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+	ctx := WithOwner(context.Background(), 7)
+
+	var codes []string
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		response, err := service.ListURLs(ctx, &domain.ListURLsQuery{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, url := range response.URLs {
+			codes = append(codes, url.ShortCode)
+			if url.ShortURL != "http://localhost:8080/"+url.ShortCode {
+				t.Errorf("unexpected short URL %s", url.ShortURL)
+			}
+		}
+		cursor = response.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	expected := "code5,code4,code3,code2,code1"
+	if strings.Join(codes, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(codes, ","))
+	}
+	if len(queries) != 3 {
+		t.Errorf("expected 3 pages, got %d", len(queries))
+	}
+	for _, query := range queries {
+		if query.OwnerID == nil || *query.OwnerID != 7 {
+			t.Errorf("expected listing to be scoped to owner 7, got %v", query.OwnerID)
+		}
+		if query.Limit != 3 {
+			t.Errorf("expected one extra URL to be requested, got limit %d", query.Limit)
+		}
+	}
+	if queries[1].After == nil || queries[1].After.ID != 4 || !queries[1].After.CreatedAt.Equal(stored[1].CreatedAt) {
+		t.Errorf("expected second page to start after code4, got %+v", queries[1].After)
+	}
+}
+
+func TestShortenerService_ListURLs_InvalidQuery(t *testing.T) {
+	clicksCursor := encodeListCursor("clicks", &domain.URL{ID: 3, Clicks: 10})
+	from := time.Date(2025, 12, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query *domain.ListURLsQuery
+	}{
+		{name: "unknown sort", query: &domain.ListURLsQuery{Sort: "long_url"}},
+		{name: "limit too large", query: &domain.ListURLsQuery{Limit: maxListLimit + 1}},
+		{name: "malformed cursor", query: &domain.ListURLsQuery{Cursor: "not-a-cursor"}},
+		{name: "cursor of another sort", query: &domain.ListURLsQuery{Sort: "created_at", Cursor: clicksCursor}},
+		{name: "empty date range", query: &domain.ListURLsQuery{CreatedFrom: from, CreatedTo: from}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewShortenerService(&mockPostgresRepo{}, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+			_, err := service.ListURLs(context.Background(), tt.query)
+			if !errors.Is(err, ErrInvalidListQuery) {
+				t.Errorf("expected ErrInvalidListQuery, got %v", err)
+			}
+		})
+	}
+}
+
+func TestShortenerService_ListURLs_ClicksCursor(t *testing.T) {
+	cursor := encodeListCursor("clicks", &domain.URL{ID: 3, Clicks: 10})
+
+	var received *domain.ListURLsQuery
+	mockPg := &mockPostgresRepo{
+		listURLsFunc: func(ctx context.Context, query *domain.ListURLsQuery) ([]*domain.URL, error) {
+			received = query
+			return nil, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+	response, err := service.ListURLs(context.Background(), &domain.ListURLsQuery{
+		Sort:   "clicks",
+		Cursor: cursor,
+		Domain: " WWW.Example.com ",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if received.After == nil || received.After.ID != 3 || received.After.Clicks != 10 {
+		t.Errorf("expected cursor after id 3 with 10 clicks, got %+v", received.After)
+	}
+	if received.Domain != "example.com" {
+		t.Errorf("expected normalized domain 'example.com', got '%s'", received.Domain)
+	}
+	if received.OwnerID != nil {
+		t.Errorf("expected no owner for internal callers, got %v", *received.OwnerID)
+	}
+	if response.NextCursor != "" || len(response.URLs) != 0 {
+		t.Errorf("expected an empty last page, got %+v", response)
+	}
+}
+
+func TestShortenerService_GetQRCode(t *testing.T) {
+	ownerID := int64(7)
+	otherID := int64(8)
+	negativeMargin := -1
+
+	tests := []struct {
+		name                string
+		ctx                 context.Context
+		query               *domain.QRCodeQuery
+		expectedErr         error
+		expectedContentType string
+	}{
+		{
+			name:                "defaults to png",
+			ctx:                 context.Background(),
+			query:               &domain.QRCodeQuery{},
+			expectedContentType: "image/png",
+		},
+		{
+			name:                "svg for the owner",
+			ctx:                 WithOwner(context.Background(), ownerID),
+			query:               &domain.QRCodeQuery{Size: 512, Format: "SVG", Level: "h"},
+			expectedContentType: "image/svg+xml",
+		},
+		{
+			name:        "other owner",
+			ctx:         WithOwner(context.Background(), otherID),
+			query:       &domain.QRCodeQuery{},
+			expectedErr: repo.ErrNotFound,
+		},
+		{
+			name:        "size too small",
+			ctx:         context.Background(),
+			query:       &domain.QRCodeQuery{Size: 10},
+			expectedErr: ErrInvalidQRCode,
+		},
+		{
+			name:        "unknown format",
+			ctx:         context.Background(),
+			query:       &domain.QRCodeQuery{Format: "gif"},
+			expectedErr: ErrInvalidQRCode,
+		},
+		{
+			name:        "unknown level",
+			ctx:         context.Background(),
+			query:       &domain.QRCodeQuery{Level: "X"},
+			expectedErr: ErrInvalidQRCode,
+		},
+		{
+			name:                "no margin",
+			ctx:                 context.Background(),
+			query:               &domain.QRCodeQuery{Margin: new(int)},
+			expectedContentType: "image/png",
+		},
+		{
+			name:        "negative margin",
+			ctx:         context.Background(),
+			query:       &domain.QRCodeQuery{Margin: &negativeMargin},
+			expectedErr: ErrInvalidQRCode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPg := &mockPostgresRepo{
+				getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{ID: 1, ShortCode: shortCode, LongURL: "https://example.com", OwnerID: &ownerID}, nil
+				},
+			}
+			service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+
+			code, err := service.GetQRCode(tt.ctx, "abc123", tt.query)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if code.ContentType != tt.expectedContentType {
+				t.Errorf("expected content type '%s', got '%s'", tt.expectedContentType, code.ContentType)
+			}
+			if len(code.Data) == 0 {
+				t.Error("expected a rendered image")
+			}
+		})
+	}
+}
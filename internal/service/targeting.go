@@ -0,0 +1,189 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/Elisandil/go-snap/internal/analytics"
+	"github.com/Elisandil/go-snap/internal/domain"
+)
+
+const (
+	matchDevice   = "device"
+	matchOS       = "os"
+	matchLanguage = "language"
+	matchCountry  = "country"
+
+	maxTargetingRules = 20
+)
+
+var ErrInvalidTargeting = errors.New("invalid targeting rule")
+
+// targetingValues lists the values accepted by the device and os matches. Languages and countries are
+// only checked for their shape.
+var targetingValues = map[string]map[string]bool{
+	matchDevice: {
+		analytics.UserAgentMobile:  true,
+		analytics.UserAgentTablet:  true,
+		analytics.UserAgentDesktop: true,
+		analytics.UserAgentBot:     true,
+	},
+	matchOS: {
+		analytics.OSiOS:     true,
+		analytics.OSAndroid: true,
+		analytics.OSWindows: true,
+		analytics.OSMacOS:   true,
+		analytics.OSLinux:   true,
+	},
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    INTERFACES
+// ----------------------------------------------------------------------------------------
+
+// CountryLookup resolves client IPs to the ISO 3166-1 alpha-2 code of their country, in upper case.
+// The second result is false when the country of the IP is unknown.
+type CountryLookup interface {
+	Country(ip netip.Addr) (string, bool)
+}
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE FUNCTIONS
+// ----------------------------------------------------------------------------------------
+
+// normalizeTargeting validates the targeting rules of a create request.
+// Values are lowercased, except countries, which are uppercased, and destinations are normalized
+// like the long URL. It returns an error wrapping ErrInvalidTargeting for unknown matches and values,
+// rules without values and invalid destinations, and nil if there are no rules.
+func normalizeTargeting(rules []domain.TargetingRule) ([]domain.TargetingRule, error) {
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxTargetingRules {
+		return nil, fmt.Errorf("%w: at most %d rules are allowed", ErrInvalidTargeting, maxTargetingRules)
+	}
+
+	normalized := make([]domain.TargetingRule, 0, len(rules))
+	for i, rule := range rules {
+		match := strings.ToLower(strings.TrimSpace(rule.Match))
+		if match != matchDevice && match != matchOS && match != matchLanguage && match != matchCountry {
+			return nil, fmt.Errorf("%w: rule %d has unknown match %q", ErrInvalidTargeting, i, rule.Match)
+		}
+		if len(rule.Values) == 0 {
+			return nil, fmt.Errorf("%w: rule %d has no values", ErrInvalidTargeting, i)
+		}
+
+		values := make([]string, 0, len(rule.Values))
+		for _, value := range rule.Values {
+			value = normalizeTargetingValue(match, value)
+			if !isValidTargetingValue(match, value) {
+				return nil, fmt.Errorf("%w: rule %d has invalid %s %q", ErrInvalidTargeting, i, match, value)
+			}
+			values = append(values, value)
+		}
+
+		longURL, err := normalizeLongURL(rule.LongURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rule %d: %w", ErrInvalidTargeting, i, err)
+		}
+
+		normalized = append(normalized, domain.TargetingRule{Match: match, Values: values, LongURL: longURL})
+	}
+
+	return normalized, nil
+}
+
+// normalizeTargetingValue trims a value of the given match and puts it in the case it is compared in.
+func normalizeTargetingValue(match, value string) string {
+	value = strings.TrimSpace(value)
+
+	if match == matchCountry {
+		return strings.ToUpper(value)
+	}
+
+	return strings.ToLower(value)
+}
+
+// isValidTargetingValue checks a normalized value of the given match.
+func isValidTargetingValue(match, value string) bool {
+
+	switch match {
+	case matchCountry:
+		return len(value) == 2 && value[0] >= 'A' && value[0] <= 'Z' && value[1] >= 'A' && value[1] <= 'Z'
+	case matchLanguage:
+		return value != "" && value != "*" && !strings.ContainsAny(value, ",; ")
+	default:
+		return targetingValues[match][value]
+	}
+}
+
+// visitTraits are the attributes of a visit that targeting rules are matched against.
+type visitTraits struct {
+	device   string
+	os       string
+	language string
+	country  string
+}
+
+// targetURL returns the destination of the first targeting rule that the visit matches.
+// Visits without metadata match no rule, and country rules only match when countries can be looked up.
+// The second result is false if no rule matches.
+func (s *ShortenerService) targetURL(rules []domain.TargetingRule, visit *domain.Visit) (string, bool) {
+
+	if len(rules) == 0 || visit == nil {
+		return "", false
+	}
+
+	traits := visitTraits{
+		device:   analytics.UserAgentClass(visit.UserAgent),
+		os:       analytics.OperatingSystem(visit.UserAgent),
+		language: analytics.PreferredLanguage(visit.AcceptLanguage),
+		country:  s.lookupCountry(visit.ClientIP),
+	}
+
+	for _, rule := range rules {
+		for _, value := range rule.Values {
+			if traits.match(rule.Match, value) {
+				return rule.LongURL, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// lookupCountry returns the country of the given client IP, or an empty string if it is unknown.
+func (s *ShortenerService) lookupCountry(clientIP string) string {
+
+	if s.countries == nil {
+		return ""
+	}
+	ip, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return ""
+	}
+	country, _ := s.countries.Country(ip)
+
+	return country
+}
+
+// match reports whether the visit matches a normalized value of the given match.
+// A language value matches the preferred language and its regional variants, so "es" matches "es-mx".
+func (t visitTraits) match(match, value string) bool {
+
+	switch match {
+	case matchDevice:
+		return t.device == value
+	case matchOS:
+		return t.os == value
+	case matchLanguage:
+		return t.language == value || strings.HasPrefix(t.language, value+"-")
+	case matchCountry:
+		return t.country != "" && t.country == value
+	default:
+		return false
+	}
+}
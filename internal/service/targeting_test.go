@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/shortid"
+)
+
+const (
+	testIPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	testAndroidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"
+	testWindowsUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+)
+
+type mockCountryLookup map[string]string
+
+func (m mockCountryLookup) Country(ip netip.Addr) (string, bool) {
+	country, ok := m[ip.String()]
+	return country, ok
+}
+
+func TestNormalizeTargeting(t *testing.T) {
+	rules, err := normalizeTargeting([]domain.TargetingRule{
+		{Match: " OS ", Values: []string{"iOS"}, LongURL: "apps.apple.com/app/id1"},
+		{Match: "country", Values: []string{"es", " mx"}, LongURL: "https://example.com/es"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []domain.TargetingRule{
+		{Match: "os", Values: []string{"ios"}, LongURL: "https://apps.apple.com/app/id1"},
+		{Match: "country", Values: []string{"ES", "MX"}, LongURL: "https://example.com/es"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rules)
+	}
+
+	invalid := []struct {
+		name string
+		rule domain.TargetingRule
+	}{
+		{name: "unknown match", rule: domain.TargetingRule{Match: "browser", Values: []string{"firefox"}, LongURL: "https://example.com"}},
+		{name: "no values", rule: domain.TargetingRule{Match: "os", LongURL: "https://example.com"}},
+		{name: "unknown os", rule: domain.TargetingRule{Match: "os", Values: []string{"beos"}, LongURL: "https://example.com"}},
+		{name: "invalid country", rule: domain.TargetingRule{Match: "country", Values: []string{"ESP"}, LongURL: "https://example.com"}},
+		{name: "wildcard language", rule: domain.TargetingRule{Match: "language", Values: []string{"*"}, LongURL: "https://example.com"}},
+		{name: "invalid destination", rule: domain.TargetingRule{Match: "device", Values: []string{"mobile"}, LongURL: "not a url"}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := normalizeTargeting([]domain.TargetingRule{tt.rule}); !errors.Is(err, ErrInvalidTargeting) {
+				t.Errorf("expected ErrInvalidTargeting, got %v", err)
+			}
+		})
+	}
+}
+
+func TestShortenerService_GetLongURL_Targeting(t *testing.T) {
+	rules := []domain.TargetingRule{
+		{Match: "os", Values: []string{"ios"}, LongURL: "https://apps.apple.com/app/id1"},
+		{Match: "os", Values: []string{"android"}, LongURL: "https://play.google.com/store/apps/details?id=app"},
+		{Match: "language", Values: []string{"es"}, LongURL: "https://example.com/es"},
+		{Match: "country", Values: []string{"FR", "BE"}, LongURL: "https://example.com/fr"},
+	}
+
+	tests := []struct {
+		name            string
+		visit           *domain.Visit
+		expectedLongURL string
+	}{
+		{
+			name:            "no visit metadata",
+			expectedLongURL: "https://example.com",
+		},
+		{
+			name:            "ios",
+			visit:           &domain.Visit{UserAgent: testIPhoneUA, AcceptLanguage: "es-ES"},
+			expectedLongURL: "https://apps.apple.com/app/id1",
+		},
+		{
+			name:            "android",
+			visit:           &domain.Visit{UserAgent: testAndroidUA},
+			expectedLongURL: "https://play.google.com/store/apps/details?id=app",
+		},
+		{
+			name:            "regional language variant",
+			visit:           &domain.Visit{UserAgent: testWindowsUA, AcceptLanguage: "es-MX,en;q=0.8"},
+			expectedLongURL: "https://example.com/es",
+		},
+		{
+			name:            "language that is not preferred",
+			visit:           &domain.Visit{UserAgent: testWindowsUA, AcceptLanguage: "en-US,es;q=0.8"},
+			expectedLongURL: "https://example.com",
+		},
+		{
+			name:            "country",
+			visit:           &domain.Visit{UserAgent: testWindowsUA, ClientIP: "192.0.2.10"},
+			expectedLongURL: "https://example.com/fr",
+		},
+		{
+			name:            "unknown country",
+			visit:           &domain.Visit{UserAgent: testWindowsUA, ClientIP: "198.51.100.7"},
+			expectedLongURL: "https://example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRedis := &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", Targeting: rules}, nil
+				},
+			}
+			service := NewShortenerService(&mockPostgresRepo{}, mockRedis, shortid.NewGenerator(), "http://localhost:8080",
+				WithCountryLookup(mockCountryLookup{"192.0.2.10": "BE"}),
+			)
+
+			url, err := service.GetLongURL(context.Background(), "abc123", tt.visit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if url.LongURL != tt.expectedLongURL {
+				t.Errorf("expected long URL '%s', got '%s'", tt.expectedLongURL, url.LongURL)
+			}
+		})
+	}
+}
+
+func TestShortenerService_GetLongURL_CountryWithoutLookup(t *testing.T) {
+	mockRedis := &mockRedisRepo{
+		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", Targeting: []domain.TargetingRule{
+				{Match: "country", Values: []string{"BE"}, LongURL: "https://example.com/fr"},
+			}}, nil
+		},
+	}
+	service := NewShortenerService(&mockPostgresRepo{}, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+	url, err := service.GetLongURL(context.Background(), "abc123", &domain.Visit{ClientIP: "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if url.LongURL != "https://example.com" {
+		t.Errorf("expected country rules not to match without a lookup, got '%s'", url.LongURL)
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+)
+
+const (
+	minVariants      = 2
+	maxVariants      = 10
+	maxVariantWeight = 1000
+)
+
+var ErrInvalidVariants = errors.New("invalid variants")
+
+// ----------------------------------------------------------------------------------------
+//                                    PRIVATE FUNCTIONS
+// ----------------------------------------------------------------------------------------
+
+// normalizeVariants validates the variants of a create request and normalizes their destinations like the long URL.
+// A link splits between minVariants and maxVariants destinations, each with a weight between 1 and
+// maxVariantWeight, and only links with variants can be sticky. Otherwise it returns an error wrapping
+// ErrInvalidVariants. It returns nil if there are no variants.
+func normalizeVariants(variants []domain.Variant, sticky bool) ([]domain.Variant, error) {
+
+	if len(variants) == 0 {
+		if sticky {
+			return nil, fmt.Errorf("%w: sticky variants require variants", ErrInvalidVariants)
+		}
+		return nil, nil
+	}
+	if len(variants) < minVariants || len(variants) > maxVariants {
+		return nil, fmt.Errorf("%w: between %d and %d variants are allowed", ErrInvalidVariants,
+			minVariants, maxVariants)
+	}
+
+	normalized := make([]domain.Variant, 0, len(variants))
+	for i, variant := range variants {
+		if variant.Weight < 1 || variant.Weight > maxVariantWeight {
+			return nil, fmt.Errorf("%w: variant %d must have a weight between 1 and %d", ErrInvalidVariants,
+				i, maxVariantWeight)
+		}
+
+		longURL, err := normalizeLongURL(variant.LongURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: variant %d: %w", ErrInvalidVariants, i, err)
+		}
+
+		normalized = append(normalized, domain.Variant{LongURL: longURL, Weight: variant.Weight})
+	}
+
+	return normalized, nil
+}
+
+// chooseVariant returns the 1-based index of the variant a visit to the given URL is sent to, or zero if the
+// URL has no variants. Sticky URLs keep visitors on the variant of their visit, if it still exists, and every
+// other visit draws a variant at random, weighted by the variant weights.
+func chooseVariant(url *domain.URL, visit *domain.Visit) int {
+
+	if len(url.Variants) == 0 {
+		return 0
+	}
+	if url.StickyVariants && visit != nil && visit.Variant >= 1 && visit.Variant <= len(url.Variants) {
+		return visit.Variant
+	}
+
+	total := 0
+	for _, variant := range url.Variants {
+		total += variant.Weight
+	}
+
+	return weightedVariant(url.Variants, rand.IntN(total))
+}
+
+// weightedVariant returns the 1-based index of the variant that the draw n, between zero and the sum of the
+// weights (exclusive), falls on when the weights are laid end to end.
+func weightedVariant(variants []domain.Variant, n int) int {
+
+	for i, variant := range variants {
+		if n < variant.Weight {
+			return i + 1
+		}
+		n -= variant.Weight
+	}
+
+	return len(variants)
+}
+
+// toVariantStats pairs the variants of a URL with their click counts, keyed by 1-based variant index.
+func toVariantStats(variants []domain.Variant, clicks map[int]int64) []domain.VariantStats {
+
+	if len(variants) == 0 {
+		return nil
+	}
+
+	stats := make([]domain.VariantStats, 0, len(variants))
+	for i, variant := range variants {
+		stats = append(stats, domain.VariantStats{
+			LongURL: variant.LongURL,
+			Weight:  variant.Weight,
+			Clicks:  clicks[i+1],
+		})
+	}
+
+	return stats
+}
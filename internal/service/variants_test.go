@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/Elisandil/go-snap/internal/shortid"
+)
+
+func TestNormalizeVariants(t *testing.T) {
+	variants, err := normalizeVariants([]domain.Variant{
+		{LongURL: "example.com/landing-a", Weight: 70},
+		{LongURL: "https://example.com/landing-b", Weight: 30},
+	}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []domain.Variant{
+		{LongURL: "https://example.com/landing-a", Weight: 70},
+		{LongURL: "https://example.com/landing-b", Weight: 30},
+	}
+	if !reflect.DeepEqual(variants, expected) {
+		t.Errorf("expected %+v, got %+v", expected, variants)
+	}
+
+	if variants, err := normalizeVariants(nil, false); err != nil || variants != nil {
+		t.Errorf("expected no variants and no error, got %+v, %v", variants, err)
+	}
+
+	valid := domain.Variant{LongURL: "https://example.com", Weight: 1}
+	invalid := []struct {
+		name     string
+		variants []domain.Variant
+		sticky   bool
+	}{
+		{name: "sticky without variants", sticky: true},
+		{name: "single variant", variants: []domain.Variant{valid}},
+		{name: "too many variants", variants: []domain.Variant{valid, valid, valid, valid, valid, valid, valid,
+			valid, valid, valid, valid}},
+		{name: "zero weight", variants: []domain.Variant{valid, {LongURL: "https://example.com", Weight: 0}}},
+		{name: "weight too large", variants: []domain.Variant{valid, {LongURL: "https://example.com", Weight: 1001}}},
+		{name: "invalid destination", variants: []domain.Variant{valid, {LongURL: "not a url", Weight: 1}}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := normalizeVariants(tt.variants, tt.sticky); !errors.Is(err, ErrInvalidVariants) {
+				t.Errorf("expected ErrInvalidVariants, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWeightedVariant(t *testing.T) {
+	variants := []domain.Variant{
+		{LongURL: "https://example.com/a", Weight: 3},
+		{LongURL: "https://example.com/b", Weight: 1},
+		{LongURL: "https://example.com/c", Weight: 2},
+	}
+	expected := []int{1, 1, 1, 2, 3, 3}
+
+	for n, want := range expected {
+		if got := weightedVariant(variants, n); got != want {
+			t.Errorf("weightedVariant(%d) = %d; want %d", n, got, want)
+		}
+	}
+}
+
+func TestShortenerService_GetLongURL_Variants(t *testing.T) {
+	variants := []domain.Variant{
+		{LongURL: "https://example.com/a", Weight: 1},
+		{LongURL: "https://example.com/b", Weight: 1},
+	}
+
+	tests := []struct {
+		name            string
+		sticky          bool
+		targeting       []domain.TargetingRule
+		visit           *domain.Visit
+		expectedVariant int
+		expectedLongURL string
+	}{
+		{
+			name:            "sticky visit keeps its variant",
+			sticky:          true,
+			visit:           &domain.Visit{Variant: 2},
+			expectedVariant: 2,
+			expectedLongURL: "https://example.com/b",
+		},
+		{
+			name: "targeting rules win over variants",
+			targeting: []domain.TargetingRule{
+				{Match: "device", Values: []string{"mobile"}, LongURL: "https://m.example.com"},
+			},
+			visit:           &domain.Visit{UserAgent: "Mozilla/5.0 (iPhone) Mobile"},
+			expectedVariant: 0,
+			expectedLongURL: "https://m.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flushed []*domain.ClickEvent
+			mockPg := &mockPostgresRepo{
+				recordClicksFunc: func(ctx context.Context, events []*domain.ClickEvent) error {
+					flushed = events
+					return nil
+				},
+			}
+			mockRedis := &mockRedisRepo{
+				getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+					return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", Variants: variants,
+						StickyVariants: tt.sticky, Targeting: tt.targeting}, nil
+				},
+			}
+			service := NewShortenerService(mockPg, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+
+			url, err := service.GetLongURL(context.Background(), "abc123", tt.visit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			service.Close(context.Background())
+
+			if url.Variant != tt.expectedVariant {
+				t.Errorf("expected variant %d, got %d", tt.expectedVariant, url.Variant)
+			}
+			if url.LongURL != tt.expectedLongURL {
+				t.Errorf("expected long URL '%s', got '%s'", tt.expectedLongURL, url.LongURL)
+			}
+			if len(flushed) != 1 || flushed[0].Variant != tt.expectedVariant {
+				t.Errorf("expected one click event on variant %d, got %+v", tt.expectedVariant, flushed)
+			}
+		})
+	}
+}
+
+func TestShortenerService_GetLongURL_VariantsSplit(t *testing.T) {
+	mockRedis := &mockRedisRepo{
+		getFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			return &domain.URL{ShortCode: shortCode, LongURL: "https://example.com", Variants: []domain.Variant{
+				{LongURL: "https://example.com/a", Weight: 1},
+				{LongURL: "https://example.com/b", Weight: 1},
+			}}, nil
+		},
+	}
+	service := NewShortenerService(&mockPostgresRepo{}, mockRedis, shortid.NewGenerator(), "http://localhost:8080")
+	defer service.Close(context.Background())
+
+	// A visit of a link without sticky variants is drawn again whatever variant it carries
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		url, err := service.GetLongURL(context.Background(), "abc123", &domain.Visit{Variant: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if url.Variant < 1 || url.Variant > 2 {
+			t.Fatalf("expected variant 1 or 2, got %d", url.Variant)
+		}
+		seen[url.LongURL] = true
+	}
+
+	if !seen["https://example.com/a"] || !seen["https://example.com/b"] {
+		t.Errorf("expected both variants to be chosen, got %v", seen)
+	}
+}
+
+func TestShortenerService_GetURLStats_Variants(t *testing.T) {
+	mockPg := &mockPostgresRepo{
+		getByShortCodeFunc: func(ctx context.Context, shortCode string) (*domain.URL, error) {
+			return &domain.URL{ID: 7, ShortCode: shortCode, LongURL: "https://example.com", StickyVariants: true,
+				Variants: []domain.Variant{
+					{LongURL: "https://example.com/a", Weight: 80},
+					{LongURL: "https://example.com/b", Weight: 20},
+				}}, nil
+		},
+		getVariantClicksFunc: func(ctx context.Context, urlID int64) (map[int]int64, error) {
+			if urlID != 7 {
+				t.Errorf("expected URL ID 7, got %d", urlID)
+			}
+			return map[int]int64{1: 41}, nil
+		},
+	}
+	service := NewShortenerService(mockPg, &mockRedisRepo{}, shortid.NewGenerator(), "http://localhost:8080")
+	defer service.Close(context.Background())
+
+	stats, err := service.GetURLStats(context.Background(), "abc123", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []domain.VariantStats{
+		{LongURL: "https://example.com/a", Weight: 80, Clicks: 41},
+		{LongURL: "https://example.com/b", Weight: 20, Clicks: 0},
+	}
+	if !reflect.DeepEqual(stats.Variants, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stats.Variants)
+	}
+	if !stats.StickyVariants {
+		t.Error("expected the stats to report sticky variants")
+	}
+}
@@ -46,6 +46,18 @@ func (g *Generator) Encode(number int64) string {
 	return string(runes)
 }
 
+// EncodePadded converts a given integer to a base62 encoded string like Encode, left-padded with zeros
+// to at least length characters. Decode ignores the padding.
+func (g *Generator) EncodePadded(number int64, length int) string {
+
+	encoded := g.Encode(number)
+	if len(encoded) >= length {
+		return encoded
+	}
+
+	return strings.Repeat(string(base62Chars[0]), length-len(encoded)) + encoded
+}
+
 // Decode converts a base62 encoded string back to its integer representation.
 func (g *Generator) Decode(encoded string) int64 {
 	var number int64
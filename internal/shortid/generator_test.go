@@ -47,6 +47,20 @@ func TestGenerator_Encode(t *testing.T) {
 	}
 }
 
+func TestGenerator_EncodePadded(t *testing.T) {
+	g := NewGenerator()
+
+	if result := g.EncodePadded(1000, 6); result != "0000G8" {
+		t.Errorf("EncodePadded(1000, 6) = %s; want 0000G8", result)
+	}
+	if result := g.EncodePadded(123456789, 3); result != "8M0kX" {
+		t.Errorf("EncodePadded(123456789, 3) = %s; want 8M0kX", result)
+	}
+	if decoded := g.Decode(g.EncodePadded(1000, 6)); decoded != 1000 {
+		t.Errorf("Decode(EncodePadded(1000, 6)) = %d; want 1000", decoded)
+	}
+}
+
 func TestGenerator_Decode(t *testing.T) {
 	g := NewGenerator()
 
@@ -0,0 +1,114 @@
+package shortid
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ObfuscatedLength is the length of the short codes of obfuscated IDs, padded with leading zeros.
+	ObfuscatedLength = 6
+	// MaxObfuscatedID bounds the IDs an Obfuscator can shuffle: those below 62^6, which fit in ObfuscatedLength
+	// base62 characters.
+	MaxObfuscatedID int64 = 56_800_235_584
+
+	// MinObfuscatorKeyLength is the minimum length of the key of an Obfuscator, in bytes.
+	MinObfuscatorKeyLength = 16
+
+	feistelRounds   = 8
+	feistelHalfBits = 18
+	feistelHalfMask = 1<<feistelHalfBits - 1
+)
+
+var ErrIDOutOfRange = errors.New("ID out of the obfuscation range")
+
+// Obfuscator shuffles IDs with a keyed, reversible permutation, so that consecutive IDs give unrelated
+// short codes that can't be guessed without the key.
+// It is a balanced Feistel network over 36 bits, whose round function is an HMAC-SHA256 of the key.
+// Results that don't fall below MaxObfuscatedID are permuted again until they do (cycle walking), so every
+// ID below MaxObfuscatedID maps to a distinct number below it.
+type Obfuscator struct {
+	key []byte
+}
+
+// NewObfuscator creates an Obfuscator with the given secret key, of at least MinObfuscatorKeyLength bytes.
+// The key must never change once short codes have been generated with it.
+func NewObfuscator(key []byte) (*Obfuscator, error) {
+
+	if len(key) < MinObfuscatorKeyLength {
+		return nil, fmt.Errorf("obfuscation key must be at least %d bytes long", MinObfuscatorKeyLength)
+	}
+
+	return &Obfuscator{key: bytes.Clone(key)}, nil
+}
+
+// Obfuscate maps an ID to a number below MaxObfuscatedID, distinct for every ID.
+// It returns ErrIDOutOfRange for negative IDs and IDs from MaxObfuscatedID on.
+func (o *Obfuscator) Obfuscate(id int64) (int64, error) {
+
+	if id < 0 || id >= MaxObfuscatedID {
+		return 0, ErrIDOutOfRange
+	}
+
+	n := uint64(id)
+	for {
+		n = o.permute(n)
+		if n < uint64(MaxObfuscatedID) {
+			return int64(n), nil
+		}
+	}
+}
+
+// Deobfuscate maps a number returned by Obfuscate back to its ID.
+// It returns ErrIDOutOfRange for negative numbers and numbers from MaxObfuscatedID on.
+func (o *Obfuscator) Deobfuscate(number int64) (int64, error) {
+
+	if number < 0 || number >= MaxObfuscatedID {
+		return 0, ErrIDOutOfRange
+	}
+
+	n := uint64(number)
+	for {
+		n = o.unpermute(n)
+		if n < uint64(MaxObfuscatedID) {
+			return int64(n), nil
+		}
+	}
+}
+
+// permute applies the Feistel network to a 36-bit number.
+func (o *Obfuscator) permute(n uint64) uint64 {
+	left, right := n>>feistelHalfBits, n&feistelHalfMask
+	for round := 0; round < feistelRounds; round++ {
+		left, right = right, left^o.round(round, right)
+	}
+
+	return left<<feistelHalfBits | right
+}
+
+// unpermute reverses permute, running the rounds backwards.
+func (o *Obfuscator) unpermute(n uint64) uint64 {
+	left, right := n>>feistelHalfBits, n&feistelHalfMask
+	for round := feistelRounds - 1; round >= 0; round-- {
+		left, right = right^o.round(round, left), left
+	}
+
+	return left<<feistelHalfBits | right
+}
+
+// round is the round function of the Feistel network: the HMAC of the round number and a half,
+// truncated to the size of a half.
+func (o *Obfuscator) round(round int, half uint64) uint64 {
+	var input [5]byte
+	input[0] = byte(round)
+	binary.BigEndian.PutUint32(input[1:], uint32(half))
+
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write(input[:])
+
+	return uint64(binary.BigEndian.Uint32(mac.Sum(nil))) & feistelHalfMask
+}
@@ -0,0 +1,73 @@
+package shortid
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestObfuscator_RoundTrip(t *testing.T) {
+	o, err := NewObfuscator([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	seen := make(map[int64]bool)
+	ids := []int64{123456789, MaxObfuscatedID - 1}
+	for id := int64(0); id < 2000; id++ {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		obfuscated, err := o.Obfuscate(id)
+		if err != nil {
+			t.Fatalf("Obfuscate(%d): unexpected error: %v", id, err)
+		}
+		if obfuscated < 0 || obfuscated >= MaxObfuscatedID {
+			t.Fatalf("Obfuscate(%d) = %d; out of range", id, obfuscated)
+		}
+		if seen[obfuscated] {
+			t.Fatalf("Obfuscate(%d) = %d; already returned for another ID", id, obfuscated)
+		}
+		seen[obfuscated] = true
+
+		if back, err := o.Deobfuscate(obfuscated); err != nil || back != id {
+			t.Errorf("Deobfuscate(%d) = %d, %v; want %d", obfuscated, back, err, id)
+		}
+	}
+}
+
+func TestObfuscator_Unpredictable(t *testing.T) {
+	g := NewGenerator()
+	o, _ := NewObfuscator([]byte("0123456789abcdef"))
+	other, _ := NewObfuscator([]byte("fedcba9876543210"))
+
+	first, _ := o.Obfuscate(1)
+	second, _ := o.Obfuscate(2)
+	if second-first == 1 || first-second == 1 {
+		t.Errorf("expected consecutive IDs not to give consecutive numbers, got %d and %d", first, second)
+	}
+
+	code := g.EncodePadded(first, ObfuscatedLength)
+	if len(code) != ObfuscatedLength {
+		t.Errorf("expected a %d character code, got %s", ObfuscatedLength, code)
+	}
+
+	if otherFirst, _ := other.Obfuscate(1); otherFirst == first {
+		t.Error("expected another key to shuffle IDs differently")
+	}
+}
+
+func TestObfuscator_Errors(t *testing.T) {
+	if _, err := NewObfuscator([]byte("short")); err == nil {
+		t.Error("expected error for a short key")
+	}
+
+	o, _ := NewObfuscator([]byte("0123456789abcdef"))
+	for _, id := range []int64{-1, MaxObfuscatedID} {
+		if _, err := o.Obfuscate(id); !errors.Is(err, ErrIDOutOfRange) {
+			t.Errorf("Obfuscate(%d): expected ErrIDOutOfRange, got %v", id, err)
+		}
+		if _, err := o.Deobfuscate(id); !errors.Is(err, ErrIDOutOfRange) {
+			t.Errorf("Deobfuscate(%d): expected ErrIDOutOfRange, got %v", id, err)
+		}
+	}
+}
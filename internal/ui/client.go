@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/Elisandil/go-snap/internal/domain"
@@ -13,6 +15,7 @@ import (
 
 type APIClient struct {
 	baseURL    string
+	apiKey     string
 	httpClient *http.Client
 }
 
@@ -35,12 +38,24 @@ func (c *APIClient) GetBaseURL() string {
 	return c.baseURL
 }
 
+// SetAPIKey sets the API key sent with every request to the /api endpoints.
+func (c *APIClient) SetAPIKey(apiKey string) {
+	c.apiKey = apiKey
+}
+
+// GetAPIKey returns the current API key of the API client.
+func (c *APIClient) GetAPIKey() string {
+	return c.apiKey
+}
+
 // CreateShortURL sends a request to create a shortened URL for the given long URL.
 // It returns a CreateURLResponse containing the shortened URL or an error if the request fails.
-// The longURL parameter is the original URL to be shortened.
-func (c *APIClient) CreateShortURL(longURL string) (*domain.CreateURLResponse, error) {
+// The longURL parameter is the original URL to be shortened, and utm the optional campaign tracking
+// parameters the server adds to it.
+func (c *APIClient) CreateShortURL(longURL string, utm *domain.UTMParams) (*domain.CreateURLResponse, error) {
 	requestBody := domain.CreateURLRequest{
 		LongURL: longURL,
+		UTM:     utm,
 	}
 
 	jsonData, err := json.Marshal(requestBody)
@@ -48,11 +63,13 @@ func (c *APIClient) CreateShortURL(longURL string) (*domain.CreateURLResponse, e
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	response, err := c.httpClient.Post(
-		c.baseURL+"/api/shorten",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	request, err := c.newAPIRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send POST request: %w", err)
 	}
@@ -63,7 +80,7 @@ func (c *APIClient) CreateShortURL(longURL string) (*domain.CreateURLResponse, e
 		}
 	}(response.Body)
 
-	if response.StatusCode != http.StatusCreated {
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(response.Body)
 
 		return nil, fmt.Errorf("unexpected status code: %d, body: %s", response.StatusCode, string(body))
@@ -80,7 +97,12 @@ func (c *APIClient) CreateShortURL(longURL string) (*domain.CreateURLResponse, e
 // It returns a StatsResponse containing the statistics data or an error if the request fails.
 // The shortCode parameter is the unique identifier for the shortened URL.
 func (c *APIClient) GetStats(shortCode string) (*domain.StatsResponse, error) {
-	response, err := c.httpClient.Get(c.baseURL + "/api/stats/" + shortCode)
+	request, err := c.newAPIRequest(http.MethodGet, "/api/stats/"+shortCode, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send GET request: %w", err)
 	}
@@ -104,6 +126,88 @@ func (c *APIClient) GetStats(shortCode string) (*domain.StatsResponse, error) {
 	return &result, nil
 }
 
+// ListURLs retrieves a page of the short URLs owned by the API key, newest first.
+// The search parameter filters by a substring of the long URL and may be empty.
+// The cursor parameter is the NextCursor of the previous page, or empty for the first page.
+// It returns a ListURLsResponse containing the page or an error if the request fails.
+func (c *APIClient) ListURLs(search, cursor string, limit int) (*domain.ListURLsResponse, error) {
+	params := url.Values{}
+	if search != "" {
+		params.Set("q", search)
+	}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	request, err := c.newAPIRequest(http.MethodGet, "/api/urls?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send GET request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			fmt.Printf("failed to close response body: %v\n", err)
+		}
+	}(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+
+		return nil, fmt.Errorf("unexpected status code: %d, body: %s", response.StatusCode, string(body))
+	}
+
+	var result domain.ListURLsResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return &result, nil
+}
+
+// GetQRCode retrieves the QR code image of a given short code.
+// The size parameter is the width and height in pixels and the format parameter is either "png" or "svg".
+// It returns the encoded image or an error if the request fails.
+func (c *APIClient) GetQRCode(shortCode string, size int, format string) ([]byte, error) {
+	params := url.Values{}
+	params.Set("size", strconv.Itoa(size))
+	params.Set("format", format)
+
+	request, err := c.newAPIRequest(http.MethodGet, "/api/qr/"+shortCode+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send GET request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			fmt.Printf("failed to close response body: %v\n", err)
+		}
+	}(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+
+		return nil, fmt.Errorf("unexpected status code: %d, body: %s", response.StatusCode, string(body))
+	}
+
+	image, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return image, nil
+}
+
 // HealthCheck checks if the server is reachable and healthy.
 // It returns an error if the server is not healthy or unreachable.
 // A healthy server should respond with HTTP 200 OK status.
@@ -125,3 +229,20 @@ func (c *APIClient) HealthCheck() error {
 
 	return nil
 }
+
+// ---------------------------------------------------------------------------------------------
+//                                      PRIVATE METHODS
+// ---------------------------------------------------------------------------------------------
+
+// newAPIRequest builds a request to the given API path, authenticated with the API key if one is set.
+func (c *APIClient) newAPIRequest(method, path string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	return request, nil
+}
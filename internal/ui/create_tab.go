@@ -1,23 +1,49 @@
 package ui
 
 import (
+	"strings"
+
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/widget"
+	"github.com/Elisandil/go-snap/internal/domain"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	// qrPreviewSize is the size in pixels of the QR code shown in the result card.
+	qrPreviewSize = 200
+	// qrPrintSize is the size in pixels of a QR code saved as PNG, large enough for print.
+	qrPrintSize = 1024
+)
+
 type CreateTab struct {
 	client    *APIClient
 	onCreated func(string)
 
 	urlEntry      *widget.Entry
+	utmEntries    *utmEntries
 	resultCard    *widget.Card
 	shortURLLabel *widget.Label
 	shortenBtn    *widget.Button
 	copyBtn       *widget.Button
 	openBtn       *widget.Button
+	qrImage       *canvas.Image
+	saveQRBtn     *widget.Button
+	shortCode     string
+}
+
+// utmEntries holds the entry fields of the campaign tracking section.
+type utmEntries struct {
+	source   *widget.Entry
+	medium   *widget.Entry
+	campaign *widget.Entry
+	term     *widget.Entry
+	content  *widget.Entry
 }
 
 func NewCreateTab(client *APIClient, onCreated func(string)) *CreateTab {
@@ -37,6 +63,7 @@ func (t *CreateTab) Build() fyne.CanvasObject {
 	form := container.NewVBox(
 		widget.NewCard("Create Short URL", "Enter the long URL you want to shorten", container.NewVBox(
 			t.urlEntry,
+			t.createCampaignSection(),
 			t.shortenBtn,
 		)),
 		t.resultCard,
@@ -59,6 +86,49 @@ func (t *CreateTab) createURLEntry() *widget.Entry {
 	return entry
 }
 
+// createCampaignSection creates the collapsed section with the UTM parameters added to the long URL.
+func (t *CreateTab) createCampaignSection() *widget.Accordion {
+	newEntry := func(placeHolder string) *widget.Entry {
+		entry := widget.NewEntry()
+		entry.SetPlaceHolder(placeHolder)
+		return entry
+	}
+
+	t.utmEntries = &utmEntries{
+		source:   newEntry("e.g. newsletter"),
+		medium:   newEntry("e.g. email"),
+		campaign: newEntry("e.g. spring_sale"),
+		term:     newEntry("Paid search keywords"),
+		content:  newEntry("Tells apart links to the same URL"),
+	}
+
+	form := widget.NewForm(
+		widget.NewFormItem("Source", t.utmEntries.source),
+		widget.NewFormItem("Medium", t.utmEntries.medium),
+		widget.NewFormItem("Campaign", t.utmEntries.campaign),
+		widget.NewFormItem("Term", t.utmEntries.term),
+		widget.NewFormItem("Content", t.utmEntries.content),
+	)
+
+	return widget.NewAccordion(widget.NewAccordionItem("Campaign tracking", form))
+}
+
+// utmParams returns the UTM parameters entered in the campaign tracking section, or nil if none is set.
+func (t *CreateTab) utmParams() *domain.UTMParams {
+	utm := &domain.UTMParams{
+		Source:   strings.TrimSpace(t.utmEntries.source.Text),
+		Medium:   strings.TrimSpace(t.utmEntries.medium.Text),
+		Campaign: strings.TrimSpace(t.utmEntries.campaign.Text),
+		Term:     strings.TrimSpace(t.utmEntries.term.Text),
+		Content:  strings.TrimSpace(t.utmEntries.content.Text),
+	}
+	if *utm == (domain.UTMParams{}) {
+		return nil
+	}
+
+	return utm
+}
+
 // createShortenButton creates the main button to shorten URLs.
 func (t *CreateTab) createShortenButton() *widget.Button {
 	btn := widget.NewButton("Shorten URL", t.handleShorten)
@@ -76,13 +146,22 @@ func (t *CreateTab) createResultCard() *widget.Card {
 
 	t.openBtn = widget.NewButton("Open Short URL", t.handleOpen)
 
-	resultBtns := container.NewHBox(t.copyBtn, t.openBtn)
+	t.saveQRBtn = widget.NewButton("Save image", t.handleSaveQR)
+
+	t.qrImage = canvas.NewImageFromResource(nil)
+	t.qrImage.FillMode = canvas.ImageFillContain
+	t.qrImage.ScaleMode = canvas.ImageScalePixels
+	t.qrImage.SetMinSize(fyne.NewSize(qrPreviewSize, qrPreviewSize))
+	t.qrImage.Hide()
+
+	resultBtns := container.NewHBox(t.copyBtn, t.openBtn, t.saveQRBtn)
 
 	card := widget.NewCard("", "", container.NewVBox(
 		widget.NewLabelWithStyle("Short URL:", fyne.TextAlignLeading, fyne.TextStyle{
 			Bold: true,
 		}),
 		t.shortURLLabel,
+		container.NewHBox(t.qrImage),
 		layout.NewSpacer(),
 		resultBtns,
 	))
@@ -99,11 +178,13 @@ func (t *CreateTab) handleShorten() {
 		return
 	}
 
+	utm := t.utmParams()
+
 	t.shortenBtn.Disable()
 	t.shortenBtn.SetText("Shortening ...")
 
 	go func() {
-		result, err := t.client.CreateShortURL(longURL)
+		result, err := t.client.CreateShortURL(longURL, utm)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to shorten URL")
 			ShowErrorDialog(fyne.CurrentApp().Driver().AllWindows()[0], "Failed to shorten URL: "+err.Error())
@@ -112,8 +193,15 @@ func (t *CreateTab) handleShorten() {
 			return
 		}
 
+		qrCode, err := t.client.GetQRCode(result.ShortCode, qrPreviewSize, "png")
+		if err != nil {
+			log.Warn().Err(err).Str("short_code", result.ShortCode).Msg("Failed to load QR code")
+		}
+
 		fyne.Do(func() {
+			t.shortCode = result.ShortCode
 			t.shortURLLabel.SetText(result.ShortURL)
+			t.showQRCode(result.ShortCode, qrCode)
 			t.resultCard.Show()
 			t.urlEntry.SetText("")
 			if t.onCreated != nil {
@@ -146,3 +234,67 @@ func (t *CreateTab) handleOpen() {
 		}
 	}
 }
+
+// showQRCode displays the given PNG QR code in the result card, or hides it if it couldn't be loaded.
+func (t *CreateTab) showQRCode(shortCode string, png []byte) {
+
+	if png == nil {
+		t.qrImage.Hide()
+		t.saveQRBtn.Disable()
+		return
+	}
+
+	t.qrImage.Resource = fyne.NewStaticResource(shortCode+".png", png)
+	t.qrImage.Refresh()
+	t.qrImage.Show()
+	t.saveQRBtn.Enable()
+}
+
+// handleSaveQR is called when the save image button is clicked.
+// It saves the QR code of the current short URL as a print-sized PNG, or as an SVG if the chosen
+// file name ends in .svg.
+func (t *CreateTab) handleSaveQR() {
+	window := fyne.CurrentApp().Driver().AllWindows()[0]
+	shortCode := t.shortCode
+
+	saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			ShowErrorDialog(window, "Failed to save QR code: "+err.Error())
+			return
+		}
+		if writer == nil {
+			return
+		}
+
+		go func() {
+			defer func() {
+				if err := writer.Close(); err != nil {
+					log.Error().Err(err).Msg("Failed to close QR code file")
+				}
+			}()
+
+			format := "png"
+			if strings.EqualFold(writer.URI().Extension(), ".svg") {
+				format = "svg"
+			}
+
+			image, err := t.client.GetQRCode(shortCode, qrPrintSize, format)
+			if err == nil {
+				_, err = writer.Write(image)
+			}
+
+			fyne.Do(func() {
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to save QR code")
+					ShowErrorDialog(window, "Failed to save QR code: "+err.Error())
+					return
+				}
+				ShowSuccessDialog(window, "QR code saved to "+writer.URI().Name())
+			})
+		}()
+	}, window)
+
+	saveDialog.SetFileName(shortCode + ".png")
+	saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{".png", ".svg"}))
+	saveDialog.Show()
+}
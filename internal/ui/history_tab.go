@@ -2,24 +2,24 @@ package ui
 
 import (
 	"fmt"
-	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
+	"github.com/Elisandil/go-snap/internal/domain"
+	"github.com/rs/zerolog/log"
 )
 
-type URLHistoryItem struct {
-	ShortCode string
-	ShortURL  string
-	LongURL   string
-	CreatedAt time.Time
-}
+// historyPageSize is the number of short URLs fetched from the server per page.
+const historyPageSize = 20
 
 type HistoryTab struct {
 	client           *APIClient
-	history          []URLHistoryItem
+	history          []domain.URLSummary
+	nextCursor       string
 	list             *widget.List
+	searchEntry      *widget.Entry
+	loadMoreBtn      *widget.Button
 	emptyLabel       *widget.Label
 	contentContainer *fyne.Container
 }
@@ -27,7 +27,7 @@ type HistoryTab struct {
 func NewHistoryTab(client *APIClient) *HistoryTab {
 	return &HistoryTab{
 		client:  client,
-		history: make([]URLHistoryItem, 0),
+		history: make([]domain.URLSummary, 0),
 	}
 }
 
@@ -35,6 +35,7 @@ func NewHistoryTab(client *APIClient) *HistoryTab {
 func (t *HistoryTab) Build() fyne.CanvasObject {
 	t.list = t.createHistoryList()
 	t.emptyLabel = t.createEmptyLabel()
+	t.loadMoreBtn = t.createLoadMoreButton()
 	toolbar := t.createToolbar()
 
 	t.contentContainer = container.NewStack()
@@ -42,36 +43,17 @@ func (t *HistoryTab) Build() fyne.CanvasObject {
 
 	header := container.NewVBox(
 		widget.NewLabelWithStyle("URL History", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
-		widget.NewLabel("List of all created short URLs in this session"),
+		widget.NewLabel("Short URLs created with your API key, newest first"),
 		toolbar,
 		widget.NewSeparator(),
 	)
 
-	content := container.NewVBox(
-		header,
-		t.contentContainer,
-	)
-
-	return content
-}
-
-// AddItem adds a new URL history item to the list.
-func (t *HistoryTab) AddItem(shortCode, shortURL, longURL string) {
-	item := URLHistoryItem{
-		ShortCode: shortCode,
-		ShortURL:  shortURL,
-		LongURL:   longURL,
-		CreatedAt: time.Now(),
-	}
-
-	t.history = append([]URLHistoryItem{item}, t.history...)
-	t.list.Refresh()
-	t.updateVisibility()
+	return container.NewBorder(header, t.loadMoreBtn, nil, nil, t.contentContainer)
 }
 
-// Refresh refreshes the history list display.
+// Refresh reloads the first page of the history from the server.
 func (t *HistoryTab) Refresh() {
-	t.list.Refresh()
+	t.loadPage("")
 }
 
 //---------------------------------------------------------------------------------------------
@@ -130,7 +112,7 @@ func (t *HistoryTab) updateListItem(id widget.ListItemID, obj fyne.CanvasObject)
 }
 
 // updateLabels updates the labels in a list item.
-func (t *HistoryTab) updateLabels(content *fyne.Container, item URLHistoryItem) {
+func (t *HistoryTab) updateLabels(content *fyne.Container, item domain.URLSummary) {
 	shortURLLabel := content.Objects[0].(*widget.Label)
 	shortURLLabel.SetText(item.ShortURL)
 
@@ -138,11 +120,12 @@ func (t *HistoryTab) updateLabels(content *fyne.Container, item URLHistoryItem)
 	longURLLabel.SetText(fmt.Sprintf("Long URL: %s", item.LongURL))
 
 	dateLabel := content.Objects[2].(*widget.Label)
-	dateLabel.SetText(fmt.Sprintf("Created: %s", item.CreatedAt.Format("02/01/2006 15:04:05")))
+	dateLabel.SetText(fmt.Sprintf("Created: %s · Clicks: %d",
+		item.CreatedAt.Local().Format("02/01/2006 15:04:05"), item.Clicks))
 }
 
 // updateButtons configures the action buttons for a list item.
-func (t *HistoryTab) updateButtons(content *fyne.Container, item URLHistoryItem) {
+func (t *HistoryTab) updateButtons(content *fyne.Container, item domain.URLSummary) {
 	buttons := content.Objects[3].(*fyne.Container)
 
 	copyBtn := buttons.Objects[0].(*widget.Button)
@@ -156,16 +139,28 @@ func (t *HistoryTab) updateButtons(content *fyne.Container, item URLHistoryItem)
 	}
 }
 
-// createToolbar creates the toolbar with refresh and clear buttons.
+// createToolbar creates the toolbar with the search field and the refresh button.
 func (t *HistoryTab) createToolbar() *fyne.Container {
+	t.searchEntry = widget.NewEntry()
+	t.searchEntry.SetPlaceHolder("Filter by long URL")
+	t.searchEntry.OnSubmitted = func(string) {
+		t.Refresh()
+	}
+
 	refreshBtn := widget.NewButton("Refresh", func() {
 		t.Refresh()
 	})
 
-	clearBtn := widget.NewButton("Clear History", t.handleClear)
-	clearBtn.Importance = widget.DangerImportance
+	return container.NewBorder(nil, nil, nil, refreshBtn, t.searchEntry)
+}
 
-	return container.NewHBox(refreshBtn, clearBtn)
+// createLoadMoreButton creates the button that fetches the next page of the history.
+func (t *HistoryTab) createLoadMoreButton() *widget.Button {
+	btn := widget.NewButton("Load More", func() {
+		t.loadPage(t.nextCursor)
+	})
+	btn.Hide()
+	return btn
 }
 
 // createEmptyLabel creates the label displayed when history is empty.
@@ -177,6 +172,43 @@ func (t *HistoryTab) createEmptyLabel() *widget.Label {
 	return emptyLabel
 }
 
+// loadPage fetches a page of the history from the server in the background.
+// An empty cursor replaces the current history with the first page, while any other cursor appends the
+// next page to it.
+func (t *HistoryTab) loadPage(cursor string) {
+	search := t.searchEntry.Text
+	t.loadMoreBtn.Disable()
+
+	go func() {
+		page, err := t.client.ListURLs(search, cursor, historyPageSize)
+
+		fyne.Do(func() {
+			t.loadMoreBtn.Enable()
+
+			if err != nil {
+				log.Error().Err(err).Msg("Error loading URL history")
+				ShowErrorDialog(fyne.CurrentApp().Driver().AllWindows()[0], "Failed to load history: "+err.Error())
+				return
+			}
+
+			if cursor == "" {
+				t.history = page.URLs
+			} else {
+				t.history = append(t.history, page.URLs...)
+			}
+			t.nextCursor = page.NextCursor
+			if t.nextCursor == "" {
+				t.loadMoreBtn.Hide()
+			} else {
+				t.loadMoreBtn.Show()
+			}
+
+			t.list.Refresh()
+			t.updateVisibility()
+		})
+	}()
+}
+
 // handleCopy copies the short URL to clipboard.
 func (t *HistoryTab) handleCopy(shortURL string) {
 	window := fyne.CurrentApp().Driver().AllWindows()[0]
@@ -193,13 +225,6 @@ func (t *HistoryTab) handleOpen(shortURL string) {
 	}
 }
 
-// handleClear clears the history list.
-func (t *HistoryTab) handleClear() {
-	t.history = make([]URLHistoryItem, 0)
-	t.list.Refresh()
-	t.updateVisibility()
-}
-
 // updateVisibility updates the visibility of the list and empty label.
 func (t *HistoryTab) updateVisibility() {
 	if len(t.history) == 0 {
@@ -9,15 +9,16 @@ import (
 
 type SettingsTab struct {
 	client    *APIClient
-	onChanged func(string)
+	onChanged func(baseURL, apiKey string)
 
 	serverURLEntry *widget.Entry
+	apiKeyEntry    *widget.Entry
 	saveBtn        *widget.Button
 	testBtn        *widget.Button
 	statusLabel    *widget.Label
 }
 
-func NewSettingsTab(client *APIClient, onChanged func(string)) *SettingsTab {
+func NewSettingsTab(client *APIClient, onChanged func(baseURL, apiKey string)) *SettingsTab {
 	return &SettingsTab{
 		client:    client,
 		onChanged: onChanged,
@@ -46,6 +47,7 @@ func (t *SettingsTab) Build() fyne.CanvasObject {
 // initializeComponents initializes all UI components.
 func (t *SettingsTab) initializeComponents() {
 	t.serverURLEntry = t.createServerURLEntry()
+	t.apiKeyEntry = t.createAPIKeyEntry()
 	t.saveBtn = t.createSaveButton()
 	t.testBtn = t.createTestButton()
 	t.statusLabel = t.createStatusLabel()
@@ -59,6 +61,14 @@ func (t *SettingsTab) createServerURLEntry() *widget.Entry {
 	return entry
 }
 
+// createAPIKeyEntry creates the API key input field, masked like a password.
+func (t *SettingsTab) createAPIKeyEntry() *widget.Entry {
+	entry := widget.NewPasswordEntry()
+	entry.SetText(t.client.GetAPIKey())
+	entry.SetPlaceHolder("gsk_...")
+	return entry
+}
+
 // createSaveButton creates the save settings button.
 func (t *SettingsTab) createSaveButton() *widget.Button {
 	btn := widget.NewButton("Save Settings", t.handleSave)
@@ -82,6 +92,7 @@ func (t *SettingsTab) createStatusLabel() *widget.Label {
 func (t *SettingsTab) createServerCard() *widget.Card {
 	settingsForm := widget.NewForm(
 		widget.NewFormItem("Server URL:", t.serverURLEntry),
+		widget.NewFormItem("API Key:", t.apiKeyEntry),
 	)
 
 	buttons := container.NewHBox(
@@ -89,7 +100,7 @@ func (t *SettingsTab) createServerCard() *widget.Card {
 		t.testBtn,
 	)
 
-	return widget.NewCard("Server Configuration", "Configure the GoSnap server URL",
+	return widget.NewCard("Server Configuration", "Configure the GoSnap server URL and API key",
 		container.NewVBox(
 			settingsForm,
 			buttons,
@@ -119,7 +130,7 @@ func (t *SettingsTab) handleSave() {
 	}
 
 	if t.onChanged != nil {
-		t.onChanged(newURL)
+		t.onChanged(newURL, t.apiKeyEntry.Text)
 	}
 
 	t.statusLabel.SetText("✓ Settings saved successfully")
@@ -59,6 +59,7 @@ func (w *MainWindow) setupUI() {
 	)
 
 	w.tabs.SetTabLocation(container.TabLocationTop)
+	w.tabs.OnSelected = w.onTabSelected
 
 	w.window.SetContent(w.tabs)
 	w.window.SetMainMenu(w.makeMenu())
@@ -82,14 +83,23 @@ func (w *MainWindow) makeMenu() *fyne.MainMenu {
 
 // onURLCreated is called when a new short URL is created.
 func (w *MainWindow) onURLCreated(shortCode string) {
-	w.historyTab.Refresh()
 	w.tabs.SelectIndex(2)
 	ShowSuccessDialog(w.window, "Short URL created: "+shortCode)
 }
 
+// onTabSelected is called when the user switches tabs.
+// The history is kept on the server, so it is reloaded every time its tab is shown.
+func (w *MainWindow) onTabSelected(tab *container.TabItem) {
+
+	if tab.Text == "History" {
+		w.historyTab.Refresh()
+	}
+}
+
 // onSettingsChanged is called when settings are updated.
-func (w *MainWindow) onSettingsChanged(baseURL string) {
+func (w *MainWindow) onSettingsChanged(baseURL, apiKey string) {
 	w.client.SetBaseURL(baseURL)
+	w.client.SetAPIKey(apiKey)
 	ShowSuccessDialog(w.window, "Settings updated successfully")
 }
 
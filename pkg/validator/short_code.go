@@ -2,6 +2,7 @@ package validator
 
 import (
 	"regexp"
+	"strings"
 )
 
 const (
@@ -12,6 +13,15 @@ const (
 
 var validShortCodeRegex = regexp.MustCompile(`^[0-9A-Za-z]+$`)
 
+// reservedShortCodes lists the codes that collide with server routes and can't be used as aliases.
+var reservedShortCodes = map[string]struct{}{
+	"api":    {},
+	"health": {},
+	"admin":  {},
+	"static": {},
+	"assets": {},
+}
+
 // IsValidShortCode checks if the provided short code is valid.
 // A valid short code is between MinShortCodeLength and MaxShortCodeLength characters
 // and contains only alphanumeric characters.
@@ -28,3 +38,11 @@ func IsValidShortCode(shortCode string) bool {
 
 	return validShortCodeRegex.MatchString(shortCode)
 }
+
+// IsReservedShortCode checks if the provided short code is reserved by the server.
+// The comparison is case-insensitive, so "API" and "Health" are reserved as well.
+func IsReservedShortCode(shortCode string) bool {
+	_, reserved := reservedShortCodes[strings.ToLower(shortCode)]
+
+	return reserved
+}
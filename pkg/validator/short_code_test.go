@@ -134,3 +134,56 @@ func TestIsValidShortCode(t *testing.T) {
 		})
 	}
 }
+
+func TestIsReservedShortCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "api route",
+			input:    "api",
+			expected: true,
+		},
+		{
+			name:     "health route",
+			input:    "health",
+			expected: true,
+		},
+		{
+			name:     "uppercase reserved word",
+			input:    "API",
+			expected: true,
+		},
+		{
+			name:     "mixed case reserved word",
+			input:    "Health",
+			expected: true,
+		},
+		{
+			name:     "regular alias",
+			input:    "springsale",
+			expected: false,
+		},
+		{
+			name:     "reserved word as prefix",
+			input:    "api2",
+			expected: false,
+		},
+		{
+			name:     "empty string",
+			input:    "",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsReservedShortCode(tt.input)
+			if result != tt.expected {
+				t.Errorf("IsReservedShortCode(%s) = %v; want %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package validator
 
 import (
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -44,3 +46,73 @@ func NormalizeURL(rawURL string) string {
 
 	return rawURL
 }
+
+// IsPrivateHost checks if the host of a URL points to the local machine or a private network, which a
+// public short URL must never redirect to.
+// It is true for "localhost" and its subdomains, and for loopback, private, link-local and unspecified
+// IP addresses, including IPv4-mapped IPv6 addresses and the shorthand, octal and hexadecimal IPv4 forms
+// browsers accept, such as "127.1" or "0x7f000001". Host names are not resolved.
+func IsPrivateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		var ok bool
+		if addr, ok = parseLooseIPv4(host); !ok {
+			return false
+		}
+	}
+	addr = addr.Unmap()
+
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsUnspecified()
+}
+
+// parseLooseIPv4 parses a host as an IPv4 address the way browsers do, with one to four dot-separated parts
+// in decimal, octal (leading 0) or hexadecimal (leading 0x), where the last part fills the remaining bytes.
+// It returns false if the host isn't such an address.
+func parseLooseIPv4(host string) (netip.Addr, bool) {
+
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+
+	numbers := make([]uint64, len(parts))
+	for i, part := range parts {
+		base := 10
+		switch {
+		case strings.HasPrefix(part, "0x"):
+			part, base = part[2:], 16
+			if part == "" {
+				part = "0"
+			}
+		case len(part) > 1 && part[0] == '0':
+			part, base = part[1:], 8
+		}
+		number, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		numbers[i] = number
+	}
+
+	var value uint64
+	for i, number := range numbers[:len(numbers)-1] {
+		if number > 255 {
+			return netip.Addr{}, false
+		}
+		value |= number << (8 * (3 - i))
+	}
+	last := numbers[len(numbers)-1]
+	if last >= 1<<(8*(5-len(numbers))) {
+		return netip.Addr{}, false
+	}
+	value |= last
+
+	return netip.AddrFrom4([4]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}), true
+}
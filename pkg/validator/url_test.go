@@ -122,3 +122,44 @@ func TestNormalizeURL(t *testing.T) {
 		})
 	}
 }
+
+func TestIsPrivateHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{name: "public domain", input: "example.com", expected: false},
+		{name: "public IPv4", input: "93.184.216.34", expected: false},
+		{name: "public IPv6", input: "2606:2800:220:1::1", expected: false},
+		{name: "numeric-looking domain", input: "123.example.com", expected: false},
+		{name: "localhost", input: "localhost", expected: true},
+		{name: "localhost with trailing dot", input: "LocalHost.", expected: true},
+		{name: "localhost subdomain", input: "app.localhost", expected: true},
+		{name: "loopback", input: "127.0.0.1", expected: true},
+		{name: "private class A", input: "10.1.2.3", expected: true},
+		{name: "private class B", input: "172.16.0.1", expected: true},
+		{name: "private class C", input: "192.168.1.1", expected: true},
+		{name: "link-local", input: "169.254.169.254", expected: true},
+		{name: "unspecified", input: "0.0.0.0", expected: true},
+		{name: "IPv6 loopback", input: "[::1]", expected: true},
+		{name: "IPv6 unique local", input: "fd00::1", expected: true},
+		{name: "IPv6 link-local", input: "fe80::1", expected: true},
+		{name: "IPv4-mapped IPv6", input: "::ffff:127.0.0.1", expected: true},
+		{name: "decimal IPv4", input: "2130706433", expected: true},
+		{name: "hexadecimal IPv4", input: "0x7f000001", expected: true},
+		{name: "octal IPv4", input: "0177.0.0.1", expected: true},
+		{name: "shorthand IPv4", input: "127.1", expected: true},
+		{name: "shorthand public IPv4", input: "8.8.2056", expected: false},
+		{name: "out of range IPv4", input: "256.0.0.1", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsPrivateHost(tt.input)
+			if result != tt.expected {
+				t.Errorf("IsPrivateHost(%s) = %v, want %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
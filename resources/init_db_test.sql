@@ -1,9 +1,86 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id BIGINT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
 CREATE TABLE IF NOT EXISTS urls (
     id BIGSERIAL PRIMARY KEY,
     short_code VARCHAR(10) UNIQUE NOT NULL,
     long_url TEXT NOT NULL,
+    long_url_hash CHAR(64) NOT NULL,
     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    clicks BIGINT NOT NULL DEFAULT 0
+    clicks BIGINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    disabled_at TIMESTAMP WITH TIME ZONE,
+    owner_id BIGINT,
+    preview BOOLEAN NOT NULL DEFAULT FALSE,
+    redirect_status SMALLINT NOT NULL DEFAULT 0,
+    passthrough BOOLEAN NOT NULL DEFAULT FALSE,
+    password_hash TEXT NOT NULL DEFAULT '',
+    max_clicks BIGINT NOT NULL DEFAULT 0,
+    targeting JSONB,
+    variants JSONB,
+    sticky_variants BOOLEAN NOT NULL DEFAULT FALSE
+    );
+
+-- Columns added since the first release, for databases created before them. Every statement of this file
+-- is idempotent, so the server applies it again on startup.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = current_schema() AND table_name = 'urls' AND column_name = 'long_url_hash') THEN
+        ALTER TABLE urls ADD COLUMN long_url_hash CHAR(64);
+        UPDATE urls SET long_url_hash = encode(sha256(convert_to(long_url, 'UTF8')), 'hex');
+        ALTER TABLE urls ALTER COLUMN long_url_hash SET NOT NULL;
+    END IF;
+END $$;
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner_id BIGINT;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS preview BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_status SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS passthrough BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS targeting JSONB;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS variants JSONB;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS sticky_variants BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_urls_short_code ON urls (short_code);
+CREATE INDEX IF NOT EXISTS idx_urls_long_url_hash ON urls (long_url_hash);
+CREATE INDEX IF NOT EXISTS idx_urls_owner_id ON urls (owner_id, created_at, id);
+
+CREATE TABLE IF NOT EXISTS url_destination_changes (
+    id BIGSERIAL PRIMARY KEY,
+    url_id BIGINT NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+    previous_long_url TEXT NOT NULL,
+    new_long_url TEXT NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_destination_changes_url_id ON url_destination_changes (url_id, changed_at);
+
+CREATE TABLE IF NOT EXISTS domain_rules (
+    domain TEXT PRIMARY KEY,
+    action VARCHAR(5) NOT NULL CHECK (action IN ('block', 'allow')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
 
-CREATE INDEX IF NOT EXISTS idx_urls_short_code ON urls (short_code);
\ No newline at end of file
+CREATE TABLE IF NOT EXISTS clicks (
+    id BIGSERIAL PRIMARY KEY,
+    url_id BIGINT NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+    clicked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    referrer_host TEXT NOT NULL DEFAULT '',
+    user_agent_class VARCHAR(16) NOT NULL DEFAULT '',
+    ip_prefix VARCHAR(64) NOT NULL DEFAULT '',
+    variant SMALLINT NOT NULL DEFAULT 0
+);
+
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS variant SMALLINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_clicks_url_id_clicked_at ON clicks (url_id, clicked_at);
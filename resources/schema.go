@@ -0,0 +1,10 @@
+// Package resources embeds the database schema, so that the server can apply it on startup.
+package resources
+
+import _ "embed"
+
+// Schema creates the tables and indexes of the database, and adds the columns of later releases to databases
+// created by earlier ones. Every statement is idempotent.
+//
+//go:embed init_db.sql
+var Schema string